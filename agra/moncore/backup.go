@@ -0,0 +1,906 @@
+package moncore
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Backup archives are gzipped tar files :
+//
+//	manifest.json               BackupManifest : collections, their options and indexes, files and checksums
+//	SHA256SUMS                  Checksums of every other file, in the format of sha256sum -c
+//	collections/<name>.bson     Stored documents as concatenated BSON, like mongodump
+//	metadata/webhooks.bson      Webhook registrations of the database (SystemDBName)
+//
+// Documents are read in one snapshot session, so the archive is the database at a single point in time.
+// Servers without snapshot reads (standalone, before 5.0) give an archive with Snapshot false, read collection by collection.
+const (
+	BackupFormat        = "mongomini-backup"
+	BackupVersion       = 1
+	BackupManifestName  = "manifest.json"
+	BackupChecksumsName = "SHA256SUMS"
+	backupWebhooksName  = "metadata/webhooks.bson"
+)
+
+// Contents of manifest.json
+type BackupManifest struct {
+	Format      string             `json:"format"`
+	Version     int                `json:"version"`
+	Database    string             `json:"database"`
+	Created     time.Time          `json:"created"`
+	Snapshot    bool               `json:"snapshot"` // Documents of all collections were read at the same point in time
+	Collections []BackupCollection `json:"collections"`
+	Metadata    []BackupFile       `json:"metadata"`
+}
+
+// A data file of the archive
+type BackupFile struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	Documents int64  `json:"documents"`
+}
+
+// A collection or view of the archive. Views have no file
+type BackupCollection struct {
+	Name    string            `json:"name"`
+	Type    string            `json:"type"`              // "collection" or "view"
+	Mode    string            `json:"mode"`              // CollectionMode of the documents
+	Options json.RawMessage   `json:"options,omitempty"` // Creation options (capped, validator, viewOn...) as canonical Extended JSON
+	Indexes []json.RawMessage `json:"indexes,omitempty"` // Index specifications as canonical Extended JSON
+	BackupFile
+}
+
+type BackupOptions struct {
+	Collections []string // Only these collections. Empty backs up all of them
+	NoSnapshot  bool     // Read collections one after the other, even if the server has snapshot reads
+}
+
+// Invalid or corrupted backup archive
+type BackupError struct {
+	File   string // File of the archive, "" for the archive itself
+	Reason string
+}
+
+func (E *BackupError) Error() string {
+	if E.File == "" {
+		return "bad backup archive : " + E.Reason
+	}
+	return "bad backup archive : " + E.File + " : " + E.Reason
+}
+
+// Write a backup archive of the database to W. Returns its manifest.
+// Documents are written as stored, guards and masks don't apply. System collections are left out
+func (MC *Moncore) BackupDatabase(ctx context.Context, Name string, W io.Writer, opts *BackupOptions) (*BackupManifest, error) {
+	if opts == nil {
+		opts = &BackupOptions{}
+	}
+	db := MC.client.Database(Name)
+
+	M := &BackupManifest{Format: BackupFormat, Version: BackupVersion, Database: Name, Created: time.Now().UTC(), Collections: []BackupCollection{}, Metadata: []BackupFile{}}
+
+	specs, err := backupCollections(ctx, db, opts.Collections)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "mongomini-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	// Documents, in a snapshot session if the server has them
+	M.Snapshot = !opts.NoSnapshot
+	if M.Snapshot {
+		if M.Snapshot, err = MC.hasSnapshotReads(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if M.Snapshot {
+		err = MC.dumpCollections(ctx, db, specs, dir, true)
+		if err != nil && snapshotUnsupported(err) {
+			M.Snapshot = false
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if !M.Snapshot {
+		if err := MC.dumpCollections(ctx, db, specs, dir, false); err != nil {
+			return nil, err
+		}
+	}
+	M.Collections = specs
+
+	hooks, err := MC.dumpWebhooks(ctx, Name, dir)
+	if err != nil {
+		return nil, err
+	}
+	M.Metadata = append(M.Metadata, *hooks)
+
+	return M, writeBackupArchive(W, M, dir)
+}
+
+// Collections and views of the database with their options and indexes, in name order
+func backupCollections(ctx context.Context, db *mongo.Database, Only []string) ([]BackupCollection, error) {
+	wanted := map[string]bool{}
+	for _, name := range Only {
+		wanted[name] = true
+	}
+
+	cur, err := db.ListCollections(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []BackupCollection{}
+	for cur.Next(ctx) {
+		spec := struct {
+			Name    string   `bson:"name"`
+			Type    string   `bson:"type"`
+			Options bson.Raw `bson:"options"`
+		}{}
+		if err := cur.Decode(&spec); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(spec.Name, "system.") || (len(wanted) != 0 && !wanted[spec.Name]) {
+			continue
+		}
+		if spec.Type == "" {
+			spec.Type = "collection"
+		}
+		if spec.Type != "collection" && spec.Type != "view" {
+			continue // Time series and other kinds can't be restored document by document
+		}
+		delete(wanted, spec.Name)
+
+		BC := BackupCollection{Name: spec.Name, Type: spec.Type}
+		if len(spec.Options) != 0 {
+			if BC.Options, err = bson.MarshalExtJSON(spec.Options, true, false); err != nil {
+				return nil, err
+			}
+		}
+
+		if spec.Type == "collection" {
+			BC.Path = "collections/" + url.PathEscape(spec.Name) + ".bson"
+			BC.Mode = (&Collection{MC: db.Collection(spec.Name), Mode: ModeAuto}).ResolvedMode().String()
+
+			icur, err := db.Collection(spec.Name).Indexes().List(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for icur.Next(ctx) {
+				J, err := bson.MarshalExtJSON(icur.Current, true, false)
+				if err != nil {
+					icur.Close(ctx)
+					return nil, err
+				}
+				BC.Indexes = append(BC.Indexes, J)
+			}
+			err = icur.Err()
+			icur.Close(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, BC)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(wanted) != 0 {
+		missing := []string{}
+		for name := range wanted {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return nil, errors.New("no collection " + strings.Join(missing, ", ") + " in database " + db.Name())
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// Dump documents of the collections into files of dir, and fill their BackupFile
+func (MC *Moncore) dumpCollections(ctx context.Context, db *mongo.Database, Specs []BackupCollection, Dir string, Snapshot bool) error {
+	dump := func(ctx context.Context) error {
+		for i := range Specs {
+			if Specs[i].Path == "" {
+				continue
+			}
+			cur, err := db.Collection(Specs[i].Name).Find(ctx, bson.M{}, options.Find().SetBatchSize(1000))
+			if err != nil {
+				return err
+			}
+			err = writeBackupFile(Dir, &Specs[i].BackupFile, func(W io.Writer) (int64, error) {
+				n := int64(0)
+				for cur.Next(ctx) {
+					if _, err := W.Write(cur.Current); err != nil {
+						return n, err
+					}
+					n++
+				}
+				return n, cur.Err()
+			})
+			cur.Close(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !Snapshot {
+		return dump(ctx)
+	}
+
+	sess, err := MC.client.StartSession(options.Session().SetSnapshot(true))
+	if err != nil {
+		return err
+	}
+	defer sess.EndSession(ctx)
+	return mongo.WithSession(ctx, sess, func(sc mongo.SessionContext) error {
+		return dump(sc)
+	})
+}
+
+// Whether the server has snapshot reads : replica set members and sharded clusters from MongoDB 5.0 (wire version 13)
+func (MC *Moncore) hasSnapshotReads(ctx context.Context) (bool, error) {
+	hello := struct {
+		MaxWireVersion int32  `bson:"maxWireVersion"`
+		SetName        string `bson:"setName"`
+		Msg            string `bson:"msg"`
+	}{}
+	if err := MC.client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello); err != nil {
+		return false, err
+	}
+	return hello.MaxWireVersion >= 13 && (hello.SetName != "" || hello.Msg == "isdbgrid"), nil
+}
+
+// Whether the error is the server refusing snapshot reads, rather than a failure of the reads.
+// Other errors (like SnapshotTooOld, when the backup took longer than the snapshot history) fail the backup
+func snapshotUnsupported(err error) bool {
+	var CE mongo.CommandError
+	if errors.As(err, &CE) {
+		switch CE.Code {
+		case 20, 72, 115: // IllegalOperation, InvalidOptions, CommandNotSupported
+			return true
+		}
+	}
+	return false
+}
+
+// Dump the webhook registrations of the database
+func (MC *Moncore) dumpWebhooks(ctx context.Context, Name string, Dir string) (*BackupFile, error) {
+	F := &BackupFile{Path: backupWebhooksName}
+	cur, err := MC.client.Database(SystemDBName).Collection(WebhooksCollectionName).Find(ctx, bson.M{"Doc.Database": Name})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	return F, writeBackupFile(Dir, F, func(W io.Writer) (int64, error) {
+		n := int64(0)
+		for cur.Next(ctx) {
+			if _, err := W.Write(cur.Current); err != nil {
+				return n, err
+			}
+			n++
+		}
+		return n, cur.Err()
+	})
+}
+
+// Write a file of the archive into dir, with its size and checksum
+func writeBackupFile(Dir string, F *BackupFile, Write func(io.Writer) (int64, error)) error {
+	f, err := os.Create(filepath.Join(Dir, tempName(F.Path)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	H := sha256.New()
+	BW := bufio.NewWriterSize(io.MultiWriter(f, H), 256*1024)
+	n, err := Write(BW)
+	if err == nil {
+		err = BW.Flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	F.Documents, F.Size, F.SHA256 = n, st.Size(), hex.EncodeToString(H.Sum(nil))
+	return nil
+}
+
+func tempName(Path string) string {
+	return url.PathEscape(Path)
+}
+
+func writeBackupArchive(W io.Writer, M *BackupManifest, Dir string) error {
+	manifest, err := json.MarshalIndent(M, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifest = append(manifest, '\n')
+
+	files := []BackupFile{}
+	for _, BC := range M.Collections {
+		if BC.Path != "" {
+			files = append(files, BC.BackupFile)
+		}
+	}
+	files = append(files, M.Metadata...)
+
+	sum := sha256.Sum256(manifest)
+	sums := hex.EncodeToString(sum[:]) + "  " + BackupManifestName + "\n"
+	for _, F := range files {
+		sums += F.SHA256 + "  " + F.Path + "\n"
+	}
+
+	GW := gzip.NewWriter(W)
+	TW := tar.NewWriter(GW)
+
+	header := func(Name string, Size int64) error {
+		return TW.WriteHeader(&tar.Header{Name: Name, Mode: 0600, Size: Size, ModTime: M.Created.Truncate(time.Second), Typeflag: tar.TypeReg})
+	}
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{BackupManifestName, manifest}, {BackupChecksumsName, []byte(sums)}} {
+		if err := header(f.name, int64(len(f.data))); err != nil {
+			return err
+		}
+		if _, err := TW.Write(f.data); err != nil {
+			return err
+		}
+	}
+
+	for _, F := range files {
+		if err := header(F.Path, F.Size); err != nil {
+			return err
+		}
+		f, err := os.Open(filepath.Join(Dir, tempName(F.Path)))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(TW, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := TW.Close(); err != nil {
+		return err
+	}
+	return GW.Close()
+}
+
+// Reader of a backup archive : manifest and checksums first, then data files
+type backupReader struct {
+	TR       *tar.Reader
+	Manifest *BackupManifest
+	Sums     map[string]string      // Checksums of SHA256SUMS by path
+	Files    map[string]*BackupFile // Data files of the manifest by path
+	seen     map[string]bool
+}
+
+func openBackup(R io.Reader) (*backupReader, error) {
+	GR, err := gzip.NewReader(R)
+	if err != nil {
+		return nil, &BackupError{Reason: err.Error()}
+	}
+	B := &backupReader{TR: tar.NewReader(GR), Sums: map[string]string{}, Files: map[string]*BackupFile{}, seen: map[string]bool{}}
+
+	manifest, err := B.next(BackupManifestName, 16*1024*1024)
+	if err != nil {
+		return nil, err
+	}
+	B.Manifest = &BackupManifest{}
+	if err := json.Unmarshal(manifest, B.Manifest); err != nil {
+		return nil, &BackupError{File: BackupManifestName, Reason: err.Error()}
+	}
+	if B.Manifest.Format != BackupFormat {
+		return nil, &BackupError{File: BackupManifestName, Reason: "not a " + BackupFormat + " archive"}
+	}
+	if B.Manifest.Version > BackupVersion {
+		return nil, &BackupError{File: BackupManifestName, Reason: "version " + strconv.Itoa(B.Manifest.Version) + " is newer than this program"}
+	}
+
+	sums, err := B.next(BackupChecksumsName, 16*1024*1024)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(sums), "\n") {
+		if sum, path, ok := strings.Cut(line, "  "); ok {
+			B.Sums[path] = sum
+		}
+	}
+
+	sum := sha256.Sum256(manifest)
+	if B.Sums[BackupManifestName] != hex.EncodeToString(sum[:]) {
+		return nil, &BackupError{File: BackupManifestName, Reason: "checksum mismatch"}
+	}
+
+	for i := range B.Manifest.Collections {
+		if F := &B.Manifest.Collections[i].BackupFile; F.Path != "" {
+			B.Files[F.Path] = F
+		}
+	}
+	for i := range B.Manifest.Metadata {
+		B.Files[B.Manifest.Metadata[i].Path] = &B.Manifest.Metadata[i]
+	}
+	for path, F := range B.Files {
+		if B.Sums[path] != F.SHA256 {
+			return nil, &BackupError{File: path, Reason: "checksum differs between the manifest and " + BackupChecksumsName}
+		}
+	}
+	return B, nil
+}
+
+// Read the whole entry, which must be Name
+func (B *backupReader) next(Name string, MaxSize int64) ([]byte, error) {
+	H, err := B.TR.Next()
+	if err == io.EOF {
+		return nil, &BackupError{File: Name, Reason: "missing"}
+	} else if err != nil {
+		return nil, &BackupError{Reason: err.Error()}
+	}
+	if H.Name != Name {
+		return nil, &BackupError{File: Name, Reason: "missing, found " + H.Name}
+	}
+	if H.Size > MaxSize {
+		return nil, &BackupError{File: Name, Reason: "too large"}
+	}
+	data, err := io.ReadAll(B.TR)
+	if err != nil {
+		return nil, &BackupError{File: Name, Reason: err.Error()}
+	}
+	return data, nil
+}
+
+// Next data file, or io.EOF once every file of the manifest was read
+func (B *backupReader) nextFile() (*BackupFile, *fileCheck, error) {
+	H, err := B.TR.Next()
+	if err == io.EOF {
+		for path := range B.Files {
+			if !B.seen[path] {
+				return nil, nil, &BackupError{File: path, Reason: "missing"}
+			}
+		}
+		return nil, nil, io.EOF
+	} else if err != nil {
+		return nil, nil, &BackupError{Reason: err.Error()}
+	}
+
+	F, ok := B.Files[H.Name]
+	if !ok || B.seen[H.Name] {
+		return nil, nil, &BackupError{File: H.Name, Reason: "unexpected file"}
+	}
+	B.seen[H.Name] = true
+	return F, &fileCheck{F: F, R: B.TR, H: sha256.New()}, nil
+}
+
+// Reader of a data file, checking its checksum, size and document count at the end
+type fileCheck struct {
+	F    *BackupFile
+	R    io.Reader
+	H    hash.Hash
+	size int64
+	docs int64
+}
+
+func (C *fileCheck) Read(P []byte) (int, error) {
+	n, err := C.R.Read(P)
+	C.H.Write(P[:n])
+	C.size += int64(n)
+	return n, err
+}
+
+// Next document of the file, or io.EOF after checking the whole file
+func (C *fileCheck) Next() (bson.Raw, error) {
+	raw, err := readBSONDocument(C)
+	if err == io.EOF {
+		if C.size != C.F.Size || hex.EncodeToString(C.H.Sum(nil)) != C.F.SHA256 {
+			return nil, &BackupError{File: C.F.Path, Reason: "checksum mismatch"}
+		}
+		if C.docs != C.F.Documents {
+			return nil, &BackupError{File: C.F.Path, Reason: strconv.FormatInt(C.docs, 10) + " documents instead of " + strconv.FormatInt(C.F.Documents, 10)}
+		}
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, &BackupError{File: C.F.Path, Reason: err.Error()}
+	}
+	if err := raw.Validate(); err != nil {
+		return nil, &BackupError{File: C.F.Path, Reason: "document " + strconv.FormatInt(C.docs+1, 10) + " : " + err.Error()}
+	}
+	C.docs++
+	return raw, nil
+}
+
+// Check a backup archive without a database : manifest, checksums, sizes and documents of every file
+func VerifyBackup(R io.Reader) (*BackupManifest, error) {
+	B, err := openBackup(R)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		_, C, err := B.nextFile()
+		if err == io.EOF {
+			return B.Manifest, nil
+		} else if err != nil {
+			return B.Manifest, err
+		}
+		for {
+			if _, err := C.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				return B.Manifest, err
+			}
+		}
+	}
+}
+
+type RestoreOptions struct {
+	Database    string   // Target database. The backed up one if ""
+	Collections []string // Only these collections of the archive. Empty restores all of them
+	Drop        bool     // Drop target collections first. Otherwise archived documents replace those with the same _id, others are kept
+	Webhooks    bool     // Also restore the webhooks of the database, pointed at the target. Existing ones are kept
+	DryRun      bool     // Only report what would change
+	BatchSize   int      // Documents per bulk write, ImportBatchSize if 0
+}
+
+// What a restore changed, or would change with DryRun
+type RestoreReport struct {
+	Source      string // Backed up database
+	Database    string // Target database
+	Created     time.Time
+	Snapshot    bool
+	DryRun      bool
+	Collections []RestoreCollectionReport
+	Webhooks    int64 // Webhooks added
+}
+
+type RestoreCollectionReport struct {
+	Name      string
+	Type      string
+	Exists    bool // The collection was in the target database
+	Dropped   bool
+	Documents int64 // Documents in the archive
+	Inserted  int64
+	Replaced  int64 // Existing documents with other contents
+	Unchanged int64
+	Removed   int64    // Documents dropped with the collection
+	Indexes   []string // Indexes created
+}
+
+// Restore a backup archive into a database. Data files are checked while they are read, a corrupted file
+// stops the restore with a *BackupError, after the documents read before it were written : use VerifyBackup
+// first for untrusted archives. Writes don't go through guards and don't emit change events
+func (MC *Moncore) RestoreDatabase(ctx context.Context, R io.Reader, opts *RestoreOptions) (*RestoreReport, error) {
+	if opts == nil {
+		opts = &RestoreOptions{}
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = ImportBatchSize
+	}
+
+	B, err := openBackup(R)
+	if err != nil {
+		return nil, err
+	}
+	M := B.Manifest
+
+	Rep := &RestoreReport{Source: M.Database, Database: opts.Database, Created: M.Created, Snapshot: M.Snapshot, DryRun: opts.DryRun, Collections: []RestoreCollectionReport{}}
+	if Rep.Database == "" {
+		Rep.Database = M.Database
+	}
+	db := MC.client.Database(Rep.Database)
+
+	selected := map[string]*BackupCollection{}
+	for i := range M.Collections {
+		selected[M.Collections[i].Name] = &M.Collections[i]
+	}
+	if len(opts.Collections) != 0 {
+		only := map[string]*BackupCollection{}
+		for _, name := range opts.Collections {
+			if selected[name] == nil {
+				return nil, errors.New("no collection " + name + " in the backup")
+			}
+			only[name] = selected[name]
+		}
+		selected = only
+	}
+
+	existing, err := db.ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	exists := map[string]bool{}
+	for _, name := range existing {
+		exists[name] = true
+	}
+
+	// Collections first : drop, create with their options
+	reports := map[string]*RestoreCollectionReport{}
+	for _, BC := range M.Collections {
+		if selected[BC.Name] == nil {
+			continue
+		}
+		Rep.Collections = append(Rep.Collections, RestoreCollectionReport{Name: BC.Name, Type: BC.Type, Exists: exists[BC.Name], Documents: BC.Documents, Indexes: []string{}})
+		CR := &Rep.Collections[len(Rep.Collections)-1]
+		reports[BC.Name] = CR
+
+		if CR.Exists && opts.Drop {
+			if CR.Removed, err = db.Collection(BC.Name).CountDocuments(ctx, bson.M{}); err != nil {
+				return Rep, err
+			}
+			CR.Dropped = true
+			if !opts.DryRun {
+				if err := db.Collection(BC.Name).Drop(ctx); err != nil {
+					return Rep, err
+				}
+			}
+		}
+		if (!CR.Exists || CR.Dropped) && !opts.DryRun {
+			if err := createCollection(ctx, db, &BC); err != nil {
+				return Rep, err
+			}
+		}
+	}
+	// Reports point into Rep.Collections, which doesn't grow anymore
+	for i := range Rep.Collections {
+		reports[Rep.Collections[i].Name] = &Rep.Collections[i]
+	}
+
+	for {
+		F, C, err := B.nextFile()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return Rep, err
+		}
+
+		var write func([]bson.Raw) error
+		switch {
+		case F.Path == backupWebhooksName && opts.Webhooks:
+			write = func(Batch []bson.Raw) error {
+				n, err := MC.restoreWebhooks(ctx, Batch, M.Database, Rep.Database, opts.DryRun)
+				Rep.Webhooks += n
+				return err
+			}
+		case F.Path != backupWebhooksName:
+			for _, BC := range M.Collections {
+				if BC.Path == F.Path && reports[BC.Name] != nil {
+					CR, Col := reports[BC.Name], db.Collection(BC.Name)
+					write = func(Batch []bson.Raw) error {
+						return restoreBatch(ctx, Col, Batch, CR, opts.DryRun)
+					}
+				}
+			}
+		}
+
+		batch := make([]bson.Raw, 0, batchSize)
+		for {
+			raw, err := C.Next()
+			if err != nil && err != io.EOF {
+				return Rep, err
+			}
+			if raw != nil && write != nil {
+				batch = append(batch, raw)
+			}
+			if len(batch) != 0 && (len(batch) == batchSize || err == io.EOF) {
+				if werr := write(batch); werr != nil {
+					return Rep, werr
+				}
+				batch = batch[:0]
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+	}
+
+	// Indexes once documents are in, and the modes of the collections
+	for _, BC := range M.Collections {
+		CR := reports[BC.Name]
+		if CR == nil || BC.Type != "collection" {
+			continue
+		}
+		if CR.Indexes, err = restoreIndexes(ctx, db, &BC, CR.Exists && !CR.Dropped, opts.DryRun); err != nil {
+			return Rep, err
+		}
+		if mode, err := ParseCollectionMode(BC.Mode); err == nil && !opts.DryRun {
+			SetCollectionMode(Rep.Database, BC.Name, mode)
+		}
+	}
+	return Rep, nil
+}
+
+// Create a collection or view with its archived options
+func createCollection(ctx context.Context, db *mongo.Database, BC *BackupCollection) error {
+	cmd := bson.D{{Key: "create", Value: BC.Name}}
+	if len(BC.Options) != 0 {
+		opts := bson.D{}
+		if err := bson.UnmarshalExtJSON(BC.Options, true, &opts); err != nil {
+			return &BackupError{File: BackupManifestName, Reason: "options of " + BC.Name + " : " + err.Error()}
+		}
+		cmd = append(cmd, opts...)
+	}
+	return db.RunCommand(ctx, cmd).Err()
+}
+
+// Write a batch of archived documents. Documents equal to the stored ones are left alone
+func restoreBatch(ctx context.Context, Col *mongo.Collection, Batch []bson.Raw, CR *RestoreCollectionReport, DryRun bool) error {
+	ids := make([]interface{}, len(Batch))
+	for i, raw := range Batch {
+		ids[i] = raw.Lookup("_id")
+	}
+
+	stored := map[string]bson.Raw{}
+	if CR.Exists && !CR.Dropped {
+		cur, err := Col.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return err
+		}
+		for cur.Next(ctx) {
+			stored[rawKey(cur.Current.Lookup("_id"))] = append(bson.Raw{}, cur.Current...)
+		}
+		err = cur.Err()
+		cur.Close(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	models := restoreModels(Batch, stored, CR)
+	if DryRun || len(models) == 0 {
+		return nil
+	}
+	ordered := false
+	_, err := Col.BulkWrite(ctx, models, &options.BulkWriteOptions{Ordered: &ordered})
+	return err
+}
+
+// Writes of archived documents against the stored ones (by rawKey of their _id), counted in CR
+func restoreModels(Batch []bson.Raw, Stored map[string]bson.Raw, CR *RestoreCollectionReport) []mongo.WriteModel {
+	models := []mongo.WriteModel{}
+	truebool := true
+	for _, raw := range Batch {
+		id := raw.Lookup("_id")
+		old, found := Stored[rawKey(id)]
+		switch {
+		case !found:
+			CR.Inserted++
+			models = append(models, mongo.NewInsertOneModel().SetDocument(raw))
+		case bytes.Equal(old, raw):
+			CR.Unchanged++
+		default:
+			CR.Replaced++
+			models = append(models, &mongo.ReplaceOneModel{Filter: bson.M{"_id": id}, Replacement: raw, Upsert: &truebool})
+		}
+	}
+	return models
+}
+
+func rawKey(V bson.RawValue) string {
+	return string([]byte{byte(V.Type)}) + string(V.Value)
+}
+
+// Add archived webhooks whose ID is not registered, moved from database Source to Target
+func (MC *Moncore) restoreWebhooks(ctx context.Context, Batch []bson.Raw, Source string, Target string, DryRun bool) (int64, error) {
+	Col := MC.client.Database(SystemDBName).Collection(WebhooksCollectionName)
+	added := int64(0)
+
+	for _, raw := range Batch {
+		id := raw.Lookup("_id")
+		err := Col.FindOne(ctx, bson.M{"_id": id}).Err()
+		if err == nil {
+			continue
+		} else if err != mongo.ErrNoDocuments {
+			return added, err
+		}
+
+		hook := bson.D{}
+		if err := bson.Unmarshal(raw, &hook); err != nil {
+			return added, &BackupError{File: backupWebhooksName, Reason: err.Error()}
+		}
+		for _, e := range hook {
+			if doc, ok := e.Value.(bson.D); ok && e.Key == "Doc" {
+				for i := range doc {
+					if doc[i].Key == "Database" && doc[i].Value == Source {
+						doc[i].Value = Target
+					}
+				}
+			}
+		}
+
+		added++
+		if !DryRun {
+			if _, err := Col.InsertOne(ctx, hook); err != nil {
+				return added - 1, err
+			}
+		}
+	}
+	return added, nil
+}
+
+// Create the archived indexes missing from the collection. Returns their names
+func restoreIndexes(ctx context.Context, db *mongo.Database, BC *BackupCollection, Existing bool, DryRun bool) ([]string, error) {
+	have := map[string]bool{"_id_": true}
+	if Existing {
+		cur, err := db.Collection(BC.Name).Indexes().List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for cur.Next(ctx) {
+			if name, ok := cur.Current.Lookup("name").StringValueOK(); ok {
+				have[name] = true
+			}
+		}
+		err = cur.Err()
+		cur.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	names := []string{}
+	specs := bson.A{}
+	for _, J := range BC.Indexes {
+		spec := bson.D{}
+		if err := bson.UnmarshalExtJSON(J, true, &spec); err != nil {
+			return nil, &BackupError{File: BackupManifestName, Reason: "index of " + BC.Name + " : " + err.Error()}
+		}
+
+		name := ""
+		clean := bson.D{}
+		for _, e := range spec {
+			switch e.Key {
+			case "v", "ns":
+				continue
+			case "name":
+				name, _ = e.Value.(string)
+			}
+			clean = append(clean, e)
+		}
+		if name == "" || have[name] {
+			continue
+		}
+		names = append(names, name)
+		specs = append(specs, clean)
+	}
+
+	if DryRun || len(specs) == 0 {
+		return names, nil
+	}
+	return names, db.RunCommand(ctx, bson.D{{Key: "createIndexes", Value: BC.Name}, {Key: "indexes", Value: specs}}).Err()
+}
@@ -0,0 +1,188 @@
+package moncore
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Archive of a database with a collection of N documents, a view and no webhooks
+func testBackup(t *testing.T, N int) ([]byte, *BackupManifest) {
+	dir := t.TempDir()
+
+	M := &BackupManifest{Format: BackupFormat, Version: BackupVersion, Database: "shop", Created: time.Now().UTC(), Snapshot: true,
+		Collections: []BackupCollection{
+			{Name: "orders", Type: "collection", Mode: ModeEnveloped.String(), BackupFile: BackupFile{Path: "collections/orders.bson"}},
+			{Name: "recent", Type: "view"},
+		},
+		Metadata: []BackupFile{{Path: backupWebhooksName}},
+	}
+
+	err := writeBackupFile(dir, &M.Collections[0].BackupFile, func(W io.Writer) (int64, error) {
+		for i := 0; i < N; i++ {
+			raw, _ := bson.Marshal(bson.D{{Key: "_id", Value: strconv.Itoa(i)}, {Key: "Doc", Value: bson.D{{Key: "n", Value: i}}}})
+			if _, err := W.Write(raw); err != nil {
+				return int64(i), err
+			}
+		}
+		return int64(N), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeBackupFile(dir, &M.Metadata[0], func(W io.Writer) (int64, error) { return 0, nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeBackupArchive(&buf, M, dir); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), M
+}
+
+// Archive with the contents of the entries changed by Edit. Entries are left out when it returns nil
+func tamper(t *testing.T, Archive []byte, Edit func(Name string, Data []byte) []byte) []byte {
+	GR, err := gzip.NewReader(bytes.NewReader(Archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	TR := tar.NewReader(GR)
+
+	var buf bytes.Buffer
+	GW := gzip.NewWriter(&buf)
+	TW := tar.NewWriter(GW)
+	for {
+		H, err := TR.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(TR)
+		if data = Edit(H.Name, data); data == nil {
+			continue
+		}
+		H.Size = int64(len(data))
+		TW.WriteHeader(H)
+		TW.Write(data)
+	}
+	TW.Close()
+	GW.Close()
+	return buf.Bytes()
+}
+
+func TestBackupVerifyRoundTrip(t *testing.T) {
+	archive, written := testBackup(t, 25)
+
+	M, err := VerifyBackup(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if M.Database != "shop" || !M.Snapshot || len(M.Collections) != 2 {
+		t.Fatalf("manifest %+v", M)
+	}
+	if got, want := M.Collections[0].BackupFile, written.Collections[0].BackupFile; got != want || got.Documents != 25 {
+		t.Fatalf("orders file %+v, want %+v", got, want)
+	}
+	if M.Collections[1].Path != "" {
+		t.Fatalf("view with a file : %+v", M.Collections[1])
+	}
+}
+
+func TestBackupVerifyTampered(t *testing.T) {
+	archive, _ := testBackup(t, 25)
+
+	tests := []struct {
+		name string
+		file string
+		edit func(Name string, Data []byte) []byte
+	}{
+		{"corrupted checksums", "collections/orders.bson", func(Name string, Data []byte) []byte {
+			if Name != BackupChecksumsName {
+				return Data
+			}
+			lines := strings.Split(string(Data), "\n")
+			for i, line := range lines {
+				if strings.HasSuffix(line, "  collections/orders.bson") {
+					lines[i] = strings.Repeat("0", 64) + "  collections/orders.bson"
+				}
+			}
+			return []byte(strings.Join(lines, "\n"))
+		}},
+		{"edited manifest", BackupManifestName, func(Name string, Data []byte) []byte {
+			if Name != BackupManifestName {
+				return Data
+			}
+			return bytes.Replace(Data, []byte(`"shop"`), []byte(`"shoq"`), 1)
+		}},
+		{"truncated documents", "collections/orders.bson", func(Name string, Data []byte) []byte {
+			if Name != "collections/orders.bson" {
+				return Data
+			}
+			return Data[:len(Data)-7]
+		}},
+		{"changed document", "collections/orders.bson", func(Name string, Data []byte) []byte {
+			if Name != "collections/orders.bson" {
+				return Data
+			}
+			out := append([]byte{}, Data...)
+			out[len(out)-3] ^= 1
+			return out
+		}},
+		{"missing file", "collections/orders.bson", func(Name string, Data []byte) []byte {
+			if Name == "collections/orders.bson" {
+				return nil
+			}
+			return Data
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyBackup(bytes.NewReader(tamper(t, archive, tt.edit)))
+			var BE *BackupError
+			if !errors.As(err, &BE) {
+				t.Fatalf("VerifyBackup() = %v, want a *BackupError", err)
+			}
+			if BE.File != tt.file {
+				t.Fatalf("error about %q (%v), want %q", BE.File, err, tt.file)
+			}
+		})
+	}
+}
+
+func TestBackupVerifyNotAnArchive(t *testing.T) {
+	if _, err := VerifyBackup(strings.NewReader("not gzip")); err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestRestoreModels(t *testing.T) {
+	doc := func(id string, n int) bson.Raw {
+		raw, _ := bson.Marshal(bson.D{{Key: "_id", Value: id}, {Key: "Doc", Value: bson.D{{Key: "n", Value: n}}}})
+		return raw
+	}
+	batch := []bson.Raw{doc("a", 1), doc("b", 2), doc("c", 3)}
+	stored := map[string]bson.Raw{
+		rawKey(doc("a", 1).Lookup("_id")): doc("a", 1),  // unchanged
+		rawKey(doc("b", 0).Lookup("_id")): doc("b", 20), // replaced
+	}
+
+	CR := &RestoreCollectionReport{}
+	models := restoreModels(batch, stored, CR)
+	if CR.Inserted != 1 || CR.Replaced != 1 || CR.Unchanged != 1 {
+		t.Fatalf("report %+v", CR)
+	}
+	if len(models) != 2 {
+		t.Fatalf("%d writes, want 2", len(models))
+	}
+}
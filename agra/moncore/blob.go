@@ -0,0 +1,7 @@
+package moncore
+
+// Raw request payload stored with its media type, for bodies that are not documents (text, images, ...)
+type Blob struct {
+	ContentType string `bson:"ContentType" json:"ContentType"`
+	Data        []byte `bson:"Data" json:"Data"` // Stored as BSON binary
+}
@@ -0,0 +1,68 @@
+package moncore
+
+import (
+	"sync"
+	"time"
+)
+
+// Change event types
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// ChangeEvent describes a write performed through a Collection
+type ChangeEvent struct {
+	Type       string             // ChangeInsert | ChangeUpdate | ChangeDelete
+	Database   string             // Database name
+	Collection string             // Collection name
+	ID         string             // Targeted document ID
+	Document   *GenericDBDocument // Document after the write, or the removed document for deletes. May be nil
+	Time       time.Time          // Time of the write (UTC)
+}
+
+// ChangeListener is called after every successful write. Listeners must not block.
+type ChangeListener func(Event *ChangeEvent)
+
+var (
+	changeListeners   []ChangeListener
+	changeListenersMu sync.RWMutex
+)
+
+// Register a listener to be called after every successful write
+func OnChange(Listener ChangeListener) {
+	changeListenersMu.Lock()
+	defer changeListenersMu.Unlock()
+
+	changeListeners = append(changeListeners, Listener)
+}
+
+func hasChangeListeners() bool {
+	changeListenersMu.RLock()
+	defer changeListenersMu.RUnlock()
+
+	return len(changeListeners) != 0
+}
+
+func notifyChange(Event *ChangeEvent) {
+	changeListenersMu.RLock()
+	listeners := changeListeners
+	changeListenersMu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(Event)
+	}
+}
+
+// Emit a change event for a write on this collection
+func (C *Collection) emitChange(Type string, ID string, Doc *GenericDBDocument) {
+	notifyChange(&ChangeEvent{
+		Type:       Type,
+		Database:   C.DatabaseName(),
+		Collection: C.Name(),
+		ID:         ID,
+		Document:   Doc,
+		Time:       time.Now().UTC(),
+	})
+}
@@ -0,0 +1,93 @@
+package moncore
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Atomically take N tokens from the token bucket stored as document key.
+// The bucket holds up to Capacity tokens and refills at Rate tokens per second; a missing bucket is full.
+// Returns the tokens left and whether N tokens were available (nothing is taken otherwise).
+//
+// The document expires (see EnsureTTLIndex on "Expires") once it would be full again.
+// Needs MongoDB 4.2+ (update pipelines).
+func (C *Collection) TakeTokens(key string, N float64, Capacity float64, Rate float64) (float64, bool, error) {
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	now := time.Now().UTC()
+	p := C.fieldPrefix()
+
+	elapsed := bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$" + p + "Updated", now}}}}, 1000}}
+	refilled := bson.M{"$min": bson.A{Capacity, bson.M{"$add": bson.A{
+		bson.M{"$ifNull": bson.A{"$" + p + "Tokens", Capacity}},
+		bson.M{"$multiply": bson.A{elapsed, Rate}},
+	}}}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{p + "Tokens": refilled, p + "Updated": now}}},
+		{{Key: "$set", Value: bson.M{p + "OK": bson.M{"$gte": bson.A{"$" + p + "Tokens", N}}}}},
+		{{Key: "$set", Value: bson.M{
+			p + "Tokens":  bson.M{"$cond": bson.A{"$" + p + "OK", bson.M{"$subtract": bson.A{"$" + p + "Tokens", N}}, "$" + p + "Tokens"}},
+			p + "Expires": now.Add(time.Duration(Capacity / Rate * float64(time.Second))),
+		}}},
+	}
+
+	res := C.MC.FindOneAndUpdate(*ctx_dbr, C.idFilter(key), pipeline,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After))
+
+	out := struct {
+		Tokens float64 `bson:"Tokens"`
+		OK     bool    `bson:"OK"`
+	}{}
+	if err := C.decodeFields(res, &out); err != nil {
+		return 0, false, err
+	}
+	return out.Tokens, out.OK, nil
+}
+
+// Atomically add N to the counter stored as document key and return the new value.
+// A new counter starts at 0 and expires at Expires (see EnsureTTLIndex on "Expires").
+func (C *Collection) Increment(key string, N int64, Expires time.Time) (int64, error) {
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	p := C.fieldPrefix()
+	update := bson.M{
+		"$inc":         bson.M{p + "Count": N},
+		"$setOnInsert": bson.M{p + "Expires": Expires.UTC()},
+	}
+
+	res := C.MC.FindOneAndUpdate(*ctx_dbr, C.idFilter(key), update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After))
+
+	out := struct {
+		Count int64 `bson:"Count"`
+	}{}
+	if err := C.decodeFields(res, &out); err != nil {
+		return 0, err
+	}
+	return out.Count, nil
+}
+
+// Decode the document fields of a single result into out
+func (C *Collection) decodeFields(res *mongo.SingleResult, out interface{}) error {
+	raw, err := res.DecodeBytes()
+	if err != nil {
+		return err
+	}
+
+	env, err := C.envelope(raw)
+	if err != nil {
+		return err
+	}
+
+	doc, err := env.LookupErr("Doc")
+	if err != nil {
+		return err
+	}
+	return doc.Unmarshal(out)
+}
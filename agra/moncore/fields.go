@@ -0,0 +1,175 @@
+package moncore
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Check a dotted field path like 'a.b.0.c'. Numeric elements are array indexes.
+func ValidateFieldPath(fieldPath string) error {
+	if fieldPath == "" {
+		return errors.New("empty field path")
+	}
+	for _, part := range strings.Split(fieldPath, ".") {
+		if part == "" {
+			return errors.New("empty element in field path : " + fieldPath)
+		}
+		if strings.HasPrefix(part, "$") {
+			return errors.New("field path elements can't start with '$' : " + fieldPath)
+		}
+	}
+	return nil
+}
+
+// Get one nested field of a document. Returns ErrNotFound if the document or the field doesn't exist,
+// ErrDenied if the guard denies reading the document.
+func (C *Collection) GetField(key string, fieldPath string) (interface{}, error) {
+	if err := ValidateFieldPath(fieldPath); err != nil {
+		return nil, err
+	}
+
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	doc := GenericDBDocument{}
+	err := C.findOne(*ctx_dbr, key, &doc)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	val, found := lookupPath(doc.Doc, fieldPath)
+	if !found {
+		return nil, ErrNotFound
+	}
+	return val, nil
+}
+
+// Atomically set one nested field of a document. The document is created if it doesn't exist.
+func (C *Collection) SetField(key string, fieldPath string, value interface{}) WriteOperationResponse {
+	if err := ValidateFieldPath(fieldPath); err != nil {
+		return WriteOperationResponse{Status: http.StatusBadRequest, Action: "path", Result: err.Error()}
+	}
+	if p := C.Masks.blocks(fieldPath); p != "" {
+		return WriteOperationResponse{Status: http.StatusForbidden, Action: "protected", Result: "field " + p + " is protected"}
+	}
+	cond, next, failed := C.guardFieldWrite(key, fieldPath, value, false)
+	if failed != nil {
+		return *failed
+	}
+	if cond != nil && !cond.found {
+		return C.writeChecked(&DBDocument{ID: key, Doc: next}, cond)
+	}
+
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	upsert := cond == nil
+	update := bson.M{"$set": bson.M{C.fieldPrefix() + fieldPath: value}}
+	res, rerr := C.MC.UpdateOne(*ctx_dbr, C.conditionFilter(key, cond), update, &options.UpdateOptions{Upsert: &upsert})
+
+	if CheckError(rerr) {
+		return WriteOperationResponse{Status: 2, Action: "dbreq", Result: rerr.Error()}
+	}
+	if cond != nil && res.MatchedCount == 0 {
+		return changed(key)
+	}
+
+	change := ChangeUpdate
+	if res.UpsertedID != nil {
+		change = ChangeInsert
+	}
+	if hasChangeListeners() {
+		C.emitChange(change, key, C.unguarded().Get(key))
+	}
+
+	return WriteOperationResponse{Status: 1, Action: change, Result: key}
+}
+
+// Atomically set one nested field of a document, only if it currently holds Expected.
+// Returns Status 409 (Action "mismatch") if the document doesn't exist or the field holds another value.
+func (C *Collection) CompareAndSetField(key string, fieldPath string, Expected interface{}, value interface{}) WriteOperationResponse {
+	if err := ValidateFieldPath(fieldPath); err != nil {
+		return WriteOperationResponse{Status: http.StatusBadRequest, Action: "path", Result: err.Error()}
+	}
+	if p := C.Masks.blocks(fieldPath); p != "" {
+		return WriteOperationResponse{Status: http.StatusForbidden, Action: "protected", Result: "field " + p + " is protected"}
+	}
+	cond, _, failed := C.guardFieldWrite(key, fieldPath, value, false)
+	if failed != nil {
+		return *failed
+	}
+	if cond != nil && !cond.found {
+		return WriteOperationResponse{Status: http.StatusConflict, Action: "mismatch", Result: key}
+	}
+
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	filter := C.conditionFilter(key, cond)
+	filter[C.fieldPrefix()+fieldPath] = Expected
+	update := bson.M{"$set": bson.M{C.fieldPrefix() + fieldPath: value}}
+	rerr := C.MC.FindOneAndUpdate(*ctx_dbr, filter, update).Err()
+
+	if rerr == mongo.ErrNoDocuments {
+		return WriteOperationResponse{Status: http.StatusConflict, Action: "mismatch", Result: key}
+	}
+	if CheckError(rerr) {
+		return WriteOperationResponse{Status: 2, Action: "dbreq", Result: rerr.Error()}
+	}
+
+	if hasChangeListeners() {
+		C.emitChange(ChangeUpdate, key, C.unguarded().Get(key))
+	}
+
+	return WriteOperationResponse{Status: 1, Action: ChangeUpdate, Result: key}
+}
+
+// Atomically remove one nested field of a document.
+// Array elements can't be removed by index, they are set to null instead (MongoDB $unset semantics).
+func (C *Collection) UnsetField(key string, fieldPath string) WriteOperationResponse {
+	if err := ValidateFieldPath(fieldPath); err != nil {
+		return WriteOperationResponse{Status: http.StatusBadRequest, Action: "path", Result: err.Error()}
+	}
+	if p := C.Masks.blocks(fieldPath); p != "" {
+		return WriteOperationResponse{Status: http.StatusForbidden, Action: "protected", Result: "field " + p + " is protected"}
+	}
+	cond, _, failed := C.guardFieldWrite(key, fieldPath, nil, true)
+	if failed != nil {
+		return *failed
+	}
+	if cond != nil && !cond.found {
+		return WriteOperationResponse{Status: http.StatusNotFound, Action: "unset", Result: key}
+	}
+
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	update := bson.M{"$unset": bson.M{C.fieldPrefix() + fieldPath: ""}}
+	res, rerr := C.MC.UpdateOne(*ctx_dbr, C.conditionFilter(key, cond), update)
+
+	if CheckError(rerr) {
+		return WriteOperationResponse{Status: 2, Action: "dbreq", Result: rerr.Error()}
+	}
+
+	if res.MatchedCount == 0 && cond != nil {
+		return changed(key)
+	}
+	if res.MatchedCount == 0 {
+		return WriteOperationResponse{Status: http.StatusNotFound, Action: "unset", Result: key}
+	}
+
+	if res.ModifiedCount != 0 && hasChangeListeners() {
+		C.emitChange(ChangeUpdate, key, C.unguarded().Get(key))
+	}
+
+	return WriteOperationResponse{Status: 1, Action: "unset", Result: key}
+}
@@ -0,0 +1,217 @@
+package moncore
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Document access operations checked by an AccessGuard
+const (
+	AccessRead   = "read"
+	AccessCreate = "create"
+	AccessUpdate = "update"
+	AccessDelete = "delete"
+)
+
+// Returned (or skipped, for queries) when the guard of the collection denies a read
+var ErrDenied = errors.New("access denied")
+
+// One document access, as seen by an AccessGuard
+type AccessRequest struct {
+	Op         string // AccessRead | AccessCreate | AccessUpdate | AccessDelete
+	Database   string
+	Collection string
+	ID         string
+	Resource   interface{} // Stored Doc (before masks). nil if the document doesn't exist
+	Incoming   interface{} // Doc as it will be stored, for creates and updates. nil otherwise
+}
+
+// Checks every document read and write made through a Collection. Returning false denies the access.
+// Denied documents are skipped by queries, denied writes fail with Status 403.
+// Allowed writes only apply if the document didn't change since it was checked, otherwise they fail with Status 409.
+type AccessGuard func(R *AccessRequest) bool
+
+// Same collection without masks and guard, for internal reads (like change events)
+func (C *Collection) unguarded() *Collection {
+	return &Collection{MC: C.MC, Mode: C.Mode}
+}
+
+func (C *Collection) accessRequest(Op string, ID string, Resource interface{}, Incoming interface{}) *AccessRequest {
+	return &AccessRequest{
+		Op:         Op,
+		Database:   C.DatabaseName(),
+		Collection: C.Name(),
+		ID:         ID,
+		Resource:   Resource,
+		Incoming:   Incoming,
+	}
+}
+
+// Document as readers of this collection see it, like the Doc of a ChangeEvent :
+// ErrDenied if the Guard denies reading it, hidden fields removed otherwise
+func (C *Collection) Visible(Doc *GenericDBDocument) (*GenericDBDocument, error) {
+	env, err := bson.Marshal(Doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := C.guardRead(env); err != nil {
+		return nil, err
+	}
+
+	env, err = C.Masks.hide(env)
+	if err != nil {
+		return nil, err
+	}
+
+	out := GenericDBDocument{}
+	if err := bson.Unmarshal(env, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Check a read of envelope bytes
+func (C *Collection) guardRead(envelope bson.Raw) error {
+	if C.Guard == nil {
+		return nil
+	}
+
+	doc := bson.M{}
+	if err := bson.Unmarshal(envelope, &doc); err != nil {
+		return err
+	}
+
+	if !C.Guard(C.accessRequest(AccessRead, idString(doc["_id"]), doc["Doc"], nil)) {
+		return ErrDenied
+	}
+	return nil
+}
+
+// Stored state of a document when a write was checked. The write only applies if the document is still in that state
+type writeCondition struct {
+	found bool
+	raw   bson.Raw // Stored document, as in the collection
+}
+
+// Stored Doc of a document, decoded as bson.M / bson.A values, and its state. cond.found is false if it doesn't exist
+func (C *Collection) stored(key string) (doc interface{}, cond *writeCondition, err error) {
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	raw, err := C.MC.FindOne(*ctx_dbr, C.idFilter(key)).DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		return nil, &writeCondition{}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env, err := C.envelope(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := bson.M{}
+	if err := bson.Unmarshal(env, &out); err != nil {
+		return nil, nil, err
+	}
+	return out["Doc"], &writeCondition{found: true, raw: raw}, nil
+}
+
+// Filter of a checked write : the document, only while it is as stored when checked. A nil cond matches the ID only.
+// Documents that didn't exist can't be matched, they are inserted instead
+func (C *Collection) conditionFilter(key string, cond *writeCondition) bson.M {
+	filter := C.idFilter(key)
+	if cond != nil && cond.found {
+		filter["$expr"] = bson.M{"$eq": bson.A{"$$ROOT", bson.M{"$literal": cond.raw}}}
+	}
+	return filter
+}
+
+// The document changed between the check of a write and the write
+func changed(Key string) WriteOperationResponse {
+	return WriteOperationResponse{Status: http.StatusConflict, Action: "changed", Result: Key + " changed during the write, try again"}
+}
+
+// Convert a value to what it would be decoded as, once stored
+func normalized(v interface{}) (interface{}, error) {
+	wrapped := bson.M{}
+	bb, err := bson.Marshal(bson.M{"v": v})
+	if err == nil {
+		err = bson.Unmarshal(bb, &wrapped)
+	}
+	return wrapped["v"], err
+}
+
+func denied(Op string, Key string) *WriteOperationResponse {
+	return &WriteOperationResponse{Status: http.StatusForbidden, Action: "denied", Result: Op + " of " + Key + " is not allowed"}
+}
+
+// Check a write of one field. Without guard, nothing is loaded and cond is nil.
+// remove is true for UnsetField. Returns the checked state and the document after the write, or a failed WriteOperationResponse
+func (C *Collection) guardFieldWrite(key string, fieldPath string, value interface{}, remove bool) (cond *writeCondition, next interface{}, failed *WriteOperationResponse) {
+	if C.Guard == nil {
+		return nil, nil, nil
+	}
+
+	old, cond, err := C.stored(key)
+	if CheckError(err) {
+		return nil, nil, &WriteOperationResponse{Status: 2, Action: "dbreq", Result: err.Error()}
+	}
+	if !cond.found && remove {
+		return cond, nil, nil // nothing to unset, reported as 404
+	}
+
+	// Apply the change to a copy of the stored document
+	next, err = normalized(old)
+	if err == nil && next == nil {
+		next = bson.M{}
+	}
+	if err == nil && !remove {
+		value, err = normalized(value)
+	}
+	if err != nil {
+		return nil, nil, &WriteOperationResponse{Status: http.StatusUnprocessableEntity, Action: "typecast", Result: err.Error()}
+	}
+
+	parts := strings.Split(fieldPath, ".")
+	if remove {
+		removePath(next, parts)
+	} else {
+		setPath(next, parts, value)
+	}
+
+	op := AccessUpdate
+	if !cond.found {
+		op = AccessCreate
+	}
+	if !C.Guard(C.accessRequest(op, key, old, next)) {
+		return nil, nil, denied(op, key)
+	}
+	return cond, next, nil
+}
+
+// Check a delete. Without guard, nothing is loaded and cond is nil. Returns the checked state, or a failed WriteOperationResponse
+func (C *Collection) guardDelete(key string) (*writeCondition, *WriteOperationResponse) {
+	if C.Guard == nil {
+		return nil, nil
+	}
+
+	old, cond, err := C.stored(key)
+	if CheckError(err) {
+		return nil, &WriteOperationResponse{Status: 2, Action: "dbreq", Result: err.Error()}
+	}
+	if !cond.found {
+		return cond, nil // reported as 404
+	}
+
+	if !C.Guard(C.accessRequest(AccessDelete, key, old, nil)) {
+		return nil, denied(AccessDelete, key)
+	}
+	return cond, nil
+}
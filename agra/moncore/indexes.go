@@ -0,0 +1,39 @@
+package moncore
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Create a TTL index on a date field (relative to the document, like Filter.Add).
+// MongoDB removes documents once the field's time plus After has passed. Does nothing if the index exists.
+func (C *Collection) EnsureTTLIndex(fieldPath string, After time.Duration) error {
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	_, err := C.MC.Indexes().CreateOne(*ctx_dbr, mongo.IndexModel{
+		Keys:    bson.D{{Key: C.fieldPrefix() + fieldPath, Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(After.Seconds())),
+	})
+	return err
+}
+
+// Delete every document matching the filter. Returns the number of deleted documents.
+// Doesn't emit change events.
+func (C *Collection) DeleteMatching(filter *Filter) (int64, error) {
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	query, err := C.queryFor(filter)
+	if err != nil {
+		return 0, err
+	}
+	res, err := C.MC.DeleteMany(*ctx_dbr, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
@@ -0,0 +1,104 @@
+package moncore
+
+import (
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Filter of a JSON (or Extended JSON) object, in a subset of the MongoDB query language :
+//
+//	{"status": "paid", "total": {"$ne": 0}, "email": {"$exists": true}, "name": {"$not": {"$regex": "^test"}},
+//	 "$or": [{"country": "FR"}, {"country": "BE"}]}
+//
+// Fields are dotted paths relative to the document (Doc). Operators are those of Filterlet ($eq, $ne, $regex, $exists, $not)
+// and $and, $or, $nor, so the filter also works with Matches().
+func Filter_FromJSON(Src []byte) (*Filter, error) {
+	Q := bson.D{}
+	if err := bson.UnmarshalExtJSON(Src, false, &Q); err != nil {
+		return nil, errors.New("filter must be a JSON object : " + err.Error())
+	}
+	return filterFromDocument(Q)
+}
+
+func filterFromDocument(Q bson.D) (*Filter, error) {
+	F := Filter_MatchAll()
+
+	for _, e := range Q {
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			subs, ok := e.Value.(bson.A)
+			if !ok || len(subs) == 0 {
+				return nil, errors.New(e.Key + " needs a non-empty array of filters")
+			}
+			A := bson.A{}
+			for _, s := range subs {
+				sd, ok := s.(bson.D)
+				if !ok {
+					return nil, errors.New(e.Key + " needs an array of objects")
+				}
+				sub, err := filterFromDocument(sd)
+				if err != nil {
+					return nil, err
+				}
+				A = append(A, sub.MongoQuery)
+			}
+			F.MongoQuery = append(F.MongoQuery, bson.E{Key: e.Key, Value: A})
+
+		default:
+			if err := ValidateFieldPath(e.Key); err != nil {
+				return nil, err
+			}
+			fl, err := filterletFromValue(e.Value)
+			if err != nil {
+				return nil, errors.New(e.Key + " : " + err.Error())
+			}
+			F.Add(e.Key, fl)
+		}
+	}
+	return F, nil
+}
+
+// Filterlet of a field condition : a value to be equal to, or an object of operators
+func filterletFromValue(V interface{}) (*Filterlet, error) {
+	fl := Filterlet_new()
+
+	ops, ok := V.(bson.D)
+	if !ok || len(ops) == 0 || !strings.HasPrefix(ops[0].Key, "$") {
+		return fl.Equals(V), nil
+	}
+
+	for _, op := range ops {
+		switch op.Key {
+		case "$eq":
+			fl.Equals(op.Value)
+		case "$ne":
+			fl.NotEquals(op.Value)
+		case "$regex":
+			pattern, ok := op.Value.(string)
+			if !ok {
+				return nil, errors.New("$regex needs a string")
+			}
+			fl.RegexMatches(pattern)
+		case "$exists":
+			exists, ok := op.Value.(bool)
+			if !ok {
+				return nil, errors.New("$exists needs true or false")
+			}
+			fl.Exists(exists)
+		case "$not":
+			inner, err := filterletFromValue(op.Value)
+			if err != nil {
+				return nil, err
+			}
+			if len(inner.Querylet) == 0 || !strings.HasPrefix(inner.Querylet[0].Key, "$") {
+				return nil, errors.New("$not needs an object of operators")
+			}
+			fl.Querylet = append(fl.Querylet, bson.E{Key: "$not", Value: inner.Querylet})
+		default:
+			return nil, errors.New("unsupported operator " + op.Key)
+		}
+	}
+	return fl, nil
+}
@@ -0,0 +1,235 @@
+package moncore
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Field masks of a Collection. Paths are relative to the document, like Filter.Add.
+//
+// HiddenFields are removed from every document read through the collection, and queries can't filter on them (see CheckFilter).
+// Writes keep their stored values.
+// ProtectedFields can be read, but writes changing them are rejected with Status 403.
+type FieldMasks struct {
+	HiddenFields    []string
+	ProtectedFields []string
+}
+
+func (M *FieldMasks) empty() bool {
+	return len(M.HiddenFields) == 0 && len(M.ProtectedFields) == 0
+}
+
+// Check if two dotted paths overlap (one is equal to or inside the other)
+func pathsOverlap(a string, b string) bool {
+	return a == b || strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".")
+}
+
+// Returns the masked path overlapping fieldPath, or ""
+func (M *FieldMasks) blocks(fieldPath string) string {
+	for _, p := range append(append([]string{}, M.HiddenFields...), M.ProtectedFields...) {
+		if pathsOverlap(p, fieldPath) {
+			return p
+		}
+	}
+	return ""
+}
+
+// Reject filters on hidden fields with an *OperationError (Status 403) : which documents match, and how many,
+// would tell their values. Paths equal to, inside or containing a hidden field are rejected, and so are
+// query operators that can read any field ($expr, $where...) when the collection has hidden fields
+func (C *Collection) CheckFilter(filter *Filter) error {
+	if len(C.Masks.HiddenFields) == 0 {
+		return nil
+	}
+	return C.Masks.checkQuery(filter.MongoQuery, C.ResolvedMode() == ModeRaw)
+}
+
+func (M *FieldMasks) checkQuery(query bson.D, raw bool) error {
+	for _, e := range query {
+		switch {
+		case e.Key == "$and" || e.Key == "$or" || e.Key == "$nor":
+			subs, _ := e.Value.(bson.A)
+			for _, sub := range subs {
+				sd, ok := sub.(bson.D)
+				if !ok {
+					return hiddenFilterError("filters with " + e.Key + " on anything but documents are not allowed on this collection")
+				}
+				if err := M.checkQuery(sd, raw); err != nil {
+					return err
+				}
+			}
+
+		case strings.HasPrefix(e.Key, "$"):
+			return hiddenFilterError("filters with " + e.Key + " are not allowed on this collection")
+
+		case e.Key == "_id":
+
+		default:
+			// Enveloped documents are under Doc, raw ones at the top level (with or without the Doc. prefix, see unwrapQuery)
+			var path string
+			switch {
+			case e.Key == "Doc": // The whole document
+			case strings.HasPrefix(e.Key, "Doc."):
+				path = strings.TrimPrefix(e.Key, "Doc.")
+			case raw:
+				path = e.Key
+			default:
+				continue
+			}
+			if p := M.hiddenOverlap(path); p != "" {
+				return hiddenFilterError("field " + p + " is hidden and can't be filtered on")
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the hidden path overlapping fieldPath ("" being the whole document), or "".
+// Array indexes in fieldPath are ignored, hidden paths apply to every element of arrays
+func (M *FieldMasks) hiddenOverlap(fieldPath string) string {
+	parts := []string{}
+	for _, part := range strings.Split(fieldPath, ".") {
+		if _, err := strconv.Atoi(part); err != nil {
+			parts = append(parts, part)
+		}
+	}
+	withoutIndexes := strings.Join(parts, ".")
+
+	for _, p := range M.HiddenFields {
+		if fieldPath == "" || pathsOverlap(p, fieldPath) || pathsOverlap(p, withoutIndexes) {
+			return p
+		}
+	}
+	return ""
+}
+
+func hiddenFilterError(Result string) error {
+	return &OperationError{WriteOperationResponse{Status: http.StatusForbidden, Action: "hidden", Result: Result}}
+}
+
+// Remove hidden fields from envelope bytes
+func (M *FieldMasks) hide(envelope bson.Raw) (bson.Raw, error) {
+	if len(M.HiddenFields) == 0 {
+		return envelope, nil
+	}
+
+	doc := bson.M{}
+	if err := bson.Unmarshal(envelope, &doc); err != nil {
+		return nil, err
+	}
+
+	for _, p := range M.HiddenFields {
+		removePath(doc["Doc"], strings.Split(p, "."))
+	}
+
+	return bson.Marshal(doc)
+}
+
+// Prepare a full document write: keep hidden fields as stored, reject changes of protected fields,
+// then ask the guard. Returns the document to store and the state it was checked against (nil if nothing
+// was checked, see writeChecked), or a failed WriteOperationResponse.
+func (C *Collection) prepareWrite(Doc *DBDocument) (*DBDocument, *writeCondition, *WriteOperationResponse) {
+	if C.Masks.empty() && C.Guard == nil {
+		return Doc, nil, nil
+	}
+
+	oldDoc, cond, err := C.stored(Doc.ID)
+	if CheckError(err) {
+		return nil, nil, &WriteOperationResponse{Status: 2, Action: "dbreq", Result: err.Error()}
+	}
+
+	Doc, failed := C.prepareChecked(Doc, oldDoc, cond)
+	return Doc, cond, failed
+}
+
+// prepareWrite against an already loaded stored Doc
+func (C *Collection) prepareChecked(Doc *DBDocument, oldDoc interface{}, cond *writeCondition) (*DBDocument, *WriteOperationResponse) {
+	// Normalize the new document through BSON, so values compare like stored ones
+	newDoc, err := normalized(Doc.Doc)
+	if err != nil {
+		return nil, &WriteOperationResponse{Status: http.StatusUnprocessableEntity, Action: "typecast", Result: err.Error()}
+	}
+
+	for _, p := range C.Masks.ProtectedFields {
+		ov, ofound := lookupPath(oldDoc, p)
+		nv, nfound := lookupPath(newDoc, p)
+		if ofound != nfound || !reflect.DeepEqual(ov, nv) {
+			return nil, &WriteOperationResponse{Status: http.StatusForbidden, Action: "protected", Result: "field " + p + " is protected"}
+		}
+	}
+
+	for _, p := range C.Masks.HiddenFields {
+		parts := strings.Split(p, ".")
+		removePath(newDoc, parts)
+		if ov, found := lookupPath(oldDoc, p); found {
+			setPath(newDoc, parts, ov)
+		}
+	}
+
+	if C.Guard != nil {
+		op := AccessUpdate
+		if !cond.found {
+			op = AccessCreate
+		}
+		if !C.Guard(C.accessRequest(op, Doc.ID, oldDoc, newDoc)) {
+			return nil, denied(op, Doc.ID)
+		}
+	}
+
+	return &DBDocument{ID: Doc.ID, Doc: newDoc}, nil
+}
+
+// Remove a path from a decoded document. Non numeric elements apply to every element of arrays
+func removePath(v interface{}, parts []string) {
+	switch t := v.(type) {
+	case bson.M:
+		if len(parts) == 1 {
+			delete(t, parts[0])
+			return
+		}
+		removePath(t[parts[0]], parts[1:])
+	case bson.A:
+		if i, err := strconv.Atoi(parts[0]); err == nil {
+			if i >= 0 && i < len(t) {
+				if len(parts) == 1 {
+					t[i] = nil
+				} else {
+					removePath(t[i], parts[1:])
+				}
+			}
+			return
+		}
+		for _, e := range t {
+			removePath(e, parts)
+		}
+	}
+}
+
+// Set a path in a decoded document, creating intermediate documents
+func setPath(v interface{}, parts []string, val interface{}) {
+	switch t := v.(type) {
+	case bson.M:
+		if len(parts) == 1 {
+			t[parts[0]] = val
+			return
+		}
+		next, ok := t[parts[0]]
+		if !ok || next == nil {
+			next = bson.M{}
+			t[parts[0]] = next
+		}
+		setPath(next, parts[1:], val)
+	case bson.A:
+		if i, err := strconv.Atoi(parts[0]); err == nil && i >= 0 && i < len(t) {
+			if len(parts) == 1 {
+				t[i] = val
+			} else {
+				setPath(t[i], parts[1:], val)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package moncore
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCheckFilterHiddenFields(t *testing.T) {
+	masks := FieldMasks{HiddenFields: []string{"secret.field", "tags.token"}}
+
+	tests := []struct {
+		name   string
+		filter string
+		raw    bool
+		denied bool
+	}{
+		{"other field", `{"name": "abc"}`, false, false},
+		{"key", `{"_id": "abc"}`, false, false},
+		{"hidden field", `{"secret.field": "abc"}`, false, true},
+		{"inside hidden field", `{"secret.field.x": {"$exists": true}}`, false, true},
+		{"parent of hidden field", `{"secret": {"$exists": true}}`, false, true},
+		{"sibling of hidden field", `{"secret.other": "abc"}`, false, false},
+		{"prefix but not parent", `{"secretive": "abc"}`, false, false},
+		{"array element", `{"tags.0.token": "abc"}`, false, true},
+		{"nested in $or", `{"$or": [{"name": "a"}, {"secret.field": {"$regex": "^a"}}]}`, false, true},
+		{"not", `{"secret.field": {"$not": {"$eq": "abc"}}}`, false, true},
+		{"raw collection", `{"secret.field": "abc"}`, true, true},
+		{"raw other field", `{"name": "abc"}`, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode := ModeEnveloped
+			if tt.raw {
+				mode = ModeRaw
+			}
+			C := &Collection{Mode: mode, Masks: masks}
+
+			F, err := Filter_FromJSON([]byte(tt.filter))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = C.CheckFilter(F)
+			var opErr *OperationError
+			if tt.denied && (!errors.As(err, &opErr) || opErr.HTTPStatus() != http.StatusForbidden) {
+				t.Fatalf("CheckFilter(%s) = %v, want a 403 OperationError", tt.filter, err)
+			}
+			if !tt.denied && err != nil {
+				t.Fatalf("CheckFilter(%s) = %v", tt.filter, err)
+			}
+		})
+	}
+}
+
+func TestCheckFilterOperators(t *testing.T) {
+	F := &Filter{MongoQuery: bson.D{{Key: "$where", Value: "this.Doc.secret == 'a'"}}}
+
+	if err := (&Collection{Mode: ModeEnveloped}).CheckFilter(F); err != nil {
+		t.Fatalf("no hidden fields : %v", err)
+	}
+	if err := (&Collection{Mode: ModeEnveloped, Masks: FieldMasks{HiddenFields: []string{"secret"}}}).CheckFilter(F); err == nil {
+		t.Fatal("$where allowed on a collection with hidden fields")
+	}
+}
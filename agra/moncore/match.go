@@ -0,0 +1,213 @@
+package moncore
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Check if a document matches the filter without a round trip to MongoDB.
+//
+// Supports the operators produced by Filterlet and Filter ($eq, $ne, $regex, $exists, $not, $and, $or, $nor).
+// Unknown operators never match.
+func (F *Filter) Matches(Doc *GenericDBDocument) bool {
+	if Doc == nil {
+		return false
+	}
+	return matchQuery(F.MongoQuery, bson.M{"_id": Doc.ID, "Doc": map[string]interface{}(Doc.Doc)})
+}
+
+func matchQuery(query bson.D, doc interface{}) bool {
+	for _, e := range query {
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			subs, _ := e.Value.(bson.A)
+			matched := 0
+			total := 0
+			for _, s := range subs {
+				sd, ok := s.(bson.D)
+				if !ok {
+					continue // nil entries are ignored
+				}
+				total++
+				if matchQuery(sd, doc) {
+					matched++
+				}
+			}
+			if (e.Key == "$and" && matched != total) ||
+				(e.Key == "$or" && matched == 0) ||
+				(e.Key == "$nor" && matched != 0) {
+				return false
+			}
+
+		default:
+			val, found := lookupPath(doc, e.Key)
+			if !matchCondition(e.Value, val, found) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchCondition(cond interface{}, val interface{}, found bool) bool {
+	ops, isOps := cond.(bson.D)
+	if !isOps || len(ops) == 0 || !strings.HasPrefix(ops[0].Key, "$") {
+		return found && matchEquals(val, cond)
+	}
+
+	for _, op := range ops {
+		switch op.Key {
+		case "$eq":
+			if !found || !matchEquals(val, op.Value) {
+				return false
+			}
+		case "$ne":
+			if found && matchEquals(val, op.Value) {
+				return false
+			}
+		case "$exists":
+			want, _ := op.Value.(bool)
+			if found != want {
+				return false
+			}
+		case "$regex":
+			pattern, _ := op.Value.(string)
+			re, err := regexp.Compile(pattern)
+			if err != nil || !found || !matchRegex(val, re) {
+				return false
+			}
+		case "$not":
+			if matchCondition(op.Value, val, found) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func matchEquals(val interface{}, want interface{}) bool {
+	if arr, ok := val.(bson.A); ok {
+		for _, v := range arr {
+			if matchEquals(v, want) {
+				return true
+			}
+		}
+	}
+	if arr, ok := val.([]interface{}); ok {
+		for _, v := range arr {
+			if matchEquals(v, want) {
+				return true
+			}
+		}
+	}
+
+	if vf, ok := toFloat(val); ok {
+		if wf, ok := toFloat(want); ok {
+			return vf == wf
+		}
+	}
+	if oid, ok := val.(primitive.ObjectID); ok {
+		if ws, ok := want.(string); ok {
+			return oid.Hex() == ws
+		}
+	}
+	return reflect.DeepEqual(val, want)
+}
+
+func matchRegex(val interface{}, re *regexp.Regexp) bool {
+	switch v := val.(type) {
+	case string:
+		return re.MatchString(v)
+	case bson.A:
+		for _, e := range v {
+			if matchRegex(e, re) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if matchRegex(e, re) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// Resolve a dotted path (like 'Doc.a.0.b') inside a decoded document.
+// Numeric path elements index into arrays.
+func lookupPath(doc interface{}, path string) (interface{}, bool) {
+	cur := doc
+	for _, part := range strings.Split(path, ".") {
+		switch v := cur.(type) {
+		case bson.M:
+			next, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case map[string]interface{}:
+			next, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case GenericDocument:
+			next, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case bson.D:
+			found := false
+			for _, e := range v {
+				if e.Key == part {
+					cur = e.Value
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		case bson.A:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			cur = v[i]
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			cur = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
@@ -118,20 +118,104 @@ func (MD *Database) ListCollections() map[string]*Collection {
 // MongoDB Collection wrapper
 type Collection struct {
 	MC *mongo.Collection
+
+	// Document layout. See CollectionMode
+	Mode CollectionMode
+
+	// Fields hidden from reads or protected from writes. See FieldMasks
+	Masks FieldMasks
+
+	// Access check of every document read and write. nil allows everything
+	Guard AccessGuard
+}
+
+// Name of the collection
+func (C *Collection) Name() string {
+	return C.MC.Name()
 }
 
-func (C *Collection) query_curser(filter *Filter) *mongo.Cursor {
+// Name of the database containing the collection
+func (C *Collection) DatabaseName() string {
+	return C.MC.Database().Name()
+}
+
+// Get a single document by ID.
+// Returns nil if the document doesn't exist or if error.
+func (C *Collection) Get(key string) *GenericDBDocument {
+	out, err := C.GetDocument(key)
+
+	if err == ErrNotFound || err == ErrDenied || CheckError(err) {
+		return nil
+	}
+
+	return out
+}
 
+// Get document by ID. Returns ErrNotFound if there is no such document, ErrDenied if the Guard denies reading it
+func (C *Collection) GetDocument(key string) (*GenericDBDocument, error) {
 	ctx_dbr, cnc_dbr := DefaultContext()
 	defer cnc_dbr()
 
-	qcur, qerr := C.MC.Find(*ctx_dbr, filter.MongoQuery)
+	out := GenericDBDocument{}
+	err := C.findOne(*ctx_dbr, key, &out)
 
-	if CheckError(qerr) {
-		return nil
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
 	}
 
-	return qcur
+	return &out, nil
+}
+
+// Delete document by ID.
+// Returns the deleted ID, or Status 404 if there was no such document
+func (C *Collection) Delete(key string) WriteOperationResponse {
+	cond, failed := C.guardDelete(key)
+	if failed != nil {
+		return *failed
+	}
+
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	old := GenericDBDocument{}
+	err := mongo.ErrNoDocuments
+	if cond == nil || cond.found {
+		var raw bson.Raw
+		raw, err = C.MC.FindOneAndDelete(*ctx_dbr, C.conditionFilter(key, cond)).DecodeBytes()
+		if err == nil {
+			err = C.unguarded().decode(raw, &old)
+		}
+	}
+
+	if err == mongo.ErrNoDocuments && cond != nil && cond.found {
+		return changed(key)
+	}
+
+	if err == mongo.ErrNoDocuments {
+		return WriteOperationResponse{
+			Status: http.StatusNotFound,
+			Action: "delete",
+			Result: key,
+		}
+	}
+
+	if CheckError(err) {
+		return WriteOperationResponse{
+			Status: 2,
+			Action: "dbreq",
+			Result: err.Error(),
+		}
+	}
+
+	C.emitChange(ChangeDelete, key, &old)
+
+	return WriteOperationResponse{
+		Status: 1,
+		Action: "delete",
+		Result: key,
+	}
 }
 
 // Query collection with filter.
@@ -139,75 +223,161 @@ func (C *Collection) query_curser(filter *Filter) *mongo.Cursor {
 // Returns nil if error.
 func (C *Collection) Query(filter *Filter) []GenericDBDocument {
 
-	qcur := C.query_curser(filter)
+	out, err := C.Find(filter)
 
-	if qcur == nil {
+	if CheckError(err) {
 		return nil
 	}
 
-	out := []GenericDBDocument{}
+	return out
+
+}
+
+// Query collection with filter, like Query(), reporting errors
+func (C *Collection) Find(filter *Filter) ([]GenericDBDocument, error) {
 
 	ctx_dbr, cnc_dbr := DefaultContext()
 	defer cnc_dbr()
 
-	cerr := qcur.All(*ctx_dbr, &out)
+	qcur, qerr := C.query_curser(*ctx_dbr, filter)
 
-	if CheckError(cerr) {
-		return nil
+	if qerr != nil {
+		return nil, qerr
 	}
 
-	return out
+	defer qcur.Close(*ctx_dbr)
+
+	out := []GenericDBDocument{}
+
+	for qcur.Next(*ctx_dbr) {
+		d := GenericDBDocument{}
+		if derr := C.decode(qcur.Current, &d); derr == ErrDenied {
+			continue
+		} else if derr != nil {
+			return nil, decodeError(qcur.Current.Lookup("_id").String(), derr)
+		}
+		out = append(out, d)
+	}
+
+	return out, qcur.Err()
 
 }
 
 // Query collection with filter.
 // You can use Filter_MatchAll() to match all documents and add filters to filter out documents.
 //
-// bufferSize: 0 means unbuffered, which will load all documents at once to memory.
+// bufferSize: 0 means unbuffered.
 //
-// Returns a channel that will be closed when all documents are read.
+// Returns a channel that will be closed when all documents are read, or when the CancelFunc is called.
+// Call the CancelFunc when stopping before the channel is closed, the reading goroutine waits for readers until then.
 // Returns nil if error.
-func (C *Collection) QueryToChannel(filter *Filter, bufferSize int) (chan *GenericDBDocument, context.CancelFunc) {
+//
+// Deprecated: use Stream(), which reports errors and supports batch size and time limits.
+func (C *Collection) QueryToChannel(filter *Filter, bufferSize int) (<-chan *GenericDBDocument, context.CancelFunc) {
 
-	qcur := C.query_curser(filter)
+	S, err := C.Stream(context.Background(), filter, &QueryOptions{Buffer: bufferSize, logErrors: true})
 
-	if qcur == nil {
+	if CheckError(err) {
 		return nil, nil
 	}
 
-	out := make(chan *GenericDBDocument, bufferSize)
-	ctx_dbr, cnc_dbr := LongRunningContext()
-
-	go func() {
+	return S.Docs, S.Close
+}
 
-		for qcur.Next(*ctx_dbr) {
+// Insert or Update document.
+// Returns Inserted ID or "" if updated already existing document
+func (C *Collection) SetDocument(Doc *DBDocument) WriteOperationResponse {
+	Doc, cond, failed := C.prepareWrite(Doc)
+	if failed != nil {
+		return *failed
+	}
+	if cond != nil {
+		return C.writeChecked(Doc, cond)
+	}
 
-			d := GenericDBDocument{}
-			derr := qcur.Decode(&d)
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
 
-			if !CheckError(derr) {
-				out <- &d
+	truebool := true
+	var res *mongo.UpdateResult
+	var rerr error
+
+	if C.ResolvedMode() == ModeRaw {
+		replacement, cerr := C.rawReplacement(Doc)
+		if CheckError(cerr) {
+			return WriteOperationResponse{
+				Status: http.StatusUnprocessableEntity,
+				Action: "typecast",
+				Result: cerr.Error(),
 			}
+		}
+		res, rerr = C.MC.ReplaceOne(*ctx_dbr, C.idFilter(Doc.ID), replacement, &options.ReplaceOptions{Upsert: &truebool})
+	} else {
+		res, rerr = C.MC.UpdateByID(*ctx_dbr, Doc.ID, bson.M{"$set": Doc}, &options.UpdateOptions{Upsert: &truebool})
+	}
 
+	if CheckError(rerr) {
+		return WriteOperationResponse{
+			Status: 2,
+			Action: "dbreq",
+			Result: rerr.Error(),
 		}
+	}
 
-		close(out)
+	if res.UpsertedID == nil {
+		if hasChangeListeners() {
+			C.emitChange(ChangeUpdate, Doc.ID, C.unguarded().Get(Doc.ID))
+		}
+
+		return WriteOperationResponse{
+			Status: 1,
+			Action: "update",
+			Result: Doc.ID,
+		}
+	}
 
-		defer cnc_dbr()
-	}()
+	str := idString(res.UpsertedID)
 
-	return out, cnc_dbr
+	if hasChangeListeners() {
+		C.emitChange(ChangeInsert, str, C.unguarded().Get(str))
+	}
 
+	return WriteOperationResponse{
+		Status: 1,
+		Action: "insert",
+		Result: str,
+	}
 }
 
-// Insert or Update document.
-// Returns Inserted ID or "" if updated already existing document
-func (C *Collection) SetDocument(Doc *DBDocument) WriteOperationResponse {
+// Write a document checked by prepareWrite, only if the stored one didn't change since. Returns Status 409 (Action "changed") otherwise
+func (C *Collection) writeChecked(Doc *DBDocument, cond *writeCondition) WriteOperationResponse {
+	if !cond.found {
+		res := C.insert(Doc)
+		if res.Action == "exists" {
+			return changed(Doc.ID)
+		}
+		return res
+	}
+
 	ctx_dbr, cnc_dbr := DefaultContext()
 	defer cnc_dbr()
 
-	truebool := true
-	res, rerr := C.MC.UpdateByID(*ctx_dbr, Doc.ID, bson.M{"$set": Doc}, &options.UpdateOptions{Upsert: &truebool})
+	var res *mongo.UpdateResult
+	var rerr error
+
+	if C.ResolvedMode() == ModeRaw {
+		replacement, cerr := C.rawReplacement(Doc)
+		if CheckError(cerr) {
+			return WriteOperationResponse{
+				Status: http.StatusUnprocessableEntity,
+				Action: "typecast",
+				Result: cerr.Error(),
+			}
+		}
+		res, rerr = C.MC.ReplaceOne(*ctx_dbr, C.conditionFilter(Doc.ID, cond), replacement)
+	} else {
+		res, rerr = C.MC.UpdateOne(*ctx_dbr, C.conditionFilter(Doc.ID, cond), bson.M{"$set": Doc})
+	}
 
 	if CheckError(rerr) {
 		return WriteOperationResponse{
@@ -217,28 +387,88 @@ func (C *Collection) SetDocument(Doc *DBDocument) WriteOperationResponse {
 		}
 	}
 
-	if res.UpsertedID == nil {
+	if res.MatchedCount == 0 {
+		return changed(Doc.ID)
+	}
+
+	if hasChangeListeners() {
+		C.emitChange(ChangeUpdate, Doc.ID, C.unguarded().Get(Doc.ID))
+	}
+
+	return WriteOperationResponse{
+		Status: 1,
+		Action: "update",
+		Result: Doc.ID,
+	}
+}
+
+// Insert a document, never replacing one. Returns Status 409 (Action "exists") if the ID is taken
+func (C *Collection) InsertDocument(Doc *DBDocument) WriteOperationResponse {
+	Doc, _, failed := C.prepareWrite(Doc)
+	if failed != nil {
+		return *failed
+	}
+	return C.insert(Doc)
+}
+
+func (C *Collection) insert(Doc *DBDocument) WriteOperationResponse {
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	var document interface{} = Doc
+	if C.ResolvedMode() == ModeRaw {
+		replacement, cerr := C.rawReplacement(Doc)
+		if CheckError(cerr) {
+			return WriteOperationResponse{
+				Status: http.StatusUnprocessableEntity,
+				Action: "typecast",
+				Result: cerr.Error(),
+			}
+		}
+		document = replacement
+	}
+
+	_, rerr := C.MC.InsertOne(*ctx_dbr, document)
+
+	if mongo.IsDuplicateKeyError(rerr) {
 		return WriteOperationResponse{
-			Status: 1,
-			Action: "update",
+			Status: http.StatusConflict,
+			Action: "exists",
 			Result: Doc.ID,
 		}
 	}
 
-	str, strOK := res.UpsertedID.(string)
-	if !strOK {
+	if CheckError(rerr) {
 		return WriteOperationResponse{
-			Status: http.StatusInternalServerError,
-			Action: "typecast",
-			Result: "UpsertedID is not a string",
+			Status: 2,
+			Action: "dbreq",
+			Result: rerr.Error(),
 		}
 	}
 
+	if hasChangeListeners() {
+		C.emitChange(ChangeInsert, Doc.ID, C.unguarded().Get(Doc.ID))
+	}
+
 	return WriteOperationResponse{
 		Status: 1,
 		Action: "insert",
-		Result: str,
+		Result: Doc.ID,
+	}
+}
+
+// Insert a document with the given ID. See InsertDocument()
+func (C *Collection) Insert(key string, val interface{}) WriteOperationResponse {
+	return C.InsertDocument(&DBDocument{ID: key, Doc: val})
+}
+
+// Find a document by ID and decode it into out
+func (C *Collection) findOne(ctx context.Context, key string, out interface{}) error {
+	raw, err := C.MC.FindOne(ctx, C.idFilter(key)).DecodeBytes()
+	if err != nil {
+		return err
 	}
+	return C.decode(raw, out)
 }
 
 // Returns Inserted ID or nil if updated already existing document
@@ -264,11 +494,37 @@ type GenericDocument bson.M
 // WriteOperationResponse is returned by Write operations.
 type WriteOperationResponse struct {
 	Status int    // 0 = unknown, 1 = success, 2 = failure (Unknown error), others : HTTP status codes (But not used for the HTTP response)
-	Action string // Performed action | "insert" | "update" | "dbreq" | "typecast"
+	Action string // Performed action | "insert" | "update" | "delete" | "unset" | "exists" | "changed" | "mismatch" | "dbreq" | "typecast" | "path" | "protected" | "denied"
 	Result string // Targeted ID or error message
 }
 
-// Cast a GenericDocument into Template type
+// Failed write operation, see WriteOperationResponse.Err()
+type OperationError struct {
+	WriteOperationResponse
+}
+
+func (E *OperationError) Error() string {
+	return E.Action + " : " + E.Result
+}
+
+// HTTP status of the failure. 500 for database and unknown errors
+func (E *OperationError) HTTPStatus() int {
+	if E.Status >= 400 && E.Status < 600 {
+		return E.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// nil if the operation succeeded, an *OperationError otherwise
+func (R WriteOperationResponse) Err() error {
+	if R.Status == 1 {
+		return nil
+	}
+	return &OperationError{R}
+}
+
+// Cast a GenericDocument into Template type.
+// Template must be a pointer (like &MyStruct{}). It is filled and returned, or nil is returned if error.
 func (D *GenericDocument) Cast(Template interface{}) interface{} {
 	bb, be := bson.Marshal(D)
 
@@ -276,15 +532,13 @@ func (D *GenericDocument) Cast(Template interface{}) interface{} {
 		return nil
 	}
 
-	var out interface{}
-
-	err := bson.Unmarshal(bb, &out)
+	err := bson.Unmarshal(bb, Template)
 
 	if CheckError(err) {
 		return nil
 	}
 
-	return out
+	return Template
 }
 
 // Serialize object into JSON
@@ -390,6 +644,8 @@ func (F *Filterlet) Not() *Filterlet {
 }
 
 // Add filterlet to filter. The returned filter and input filter are the same.
+//
+// filedPath is relative to the document (Doc). In raw collections it applies to top-level fields.
 func (F *Filter) Add(filedPath string, fl *Filterlet) *Filter {
 
 	F.MongoQuery = append(F.MongoQuery, bson.E{
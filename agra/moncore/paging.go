@@ -0,0 +1,141 @@
+package moncore
+
+import (
+	"encoding/base64"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Options for FindPage()
+type PageOptions struct {
+	Limit int64  // Documents per page. 0 means no limit (a single page)
+	After string // Next token of the previous page. "" for the first page
+}
+
+// A page of documents, in _id order
+type Page struct {
+	Docs []GenericDBDocument
+	Next string // Token of the following page, "" on the last page
+}
+
+var ErrBadPageToken = errors.New("invalid page token")
+
+// Find documents matching the filter, a page at a time. Pages are cut on _id, so they stay consistent
+// while documents are added or removed.
+// Documents denied by the Guard are skipped, so a page may hold fewer than Limit documents without being the last.
+func (C *Collection) FindPage(filter *Filter, opts PageOptions) (*Page, error) {
+	query, err := C.queryFor(filter)
+	if err != nil {
+		return nil, err
+	}
+	if query, err = queryAfter(query, opts.After); err != nil {
+		return nil, err
+	}
+
+	findOpts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if opts.Limit > 0 {
+		findOpts.SetLimit(opts.Limit)
+	}
+
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	qcur, qerr := C.MC.Find(*ctx_dbr, query, findOpts)
+	if qerr != nil {
+		return nil, qerr
+	}
+	defer qcur.Close(*ctx_dbr)
+
+	P := &Page{Docs: []GenericDBDocument{}}
+	read, last := int64(0), bson.RawValue{}
+
+	for qcur.Next(*ctx_dbr) {
+		read++
+		id := qcur.Current.Lookup("_id")
+		last = bson.RawValue{Type: id.Type, Value: append([]byte(nil), id.Value...)}
+
+		d := GenericDBDocument{}
+		if derr := C.decode(qcur.Current, &d); derr == ErrDenied {
+			continue
+		} else if derr != nil {
+			return nil, decodeError(qcur.Current.Lookup("_id").String(), derr)
+		}
+		P.Docs = append(P.Docs, d)
+	}
+	if err := qcur.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts.Limit > 0 && read == opts.Limit {
+		P.Next = pageToken(last)
+	}
+	return P, nil
+}
+
+// Page token of the last _id of a page : the _id as canonical Extended JSON, so it keeps its type
+// (a numeric _id must be compared as a number)
+func pageToken(id bson.RawValue) string {
+	J, err := bson.MarshalExtJSON(bson.D{{Key: "_id", Value: id}}, true, false)
+	if CheckError(err) {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(J)
+}
+
+// Restrict the query to documents after the page token. "" leaves it as is
+func queryAfter(query bson.D, After string) (bson.D, error) {
+	if After == "" {
+		return query, nil
+	}
+	J, err := base64.RawURLEncoding.DecodeString(After)
+	if err != nil {
+		return nil, ErrBadPageToken
+	}
+	last := struct {
+		ID bson.RawValue `bson:"_id"`
+	}{}
+	if err := bson.UnmarshalExtJSON(J, true, &last); err != nil || last.ID.Type == 0 {
+		return nil, ErrBadPageToken
+	}
+	return bson.D{{Key: "$and", Value: bson.A{query, bson.M{"_id": bson.M{"$gt": last.ID}}}}}, nil
+}
+
+// Number of documents matching the filter. Documents denied by the Guard are not counted :
+// with a Guard, every matching document is read to be checked
+func (C *Collection) Count(filter *Filter) (int64, error) {
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	query, err := C.queryFor(filter)
+	if err != nil {
+		return 0, err
+	}
+	if C.Guard == nil {
+		return C.MC.CountDocuments(*ctx_dbr, query)
+	}
+
+	qcur, qerr := C.MC.Find(*ctx_dbr, query)
+	if qerr != nil {
+		return 0, qerr
+	}
+	defer qcur.Close(*ctx_dbr)
+
+	n := int64(0)
+	for qcur.Next(*ctx_dbr) {
+		d := GenericDBDocument{}
+		if derr := C.decode(qcur.Current, &d); derr == ErrDenied {
+			continue
+		} else if derr != nil {
+			return 0, decodeError(qcur.Current.Lookup("_id").String(), derr)
+		}
+		n++
+	}
+	return n, qcur.Err()
+}
+
+// MongoDB query run for the filter, adapted to the collection layout (see CollectionMode)
+func (C *Collection) NormalizedFilter(filter *Filter) bson.D {
+	return C.layoutQuery(filter)
+}
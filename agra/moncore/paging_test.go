@@ -0,0 +1,64 @@
+package moncore
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestQueryAfter(t *testing.T) {
+	query := bson.D{{Key: "Doc.status", Value: "paid"}}
+	oid := primitive.NewObjectID()
+
+	tests := []struct {
+		name string
+		id   interface{}
+	}{
+		{"string", "k5"},
+		{"hex string", oid.Hex()},
+		{"ObjectID", oid},
+		{"int32", int32(5)},
+		{"int64", int64(1) << 40},
+		{"float", 2.5},
+		{"document", bson.D{{Key: "user", Value: "alice"}, {Key: "n", Value: int32(2)}}},
+	}
+	for _, tt := range tests {
+		raw, _ := bson.Marshal(bson.D{{Key: "_id", Value: tt.id}})
+		token := pageToken(bson.Raw(raw).Lookup("_id"))
+
+		got, err := queryAfter(query, token)
+		if err != nil {
+			t.Fatalf("%s : queryAfter(%q) : %v", tt.name, token, err)
+		}
+
+		// The bound keeps the type of the _id
+		gt := got[0].Value.(bson.A)[1].(bson.M)["_id"].(bson.M)["$gt"].(bson.RawValue)
+		var back interface{}
+		if err := gt.Unmarshal(&back); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(back, tt.id) {
+			t.Errorf("%s : bound %#v, want %#v", tt.name, back, tt.id)
+		}
+	}
+
+	if got, err := queryAfter(query, ""); err != nil || !reflect.DeepEqual(got, query) {
+		t.Errorf("first page : %v, %v", got, err)
+	}
+	for _, bad := range []string{"!!", base64.RawURLEncoding.EncodeToString([]byte("k5")), base64.RawURLEncoding.EncodeToString([]byte(`{"other":1}`))} {
+		if _, err := queryAfter(query, bad); err != ErrBadPageToken {
+			t.Errorf("queryAfter(%q) err = %v, want ErrBadPageToken", bad, err)
+		}
+	}
+}
+
+func TestStreamBadPageToken(t *testing.T) {
+	C := &Collection{Mode: ModeEnveloped}
+	if _, err := C.Stream(context.Background(), Filter_MatchAll(), &QueryOptions{After: "!!"}); err != ErrBadPageToken {
+		t.Fatalf("Stream() err = %v, want ErrBadPageToken", err)
+	}
+}
@@ -0,0 +1,270 @@
+package moncore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// How documents are laid out in a collection
+type CollectionMode int
+
+const (
+	ModeEnveloped CollectionMode = iota // Documents are wrapped as {_id, Doc} (default)
+	ModeRaw                             // Fields are stored at the top level, like collections written by other services
+	ModeAuto                            // Detect from the stored documents. Empty collections are treated as ModeEnveloped
+)
+
+func (M CollectionMode) String() string {
+	switch M {
+	case ModeEnveloped:
+		return "enveloped"
+	case ModeRaw:
+		return "raw"
+	case ModeAuto:
+		return "auto"
+	}
+	return "unknown"
+}
+
+// Parse "enveloped" | "raw" | "auto"
+func ParseCollectionMode(s string) (CollectionMode, error) {
+	switch strings.ToLower(s) {
+	case "enveloped", "wrapped", "":
+		return ModeEnveloped, nil
+	case "raw", "unwrapped":
+		return ModeRaw, nil
+	case "auto":
+		return ModeAuto, nil
+	}
+	return ModeEnveloped, errors.New("unknown collection mode : " + s)
+}
+
+// Pinned or detected modes by "<db>/<collection>"
+var collectionModes sync.Map
+
+// Empty ModeAuto collections by "<db>/<collection>", with the time until which they aren't sampled again
+var emptyCollections sync.Map
+
+// How long an empty ModeAuto collection is treated as ModeEnveloped before it's sampled again
+var EmptyCollectionModeTTL = 10 * time.Second
+
+// Pin the mode of a collection. Collections opened with ModeAuto will use it instead of detecting.
+func SetCollectionMode(db string, collection string, mode CollectionMode) {
+	collectionModes.Store(db+"/"+collection, mode)
+}
+
+// Specify Collection to use with the given document layout
+func (MD *Database) CollectionWithMode(name string, mode CollectionMode) *Collection {
+	return &Collection{MC: MD.db.Collection(name), Mode: mode}
+}
+
+// Resolved mode of the collection. ModeAuto is resolved by sampling one document, empty collections are sampled again
+// after EmptyCollectionModeTTL
+func (C *Collection) ResolvedMode() CollectionMode {
+	if C.Mode != ModeAuto {
+		return C.Mode
+	}
+
+	key := C.DatabaseName() + "/" + C.Name()
+	if m, ok := collectionModes.Load(key); ok {
+		return m.(CollectionMode)
+	}
+	if until, ok := emptyCollections.Load(key); ok && time.Now().Before(until.(time.Time)) {
+		return ModeEnveloped
+	}
+
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	sample, err := C.MC.FindOne(*ctx_dbr, bson.M{}).DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		// Only for a while, the collection may be filled by others later
+		emptyCollections.Store(key, time.Now().Add(EmptyCollectionModeTTL))
+		return ModeEnveloped
+	} else if CheckError(err) {
+		return ModeEnveloped
+	}
+	emptyCollections.Delete(key)
+
+	mode := detectMode(sample)
+	collectionModes.Store(key, mode)
+	return mode
+}
+
+func detectMode(sample bson.Raw) CollectionMode {
+	elems, err := sample.Elements()
+	if err != nil || len(elems) != 2 {
+		return ModeRaw
+	}
+
+	for _, e := range elems {
+		switch e.Key() {
+		case "_id":
+		case "Doc":
+		default:
+			return ModeRaw
+		}
+	}
+	return ModeEnveloped
+}
+
+// Filter on _id for the given key. In raw mode, keys that look like ObjectIDs are matched as ObjectIDs.
+func (C *Collection) idFilter(key string) bson.M {
+	return bson.M{"_id": C.idValue(key)}
+}
+
+func (C *Collection) idValue(key string) interface{} {
+	if C.ResolvedMode() == ModeRaw {
+		if oid, err := primitive.ObjectIDFromHex(key); err == nil {
+			return oid
+		}
+	}
+	return key
+}
+
+// String form of an _id value. Values other than strings, ObjectIDs and numbers are written as relaxed Extended JSON
+func idString(id interface{}) string {
+	switch v := id.(type) {
+	case string:
+		return v
+	case primitive.ObjectID:
+		return v.Hex()
+	case int32, int64, float64:
+		return fmt.Sprint(v)
+	case nil:
+		return ""
+	}
+
+	J, err := bson.MarshalExtJSON(bson.D{{Key: "_id", Value: id}}, false, false)
+	if err != nil {
+		return fmt.Sprint(id)
+	}
+	return string(J[len(`{"_id":`) : len(J)-1])
+}
+
+// Path prefix of document fields in this collection ("Doc." or "")
+func (C *Collection) fieldPrefix() string {
+	if C.ResolvedMode() == ModeRaw {
+		return ""
+	}
+	return "Doc."
+}
+
+// Query of the filter adapted to the collection layout. Filters on hidden fields are rejected, see CheckFilter()
+func (C *Collection) queryFor(filter *Filter) (bson.D, error) {
+	if err := C.CheckFilter(filter); err != nil {
+		return nil, err
+	}
+	return C.layoutQuery(filter), nil
+}
+
+// Query of the filter adapted to the collection layout
+func (C *Collection) layoutQuery(filter *Filter) bson.D {
+	if C.ResolvedMode() != ModeRaw {
+		return filter.MongoQuery
+	}
+	return unwrapQuery(filter.MongoQuery)
+}
+
+// Strip the 'Doc.' prefix from field paths so the query applies to top-level fields
+func unwrapQuery(query bson.D) bson.D {
+	out := make(bson.D, 0, len(query))
+	for _, e := range query {
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			subs, _ := e.Value.(bson.A)
+			A := make(bson.A, 0, len(subs))
+			for _, s := range subs {
+				if sd, ok := s.(bson.D); ok {
+					A = append(A, unwrapQuery(sd))
+				}
+			}
+			out = append(out, bson.E{Key: e.Key, Value: A})
+		default:
+			out = append(out, bson.E{Key: strings.TrimPrefix(e.Key, "Doc."), Value: e.Value})
+		}
+	}
+	return out
+}
+
+// Decode a stored document into out (a *GenericDBDocument, *TypedDBDocument[T] or similar envelope).
+// Raw documents are wrapped into the envelope first, then checked by the guard (ErrDenied), hidden fields are removed.
+func (C *Collection) decode(raw bson.Raw, out interface{}) error {
+	env, err := C.envelope(raw)
+	if err != nil {
+		return err
+	}
+
+	if err := C.guardRead(env); err != nil {
+		return err
+	}
+
+	env, err = C.Masks.hide(env)
+	if err != nil {
+		return err
+	}
+
+	return bson.Unmarshal(env, out)
+}
+
+// Stored document as {_id, Doc} envelope bytes
+func (C *Collection) envelope(raw bson.Raw) (bson.Raw, error) {
+	if C.ResolvedMode() != ModeRaw {
+		return raw, nil
+	}
+	return wrapRaw(raw)
+}
+
+// Convert a top-level document into {_id, Doc} envelope bytes
+func wrapRaw(raw bson.Raw) (bson.Raw, error) {
+	elems, err := raw.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	var id interface{}
+	rest := bson.D{}
+
+	for _, e := range elems {
+		var v interface{}
+		if uerr := e.Value().Unmarshal(&v); uerr != nil {
+			return nil, uerr
+		}
+
+		if e.Key() == "_id" {
+			id = v
+			continue
+		}
+		rest = append(rest, bson.E{Key: e.Key(), Value: v})
+	}
+
+	return bson.Marshal(bson.D{{Key: "_id", Value: idString(id)}, {Key: "Doc", Value: rest}})
+}
+
+// Top-level replacement document for a raw write. Doc must encode to a BSON document.
+func (C *Collection) rawReplacement(Doc *DBDocument) (bson.D, error) {
+	bb, err := bson.Marshal(Doc.Doc)
+	if err != nil {
+		return nil, errors.New("raw collections can only store documents : " + err.Error())
+	}
+
+	fields := bson.D{}
+	if err := bson.Unmarshal(bb, &fields); err != nil {
+		return nil, err
+	}
+
+	out := bson.D{{Key: "_id", Value: C.idValue(Doc.ID)}}
+	for _, f := range fields {
+		if f.Key != "_id" {
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,139 @@
+package moncore
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDetectMode(t *testing.T) {
+	oid := primitive.NewObjectID()
+	tests := []struct {
+		name string
+		doc  bson.D
+		want CollectionMode
+	}{
+		{"envelope", bson.D{{Key: "_id", Value: "k1"}, {Key: "Doc", Value: bson.D{{Key: "a", Value: 1}}}}, ModeEnveloped},
+		{"envelope of a scalar", bson.D{{Key: "_id", Value: "k1"}, {Key: "Doc", Value: 42}}, ModeEnveloped},
+		{"envelope with an ObjectID", bson.D{{Key: "_id", Value: oid}, {Key: "Doc", Value: bson.D{}}}, ModeEnveloped},
+		{"top-level fields", bson.D{{Key: "_id", Value: oid}, {Key: "name", Value: "alice"}, {Key: "age", Value: 30}}, ModeRaw},
+		{"single field", bson.D{{Key: "_id", Value: oid}, {Key: "name", Value: "alice"}}, ModeRaw},
+		{"Doc and more", bson.D{{Key: "_id", Value: "k1"}, {Key: "Doc", Value: 1}, {Key: "other", Value: 2}}, ModeRaw},
+		{"only _id", bson.D{{Key: "_id", Value: "k1"}}, ModeRaw},
+	}
+	for _, tt := range tests {
+		raw, _ := bson.Marshal(tt.doc)
+		if got := detectMode(raw); got != tt.want {
+			t.Errorf("%s : detectMode() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if got := detectMode(bson.Raw{1, 2, 3}); got != ModeRaw {
+		t.Errorf("malformed document : detectMode() = %v", got)
+	}
+}
+
+func TestUnwrapQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query bson.D
+		want  bson.D
+	}{
+		{"empty", bson.D{}, bson.D{}},
+		{"fields", bson.D{{Key: "Doc.name", Value: "alice"}, {Key: "Doc.address.city", Value: bson.M{"$eq": "Paris"}}},
+			bson.D{{Key: "name", Value: "alice"}, {Key: "address.city", Value: bson.M{"$eq": "Paris"}}}},
+		{"_id", bson.D{{Key: "_id", Value: "k1"}}, bson.D{{Key: "_id", Value: "k1"}}},
+		{"only the prefix", bson.D{{Key: "Doc.Doc.x", Value: 1}}, bson.D{{Key: "Doc.x", Value: 1}}},
+		{"nested operators",
+			bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "Doc.a", Value: 1}},
+				bson.D{{Key: "$and", Value: bson.A{bson.D{{Key: "Doc.b", Value: 2}}, bson.D{{Key: "Doc.c", Value: 3}}}}},
+			}}},
+			bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "a", Value: 1}},
+				bson.D{{Key: "$and", Value: bson.A{bson.D{{Key: "b", Value: 2}}, bson.D{{Key: "c", Value: 3}}}}},
+			}}}},
+		{"$nor", bson.D{{Key: "$nor", Value: bson.A{bson.D{{Key: "Doc.a", Value: 1}}}}}, bson.D{{Key: "$nor", Value: bson.A{bson.D{{Key: "a", Value: 1}}}}}},
+	}
+	for _, tt := range tests {
+		if got := unwrapQuery(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s : unwrapQuery() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWrapRaw(t *testing.T) {
+	oid, _ := primitive.ObjectIDFromHex("65a1b2c3d4e5f60718293a4b")
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		id   interface{}
+		key  string
+	}{
+		{"string", "k1", "k1"},
+		{"ObjectID", oid, "65a1b2c3d4e5f60718293a4b"},
+		{"int32", int32(7), "7"},
+		{"int64", int64(1) << 40, "1099511627776"},
+		{"float", 2.5, "2.5"},
+		{"date", at, `{"$date":"2024-01-02T03:04:05Z"}`},
+		{"document", bson.D{{Key: "user", Value: "alice"}, {Key: "n", Value: int32(2)}}, `{"user":"alice","n":2}`},
+		{"missing", nil, ""},
+	}
+	for _, tt := range tests {
+		doc := bson.D{{Key: "name", Value: "alice"}, {Key: "tags", Value: bson.A{"a", "b"}}}
+		if tt.id != nil {
+			doc = append(bson.D{{Key: "_id", Value: tt.id}}, doc...)
+		}
+		raw, _ := bson.Marshal(doc)
+
+		env, err := wrapRaw(raw)
+		if err != nil {
+			t.Fatalf("%s : %v", tt.name, err)
+		}
+		got := GenericDBDocument{}
+		if err := bson.Unmarshal(env, &got); err != nil {
+			t.Fatalf("%s : %v", tt.name, err)
+		}
+
+		if got.ID != tt.key {
+			t.Errorf("%s : ID = %q, want %q", tt.name, got.ID, tt.key)
+		}
+		if got.Doc["name"] != "alice" || len(got.Doc) != 2 {
+			t.Errorf("%s : Doc = %v", tt.name, got.Doc)
+		}
+		if _, ok := got.Doc["_id"]; ok {
+			t.Errorf("%s : _id kept in Doc", tt.name)
+		}
+	}
+
+	if _, err := wrapRaw(bson.Raw{1, 2, 3}); err == nil {
+		t.Error("malformed document wrapped")
+	}
+}
+
+func TestIDValue(t *testing.T) {
+	hex := "65a1b2c3d4e5f60718293a4b"
+	oid, _ := primitive.ObjectIDFromHex(hex)
+
+	tests := []struct {
+		mode CollectionMode
+		key  string
+		want interface{}
+	}{
+		{ModeEnveloped, hex, hex},
+		{ModeEnveloped, "k1", "k1"},
+		{ModeRaw, hex, oid},
+		{ModeRaw, "k1", "k1"},
+		{ModeRaw, "7", "7"},
+	}
+	for _, tt := range tests {
+		C := &Collection{Mode: tt.mode}
+		if got := C.idValue(tt.key); got != tt.want {
+			t.Errorf("%v : idValue(%q) = %#v, want %#v", tt.mode, tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package moncore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Options for Stream()
+type QueryOptions struct {
+	BatchSize int32         // Documents per server round trip. 0 means server default
+	MaxTime   time.Duration // Server side time limit for the whole query. 0 means no limit
+	Buffer    int           // Channel buffer size. 0 means unbuffered
+	Limit     int64         // Maximum number of documents read. 0 means no limit
+	Paged     bool          // In _id order, like FindPage()
+	After     string        // Only documents after this page token (see Page.Next). Implies Paged
+
+	logErrors bool // Log the terminal error, for callers that can't read Err()
+}
+
+// Documents streamed from an open cursor. Read Docs until it is closed, then check Err().
+//
+// Call Close() when done (or to stop early). Close closes the cursor and waits for the reading goroutine to exit.
+type DocumentStream struct {
+	Docs <-chan *GenericDBDocument
+	*streamState
+}
+
+type streamState struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+// Terminal error of the stream, if any. Only meaningful after Docs is closed.
+// Stopping the stream with Close() is not an error.
+func (S *streamState) Err() error {
+	S.mu.Lock()
+	defer S.mu.Unlock()
+	return S.err
+}
+
+// Stop the stream, close the cursor and wait for the reading goroutine to exit. Safe to call more than once.
+func (S *streamState) Close() {
+	S.mu.Lock()
+	S.closed = true
+	S.mu.Unlock()
+
+	S.cancel()
+	<-S.done
+}
+
+func (S *streamState) fail(err error) {
+	S.mu.Lock()
+	defer S.mu.Unlock()
+
+	if S.closed && errors.Is(err, context.Canceled) {
+		return
+	}
+	if S.err == nil {
+		S.err = err
+	}
+}
+
+// Stream documents matching the filter through a channel.
+// The cursor lives until the stream ends, ctx is done or Close() is called.
+// Decode errors are terminal and reported by Err().
+func (C *Collection) Stream(ctx context.Context, filter *Filter, opts *QueryOptions) (*DocumentStream, error) {
+	docs, state, err := openStream[GenericDBDocument](ctx, C, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &DocumentStream{Docs: docs, streamState: state}, nil
+}
+
+// Open a cursor and start a goroutine decoding documents of type D into the returned channel
+func openStream[D any](ctx context.Context, C *Collection, filter *Filter, opts *QueryOptions) (<-chan *D, *streamState, error) {
+	if opts == nil {
+		opts = &QueryOptions{}
+	}
+
+	findOpts := options.Find()
+	if opts.BatchSize > 0 {
+		findOpts.SetBatchSize(opts.BatchSize)
+	}
+	if opts.MaxTime > 0 {
+		findOpts.SetMaxTime(opts.MaxTime)
+	}
+	if opts.Limit > 0 {
+		findOpts.SetLimit(opts.Limit)
+	}
+	if opts.Paged || opts.After != "" {
+		findOpts.SetSort(bson.D{{Key: "_id", Value: 1}})
+	}
+
+	query, err := C.queryFor(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if query, err = queryAfter(query, opts.After); err != nil {
+		return nil, nil, err
+	}
+
+	ctx_str, cnc_str := context.WithCancel(ctx)
+
+	qcur, qerr := C.MC.Find(ctx_str, query, findOpts)
+	if qerr != nil {
+		cnc_str()
+		return nil, nil, qerr
+	}
+
+	docs, S := startStream[D](ctx_str, cnc_str, C, mongoCursor{qcur}, opts)
+	return docs, S, nil
+}
+
+// Cursor read by a stream
+type streamCursor interface {
+	Next(ctx context.Context) bool
+	Raw() bson.Raw
+	Err() error
+	Close(ctx context.Context) error
+}
+
+type mongoCursor struct {
+	*mongo.Cursor
+}
+
+func (c mongoCursor) Raw() bson.Raw {
+	return c.Current
+}
+
+// Start the goroutine decoding the documents of qcur into the returned channel. cnc_str cancels ctx_str
+func startStream[D any](ctx_str context.Context, cnc_str context.CancelFunc, C *Collection, qcur streamCursor, opts *QueryOptions) (<-chan *D, *streamState) {
+	docs := make(chan *D, opts.Buffer)
+	S := &streamState{cancel: cnc_str, done: make(chan struct{})}
+
+	go func() {
+		defer close(S.done)
+		defer close(docs)
+		defer func() {
+			ctx_cls, cnc_cls := DefaultContext()
+			defer cnc_cls()
+			PrintError(qcur.Close(*ctx_cls))
+			if opts.logErrors {
+				PrintError(S.Err())
+			}
+		}()
+
+		for qcur.Next(ctx_str) {
+
+			d := new(D)
+			if derr := C.decode(qcur.Raw(), d); derr == ErrDenied {
+				continue
+			} else if derr != nil {
+				S.fail(decodeError(qcur.Raw().Lookup("_id").String(), derr))
+				return
+			}
+
+			select {
+			case docs <- d:
+			case <-ctx_str.Done():
+				S.fail(ctx_str.Err())
+				return
+			}
+		}
+
+		if cerr := qcur.Err(); cerr != nil {
+			S.fail(cerr)
+		}
+	}()
+
+	return docs, S
+}
+
+func (C *Collection) query_curser(ctx context.Context, filter *Filter, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	query, err := C.queryFor(filter)
+	if err != nil {
+		return nil, err
+	}
+	return C.MC.Find(ctx, query, opts...)
+}
@@ -0,0 +1,168 @@
+package moncore
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/goleak"
+)
+
+// In-memory cursor over enveloped documents. With wait, Next blocks until ctx is done once the documents are read
+type fakeCursor struct {
+	docs    []bson.Raw
+	pos     int
+	wait    bool
+	err     error
+	current bson.Raw
+	closed  atomic.Bool
+}
+
+func newFakeCursor(N int, wait bool) *fakeCursor {
+	FC := &fakeCursor{wait: wait}
+	for i := 0; i < N; i++ {
+		raw, _ := bson.Marshal(bson.D{{Key: "_id", Value: strconv.Itoa(i)}, {Key: "Doc", Value: bson.D{{Key: "n", Value: i}}}})
+		FC.docs = append(FC.docs, raw)
+	}
+	return FC
+}
+
+func (FC *fakeCursor) Next(ctx context.Context) bool {
+	if FC.pos < len(FC.docs) {
+		FC.current = FC.docs[FC.pos]
+		FC.pos++
+		return true
+	}
+	if FC.wait {
+		<-ctx.Done()
+		FC.err = ctx.Err()
+	}
+	return false
+}
+
+func (FC *fakeCursor) Raw() bson.Raw { return FC.current }
+func (FC *fakeCursor) Err() error    { return FC.err }
+
+func (FC *fakeCursor) Close(ctx context.Context) error {
+	FC.closed.Store(true)
+	return nil
+}
+
+func testStream(ctx context.Context, FC *fakeCursor, opts *QueryOptions) *DocumentStream {
+	if opts == nil {
+		opts = &QueryOptions{}
+	}
+	ctx_str, cnc_str := context.WithCancel(ctx)
+	docs, S := startStream[GenericDBDocument](ctx_str, cnc_str, &Collection{Mode: ModeEnveloped}, FC, opts)
+	return &DocumentStream{Docs: docs, streamState: S}
+}
+
+func TestStreamReadsAll(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	FC := newFakeCursor(10, false)
+	S := testStream(context.Background(), FC, nil)
+
+	n := 0
+	for d := range S.Docs {
+		if d.ID != strconv.Itoa(n) {
+			t.Fatalf("document %d has _id %q", n, d.ID)
+		}
+		n++
+	}
+	S.Close()
+
+	if n != 10 {
+		t.Fatalf("read %d documents, want 10", n)
+	}
+	if err := S.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	if !FC.closed.Load() {
+		t.Fatal("cursor not closed")
+	}
+}
+
+func TestStreamCloseEarly(t *testing.T) {
+	for _, buffer := range []int{0, 1, 100} {
+		t.Run("buffer "+strconv.Itoa(buffer), func(t *testing.T) {
+			defer goleak.VerifyNone(t)
+
+			FC := newFakeCursor(50, true)
+			S := testStream(context.Background(), FC, &QueryOptions{Buffer: buffer})
+
+			<-S.Docs
+			S.Close()
+			S.Close() // safe twice
+
+			if err := S.Err(); err != nil {
+				t.Fatalf("Close() is not an error, Err() = %v", err)
+			}
+			if !FC.closed.Load() {
+				t.Fatal("cursor not closed")
+			}
+		})
+	}
+}
+
+func TestStreamContextCancel(t *testing.T) {
+	for _, name := range []string{"blocked sending", "waiting for the server"} {
+		t.Run(name, func(t *testing.T) {
+			defer goleak.VerifyNone(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			FC := newFakeCursor(5, true)
+			S := testStream(ctx, FC, nil)
+
+			if name == "waiting for the server" {
+				for i := 0; i < 5; i++ {
+					<-S.Docs
+				}
+			}
+			cancel()
+
+			for range S.Docs {
+			}
+			if err := S.Err(); !errors.Is(err, context.Canceled) {
+				t.Fatalf("Err() = %v, want context.Canceled", err)
+			}
+			if !FC.closed.Load() {
+				t.Fatal("cursor not closed")
+			}
+			S.Close()
+		})
+	}
+}
+
+func TestStreamDecodeError(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	FC := newFakeCursor(2, true)
+	FC.docs[1], _ = bson.Marshal(bson.D{{Key: "_id", Value: "1"}, {Key: "Doc", Value: "not a document"}})
+	S := testStream(context.Background(), FC, nil)
+
+	n := 0
+	for range S.Docs {
+		n++
+	}
+	if n != 1 {
+		t.Fatalf("read %d documents before the error, want 1", n)
+	}
+	if S.Err() == nil {
+		t.Fatal("decode error not reported")
+	}
+	S.Close()
+}
+
+// QueryToChannel callers can't read Err() : the stream goroutine logs it and nothing waits for it
+func TestStreamLoggedErrorsDontLeak(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	S := testStream(context.Background(), newFakeCursor(3, false), &QueryOptions{logErrors: true})
+	for range S.Docs {
+	}
+	<-S.done
+}
@@ -0,0 +1,770 @@
+package moncore
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Export and import formats. Documents are {_id, Doc} envelopes, whatever the collection mode.
+// Imports also accept plain documents with an _id field (a new ObjectID is used when it is missing)
+const (
+	TransferNDJSON = "ndjson" // One relaxed Extended JSON document per line
+	TransferJSON   = "json"   // Array of relaxed Extended JSON documents
+	TransferCSV    = "csv"    // Header row, then one row per document. See FieldMapping
+	TransferBSON   = "bson"   // Concatenated BSON documents, like mongodump files
+)
+
+// How Import writes documents whose _id already exists
+const (
+	ImportUpsert  = "upsert"  // Merge top-level fields into the existing document, insert missing ones
+	ImportReplace = "replace" // Replace the whole document, insert missing ones
+	ImportInsert  = "insert"  // Only insert new documents. Existing ones are left untouched and counted as skipped
+)
+
+// Documents per bulk write of Import
+var ImportBatchSize = 500
+
+// Failures kept in ImportResult.Errors
+var MaxImportErrors = 100
+
+// CSV column of a document field. Type is how cells are read on import :
+// "string" (default), "int", "float", "bool", "date" (RFC 3339) or "json" (Extended JSON)
+type FieldMapping struct {
+	Column string
+	Path   string // Dotted path in the document. "_id" is the document key
+	Type   string
+}
+
+// Field mappings like "_id,name,email=contact.email,age:int,born=birth.date:date".
+// Each item is column[=path][:type], the path defaults to the column
+func ParseFieldMappings(Spec string) ([]FieldMapping, error) {
+	out := []FieldMapping{}
+	for _, item := range strings.Split(Spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		M := FieldMapping{Type: "string"}
+		if i := strings.LastIndex(item, ":"); i >= 0 {
+			M.Type = item[i+1:]
+			item = item[:i]
+		}
+		M.Column, M.Path, _ = strings.Cut(item, "=")
+		if M.Path == "" {
+			M.Path = M.Column
+		}
+
+		switch M.Type {
+		case "string", "int", "float", "bool", "date", "json":
+		default:
+			return nil, errors.New("unknown type " + M.Type + " of column " + M.Column)
+		}
+		if M.Path != "_id" {
+			if err := ValidateFieldPath(M.Path); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, M)
+	}
+	return out, nil
+}
+
+type ExportOptions struct {
+	Format string         // Transfer format, TransferNDJSON if ""
+	Filter *Filter        // nil exports every document
+	Limit  int64          // 0 means no limit
+	Fields []FieldMapping // CSV columns. Default is _id then the top-level fields of the first document : other fields are left out, with a warning
+}
+
+// Write documents of the collection in a transfer format. Returns the number of documents written.
+// Documents denied by the guard are left out, hidden fields are removed
+func (C *Collection) Export(ctx context.Context, W io.Writer, opts *ExportOptions) (int64, error) {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+	filter := opts.Filter
+	if filter == nil {
+		filter = Filter_MatchAll()
+	}
+
+	var write func(*GenericDBDocument) error
+	var end func() error
+
+	BW := bufio.NewWriter(W)
+	switch opts.Format {
+	case TransferNDJSON, "":
+		write = func(D *GenericDBDocument) error {
+			J, err := bson.MarshalExtJSON(D, false, false)
+			if err == nil {
+				BW.Write(J)
+				err = BW.WriteByte('\n')
+			}
+			return err
+		}
+
+	case TransferJSON:
+		n := 0
+		BW.WriteString("[")
+		write = func(D *GenericDBDocument) error {
+			J, err := bson.MarshalExtJSON(D, false, false)
+			if err != nil {
+				return err
+			}
+			if n++; n > 1 {
+				BW.WriteString(",")
+			}
+			BW.WriteString("\n")
+			_, err = BW.Write(J)
+			return err
+		}
+		end = func() error {
+			_, err := BW.WriteString("\n]\n")
+			return err
+		}
+
+	case TransferBSON:
+		write = func(D *GenericDBDocument) error {
+			B, err := bson.Marshal(D)
+			if err == nil {
+				_, err = BW.Write(B)
+			}
+			return err
+		}
+
+	case TransferCSV:
+		write, end = csvExporter(BW, opts.Fields, func(Msg string) {
+			Print("CSV export of " + C.DatabaseName() + "/" + C.Name() + " : " + Msg)
+		})
+
+	default:
+		return 0, errors.New("unknown export format " + opts.Format)
+	}
+
+	Stream, err := C.Stream(ctx, filter, &QueryOptions{BatchSize: int32(ImportBatchSize), Buffer: 64, Limit: opts.Limit})
+	if err != nil {
+		return 0, err
+	}
+	defer Stream.Close()
+
+	written := int64(0)
+	for D := range Stream.Docs {
+		if err := write(D); err != nil {
+			return written, err
+		}
+		written++
+	}
+	if err := Stream.Err(); err != nil {
+		return written, err
+	}
+
+	if end != nil {
+		if err := end(); err != nil {
+			return written, err
+		}
+	}
+	return written, BW.Flush()
+}
+
+// Write and end functions of a CSV export. The header row comes from Fields, or from the first document without Fields.
+// Then, the end calls Warn with the top-level fields of later documents that had no column
+func csvExporter(W io.Writer, Fields []FieldMapping, Warn func(string)) (func(*GenericDBDocument) error, func() error) {
+	CW := csv.NewWriter(W)
+	fields := Fields
+	headerWritten := false
+	columns := map[string]bool{}
+	left := map[string]bool{}
+
+	writeHeader := func() error {
+		headerWritten = true
+		header := make([]string, len(fields))
+		for i, M := range fields {
+			header[i] = M.Column
+		}
+		return CW.Write(header)
+	}
+
+	write := func(D *GenericDBDocument) error {
+		if !headerWritten {
+			if len(fields) == 0 {
+				fields = defaultFieldMappings(D)
+				for _, M := range fields {
+					columns[M.Path] = true
+				}
+			}
+			if err := writeHeader(); err != nil {
+				return err
+			}
+		}
+		if len(Fields) == 0 {
+			for k := range D.Doc {
+				if !columns[k] {
+					left[k] = true
+				}
+			}
+		}
+
+		row := make([]string, len(fields))
+		for i, M := range fields {
+			if M.Path == "_id" {
+				row[i] = D.ID
+			} else if v, found := lookupPath(D.Doc, M.Path); found {
+				row[i] = csvCell(v)
+			}
+		}
+		return CW.Write(row)
+	}
+
+	end := func() error {
+		if !headerWritten && len(fields) != 0 { // Header of an empty export
+			writeHeader()
+		}
+		if len(left) != 0 {
+			names := make([]string, 0, len(left))
+			for k := range left {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			Warn("fields " + strings.Join(names, ", ") + " are not in the first document and were left out. Give the fields to export them")
+		}
+		CW.Flush()
+		return CW.Error()
+	}
+	return write, end
+}
+
+func defaultFieldMappings(D *GenericDBDocument) []FieldMapping {
+	keys := make([]string, 0, len(D.Doc))
+	for k := range D.Doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := []FieldMapping{{Column: "_id", Path: "_id", Type: "string"}}
+	for _, k := range keys {
+		out = append(out, FieldMapping{Column: k, Path: k, Type: "string"})
+	}
+	return out
+}
+
+// CSV text of a value. Documents and arrays are relaxed Extended JSON
+func csvCell(v interface{}) string {
+	switch V := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return V
+	case bool:
+		return strconv.FormatBool(V)
+	case int32:
+		return strconv.FormatInt(int64(V), 10)
+	case int64:
+		return strconv.FormatInt(V, 10)
+	case float64:
+		return strconv.FormatFloat(V, 'g', -1, 64)
+	case primitive.DateTime:
+		return V.Time().UTC().Format(time.RFC3339Nano)
+	case primitive.ObjectID:
+		return V.Hex()
+	}
+
+	J, err := bson.MarshalExtJSON(bson.M{"v": v}, false, false)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(string(J), `{"v":`), "}")
+}
+
+type ImportOptions struct {
+	Format    string         // Transfer format, TransferNDJSON if ""
+	Mode      string         // ImportUpsert (default), ImportReplace or ImportInsert
+	BatchSize int            // Documents per bulk write, ImportBatchSize if 0
+	Fields    []FieldMapping // CSV columns. Default is every header column as a string field, "_id" being the key
+	KeepGoing bool           // Go on after failed documents. Otherwise Import stops after the batch of the first failure
+}
+
+type ImportResult struct {
+	Read     int64 // Records read
+	Inserted int64
+	Updated  int64
+	Skipped  int64 // Existing documents in insert mode
+	Failed   int64
+	Errors   []ImportError // The first MaxImportErrors failures
+}
+
+// Failed record of an import. Record counts from 1
+type ImportError struct {
+	Record  int64
+	ID      string
+	Message string
+}
+
+// Unreadable import input, like a truncated BSON document or a malformed CSV row.
+// Record is the record being read, 0 for the header or start of the input
+type ImportInputError struct {
+	Record int64
+	Err    error
+}
+
+func (E *ImportInputError) Error() string {
+	if E.Record == 0 {
+		return "can't read import : " + E.Err.Error()
+	}
+	return "can't read import record " + strconv.FormatInt(E.Record, 10) + " : " + E.Err.Error()
+}
+
+func (E *ImportInputError) Unwrap() error {
+	return E.Err
+}
+
+// One document read by an import
+type importRecord struct {
+	n   int64
+	ID  string
+	Doc bson.D
+	err error
+}
+
+// Read documents in a transfer format and write them to the collection in bulk.
+// Guards and field masks apply to every document, like Set().
+// The error is about the input or the database. Rejected documents are reported in the result
+func (C *Collection) Import(ctx context.Context, R io.Reader, opts *ImportOptions) (*ImportResult, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	switch opts.Mode {
+	case "":
+		opts.Mode = ImportUpsert
+	case ImportUpsert, ImportReplace, ImportInsert:
+	default:
+		return nil, errors.New("unknown import mode " + opts.Mode)
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = ImportBatchSize
+	}
+
+	next, err := importReader(R, opts)
+	if err != nil {
+		return nil, &ImportInputError{Record: 0, Err: err}
+	}
+
+	Res := &ImportResult{}
+	batch := make([]*importRecord, 0, batchSize)
+	for {
+		rec, rerr := next()
+		if rerr != nil && rerr != io.EOF {
+			return Res, &ImportInputError{Record: Res.Read + 1, Err: rerr}
+		}
+
+		if rec != nil {
+			Res.Read++
+			if rec.err != nil {
+				Res.fail(rec, rec.err.Error())
+			} else {
+				batch = append(batch, rec)
+			}
+		}
+
+		if len(batch) == batchSize || (rerr == io.EOF && len(batch) != 0) {
+			if err := C.importBatch(ctx, batch, opts.Mode, Res); err != nil {
+				return Res, err
+			}
+			batch = batch[:0]
+		}
+
+		if rerr == io.EOF || (Res.Failed != 0 && !opts.KeepGoing) {
+			return Res, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return Res, err
+		}
+	}
+}
+
+func (Res *ImportResult) fail(rec *importRecord, Message string) {
+	Res.Failed++
+	if len(Res.Errors) < MaxImportErrors {
+		Res.Errors = append(Res.Errors, ImportError{Record: rec.n, ID: rec.ID, Message: Message})
+	}
+}
+
+// Function returning the next record, or io.EOF
+func importReader(R io.Reader, opts *ImportOptions) (func() (*importRecord, error), error) {
+	n := int64(0)
+
+	switch opts.Format {
+	case TransferNDJSON, "":
+		lines := bufio.NewReaderSize(R, 64*1024)
+		return func() (*importRecord, error) {
+			for {
+				line, err := lines.ReadBytes('\n')
+				if err != nil && err != io.EOF {
+					return nil, err
+				}
+				if line = []byte(strings.TrimSpace(string(line))); len(line) != 0 {
+					n++
+					return extJSONRecord(n, line), nil
+				}
+				if err == io.EOF {
+					return nil, io.EOF
+				}
+			}
+		}, nil
+
+	case TransferJSON:
+		D := json.NewDecoder(R)
+		if tok, err := D.Token(); err != nil {
+			return nil, err
+		} else if tok != json.Delim('[') {
+			return nil, errors.New("JSON imports must be an array of documents")
+		}
+		return func() (*importRecord, error) {
+			if !D.More() {
+				return nil, io.EOF
+			}
+			raw := json.RawMessage{}
+			if err := D.Decode(&raw); err != nil {
+				return nil, err
+			}
+			n++
+			return extJSONRecord(n, raw), nil
+		}, nil
+
+	case TransferBSON:
+		BR := bufio.NewReaderSize(R, 64*1024)
+		return func() (*importRecord, error) {
+			raw, err := readBSONDocument(BR)
+			if err != nil {
+				return nil, err
+			}
+
+			n++
+			D := bson.D{}
+			if err := bson.Unmarshal(raw, &D); err != nil {
+				return &importRecord{n: n, err: err}, nil
+			}
+			return documentRecord(n, D), nil
+		}, nil
+
+	case TransferCSV:
+		CR := csv.NewReader(R)
+		CR.FieldsPerRecord = -1
+		header, err := CR.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		// Columns of the header, with the mapping of the options or as string fields
+		fields := make([]*FieldMapping, len(header))
+		for i, column := range header {
+			for j := range opts.Fields {
+				if opts.Fields[j].Column == column {
+					fields[i] = &opts.Fields[j]
+				}
+			}
+			if fields[i] == nil && len(opts.Fields) == 0 {
+				fields[i] = &FieldMapping{Column: column, Path: column, Type: "string"}
+			}
+		}
+
+		return func() (*importRecord, error) {
+			row, err := CR.Read()
+			if err != nil {
+				return nil, err
+			}
+			n++
+			return csvRecord(n, fields, row), nil
+		}, nil
+	}
+	return nil, errors.New("unknown import format " + opts.Format)
+}
+
+// Largest document read from BSON input, over the 16 MB server limit to leave room for envelopes
+var MaxBSONDocumentSize = 48 * 1024 * 1024
+
+// Next document of concatenated BSON documents, or io.EOF at the end
+func readBSONDocument(R io.Reader) (bson.Raw, error) {
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(R, size); err != nil {
+		return nil, err
+	}
+	length := int(binary.LittleEndian.Uint32(size))
+	if length < 5 || length > MaxBSONDocumentSize {
+		return nil, fmt.Errorf("bad BSON document length %d", length)
+	}
+
+	raw := make([]byte, length)
+	copy(raw, size)
+	if _, err := io.ReadFull(R, raw[4:]); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+func extJSONRecord(n int64, Src []byte) *importRecord {
+	D := bson.D{}
+	if err := bson.UnmarshalExtJSON(Src, false, &D); err != nil {
+		return &importRecord{n: n, err: err}
+	}
+	return documentRecord(n, D)
+}
+
+// Record of an {_id, Doc} envelope, or of a plain document with an optional _id
+func documentRecord(n int64, D bson.D) *importRecord {
+	rec := &importRecord{n: n}
+
+	var id interface{}
+	hasID := false
+	var doc interface{}
+	hasDoc := false
+	for _, e := range D {
+		switch e.Key {
+		case "_id":
+			id, hasID = e.Value, true
+		case "Doc":
+			doc, hasDoc = e.Value, true
+		}
+	}
+
+	if hasID {
+		rec.ID = idString(id)
+	} else {
+		rec.ID = primitive.NewObjectID().Hex()
+	}
+
+	if hasDoc && len(D) == 2 {
+		fields, ok := doc.(bson.D)
+		if !ok {
+			rec.err = errors.New("Doc must be a document")
+			return rec
+		}
+		rec.Doc = fields
+		return rec
+	}
+
+	rec.Doc = bson.D{}
+	for _, e := range D {
+		if e.Key != "_id" {
+			rec.Doc = append(rec.Doc, e)
+		}
+	}
+	return rec
+}
+
+func csvRecord(n int64, Fields []*FieldMapping, Row []string) *importRecord {
+	rec := &importRecord{n: n}
+	doc := bson.M{}
+
+	for i, cell := range Row {
+		if i >= len(Fields) || Fields[i] == nil {
+			continue
+		}
+		M := Fields[i]
+		if M.Path == "_id" {
+			rec.ID = cell
+			continue
+		}
+		if cell == "" && M.Type != "string" {
+			continue
+		}
+
+		v, err := csvValue(cell, M.Type)
+		if err != nil {
+			rec.err = errors.New("column " + M.Column + " : " + err.Error())
+			return rec
+		}
+		setPath(doc, strings.Split(M.Path, "."), v)
+	}
+
+	if rec.ID == "" {
+		rec.ID = primitive.NewObjectID().Hex()
+	}
+
+	D, err := bson.Marshal(doc)
+	if err == nil {
+		err = bson.Unmarshal(D, &rec.Doc)
+	}
+	rec.err = err
+	return rec
+}
+
+func csvValue(Cell string, Type string) (interface{}, error) {
+	switch Type {
+	case "int":
+		return strconv.ParseInt(strings.TrimSpace(Cell), 10, 64)
+	case "float":
+		f, err := strconv.ParseFloat(strings.TrimSpace(Cell), 64)
+		if err == nil && (math.IsInf(f, 0) || math.IsNaN(f)) {
+			err = errors.New("not a finite number")
+		}
+		return f, err
+	case "bool":
+		return strconv.ParseBool(strings.TrimSpace(Cell))
+	case "date":
+		t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(Cell))
+		return primitive.NewDateTimeFromTime(t), err
+	case "json":
+		wrapped := bson.M{}
+		if err := bson.UnmarshalExtJSON([]byte(`{"v":`+Cell+`}`), false, &wrapped); err != nil {
+			return nil, err
+		}
+		return wrapped["v"], nil
+	}
+	return Cell, nil
+}
+
+// Write a batch of records with one bulk write
+func (C *Collection) importBatch(ctx context.Context, Batch []*importRecord, Mode string, Res *ImportResult) error {
+	models := make([]mongo.WriteModel, 0, len(Batch))
+	written := make([]*importRecord, 0, len(Batch))
+	raw := C.ResolvedMode() == ModeRaw
+	checked := C.Guard != nil || !C.Masks.empty()
+	truebool := true
+
+	for _, rec := range Batch {
+
+		if checked {
+			// Writes go through the guard and masks like Set(), one at a time : merges are done here,
+			// then the document is replaced only if it didn't change since it was checked
+			stored, cond, err := C.stored(rec.ID)
+			if err != nil {
+				return err
+			}
+			if Mode == ImportInsert && cond.found {
+				Res.Skipped++
+				continue
+			}
+			var doc interface{} = rec.Doc
+			if Mode == ImportUpsert && cond.found {
+				doc = mergeFields(stored, rec.Doc)
+			}
+
+			prepared, failed := C.prepareChecked(&DBDocument{ID: rec.ID, Doc: doc}, stored, cond)
+			if failed != nil {
+				Res.fail(rec, failed.Action+" : "+failed.Result)
+				continue
+			}
+
+			switch res := C.writeChecked(prepared, cond); {
+			case res.Status == 1 && res.Action == ChangeInsert:
+				Res.Inserted++
+			case res.Status == 1:
+				Res.Updated++
+			case Mode == ImportInsert && res.Action == "changed":
+				Res.Skipped++ // created meanwhile
+			default:
+				Res.fail(rec, res.Action+" : "+res.Result)
+			}
+			continue
+		}
+
+		var replacement interface{}
+		if raw {
+			R, err := C.rawReplacement(&DBDocument{ID: rec.ID, Doc: rec.Doc})
+			if err != nil {
+				Res.fail(rec, err.Error())
+				continue
+			}
+			replacement = R
+		} else {
+			replacement = bson.D{{Key: "_id", Value: rec.ID}, {Key: "Doc", Value: rec.Doc}}
+		}
+
+		switch {
+		case Mode == ImportInsert:
+			models = append(models, mongo.NewInsertOneModel().SetDocument(replacement))
+
+		case Mode == ImportUpsert && len(rec.Doc) != 0:
+			set := bson.D{}
+			for _, e := range rec.Doc {
+				set = append(set, bson.E{Key: C.fieldPrefix() + e.Key, Value: e.Value})
+			}
+			models = append(models, mongo.NewUpdateOneModel().SetFilter(C.idFilter(rec.ID)).SetUpdate(bson.M{"$set": set}).SetUpsert(true))
+
+		default:
+			models = append(models, &mongo.ReplaceOneModel{Filter: C.idFilter(rec.ID), Replacement: replacement, Upsert: &truebool})
+		}
+		written = append(written, rec)
+	}
+
+	if len(models) == 0 {
+		return nil
+	}
+
+	ordered := false
+	result, err := C.MC.BulkWrite(ctx, models, &options.BulkWriteOptions{Ordered: &ordered})
+
+	failed := map[int]bool{}
+	var BE mongo.BulkWriteException
+	if errors.As(err, &BE) {
+		for _, WE := range BE.WriteErrors {
+			failed[WE.Index] = true
+			if Mode == ImportInsert && WE.Code == 11000 { // Duplicate key : the document exists
+				Res.Skipped++
+			} else {
+				Res.fail(written[WE.Index], WE.Message)
+			}
+		}
+		if BE.WriteConcernError != nil {
+			return BE
+		}
+	} else if err != nil {
+		return err
+	}
+
+	inserted := map[int64]bool{}
+	if result != nil {
+		for i := range result.UpsertedIDs {
+			inserted[i] = true
+		}
+	}
+
+	for i, rec := range written {
+		if failed[i] {
+			continue
+		}
+		Type := ChangeUpdate
+		if Mode == ImportInsert || inserted[int64(i)] {
+			Type = ChangeInsert
+			Res.Inserted++
+		} else {
+			Res.Updated++
+		}
+		if hasChangeListeners() {
+			C.emitChange(Type, rec.ID, C.unguarded().Get(rec.ID))
+		}
+	}
+	return nil
+}
+
+// Stored document with the top-level fields of D set
+func mergeFields(Stored interface{}, D bson.D) bson.M {
+	out := bson.M{}
+	if M, ok := Stored.(bson.M); ok {
+		for k, v := range M {
+			out[k] = v
+		}
+	}
+	for _, e := range D {
+		out[e.Key] = e.Value
+	}
+	return out
+}
@@ -0,0 +1,156 @@
+package moncore
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Documents as decoded by a stream
+func transferDocs(t *testing.T, Docs ...bson.D) []*GenericDBDocument {
+	out := []*GenericDBDocument{}
+	for _, D := range Docs {
+		raw, err := bson.Marshal(D)
+		if err != nil {
+			t.Fatal(err)
+		}
+		G := &GenericDBDocument{}
+		if err := bson.Unmarshal(raw, G); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, G)
+	}
+	return out
+}
+
+// CSV export of the documents, and its warnings
+func exportCSV(t *testing.T, Fields []FieldMapping, Docs []*GenericDBDocument) (string, []string) {
+	var buf bytes.Buffer
+	warnings := []string{}
+	write, end := csvExporter(&buf, Fields, func(Msg string) { warnings = append(warnings, Msg) })
+	for _, D := range Docs {
+		if err := write(D); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := end(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String(), warnings
+}
+
+func importAll(t *testing.T, Src string, opts *ImportOptions) []*importRecord {
+	next, err := importReader(strings.NewReader(Src), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := []*importRecord{}
+	for {
+		rec, err := next()
+		if err == io.EOF {
+			return out
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if rec.err != nil {
+			t.Fatalf("record %d : %v", rec.n, rec.err)
+		}
+		out = append(out, rec)
+	}
+}
+
+func TestCSVRoundTripWithFields(t *testing.T) {
+	born := time.Date(1990, 5, 17, 8, 30, 0, 0, time.UTC)
+	docs := transferDocs(t,
+		bson.D{{Key: "_id", Value: "u1"}, {Key: "Doc", Value: bson.D{
+			{Key: "name", Value: "Ada, \"the first\""},
+			{Key: "contact", Value: bson.D{{Key: "email", Value: "ada@example.com"}}},
+			{Key: "age", Value: int32(36)},
+			{Key: "birth", Value: bson.D{{Key: "date", Value: primitive.NewDateTimeFromTime(born)}}},
+			{Key: "tags", Value: bson.A{"a", "b"}},
+			{Key: "secret", Value: "not exported"},
+		}}},
+		bson.D{{Key: "_id", Value: "u2"}, {Key: "Doc", Value: bson.D{{Key: "name", Value: "Bob"}}}},
+	)
+
+	fields, err := ParseFieldMappings("_id,name,email=contact.email,age:int,born=birth.date:date,tags:json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, warnings := exportCSV(t, fields, docs)
+	if len(warnings) != 0 {
+		t.Fatalf("warnings with fields : %q", warnings)
+	}
+	if header := strings.SplitN(out, "\n", 2)[0]; header != "_id,name,email,age,born,tags" {
+		t.Fatalf("header = %q", header)
+	}
+
+	recs := importAll(t, out, &ImportOptions{Format: TransferCSV, Fields: fields})
+	if len(recs) != 2 {
+		t.Fatalf("imported %d records, want 2 :\n%s", len(recs), out)
+	}
+
+	got := bson.M{}
+	raw, _ := bson.Marshal(recs[0].Doc)
+	bson.Unmarshal(raw, &got)
+
+	if recs[0].ID != "u1" || recs[1].ID != "u2" {
+		t.Fatalf("ids %q, %q", recs[0].ID, recs[1].ID)
+	}
+	if got["name"] != "Ada, \"the first\"" || got["age"] != int64(36) {
+		t.Fatalf("imported %v", got)
+	}
+	if v, _ := lookupPath(got, "contact.email"); v != "ada@example.com" {
+		t.Fatalf("contact.email = %v", v)
+	}
+	if v, _ := lookupPath(got, "birth.date"); v != primitive.NewDateTimeFromTime(born) {
+		t.Fatalf("birth.date = %v", v)
+	}
+	if v, _ := lookupPath(got, "tags.1"); v != "b" {
+		t.Fatalf("tags = %v", got["tags"])
+	}
+	if _, found := got["secret"]; found {
+		t.Fatal("unmapped field exported")
+	}
+	for _, e := range recs[1].Doc {
+		if e.Key == "age" || e.Key == "birth" || e.Key == "tags" {
+			t.Fatalf("empty typed cell imported : %v", recs[1].Doc)
+		}
+	}
+}
+
+func TestCSVRoundTripDefaultFields(t *testing.T) {
+	docs := transferDocs(t,
+		bson.D{{Key: "_id", Value: "a"}, {Key: "Doc", Value: bson.D{{Key: "x", Value: "1"}, {Key: "y", Value: "2"}}}},
+		bson.D{{Key: "_id", Value: "b"}, {Key: "Doc", Value: bson.D{{Key: "y", Value: "3"}, {Key: "z", Value: "4"}}}},
+	)
+
+	out, warnings := exportCSV(t, nil, docs)
+	if out != "_id,x,y\na,1,2\nb,,3\n" {
+		t.Fatalf("export = %q", out)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "fields z ") {
+		t.Fatalf("warnings = %q, want one about z", warnings)
+	}
+
+	recs := importAll(t, out, &ImportOptions{Format: TransferCSV})
+	if len(recs) != 2 || recs[1].ID != "b" {
+		t.Fatalf("imported %d records", len(recs))
+	}
+}
+
+func TestCSVEmptyExport(t *testing.T) {
+	fields, _ := ParseFieldMappings("_id,name")
+	if out, _ := exportCSV(t, fields, nil); out != "_id,name\n" {
+		t.Fatalf("export = %q", out)
+	}
+	if out, _ := exportCSV(t, nil, nil); out != "" {
+		t.Fatalf("export without fields = %q", out)
+	}
+}
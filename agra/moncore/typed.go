@@ -0,0 +1,130 @@
+package moncore
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Returned when a document doesn't exist
+var ErrNotFound = errors.New("document not found")
+
+// Document structure with Doc decoded into T
+type TypedDBDocument[T any] struct {
+	ID  string `bson:"_id"`
+	Doc T      `bson:"Doc"`
+}
+
+// Collection wrapper that encodes and decodes T directly into the Doc envelope
+type TypedCollection[T any] struct {
+	*Collection
+}
+
+// Documents of type T streamed from an open cursor. See DocumentStream
+type TypedStream[T any] struct {
+	Docs <-chan *TypedDBDocument[T]
+	*streamState
+}
+
+// Error decoding a stored document into a Go type
+type DocumentDecodeError struct {
+	ID    string // Document ID, if known
+	Field string // Dotted path of the failing field, like 'Doc.address.zip'. Empty if unknown
+	Err   error
+}
+
+func (E *DocumentDecodeError) Error() string {
+	msg := "can't decode document " + E.ID
+	if E.Field != "" {
+		msg += " field " + E.Field
+	}
+	return msg + " : " + E.Err.Error()
+}
+
+func (E *DocumentDecodeError) Unwrap() error {
+	return E.Err
+}
+
+func decodeError(ID string, err error) error {
+	E := &DocumentDecodeError{ID: strings.Trim(ID, `"`), Err: err}
+
+	var de *bsoncodec.DecodeError
+	if errors.As(err, &de) {
+		E.Field = strings.Join(de.Keys(), ".")
+	}
+	return E
+}
+
+// Use the collection with documents of type T
+func Typed[T any](C *Collection) *TypedCollection[T] {
+	return &TypedCollection[T]{Collection: C}
+}
+
+// Get a document by ID. Returns ErrNotFound if it doesn't exist, ErrDenied if the guard denies it.
+func (TC *TypedCollection[T]) Get(key string) (*T, error) {
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	out := TypedDBDocument[T]{}
+	err := TC.findOne(*ctx_dbr, key, &out)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err == ErrDenied {
+		return nil, err
+	}
+	if err != nil {
+		return nil, decodeError(key, err)
+	}
+
+	return &out.Doc, nil
+}
+
+// Insert or update a document. See Collection.SetDocument()
+func (TC *TypedCollection[T]) Set(key string, val T) WriteOperationResponse {
+	return TC.SetDocument(&DBDocument{ID: key, Doc: val})
+}
+
+// Insert a document, never replacing one. See Collection.InsertDocument()
+func (TC *TypedCollection[T]) Insert(key string, val T) WriteOperationResponse {
+	return TC.InsertDocument(&DBDocument{ID: key, Doc: val})
+}
+
+// Query documents matching the filter
+func (TC *TypedCollection[T]) Query(filter *Filter) ([]TypedDBDocument[T], error) {
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	qcur, qerr := TC.query_curser(*ctx_dbr, filter)
+	if qerr != nil {
+		return nil, qerr
+	}
+	defer qcur.Close(*ctx_dbr)
+
+	out := []TypedDBDocument[T]{}
+
+	for qcur.Next(*ctx_dbr) {
+		d := TypedDBDocument[T]{}
+		if derr := TC.decode(qcur.Current, &d); derr == ErrDenied {
+			continue
+		} else if derr != nil {
+			return nil, decodeError(qcur.Current.Lookup("_id").String(), derr)
+		}
+		out = append(out, d)
+	}
+
+	return out, qcur.Err()
+}
+
+// Stream documents matching the filter. See Collection.Stream()
+func (TC *TypedCollection[T]) Stream(ctx context.Context, filter *Filter, opts *QueryOptions) (*TypedStream[T], error) {
+	docs, state, err := openStream[TypedDBDocument[T]](ctx, TC.Collection, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedStream[T]{Docs: docs, streamState: state}, nil
+}
@@ -0,0 +1,552 @@
+package moncore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// Database holding mongomini's own bookkeeping collections. Writes to it never trigger webhooks.
+	SystemDBName string = "mongomini"
+
+	WebhooksCollectionName           string = "webhooks"
+	WebhookDeliveriesCollectionName  string = "webhook_deliveries"
+	WebhookDeadLettersCollectionName string = "webhook_deadletters"
+
+	WebhookMaxAttempts    int           = 6
+	WebhookInitialBackoff time.Duration = 1 * time.Second
+	WebhookMaxBackoff     time.Duration = 1 * time.Minute
+	WebhookRequestTimeout time.Duration = 10 * time.Second
+
+	// How long registrations are cached before being reloaded from the system collection
+	WebhookCacheTTL time.Duration = 30 * time.Second
+
+	// How long a delivery being attempted is hidden from other instances
+	WebhookLease time.Duration = 2 * WebhookRequestTimeout
+
+	// How often DeliverDue looks for due deliveries when this instance queued none
+	WebhookPollInterval time.Duration = 5 * time.Second
+
+	// Allow webhook URLs on loopback, link-local and private addresses. Only for tests and local setups
+	WebhookAllowPrivate bool = false
+)
+
+// Webhook registration. Stored in SystemDBName/WebhooksCollectionName
+type Webhook struct {
+	ID         string              `bson:"-" json:"id"`
+	Database   string              `bson:"Database" json:"database"`     // Database name or path.Match pattern ('*' matches all)
+	Collection string              `bson:"Collection" json:"collection"` // Collection name or path.Match pattern ('*' matches all)
+	Filter     map[string][]string `bson:"Filter" json:"filter"`         // Query string filter. See Filter_FromQueryStrings()
+	Events     []string            `bson:"Events" json:"events"`         // ChangeInsert | ChangeUpdate | ChangeDelete. Empty means all
+	URL        string              `bson:"URL" json:"url"`
+	Secret     string              `bson:"Secret" json:"secret,omitempty"` // HMAC-SHA256 key used to sign deliveries
+	Disabled   bool                `bson:"Disabled" json:"disabled"`
+	Created    time.Time           `bson:"Created" json:"created"`
+}
+
+// Webhook payload sent as the HTTP POST body
+type WebhookPayload struct {
+	Delivery   string          `json:"delivery"`
+	Webhook    string          `json:"webhook"`
+	Event      string          `json:"event"`
+	Database   string          `json:"database"`
+	Collection string          `json:"collection"`
+	DocumentID string          `json:"document_id"`
+	Document   GenericDocument `json:"document,omitempty"`
+	Time       time.Time       `json:"time"`
+}
+
+// Delivery waiting for its next attempt. Stored in SystemDBName/WebhookDeliveriesCollectionName, under the
+// delivery ID, until it succeeds or fails WebhookMaxAttempts times
+type WebhookDelivery struct {
+	Webhook     string    `bson:"Webhook" json:"webhook"`
+	Event       string    `bson:"Event" json:"event"`
+	Payload     string    `bson:"Payload" json:"payload"`
+	Attempts    int       `bson:"Attempts" json:"attempts"` // Failed attempts so far
+	NextAttempt time.Time `bson:"NextAttempt" json:"next_attempt"`
+	LastStatus  int       `bson:"LastStatus" json:"last_status"`
+	LastError   string    `bson:"LastError" json:"last_error"`
+}
+
+// Dead-letter record of a delivery that failed WebhookMaxAttempts times.
+// Stored in SystemDBName/WebhookDeadLettersCollectionName
+type WebhookDeadLetter struct {
+	Webhook    string    `bson:"Webhook" json:"webhook"`
+	URL        string    `bson:"URL" json:"url"`
+	Payload    string    `bson:"Payload" json:"payload"`
+	Attempts   int       `bson:"Attempts" json:"attempts"`
+	LastStatus int       `bson:"LastStatus" json:"last_status"`
+	LastError  string    `bson:"LastError" json:"last_error"`
+	Time       time.Time `bson:"Time" json:"time"`
+}
+
+// Delivers signed webhooks for document changes.
+// Writes queue deliveries in the system database and DeliverDue sends them, so no delivery is lost when
+// the process stops (like a serverless instance between requests). Nothing but caches is kept in memory.
+type WebhookDispatcher struct {
+	MC     *Moncore
+	Client *http.Client
+
+	mu        sync.Mutex
+	cache     []Webhook
+	cacheAt   time.Time
+	queued    bool
+	checkedAt time.Time
+}
+
+// Queue webhook deliveries for every write made through Collection. See DeliverDue
+func (MC *Moncore) EnableWebhooks() *WebhookDispatcher {
+	W := &WebhookDispatcher{MC: MC, Client: webhookClient()}
+	OnChange(W.dispatch)
+	return W
+}
+
+// HTTP client that only connects to public addresses (unless WebhookAllowPrivate), whatever the URL resolves to when delivering
+func webhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: WebhookRequestTimeout,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || (!WebhookAllowPrivate && privateAddress(ip)) {
+				return errors.New("webhook address " + host + " is not public")
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout:   WebhookRequestTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: WebhookRequestTimeout},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Loopback, link-local, private and other addresses that aren't reachable on the internet
+func privateAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// Check that the URL is an absolute http(s) URL of a public host. Host names are resolved, see WebhookAllowPrivate
+func checkWebhookURL(URL string) error {
+	u, err := url.Parse(URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook url must be an absolute http(s) url")
+	}
+	if WebhookAllowPrivate {
+		return nil
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("webhook url must not point to this server")
+	}
+
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		addrs, err := net.LookupIP(host)
+		if err != nil {
+			return errors.New("can't resolve webhook host " + host)
+		}
+		ips = addrs
+	}
+	for _, ip := range ips {
+		if privateAddress(ip) {
+			return errors.New("webhook url must be on a public address, not " + ip.String())
+		}
+	}
+	return nil
+}
+
+func (W *WebhookDispatcher) deliveries() *Collection {
+	return W.MC.Database(SystemDBName).Collection(WebhookDeliveriesCollectionName)
+}
+
+func (W *WebhookDispatcher) collection() *Collection {
+	return W.MC.Database(SystemDBName).Collection(WebhooksCollectionName)
+}
+
+// Register a new webhook. A secret is generated if not provided.
+// URLs on loopback, link-local or private addresses are rejected, see WebhookAllowPrivate
+func (W *WebhookDispatcher) Register(Hook *Webhook) (*Webhook, error) {
+	if err := checkWebhookURL(Hook.URL); err != nil {
+		return nil, err
+	}
+
+	for _, ev := range Hook.Events {
+		if ev != ChangeInsert && ev != ChangeUpdate && ev != ChangeDelete {
+			return nil, errors.New("unknown webhook event : " + ev)
+		}
+	}
+
+	if Hook.Database == "" {
+		Hook.Database = "*"
+	}
+	if Hook.Collection == "" {
+		Hook.Collection = "*"
+	}
+	if _, perr := path.Match(Hook.Database, ""); perr != nil {
+		return nil, errors.New("bad database pattern : " + perr.Error())
+	}
+	if _, perr := path.Match(Hook.Collection, ""); perr != nil {
+		return nil, errors.New("bad collection pattern : " + perr.Error())
+	}
+
+	if Hook.Secret == "" {
+		Hook.Secret = randomHex(32)
+	}
+
+	Hook.ID = primitive.NewObjectID().Hex()
+	Hook.Created = time.Now().UTC()
+
+	res := W.collection().Set(Hook.ID, Hook)
+	if res.Status != 1 {
+		return nil, errors.New("can't store webhook : " + res.Result)
+	}
+
+	W.invalidate()
+	return Hook, nil
+}
+
+// List all webhook registrations. Secrets are not included.
+func (W *WebhookDispatcher) List() []Webhook {
+	hooks := W.load(false)
+
+	out := make([]Webhook, len(hooks))
+	for i, h := range hooks {
+		h.Secret = ""
+		out[i] = h
+	}
+	return out
+}
+
+// Get a webhook registration by ID. Secret is not included.
+func (W *WebhookDispatcher) Get(ID string) *Webhook {
+	for _, h := range W.List() {
+		if h.ID == ID {
+			return &h
+		}
+	}
+	return nil
+}
+
+// Remove a webhook registration. Returns false if there was no such webhook.
+func (W *WebhookDispatcher) Remove(ID string) bool {
+	res := W.collection().Delete(ID)
+	W.invalidate()
+	return res.Status == 1
+}
+
+// List latest dead-letter records
+func (W *WebhookDispatcher) DeadLetters(Limit int64) []WebhookDeadLetter {
+	ctx_dbr, cnc_dbr := DefaultContext()
+	defer cnc_dbr()
+
+	col := W.MC.Database(SystemDBName).Collection(WebhookDeadLettersCollectionName)
+
+	findOpts := options.Find().SetSort(bson.D{{Key: "Doc.Time", Value: -1}}).SetLimit(Limit)
+	cur, err := col.MC.Find(*ctx_dbr, bson.M{}, findOpts)
+	if CheckError(err) {
+		return nil
+	}
+
+	recs := []struct {
+		Doc WebhookDeadLetter `bson:"Doc"`
+	}{}
+	if CheckError(cur.All(*ctx_dbr, &recs)) {
+		return nil
+	}
+
+	out := make([]WebhookDeadLetter, len(recs))
+	for i, r := range recs {
+		out[i] = r.Doc
+	}
+	return out
+}
+
+func (W *WebhookDispatcher) invalidate() {
+	W.mu.Lock()
+	W.cache = nil
+	W.mu.Unlock()
+}
+
+// Load registrations from cache or from the system collection
+func (W *WebhookDispatcher) load(useCache bool) []Webhook {
+	W.mu.Lock()
+	defer W.mu.Unlock()
+
+	if useCache && W.cache != nil && time.Since(W.cacheAt) < WebhookCacheTTL {
+		return W.cache
+	}
+
+	recs, err := Typed[Webhook](W.collection()).Query(Filter_MatchAll())
+	if CheckError(err) {
+		return W.cache
+	}
+
+	hooks := make([]Webhook, len(recs))
+	for i, r := range recs {
+		r.Doc.ID = r.ID
+		hooks[i] = r.Doc
+	}
+
+	W.cache = hooks
+	W.cacheAt = time.Now()
+	return hooks
+}
+
+// Check whether the webhook wants this event
+func (H *Webhook) wants(Event *ChangeEvent) bool {
+	if H.Disabled {
+		return false
+	}
+
+	if dbok, _ := path.Match(H.Database, Event.Database); !dbok {
+		return false
+	}
+	if colok, _ := path.Match(H.Collection, Event.Collection); !colok {
+		return false
+	}
+
+	if len(H.Events) != 0 {
+		found := false
+		for _, ev := range H.Events {
+			if ev == Event.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(H.Filter) != 0 {
+		return Filter_FromQueryStrings(H.Filter).Matches(Event.Document)
+	}
+
+	return true
+}
+
+// Queue a delivery for every webhook that wants the event
+func (W *WebhookDispatcher) dispatch(Event *ChangeEvent) {
+	if Event.Database == SystemDBName {
+		return
+	}
+
+	for _, hook := range W.load(true) {
+		if !hook.wants(Event) {
+			continue
+		}
+
+		payload := WebhookPayload{
+			Delivery:   primitive.NewObjectID().Hex(),
+			Webhook:    hook.ID,
+			Event:      Event.Type,
+			Database:   Event.Database,
+			Collection: Event.Collection,
+			DocumentID: Event.ID,
+			Time:       Event.Time,
+		}
+		if Event.Document != nil {
+			payload.Document = Event.Document.Doc
+		}
+
+		body, err := json.Marshal(payload)
+		if CheckError(err) {
+			continue
+		}
+
+		res := W.deliveries().Insert(payload.Delivery, WebhookDelivery{
+			Webhook:     hook.ID,
+			Event:       Event.Type,
+			Payload:     string(body),
+			NextAttempt: Event.Time,
+		})
+		if res.Status != 1 {
+			PrintErrorMsg("Can't queue webhook "+hook.ID+" delivery :", errors.New(res.Result))
+			continue
+		}
+
+		W.mu.Lock()
+		W.queued = true
+		W.mu.Unlock()
+	}
+}
+
+// Sign the body with the webhook secret. Signature covers "<timestamp>.<body>"
+func SignWebhook(Secret string, Timestamp string, Body []byte) string {
+	mac := hmac.New(sha256.New, []byte(Secret))
+	mac.Write([]byte(Timestamp))
+	mac.Write([]byte("."))
+	mac.Write(Body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Wait before the next attempt of a delivery that failed Attempts times :
+// WebhookInitialBackoff, doubled after every failure up to WebhookMaxBackoff
+func WebhookBackoff(Attempts int) time.Duration {
+	backoff := WebhookInitialBackoff
+	for i := 1; i < Attempts && backoff < WebhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > WebhookMaxBackoff {
+		backoff = WebhookMaxBackoff
+	}
+	return backoff
+}
+
+// Record a failed attempt and schedule the next one. Returns true once the delivery is out of attempts
+func (D *WebhookDelivery) failed(Status int, Err string, Now time.Time) bool {
+	D.Attempts++
+	D.LastStatus, D.LastError = Status, Err
+	D.NextAttempt = Now.Add(WebhookBackoff(D.Attempts))
+	return D.Attempts >= WebhookMaxAttempts
+}
+
+// Send up to Max due deliveries, queued by this or any other instance. Returns the number of attempts made.
+// Without deliveries queued by this instance, the system collection is checked at most every WebhookPollInterval.
+// Failed deliveries are retried with backoff by later calls, and moved to the dead letters after WebhookMaxAttempts.
+func (W *WebhookDispatcher) DeliverDue(ctx context.Context, Max int) int {
+	W.mu.Lock()
+	if !W.queued && time.Since(W.checkedAt) < WebhookPollInterval {
+		W.mu.Unlock()
+		return 0
+	}
+	W.queued = false
+	W.checkedAt = time.Now()
+	W.mu.Unlock()
+
+	n := 0
+	for ; n < Max && ctx.Err() == nil; n++ {
+		ID, D, err := W.claim(ctx)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				PrintError(err)
+			}
+			break
+		}
+		W.attempt(ctx, ID, D)
+	}
+	return n
+}
+
+// Take the oldest due delivery, hiding it from other instances for WebhookLease
+func (W *WebhookDispatcher) claim(ctx context.Context) (string, *WebhookDelivery, error) {
+	now := time.Now().UTC()
+
+	res := W.deliveries().MC.FindOneAndUpdate(ctx,
+		bson.M{"Doc.NextAttempt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"Doc.NextAttempt": now.Add(WebhookLease)}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "Doc.NextAttempt", Value: 1}}))
+
+	rec := struct {
+		ID  string          `bson:"_id"`
+		Doc WebhookDelivery `bson:"Doc"`
+	}{}
+	if err := res.Decode(&rec); err != nil {
+		return "", nil, err
+	}
+	return rec.ID, &rec.Doc, nil
+}
+
+// Attempt a claimed delivery. Deliveries of removed or disabled webhooks are dropped
+func (W *WebhookDispatcher) attempt(ctx context.Context, ID string, D *WebhookDelivery) {
+	var hook *Webhook
+	for _, h := range W.load(true) {
+		if h.ID == D.Webhook {
+			hook = &h
+			break
+		}
+	}
+	if hook == nil || hook.Disabled {
+		W.deliveries().Delete(ID)
+		return
+	}
+
+	status, msg := W.post(ctx, *hook, ID, D.Event, []byte(D.Payload), D.Attempts+1)
+	if msg == "" {
+		W.deliveries().Delete(ID)
+		return
+	}
+
+	if !D.failed(status, msg, time.Now().UTC()) {
+		W.deliveries().Set(ID, D)
+		return
+	}
+
+	PrintErrorMsg("Webhook "+hook.ID+" failed :", errors.New(msg))
+
+	dl := W.MC.Database(SystemDBName).Collection(WebhookDeadLettersCollectionName)
+	dl.Set(ID, WebhookDeadLetter{
+		Webhook:    hook.ID,
+		URL:        hook.URL,
+		Payload:    D.Payload,
+		Attempts:   D.Attempts,
+		LastStatus: D.LastStatus,
+		LastError:  D.LastError,
+		Time:       time.Now().UTC(),
+	})
+	W.deliveries().Delete(ID)
+}
+
+// Single delivery attempt. Returns the HTTP status and an error message ("" on success)
+func (W *WebhookDispatcher) post(ctx context.Context, Hook Webhook, Delivery string, EventType string, Body []byte, Attempt int) (int, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, Hook.URL, bytes.NewReader(Body))
+	if err != nil {
+		return 0, err.Error()
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mongomini-webhooks")
+	req.Header.Set("X-Mongomini-Event", EventType)
+	req.Header.Set("X-Mongomini-Delivery", Delivery)
+	req.Header.Set("X-Mongomini-Attempt", strconv.Itoa(Attempt))
+	req.Header.Set("X-Mongomini-Timestamp", ts)
+	req.Header.Set("X-Mongomini-Signature", SignWebhook(Hook.Secret, ts, Body))
+
+	res, err := W.Client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, "unexpected status " + res.Status
+	}
+	return res.StatusCode, ""
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,151 @@
+package moncore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"event":"insert"}`)
+	want := "sha256=c9e50f81fec66f9854e4dd8c648211c0065fdd0bb4c29c07c012b4bd1da40b9b"
+
+	if got := SignWebhook("whsec", "1700000000", body); got != want {
+		t.Fatalf("SignWebhook() = %s, want %s", got, want)
+	}
+	if SignWebhook("whsec", "1700000001", body) == want {
+		t.Fatal("signature doesn't cover the timestamp")
+	}
+	if SignWebhook("other", "1700000000", body) == want {
+		t.Fatal("signature doesn't depend on the secret")
+	}
+}
+
+func TestWebhookWants(t *testing.T) {
+	doc := &GenericDBDocument{ID: "o1", Doc: GenericDocument{"status": "paid"}}
+	insert := &ChangeEvent{Type: ChangeInsert, Database: "shop", Collection: "orders", ID: "o1", Document: doc}
+
+	tests := []struct {
+		name  string
+		hook  Webhook
+		event *ChangeEvent
+		wants bool
+	}{
+		{"everything", Webhook{Database: "*", Collection: "*"}, insert, true},
+		{"same collection", Webhook{Database: "shop", Collection: "orders"}, insert, true},
+		{"pattern", Webhook{Database: "sh*", Collection: "ord?rs"}, insert, true},
+		{"other database", Webhook{Database: "blog", Collection: "*"}, insert, false},
+		{"other collection", Webhook{Database: "shop", Collection: "users"}, insert, false},
+		{"listed event", Webhook{Database: "*", Collection: "*", Events: []string{ChangeUpdate, ChangeInsert}}, insert, true},
+		{"other event", Webhook{Database: "*", Collection: "*", Events: []string{ChangeDelete}}, insert, false},
+		{"disabled", Webhook{Database: "*", Collection: "*", Disabled: true}, insert, false},
+		{"matching filter", Webhook{Database: "*", Collection: "*", Filter: map[string][]string{"status": {"=paid"}}}, insert, true},
+		{"other filter", Webhook{Database: "*", Collection: "*", Filter: map[string][]string{"status": {"=new"}}}, insert, false},
+		{"filter without document", Webhook{Database: "*", Collection: "*", Filter: map[string][]string{"status": {"=paid"}}},
+			&ChangeEvent{Type: ChangeDelete, Database: "shop", Collection: "orders", ID: "o1"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.hook.wants(tt.event); got != tt.wants {
+			t.Errorf("%s : wants() = %v, want %v", tt.name, got, tt.wants)
+		}
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 32 * time.Second, time.Minute, time.Minute}
+	for i, w := range want {
+		if got := WebhookBackoff(i + 1); got != w {
+			t.Errorf("WebhookBackoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestWebhookDeliveryFailed(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	D := &WebhookDelivery{Webhook: "h1", NextAttempt: now}
+
+	for attempt := 1; attempt <= WebhookMaxAttempts; attempt++ {
+		dead := D.failed(http.StatusBadGateway, "unexpected status 502", now)
+		if dead != (attempt == WebhookMaxAttempts) {
+			t.Fatalf("attempt %d : dead = %v", attempt, dead)
+		}
+		if D.Attempts != attempt || !D.NextAttempt.Equal(now.Add(WebhookBackoff(attempt))) {
+			t.Fatalf("attempt %d : %+v", attempt, D)
+		}
+	}
+	if D.LastStatus != http.StatusBadGateway || D.LastError == "" {
+		t.Fatalf("last failure not kept : %+v", D)
+	}
+}
+
+func TestCheckWebhookURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://203.0.113.10/hook", true},
+		{"http://[2001:db8::1]:8080/hook", true},
+		{"ftp://203.0.113.10/hook", false},
+		{"/hook", false},
+		{"http://localhost:8080/hook", false},
+		{"http://api.localhost/hook", false},
+		{"http://127.0.0.1/hook", false},
+		{"http://[::1]/hook", false},
+		{"http://10.1.2.3/hook", false},
+		{"http://192.168.0.10/hook", false},
+		{"http://172.16.5.4/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://[fe80::1]/hook", false},
+		{"http://[fd00::1]/hook", false},
+		{"http://0.0.0.0/hook", false},
+		{"http://[::ffff:127.0.0.1]/hook", false},
+	}
+	for _, tt := range tests {
+		if err := checkWebhookURL(tt.url); (err == nil) != tt.ok {
+			t.Errorf("checkWebhookURL(%s) = %v, want ok %v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestWebhookPost(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	W := &WebhookDispatcher{Client: webhookClient()}
+	defer W.Client.CloseIdleConnections()
+	hook := Webhook{ID: "h1", URL: srv.URL, Secret: "whsec"}
+	payload := []byte(`{"event":"update"}`)
+
+	// The test server listens on a loopback address
+	if _, msg := W.post(context.Background(), hook, "d1", ChangeUpdate, payload, 1); !strings.Contains(msg, "not public") {
+		t.Fatalf("post to a loopback address : %q", msg)
+	}
+
+	WebhookAllowPrivate = true
+	defer func() { WebhookAllowPrivate = false }()
+
+	status, msg := W.post(context.Background(), hook, "d1", ChangeUpdate, payload, 3)
+	if status != http.StatusNoContent || msg != "" {
+		t.Fatalf("post() = %d, %q", status, msg)
+	}
+	if string(body) != string(payload) {
+		t.Fatalf("body %q", body)
+	}
+	H := got.Header
+	if H.Get("X-Mongomini-Delivery") != "d1" || H.Get("X-Mongomini-Event") != ChangeUpdate || H.Get("X-Mongomini-Attempt") != "3" {
+		t.Fatalf("headers %v", H)
+	}
+	if sig := SignWebhook("whsec", H.Get("X-Mongomini-Timestamp"), payload); H.Get("X-Mongomini-Signature") != sig {
+		t.Fatalf("signature %q, want %q", H.Get("X-Mongomini-Signature"), sig)
+	}
+}
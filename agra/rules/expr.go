@@ -0,0 +1,573 @@
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Rule conditions are small expressions :
+//
+//	auth.uid == id && request.doc.role == resource.doc.role
+//	"admin" in auth.roles || !(resource.doc.locked == true)
+//	size(request.doc.tags) <= 10 && request.doc.tags[0] != null
+//
+// Operators : || && ! == != < <= > >= in, member access (a.b), indexes (a[0], a["b"]) and list literals ([1, 2]).
+// Literals : "strings" or 'strings', numbers, true, false, null. Functions : size(x).
+//
+// Missing fields evaluate to null. Comparing values of different types is false.
+type Expr interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type token struct {
+	kind string // "ident" | "number" | "string" | "op" | "eof"
+	text string
+	pos  int
+}
+
+func tokenize(src string) ([]token, error) {
+	toks := []token{}
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, token{"ident", src[i:j], i})
+			i = j
+
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{"number", src[i:j], i})
+			i = j
+
+		case c == '"' || c == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for j < len(src) && src[j] != src[i] {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				sb.WriteByte(src[j])
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			toks = append(toks, token{"string", sb.String(), i})
+			i = j + 1
+
+		default:
+			op := ""
+			for _, o := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ",", "."} {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			toks = append(toks, token{"op", op, i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{"eof", "", len(src)}), nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(kind string, text string) bool {
+	if t := p.peek(); t.kind == kind && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept("op", text) {
+		t := p.peek()
+		return fmt.Errorf("expected '%s' at %d, got '%s'", text, t.pos, t.text)
+	}
+	return nil
+}
+
+// Parse a condition
+func ParseExpr(src string) (Expr, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{toks: toks}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, fmt.Errorf("unexpected '%s' at %d", t.text, t.pos)
+	}
+	return e, nil
+}
+
+func (p *parser) or() (Expr, error) {
+	left, err := p.and()
+	for err == nil && p.accept("op", "||") {
+		var right Expr
+		if right, err = p.and(); err == nil {
+			left = &logical{op: "||", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) and() (Expr, error) {
+	left, err := p.unary()
+	for err == nil && p.accept("op", "&&") {
+		var right Expr
+		if right, err = p.unary(); err == nil {
+			left = &logical{op: "&&", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) unary() (Expr, error) {
+	if p.accept("op", "!") {
+		e, err := p.unary()
+		return &not{e}, err
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (Expr, error) {
+	left, err := p.postfix()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	switch {
+	case t.kind == "op" && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="),
+		t.kind == "ident" && t.text == "in":
+		p.next()
+		right, err := p.postfix()
+		if err != nil {
+			return nil, err
+		}
+		return &compare{op: t.text, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) postfix() (Expr, error) {
+	e, err := p.primary()
+	for err == nil {
+		switch {
+		case p.accept("op", "."):
+			t := p.next()
+			if t.kind != "ident" {
+				return nil, fmt.Errorf("expected field name at %d", t.pos)
+			}
+			e = &member{target: e, key: &literal{t.text}}
+		case p.accept("op", "["):
+			var key Expr
+			if key, err = p.or(); err == nil {
+				err = p.expect("]")
+			}
+			e = &member{target: e, key: key}
+		default:
+			return e, nil
+		}
+	}
+	return nil, err
+}
+
+func (p *parser) primary() (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case "number":
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number '%s' at %d", t.text, t.pos)
+		}
+		return &literal{f}, nil
+
+	case "string":
+		return &literal{t.text}, nil
+
+	case "ident":
+		switch t.text {
+		case "true":
+			return &literal{true}, nil
+		case "false":
+			return &literal{false}, nil
+		case "null":
+			return &literal{nil}, nil
+		}
+
+		if p.accept("op", "(") {
+			fn, ok := functions[t.text]
+			if !ok {
+				return nil, fmt.Errorf("unknown function '%s' at %d", t.text, t.pos)
+			}
+			args, err := p.list(")")
+			if err != nil {
+				return nil, err
+			}
+			return &call{name: t.text, fn: fn, args: args}, nil
+		}
+		return &variable{t.text}, nil
+
+	case "op":
+		switch t.text {
+		case "(":
+			e, err := p.or()
+			if err == nil {
+				err = p.expect(")")
+			}
+			return e, err
+		case "[":
+			items, err := p.list("]")
+			return &list{items}, err
+		}
+	}
+	return nil, fmt.Errorf("unexpected '%s' at %d", t.text, t.pos)
+}
+
+// Comma separated expressions up to the closing token
+func (p *parser) list(closing string) ([]Expr, error) {
+	out := []Expr{}
+	if p.accept("op", closing) {
+		return out, nil
+	}
+	for {
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+		if p.accept("op", closing) {
+			return out, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Names of the variables used by the expression
+func variables(e Expr, out map[string]bool) {
+	switch t := e.(type) {
+	case *variable:
+		out[t.name] = true
+	case *logical:
+		variables(t.left, out)
+		variables(t.right, out)
+	case *not:
+		variables(t.e, out)
+	case *compare:
+		variables(t.left, out)
+		variables(t.right, out)
+	case *member:
+		variables(t.target, out)
+		variables(t.key, out)
+	case *call:
+		for _, a := range t.args {
+			variables(a, out)
+		}
+	case *list:
+		for _, a := range t.items {
+			variables(a, out)
+		}
+	}
+}
+
+type literal struct{ value interface{} }
+
+func (L *literal) eval(map[string]interface{}) (interface{}, error) {
+	return L.value, nil
+}
+
+type variable struct{ name string }
+
+func (V *variable) eval(vars map[string]interface{}) (interface{}, error) {
+	v, ok := vars[V.name]
+	if !ok {
+		return nil, errors.New("unknown variable " + V.name)
+	}
+	return v, nil
+}
+
+type logical struct {
+	op          string
+	left, right Expr
+}
+
+func (L *logical) eval(vars map[string]interface{}) (interface{}, error) {
+	l, err := L.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(l) == (L.op == "||") {
+		return L.op == "||", nil
+	}
+	r, err := L.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	return truthy(r), nil
+}
+
+type not struct{ e Expr }
+
+func (N *not) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := N.e.eval(vars)
+	return !truthy(v), err
+}
+
+type compare struct {
+	op          string
+	left, right Expr
+}
+
+func (C *compare) eval(vars map[string]interface{}) (interface{}, error) {
+	l, err := C.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	r, err := C.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch C.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "in":
+		switch t := r.(type) {
+		case []interface{}:
+			for _, e := range t {
+				if equal(l, e) {
+					return true, nil
+				}
+			}
+		case map[string]interface{}:
+			k, ok := l.(string)
+			_, found := t[k]
+			return ok && found, nil
+		case string:
+			k, ok := l.(string)
+			return ok && strings.Contains(t, k), nil
+		}
+		return false, nil
+	}
+
+	var c int
+	switch lv := l.(type) {
+	case float64:
+		rv, ok := r.(float64)
+		if !ok {
+			return false, nil
+		}
+		switch {
+		case lv < rv:
+			c = -1
+		case lv > rv:
+			c = 1
+		}
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return false, nil
+		}
+		c = strings.Compare(lv, rv)
+	default:
+		return false, nil
+	}
+
+	switch C.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	}
+	return c >= 0, nil // ">="
+}
+
+type member struct {
+	target Expr
+	key    Expr
+}
+
+func (M *member) eval(vars map[string]interface{}) (interface{}, error) {
+	t, err := M.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	k, err := M.key.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch tv := t.(type) {
+	case map[string]interface{}:
+		if ks, ok := k.(string); ok {
+			return tv[ks], nil
+		}
+	case []interface{}:
+		if kf, ok := k.(float64); ok && kf >= 0 && int(kf) < len(tv) && kf == float64(int(kf)) {
+			return tv[int(kf)], nil
+		}
+	}
+	return nil, nil
+}
+
+type list struct{ items []Expr }
+
+func (L *list) eval(vars map[string]interface{}) (interface{}, error) {
+	out := make([]interface{}, len(L.items))
+	for i, e := range L.items {
+		v, err := e.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+type call struct {
+	name string
+	fn   func(args []interface{}) (interface{}, error)
+	args []Expr
+}
+
+func (F *call) eval(vars map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(F.args))
+	for i, e := range F.args {
+		v, err := e.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return F.fn(args)
+}
+
+var functions = map[string]func(args []interface{}) (interface{}, error){
+	"size": func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, errors.New("size() takes one argument")
+		}
+		switch t := args[0].(type) {
+		case string:
+			return float64(len(t)), nil
+		case []interface{}:
+			return float64(len(t)), nil
+		case map[string]interface{}:
+			return float64(len(t)), nil
+		}
+		return float64(0), nil
+	},
+}
+
+func truthy(v interface{}) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
+
+func equal(a interface{}, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// Convert Go values to the types used by expressions :
+// nil, bool, float64, string, []interface{} and map[string]interface{}. Structs go through encoding/json
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil, bool, float64, string:
+		return v
+	case fmt.Stringer:
+		if rv := reflect.ValueOf(v); rv.Kind() != reflect.Map && rv.Kind() != reflect.Slice {
+			return t.String() // ObjectIDs, times, ...
+		}
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.String:
+		return rv.String()
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return normalize(rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		out := make([]interface{}, rv.Len())
+		for i := range out {
+			out[i] = normalize(rv.Index(i).Interface())
+		}
+		return out
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil
+		}
+		out := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			out[fmt.Sprint(iter.Key().Interface())] = normalize(iter.Value().Interface())
+		}
+		return out
+	case reflect.Struct:
+		var out interface{}
+		if J, err := json.Marshal(v); err == nil && json.Unmarshal(J, &out) == nil {
+			return out
+		}
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,161 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+type exprDoc struct {
+	Name  string   `json:"name"`
+	Count int32    `json:"count"`
+	Tags  []string `json:"tags"`
+}
+
+var exprVars = map[string]interface{}{
+	"i32":  int32(3),
+	"i64":  int64(3),
+	"u8":   uint8(3),
+	"f32":  float32(2.5),
+	"f64":  2.5,
+	"big":  int64(1) << 40,
+	"str":  "abc",
+	"list": []int{1, 2, 3},
+	"doc":  map[string]interface{}{"role": "admin", "nested": map[string]int64{"n": 7}, "tags": []string{"a", "b"}},
+	"st":   exprDoc{Name: "x", Count: 4, Tags: []string{"t"}},
+	"ptr":  &exprDoc{Name: "p"},
+	"none": nil,
+}
+
+func evalExpr(t *testing.T, src string) (interface{}, error) {
+	t.Helper()
+	e, err := ParseExpr(src)
+	if err != nil {
+		t.Fatalf("%s : parse error %v", src, err)
+	}
+	vars := map[string]interface{}{}
+	for k, v := range exprVars {
+		vars[k] = normalize(v)
+	}
+	return e.eval(vars)
+}
+
+func TestExprEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		// Precedence : ! over comparisons over && over ||
+		{`true || false && false`, true},
+		{`false && true || true`, true},
+		{`(true || false) && false`, false},
+		{`!false && false`, false},
+		{`!(false && false)`, true},
+		{`!i32 == 3`, false},
+		{`!!true`, true},
+		{`"a" in ["b", "a"] && !("c" in ["a"])`, true},
+
+		// Numbers of any Go type compare as float64
+		{`i32 == 3`, true},
+		{`i32 == i64`, true},
+		{`u8 == i32`, true},
+		{`f32 == f64`, true},
+		{`f32 < i32`, true},
+		{`big > 1000000000`, true},
+		{`i64 >= 3 && i64 <= 3`, true},
+		{`i32 == "3"`, false},
+		{`i32 < "4"`, false},
+		{`"abc" < "abd"`, true},
+		{`doc.nested.n == 7`, true},
+		{`st.count == 4`, true},
+		{`size(list) == 3`, true},
+		{`size(str) == 3 && size(doc) == 3`, true},
+		{`list[1] == 2`, true},
+		{`list[1.5] == null`, true},
+		{`3 in list`, true},
+		{`i32 in list`, true},
+		{`"role" in doc`, true},
+		{`"b" in str`, true},
+
+		// Missing members are null
+		{`doc.missing == null`, true},
+		{`doc.missing.deeper == null`, true},
+		{`none.uid == null`, true},
+		{`none.uid == "x"`, false},
+		{`list[10] == null`, true},
+		{`list["0"] == null`, true},
+		{`str.length == null`, true},
+		{`doc["role"] == "admin"`, true},
+		{`doc.tags[0] == "a"`, true},
+		{`st.tags[0] == "t"`, true},
+		{`ptr.name == "p"`, true},
+		{`size(none) == 0`, true},
+		{`doc.missing < 1`, false},
+		{`doc.missing != null`, false},
+	}
+
+	for _, tt := range tests {
+		got, err := evalExpr(t, tt.src)
+		if err != nil {
+			t.Errorf("%s : error %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestExprEvalErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{`unknown == 1`, "unknown variable unknown"},
+		{`true && unknown`, "unknown variable unknown"},
+		{`size(list, list) == 1`, "size() takes one argument"},
+	}
+
+	for _, tt := range tests {
+		_, err := evalExpr(t, tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s : error %v, want %q", tt.src, err, tt.err)
+		}
+	}
+
+	// Short-circuit : the right side isn't evaluated
+	for _, src := range []string{`true || unknown`, `false && unknown`} {
+		if _, err := evalExpr(t, src); err != nil {
+			t.Errorf("%s : error %v", src, err)
+		}
+	}
+}
+
+func TestExprParseErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{``, "unexpected '' at 0"},
+		{`a ==`, "unexpected '' at 4"},
+		{`(a == 1`, "expected ')' at 7, got ''"},
+		{`a[1`, "expected ']' at 3, got ''"},
+		{`'abc`, "unterminated string at 0"},
+		{`a $ b`, "unexpected character '$' at 2"},
+		{`a b`, "unexpected 'b' at 2"},
+		{`a == 1 == 2`, "unexpected '==' at 7"},
+		{`a.`, "expected field name at 2"},
+		{`a.1`, "expected field name at 2"},
+		{`1.2.3 == 1`, "bad number '1.2.3' at 0"},
+		{`nope(1)`, "unknown function 'nope' at 0"},
+		{`-1 < 0`, "unexpected character '-' at 0"},
+		{`[1, 2`, "expected ',' at 5, got ''"},
+		{`size(1 2)`, "expected ',' at 7, got '2'"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseExpr(tt.src)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q : error %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Sample request for testing a rule file. Cases files are JSON arrays of cases :
+//
+//	[{"name": "owner can update", "op": "update", "path": "users/accounts/alice",
+//	  "auth": {"uid": "alice"}, "request": {"doc": {"role": "user"}},
+//	  "resource": {"id": "alice", "doc": {"role": "user"}}, "allow": true}]
+type Case struct {
+	Name     string      `json:"name"`
+	Op       string      `json:"op"`
+	Path     string      `json:"path"`
+	Auth     interface{} `json:"auth"`
+	Request  interface{} `json:"request"`
+	Resource interface{} `json:"resource"`
+	Allow    bool        `json:"allow"` // Expected decision
+}
+
+type CaseResult struct {
+	Case     *Case
+	Decision *Decision
+	Pass     bool
+}
+
+// Read a cases file
+func LoadCases(Filename string) ([]Case, error) {
+	src, err := os.ReadFile(Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	cases := []Case{}
+	if err := json.Unmarshal(src, &cases); err != nil {
+		return nil, fmt.Errorf("%s : %s", Filename, err.Error())
+	}
+	return cases, nil
+}
+
+// Run the cases against the rules
+func (RS *RuleSet) Run(Cases []Case) []CaseResult {
+	out := make([]CaseResult, len(Cases))
+	for i := range Cases {
+		c := &Cases[i]
+
+		req := map[string]interface{}{"op": c.Op}
+		if m, ok := c.Request.(map[string]interface{}); ok {
+			for k, v := range m {
+				req[k] = v
+			}
+		}
+
+		D := RS.Check(c.Op, c.Path, map[string]interface{}{
+			"auth":     c.Auth,
+			"request":  req,
+			"resource": c.Resource,
+		})
+		out[i] = CaseResult{Case: c, Decision: D, Pass: D.Allowed == c.Allow}
+	}
+	return out
+}
+
+// Print results, one line per case. Returns the number of failed cases
+func Report(W io.Writer, Results []CaseResult) int {
+	failed := 0
+	for _, r := range Results {
+		status := "PASS"
+		if !r.Pass {
+			status = "FAIL"
+			failed++
+		}
+
+		decision := "denied"
+		if r.Decision.Allowed {
+			decision = fmt.Sprintf("allowed by line %d", r.Decision.Rule.Line)
+		}
+
+		name := r.Case.Name
+		if name == "" {
+			name = r.Case.Op + " " + r.Case.Path
+		}
+		fmt.Fprintf(W, "%s  %s : %s\n", status, name, decision)
+		for _, err := range r.Decision.Errors {
+			fmt.Fprintf(W, "      %s\n", err.Error())
+		}
+	}
+
+	fmt.Fprintf(W, "%d cases, %d failed\n", len(Results), failed)
+	return failed
+}
+
+// Run a cases file against a rule file and print the report. Returns the number of failed cases
+func TestFiles(W io.Writer, RulesFile string, CasesFile string) (int, error) {
+	RS, err := ParseFile(RulesFile)
+	if err != nil {
+		return 0, fmt.Errorf("%s : %s", RulesFile, err.Error())
+	}
+
+	cases, err := LoadCases(CasesFile)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, c := range cases {
+		switch c.Op {
+		case OpRead, OpCreate, OpUpdate, OpDelete:
+		default:
+			return 0, fmt.Errorf("case %q : op must be read, create, update or delete", c.Name)
+		}
+	}
+
+	return Report(W, RS.Run(cases)), nil
+}
@@ -0,0 +1,257 @@
+// Package rules is a small declarative language to allow document access.
+//
+// A rule file is a list of rules, one per line (lines starting with a space continue the previous rule) :
+//
+//	# Everyone signed in can read profiles, only their owner can write them
+//	allow read on users/profiles/{id} if auth != null
+//	allow create, update on users/profiles/{id} if auth.uid == id
+//	allow update on users/accounts/{id}
+//	    if auth.uid == id && request.doc.role == resource.doc.role
+//
+// Operations : read, create, update, delete, and write (create, update and delete).
+// Paths are "<db>/<collection>/<document id>". Segments are names, '*' (any) or {name} (any, captured
+// as a variable). Access is denied unless a rule matching the operation and the path allows it;
+// rules without condition always allow.
+//
+// Conditions (see Expr) can use the path variables and :
+//
+//	auth      : the caller, null if anonymous. {uid, type ("user" or "key"), roles, scopes (keys)}
+//	request   : {op, method, doc (incoming document of creates and updates), params (route params)}
+//	resource  : the stored document, null if it doesn't exist. {id, doc}
+package rules
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Operations
+const (
+	OpRead   = "read"
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+	OpWrite  = "write" // create, update and delete
+)
+
+// Variables available to every condition, besides path variables
+var Globals = []string{"auth", "request", "resource"}
+
+type Rule struct {
+	Line      int
+	Ops       []string
+	Path      []string // segments
+	Condition Expr     // nil always allows
+	Source    string
+}
+
+type RuleSet struct {
+	Rules []*Rule
+}
+
+var ruleLine = regexp.MustCompile(`(?s)^allow\s+([a-z,\s]+?)\s+on\s+(\S+)(?:\s+if\s+(.+))?$`)
+
+var validSegment = regexp.MustCompile(`^(\*|\{[A-Za-z_][A-Za-z0-9_]*\}|[^{}*\s]+)$`)
+
+// Parse rules from text
+func Parse(src string) (*RuleSet, error) {
+	RS := &RuleSet{}
+
+	type pending struct {
+		line int
+		text string
+	}
+	chunks := []pending{}
+
+	sc := bufio.NewScanner(strings.NewReader(src))
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(chunks) == 0 {
+				return nil, fmt.Errorf("line %d : continuation without rule", n)
+			}
+			chunks[len(chunks)-1].text += " " + trimmed
+			continue
+		}
+		chunks = append(chunks, pending{n, trimmed})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, c := range chunks {
+		R, err := parseRule(c.text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d : %s", c.line, err.Error())
+		}
+		R.Line = c.line
+		RS.Rules = append(RS.Rules, R)
+	}
+	return RS, nil
+}
+
+// Parse a rule file
+func ParseFile(Filename string) (*RuleSet, error) {
+	src, err := os.ReadFile(Filename)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(string(src))
+}
+
+func parseRule(text string) (*Rule, error) {
+	m := ruleLine.FindStringSubmatch(text)
+	if m == nil {
+		return nil, errors.New("expected 'allow <ops> on <db>/<collection>/<id> [if <condition>]'")
+	}
+
+	R := &Rule{Source: text}
+
+	for _, op := range strings.Split(m[1], ",") {
+		op = strings.TrimSpace(op)
+		switch op {
+		case OpRead, OpCreate, OpUpdate, OpDelete, OpWrite:
+			R.Ops = append(R.Ops, op)
+		default:
+			return nil, errors.New("unknown operation '" + op + "'")
+		}
+	}
+
+	R.Path = strings.Split(strings.Trim(m[2], "/"), "/")
+	if len(R.Path) != 3 {
+		return nil, errors.New("path must be <db>/<collection>/<id>")
+	}
+
+	known := map[string]bool{}
+	for _, g := range Globals {
+		known[g] = true
+	}
+	for _, seg := range R.Path {
+		if !validSegment.MatchString(seg) {
+			return nil, errors.New("bad path segment '" + seg + "'")
+		}
+		if strings.HasPrefix(seg, "{") {
+			known[seg[1:len(seg)-1]] = true
+		}
+	}
+
+	if m[3] != "" {
+		cond, err := ParseExpr(m[3])
+		if err != nil {
+			return nil, err
+		}
+
+		used := map[string]bool{}
+		variables(cond, used)
+		for name := range used {
+			if !known[name] {
+				return nil, errors.New("unknown variable '" + name + "'")
+			}
+		}
+		R.Condition = cond
+	}
+
+	return R, nil
+}
+
+func (R *Rule) coversOp(Op string) bool {
+	for _, o := range R.Ops {
+		if o == Op || (o == OpWrite && Op != OpRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match the path, returning captured variables
+func (R *Rule) match(Path []string) (map[string]interface{}, bool) {
+	if len(Path) != len(R.Path) {
+		return nil, false
+	}
+
+	captured := map[string]interface{}{}
+	for i, seg := range R.Path {
+		switch {
+		case seg == "*":
+		case strings.HasPrefix(seg, "{"):
+			captured[seg[1:len(seg)-1]] = Path[i]
+		case seg != Path[i]:
+			return nil, false
+		}
+	}
+	return captured, true
+}
+
+// Evaluate the rule. ok is false if it doesn't apply to the operation and path
+func (R *Rule) Evaluate(Op string, Path []string, Vars map[string]interface{}) (allowed bool, ok bool, err error) {
+	if !R.coversOp(Op) {
+		return false, false, nil
+	}
+	captured, matched := R.match(Path)
+	if !matched {
+		return false, false, nil
+	}
+	if R.Condition == nil {
+		return true, true, nil
+	}
+
+	scope := make(map[string]interface{}, len(Vars)+len(captured))
+	for k, v := range Vars {
+		scope[k] = v
+	}
+	for k, v := range captured {
+		scope[k] = v
+	}
+
+	v, err := R.Condition.eval(scope)
+	return truthy(v), true, err
+}
+
+// Result of checking an access
+type Decision struct {
+	Allowed bool
+	Rule    *Rule   // Rule that allowed the access. nil if denied
+	Errors  []error // Evaluation errors of matching rules (they count as not allowing)
+}
+
+// Check an operation on "<db>/<collection>/<id>". Vars are the Globals (missing ones are null).
+func (RS *RuleSet) Check(Op string, Path string, Vars map[string]interface{}) *Decision {
+	D := &Decision{}
+
+	scope := make(map[string]interface{}, len(Globals))
+	for _, g := range Globals {
+		scope[g] = normalize(Vars[g])
+	}
+
+	segs := strings.SplitN(Path, "/", 3) // document IDs may contain slashes
+	for _, R := range RS.Rules {
+		allowed, ok, err := R.Evaluate(Op, segs, scope)
+		if !ok {
+			continue
+		}
+		if err != nil {
+			D.Errors = append(D.Errors, fmt.Errorf("line %d : %s", R.Line, err.Error()))
+			continue
+		}
+		if allowed {
+			D.Allowed = true
+			D.Rule = R
+			return D
+		}
+	}
+	return D
+}
+
+// Check an operation. See Check()
+func (RS *RuleSet) Allows(Op string, Path string, Vars map[string]interface{}) bool {
+	return RS.Check(Op, Path, Vars).Allowed
+}
@@ -0,0 +1,275 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"time"
+
+	"mongomini/agra/moncore"
+	"mongomini/client"
+	"mongomini/endpoints"
+)
+
+// Where commands read and write : the HTTP API (with its auth and rules) or MongoDB itself
+type Backend interface {
+	ListCollections(ctx context.Context, DB string) ([]string, error)
+	Get(ctx context.Context, DB, Col, Key string) (*moncore.GenericDBDocument, error)
+	Set(ctx context.Context, DB, Col, Key string, Value interface{}) (*moncore.WriteOperationResponse, error)
+	Delete(ctx context.Context, DB, Col, Key string) (*moncore.WriteOperationResponse, error)
+
+	// Call each for the documents matching the filter (in _id order over HTTP). Limit 0 means no limit
+	Query(ctx context.Context, DB, Col string, Filter url.Values, Limit int64, each func(*moncore.GenericDBDocument) error) error
+
+	// Write the documents matching the filter to W in a transfer format, see moncore.Collection.Export
+	Export(ctx context.Context, DB, Col string, Filter url.Values, Options client.ExportOptions, W io.Writer) error
+	// Write the documents read from R in bulk, see moncore.Collection.Import
+	Import(ctx context.Context, DB, Col string, R io.Reader, Options client.ImportOptions) (*moncore.ImportResult, error)
+
+	// Call each for change events until ctx is done
+	Watch(ctx context.Context, DB, Col string, Filter url.Values, Events []string, each func(*moncore.ChangeEvent) error) error
+
+	ListKeys(ctx context.Context) ([]client.APIKey, error)
+	CreateKey(ctx context.Context, Name string, Scopes []string) (*client.APIKeyToken, error)
+	RotateKey(ctx context.Context, ID string) (*client.APIKeyToken, error)
+	RevokeKey(ctx context.Context, ID string) error
+
+	Close() error
+}
+
+// Documents per request when paging through the HTTP API
+var PageSize int64 = 500
+
+// Open the backend of the profile
+func Open(P *Profile) (Backend, error) {
+	switch {
+	case P.URL != "":
+		C, err := client.New(P.URL, client.WithAPIKey(P.Key))
+		if err != nil {
+			return nil, err
+		}
+		return &httpBackend{client: C}, nil
+
+	case P.Mongo != "":
+		MC, err := moncore.InitMongo(P.Mongo)
+		if err != nil {
+			return nil, err
+		}
+		return &directBackend{mc: MC}, nil
+	}
+	return nil, errors.New("no url or mongo in the profile. Try : mongomini profile set <name> -url https://...")
+}
+
+type httpBackend struct {
+	client *client.Client
+}
+
+func (B *httpBackend) ListCollections(ctx context.Context, DB string) ([]string, error) {
+	return B.client.Database(DB).ListCollections(ctx)
+}
+
+func (B *httpBackend) Get(ctx context.Context, DB, Col, Key string) (*moncore.GenericDBDocument, error) {
+	return B.client.Database(DB).Collection(Col).Get(ctx, Key)
+}
+
+func (B *httpBackend) Set(ctx context.Context, DB, Col, Key string, Value interface{}) (*moncore.WriteOperationResponse, error) {
+	return B.client.Database(DB).Collection(Col).Set(ctx, Key, Value)
+}
+
+func (B *httpBackend) Delete(ctx context.Context, DB, Col, Key string) (*moncore.WriteOperationResponse, error) {
+	return B.client.Database(DB).Collection(Col).Delete(ctx, Key)
+}
+
+// Pages keep Extended JSON types, unlike the NDJSON stream
+func (B *httpBackend) Query(ctx context.Context, DB, Col string, Filter url.Values, Limit int64, each func(*moncore.GenericDBDocument) error) error {
+	C := B.client.Database(DB).Collection(Col)
+	F := client.Filter_FromQueryStrings(Filter)
+
+	opts := moncore.PageOptions{Limit: PageSize}
+	for read := int64(0); ; {
+		if Limit > 0 {
+			opts.Limit = min(PageSize, Limit-read)
+		}
+
+		P, err := C.Page(ctx, F, opts)
+		if err != nil {
+			return err
+		}
+		for _, Doc := range P.Docs {
+			if err := each(Doc); err != nil {
+				return err
+			}
+		}
+
+		read += int64(len(P.Docs))
+		if P.Next == "" || (Limit > 0 && read >= Limit) {
+			return nil
+		}
+		opts.After = P.Next
+	}
+}
+
+func (B *httpBackend) Export(ctx context.Context, DB, Col string, Filter url.Values, Options client.ExportOptions, W io.Writer) error {
+	_, err := B.client.Database(DB).Collection(Col).Export(ctx, W, client.Filter_FromQueryStrings(Filter), Options)
+	return err
+}
+
+func (B *httpBackend) Import(ctx context.Context, DB, Col string, R io.Reader, Options client.ImportOptions) (*moncore.ImportResult, error) {
+	return B.client.Database(DB).Collection(Col).Import(ctx, R, Options)
+}
+
+func (B *httpBackend) Watch(ctx context.Context, DB, Col string, Filter url.Values, Events []string, each func(*moncore.ChangeEvent) error) error {
+	S, err := B.client.Database(DB).Collection(Col).Watch(ctx, client.Filter_FromQueryStrings(Filter), Events...)
+	if err != nil {
+		return err
+	}
+	defer S.Close()
+
+	for Event := range S.Events {
+		if err := each(Event); err != nil {
+			return err
+		}
+	}
+	if err := S.Err(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+func (B *httpBackend) ListKeys(ctx context.Context) ([]client.APIKey, error) {
+	return B.client.ListKeys(ctx)
+}
+
+func (B *httpBackend) CreateKey(ctx context.Context, Name string, Scopes []string) (*client.APIKeyToken, error) {
+	return B.client.CreateKey(ctx, Name, Scopes)
+}
+
+func (B *httpBackend) RotateKey(ctx context.Context, ID string) (*client.APIKeyToken, error) {
+	return B.client.RotateKey(ctx, ID)
+}
+
+func (B *httpBackend) RevokeKey(ctx context.Context, ID string) error {
+	return B.client.RevokeKey(ctx, ID)
+}
+
+func (B *httpBackend) Close() error {
+	return nil
+}
+
+// Straight to MongoDB : no auth, no rules. For operators with the connection string
+type directBackend struct {
+	mc *moncore.Moncore
+}
+
+func (B *directBackend) ListCollections(ctx context.Context, DB string) ([]string, error) {
+	return B.mc.Database(DB).ListCollectionNames(), nil
+}
+
+func (B *directBackend) Get(ctx context.Context, DB, Col, Key string) (*moncore.GenericDBDocument, error) {
+	return B.mc.Database(DB).Collection(Col).GetDocument(Key)
+}
+
+func (B *directBackend) Set(ctx context.Context, DB, Col, Key string, Value interface{}) (*moncore.WriteOperationResponse, error) {
+	Res := B.mc.Database(DB).Collection(Col).Set(Key, Value)
+	return &Res, Res.Err()
+}
+
+func (B *directBackend) Delete(ctx context.Context, DB, Col, Key string) (*moncore.WriteOperationResponse, error) {
+	Res := B.mc.Database(DB).Collection(Col).Delete(Key)
+	return &Res, Res.Err()
+}
+
+func (B *directBackend) Query(ctx context.Context, DB, Col string, Filter url.Values, Limit int64, each func(*moncore.GenericDBDocument) error) error {
+	S, err := B.mc.Database(DB).Collection(Col).Stream(ctx, moncore.Filter_FromQueryStrings(Filter), &moncore.QueryOptions{BatchSize: 500, Limit: Limit})
+	if err != nil {
+		return err
+	}
+	defer S.Close()
+
+	for Doc := range S.Docs {
+		if err := each(Doc); err != nil {
+			return err
+		}
+	}
+	return S.Err()
+}
+
+func (B *directBackend) Export(ctx context.Context, DB, Col string, Filter url.Values, Options client.ExportOptions, W io.Writer) error {
+	opts := &moncore.ExportOptions{Format: Options.Format, Filter: moncore.Filter_FromQueryStrings(Filter), Limit: Options.Limit}
+	if Options.Fields != "" {
+		fields, err := moncore.ParseFieldMappings(Options.Fields)
+		if err != nil {
+			return err
+		}
+		opts.Fields = fields
+	}
+	_, err := B.mc.Database(DB).Collection(Col).Export(ctx, W, opts)
+	return err
+}
+
+func (B *directBackend) Import(ctx context.Context, DB, Col string, R io.Reader, Options client.ImportOptions) (*moncore.ImportResult, error) {
+	opts := &moncore.ImportOptions{Format: Options.Format, Mode: Options.Mode, BatchSize: Options.BatchSize, KeepGoing: Options.KeepGoing}
+	if Options.Fields != "" {
+		fields, err := moncore.ParseFieldMappings(Options.Fields)
+		if err != nil {
+			return nil, err
+		}
+		opts.Fields = fields
+	}
+	return B.mc.Database(DB).Collection(Col).Import(ctx, R, opts)
+}
+
+func (B *directBackend) Watch(ctx context.Context, DB, Col string, Filter url.Values, Events []string, each func(*moncore.ChangeEvent) error) error {
+	return errors.New("watch needs a profile with a url : change events are published by the server")
+}
+
+// Key management works on the key store of the endpoints package
+func (B *directBackend) keyStore() {
+	endpoints.Moncore = B.mc
+}
+
+func (B *directBackend) ListKeys(ctx context.Context) ([]client.APIKey, error) {
+	B.keyStore()
+	keys, err := endpoints.ListAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]client.APIKey, len(keys))
+	for i, K := range keys {
+		out[i] = clientKey(K)
+	}
+	return out, nil
+}
+
+func (B *directBackend) CreateKey(ctx context.Context, Name string, Scopes []string) (*client.APIKeyToken, error) {
+	B.keyStore()
+	T, err := endpoints.CreateAPIKey(Name, Scopes, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return &client.APIKeyToken{APIKey: clientKey(T.APIKey), Token: T.Token}, nil
+}
+
+func (B *directBackend) RotateKey(ctx context.Context, ID string) (*client.APIKeyToken, error) {
+	B.keyStore()
+	T, err := endpoints.RotateAPIKey(ID)
+	if err != nil {
+		return nil, err
+	}
+	return &client.APIKeyToken{APIKey: clientKey(T.APIKey), Token: T.Token}, nil
+}
+
+func (B *directBackend) RevokeKey(ctx context.Context, ID string) error {
+	B.keyStore()
+	return endpoints.RevokeAPIKey(ID)
+}
+
+func (B *directBackend) Close() error {
+	return B.mc.Disconnect()
+}
+
+func clientKey(K endpoints.APIKey) client.APIKey {
+	return client.APIKey{ID: K.ID, Name: K.Name, Hint: K.Hint, Scopes: K.Scopes, Revoked: K.Revoked, Expires: K.Expires, Created: K.Created, Rotated: K.Rotated}
+}
@@ -0,0 +1,226 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mongomini/agra/moncore"
+)
+
+// Moncore of a direct backend : backups work on MongoDB itself
+func (E *Env) moncore(Cmd string) (*moncore.Moncore, error) {
+	B, err := E.Backend()
+	if err != nil {
+		return nil, err
+	}
+	direct, ok := B.(*directBackend)
+	if !ok {
+		return nil, errors.New(Cmd + " needs a profile with a mongo connection string")
+	}
+	return direct.mc, nil
+}
+
+// Names of a comma-separated list
+func splitList(S string) []string {
+	out := []string{}
+	for _, s := range strings.Split(S, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func cmdBackup(E *Env, Args []string) error {
+	fs := E.Flags("backup")
+	file := fs.String("file", "", "archive file, - for stdout. Default is <db>-<time>.tar.gz")
+	collections := fs.String("collections", "", "comma-separated collections. Default is all")
+	noSnapshot := fs.Bool("no-snapshot", false, "don't read in a snapshot session")
+	args, err := parseFlags(fs, Args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usage("needs a database")
+	}
+	MC, err := E.moncore("backup")
+	if err != nil {
+		return err
+	}
+
+	path := *file
+	if path == "" {
+		path = args[0] + "-" + time.Now().UTC().Format("20060102-150405") + ".tar.gz"
+	}
+
+	ctx, cancel := E.Context()
+	defer cancel()
+	opts := &moncore.BackupOptions{Collections: splitList(*collections), NoSnapshot: *noSnapshot}
+
+	if path == "-" {
+		M, err := MC.BackupDatabase(ctx, args[0], E.Stdout, opts)
+		if err == nil && !M.Snapshot && !*noSnapshot {
+			warnNoSnapshot(E)
+		}
+		return err
+	}
+
+	// Written next to the file then renamed, so failures don't leave a partial archive
+	f, err := os.CreateTemp(filepath.Dir(path), ".mongomini-backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	M, err := MC.BackupDatabase(ctx, args[0], f, opts)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	if !M.Snapshot && !*noSnapshot {
+		warnNoSnapshot(E)
+	}
+
+	docs := int64(0)
+	for _, BC := range M.Collections {
+		docs += BC.Documents
+	}
+	consistency := "snapshot"
+	if !M.Snapshot {
+		consistency = "no snapshot : collections were read one after the other"
+	}
+	fmt.Fprintf(E.Stderr, "%d collections, %d documents of %s backed up to %s (%s)\n", len(M.Collections), docs, M.Database, path, consistency)
+	return nil
+}
+
+func warnNoSnapshot(E *Env) {
+	fmt.Fprintln(E.Stderr, "warning : the server has no snapshot reads, collections were read one after the other and may not be consistent with each other")
+}
+
+func cmdVerify(E *Env, Args []string) error {
+	fs := E.Flags("verify")
+	args, err := parseFlags(fs, Args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usage("needs an archive file")
+	}
+
+	M, err := verifyArchive(args[0])
+	if err != nil {
+		return err
+	}
+
+	P, err := E.Printer()
+	if err != nil {
+		return err
+	}
+	if P.Format != OutputTable {
+		return P.One(M)
+	}
+
+	fmt.Fprintf(E.Stderr, "%s : backup of %s at %s, snapshot %t. Checksums OK\n", args[0], M.Database, M.Created.Format(time.RFC3339), M.Snapshot)
+	for _, BC := range M.Collections {
+		P.Add(struct {
+			Name      string
+			Type      string
+			Mode      string
+			Documents int64
+			Indexes   int
+			Size      int64
+		}{BC.Name, BC.Type, BC.Mode, BC.Documents, len(BC.Indexes), BC.Size})
+	}
+	return P.Flush()
+}
+
+func verifyArchive(Path string) (*moncore.BackupManifest, error) {
+	f, err := os.Open(Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return moncore.VerifyBackup(f)
+}
+
+func cmdRestore(E *Env, Args []string) error {
+	fs := E.Flags("restore")
+	db := fs.String("db", "", "target database. Default is the backed up one")
+	collections := fs.String("collections", "", "comma-separated collections of the archive. Default is all")
+	drop := fs.Bool("drop", false, "drop the target collections first. Otherwise documents are replaced by _id and others kept")
+	webhooks := fs.Bool("webhooks", false, "also restore the webhooks of the database")
+	dryRun := fs.Bool("dry-run", false, "only report what would change")
+	args, err := parseFlags(fs, Args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usage("needs an archive file")
+	}
+	MC, err := E.moncore("restore")
+	if err != nil {
+		return err
+	}
+	P, err := E.Printer()
+	if err != nil {
+		return err
+	}
+
+	// A first pass checks the whole archive, so a corrupted one writes nothing
+	if _, err := verifyArchive(args[0]); err != nil {
+		return err
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx, cancel := E.Context()
+	defer cancel()
+	Rep, err := MC.RestoreDatabase(ctx, f, &moncore.RestoreOptions{Database: *db, Collections: splitList(*collections), Drop: *drop, Webhooks: *webhooks, DryRun: *dryRun})
+	if err != nil {
+		return err
+	}
+
+	if P.Format != OutputTable {
+		return P.One(Rep)
+	}
+
+	what := "restored into"
+	if Rep.DryRun {
+		what = "would be restored into (dry run)"
+	}
+	fmt.Fprintf(E.Stderr, "backup of %s at %s %s %s\n", Rep.Source, Rep.Created.Format(time.RFC3339), what, Rep.Database)
+	for _, CR := range Rep.Collections {
+		P.Add(struct {
+			Name      string
+			Exists    bool
+			Dropped   bool
+			Documents int64
+			Inserted  int64
+			Replaced  int64
+			Unchanged int64
+			Removed   int64
+			Indexes   string
+		}{CR.Name, CR.Exists, CR.Dropped, CR.Documents, CR.Inserted, CR.Replaced, CR.Unchanged, CR.Removed, strings.Join(CR.Indexes, ",")})
+	}
+	if err := P.Flush(); err != nil {
+		return err
+	}
+	if *webhooks {
+		fmt.Fprintf(E.Stderr, "%d webhooks added\n", Rep.Webhooks)
+	}
+	return nil
+}
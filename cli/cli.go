@@ -0,0 +1,220 @@
+// Package cli is the mongomini command-line tool :
+//
+//	mongomini [-profile NAME] [-o table|json|ndjson] <command> [arguments]
+//
+// Commands talk to the HTTP API (profile url and key) or straight to MongoDB through moncore (profile mongo).
+// Profiles are kept in a YAML config file, see Config.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+// A subcommand
+type Command struct {
+	Name    string
+	Usage   string // Arguments, after the name
+	Summary string
+	Run     func(E *Env, Args []string) error
+}
+
+// Subcommands, in help order. Filled in init() as commands refer to the list in help
+var Commands []*Command
+
+// State of a command line run
+type Env struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	ConfigPath  string
+	ProfileName string
+	Output      string // Output format, "" for the profile one
+
+	config  *Config
+	backend Backend
+}
+
+// Error of bad arguments : the usage of the command is printed
+type usageError struct {
+	msg string
+}
+
+func (E *usageError) Error() string {
+	return E.msg
+}
+
+func usage(msg string) error {
+	return &usageError{msg: msg}
+}
+
+// Run the command line (without the program name) and return the exit code
+func Main(Args []string) int {
+	E := &Env{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+	return E.Run(Args)
+}
+
+func (E *Env) Run(Args []string) int {
+	fs := flag.NewFlagSet("mongomini", flag.ContinueOnError)
+	fs.SetOutput(E.Stderr)
+	E.globalFlags(fs)
+	fs.Usage = func() { E.help(nil) }
+	if err := fs.Parse(Args); err != nil {
+		return 2
+	}
+
+	if fs.NArg() == 0 {
+		E.help(nil)
+		return 2
+	}
+
+	Cmd := findCommand(fs.Arg(0))
+	if Cmd == nil {
+		fmt.Fprintln(E.Stderr, "mongomini : unknown command "+fs.Arg(0))
+		E.help(nil)
+		return 2
+	}
+
+	err := Cmd.Run(E, fs.Args()[1:])
+	if E.backend != nil {
+		E.backend.Close()
+	}
+
+	var U *usageError
+	if errors.As(err, &U) {
+		fmt.Fprintln(E.Stderr, "mongomini "+Cmd.Name+" : "+U.msg)
+		fmt.Fprintln(E.Stderr, "usage : mongomini "+Cmd.Name+" "+Cmd.Usage)
+		return 2
+	} else if errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		fmt.Fprintln(E.Stderr, "mongomini "+Cmd.Name+" : "+err.Error())
+		return 1
+	}
+	return 0
+}
+
+func findCommand(Name string) *Command {
+	for _, C := range Commands {
+		if C.Name == Name {
+			return C
+		}
+	}
+	return nil
+}
+
+func (E *Env) globalFlags(fs *flag.FlagSet) {
+	fs.StringVar(&E.ConfigPath, "config", E.ConfigPath, "config file (default $MONGOMINI_CONFIG or the user config directory)")
+	fs.StringVar(&E.ProfileName, "profile", E.ProfileName, "profile of the config file (default $MONGOMINI_PROFILE or the default profile)")
+	fs.StringVar(&E.Output, "o", E.Output, "output format : table | json | ndjson")
+}
+
+// Flags of a command, global ones included
+func (E *Env) Flags(Cmd string) *flag.FlagSet {
+	fs := flag.NewFlagSet("mongomini "+Cmd, flag.ContinueOnError)
+	fs.SetOutput(E.Stderr)
+	E.globalFlags(fs)
+	return fs
+}
+
+// Parse flags placed anywhere between the positional arguments, and return those
+func parseFlags(fs *flag.FlagSet, Args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(Args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		Args = fs.Args()[1:]
+	}
+}
+
+func (E *Env) Config() (*Config, error) {
+	if E.config != nil {
+		return E.config, nil
+	}
+	if E.ConfigPath == "" {
+		E.ConfigPath = ConfigPath()
+	}
+	C, err := LoadConfig(E.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	E.config = C
+	return C, nil
+}
+
+// Selected profile, with environment overrides
+func (E *Env) Profile() (*Profile, error) {
+	C, err := E.Config()
+	if err != nil {
+		return nil, err
+	}
+	name := E.ProfileName
+	if name == "" {
+		name = os.Getenv("MONGOMINI_PROFILE")
+	}
+	return C.Profile(name)
+}
+
+// Backend of the selected profile, opened on first use
+func (E *Env) Backend() (Backend, error) {
+	if E.backend != nil {
+		return E.backend, nil
+	}
+	P, err := E.Profile()
+	if err != nil {
+		return nil, err
+	}
+	B, err := Open(P)
+	if err != nil {
+		return nil, err
+	}
+	E.backend = B
+	return B, nil
+}
+
+// Printer of the output format : -o, or the profile one
+func (E *Env) Printer() (*Printer, error) {
+	format := E.Output
+	if format == "" {
+		if P, err := E.Profile(); err == nil {
+			format = P.Output
+		}
+	}
+	return NewPrinter(format, E.Stdout)
+}
+
+// Context cancelled by Ctrl-C
+func (E *Env) Context() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+func (E *Env) help(Cmd *Command) {
+	if Cmd != nil {
+		fmt.Fprintln(E.Stderr, "usage : mongomini "+Cmd.Name+" "+Cmd.Usage)
+		fmt.Fprintln(E.Stderr, "\n"+Cmd.Summary)
+		return
+	}
+
+	fmt.Fprintln(E.Stderr, "usage : mongomini [-profile NAME] [-o table|json|ndjson] <command> [arguments]")
+	fmt.Fprintln(E.Stderr, "        mongomini (no command, or serve) runs the API server")
+	fmt.Fprintln(E.Stderr, "\ncommands :")
+	width := 0
+	for _, C := range Commands {
+		width = max(width, len(C.Name))
+	}
+	for _, C := range Commands {
+		fmt.Fprintln(E.Stderr, "  "+C.Name+strings.Repeat(" ", width-len(C.Name)+2)+C.Summary)
+	}
+}
@@ -0,0 +1,594 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mongomini/agra/moncore"
+	"mongomini/agra/rules"
+	"mongomini/client"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func init() {
+	Commands = []*Command{
+		{Name: "ls", Usage: "<db> [collection [filter...]] [-limit N]", Summary: "List the collections of a database, or the documents of a collection", Run: cmdLs},
+		{Name: "get", Usage: "<db> <collection> <key>", Summary: "Print a document", Run: cmdGet},
+		{Name: "set", Usage: "<db> <collection> <key> <document | ->", Summary: "Create or replace a document. The document is (Extended) JSON, - reads it from stdin", Run: cmdSet},
+		{Name: "delete", Usage: "<db> <collection> <key>", Summary: "Delete a document", Run: cmdDelete},
+		{Name: "query", Usage: "<db> <collection> [filter...] [-limit N]", Summary: "Print the documents matching the filters, like 'status==paid' 'email=exist' (see moncore.Filter_FromQueryStrings)", Run: cmdQuery},
+		{Name: "export", Usage: "<db> <collection> [filter...] [-file F] [-format ndjson|json|csv|bson] [-fields F] [-limit N]", Summary: "Write documents to stdout or a file, as Extended JSON, CSV or BSON", Run: cmdExport},
+		{Name: "import", Usage: "<db> <collection> [-file F] [-format ndjson|json|csv|bson] [-fields F] [-mode upsert|replace|insert] [-batch N] [-keep-going]", Summary: "Write documents read from stdin or a file in bulk", Run: cmdImport},
+		{Name: "backup", Usage: "<db> [-file F] [-collections a,b] [-no-snapshot]", Summary: "Write a point-in-time archive of a database : documents, indexes and webhooks (needs a mongo profile)", Run: cmdBackup},
+		{Name: "verify", Usage: "<archive>", Summary: "Check the manifest and checksums of a backup archive, offline", Run: cmdVerify},
+		{Name: "restore", Usage: "<archive> [-db name] [-collections a,b] [-drop] [-webhooks] [-dry-run]", Summary: "Restore a backup archive, or report what would change with -dry-run (needs a mongo profile)", Run: cmdRestore},
+		{Name: "watch", Usage: "<db> <collection> [filter...] [-events insert,update,delete]", Summary: "Print changes of a collection until Ctrl-C (needs a url profile)", Run: cmdWatch},
+		{Name: "shell", Usage: "[db] [-write] [-pagesize N]", Summary: "Interactive shell on MongoDB (needs a mongo profile). Read-only unless -write", Run: cmdShell},
+		{Name: "keys", Usage: "ls | create <name> <scope>... | rotate <id> | revoke <id>", Summary: "Manage API keys", Run: cmdKeys},
+		{Name: "profile", Usage: "ls | show [name] | set <name> [-url U] [-key K] [-mongo URI] [-output F] | use <name> | rm <name>", Summary: "Manage connection profiles of the config file", Run: cmdProfile},
+		{Name: "test-rules", Usage: "<rules file> <cases file>", Summary: "Check a rules file against sample requests (see package rules)", Run: cmdTestRules},
+		{Name: "help", Usage: "[command]", Summary: "Show help", Run: cmdHelp},
+	}
+}
+
+// Filter arguments in the query string grammar : 'status==paid', 'age=-0&email=exist'
+func parseFilter(Args []string) (url.Values, error) {
+	Q := url.Values{}
+	for _, arg := range Args {
+		part, err := url.ParseQuery(arg)
+		if err != nil {
+			return nil, usage("bad filter " + arg + " : " + err.Error())
+		}
+		for k, vs := range part {
+			Q[k] = append(Q[k], vs...)
+		}
+	}
+	return Q, nil
+}
+
+// Document of (Extended) JSON text
+func parseDocument(Src []byte) (bson.D, error) {
+	Doc := bson.D{}
+	if err := bson.UnmarshalExtJSON(bytes.TrimSpace(Src), false, &Doc); err != nil {
+		return nil, errors.New("document must be a JSON object : " + err.Error())
+	}
+	return Doc, nil
+}
+
+func cmdLs(E *Env, Args []string) error {
+	fs := E.Flags("ls")
+	limit := fs.Int64("limit", 0, "most documents to list. 0 is no limit")
+	args, err := parseFlags(fs, Args)
+	if err != nil {
+		return err
+	}
+
+	switch len(args) {
+	case 0:
+		return usage("missing database")
+	case 1:
+		B, err := E.Backend()
+		if err != nil {
+			return err
+		}
+		P, err := E.Printer()
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := E.Context()
+		defer cancel()
+		names, err := B.ListCollections(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			P.Add(map[string]string{"collection": name})
+		}
+		return P.Flush()
+	}
+	return query(E, args, *limit)
+}
+
+func cmdQuery(E *Env, Args []string) error {
+	fs := E.Flags("query")
+	limit := fs.Int64("limit", 0, "most documents to print. 0 is no limit")
+	args, err := parseFlags(fs, Args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return usage("missing database or collection")
+	}
+	return query(E, args, *limit)
+}
+
+// Print the documents of args : db, collection, filters...
+func query(E *Env, args []string, Limit int64) error {
+	F, err := parseFilter(args[2:])
+	if err != nil {
+		return err
+	}
+	B, err := E.Backend()
+	if err != nil {
+		return err
+	}
+	P, err := E.Printer()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := E.Context()
+	defer cancel()
+	err = B.Query(ctx, args[0], args[1], F, Limit, func(Doc *moncore.GenericDBDocument) error {
+		return P.Add(Doc)
+	})
+	if err != nil {
+		return err
+	}
+	return P.Flush()
+}
+
+func cmdGet(E *Env, Args []string) error {
+	args, err := parseFlags(E.Flags("get"), Args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 3 {
+		return usage("needs a database, a collection and a key")
+	}
+	B, err := E.Backend()
+	if err != nil {
+		return err
+	}
+	P, err := E.Printer()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := E.Context()
+	defer cancel()
+	Doc, err := B.Get(ctx, args[0], args[1], args[2])
+	if err != nil {
+		return err
+	}
+	return P.One(Doc)
+}
+
+func cmdSet(E *Env, Args []string) error {
+	args, err := parseFlags(E.Flags("set"), Args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 4 {
+		return usage("needs a database, a collection, a key and a document")
+	}
+
+	src := []byte(args[3])
+	if args[3] == "-" {
+		if src, err = io.ReadAll(E.Stdin); err != nil {
+			return err
+		}
+	}
+	Doc, err := parseDocument(src)
+	if err != nil {
+		return err
+	}
+
+	B, err := E.Backend()
+	if err != nil {
+		return err
+	}
+	P, err := E.Printer()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := E.Context()
+	defer cancel()
+	Res, err := B.Set(ctx, args[0], args[1], args[2], Doc)
+	if err != nil {
+		return err
+	}
+	return P.One(Res)
+}
+
+func cmdDelete(E *Env, Args []string) error {
+	args, err := parseFlags(E.Flags("delete"), Args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 3 {
+		return usage("needs a database, a collection and a key")
+	}
+	B, err := E.Backend()
+	if err != nil {
+		return err
+	}
+	P, err := E.Printer()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := E.Context()
+	defer cancel()
+	Res, err := B.Delete(ctx, args[0], args[1], args[2])
+	if err != nil {
+		return err
+	}
+	return P.One(Res)
+}
+
+// Transfer format of -format, or of the file extension. NDJSON by default
+func transferFormat(Format string, File string) string {
+	if Format != "" {
+		return Format
+	}
+	switch strings.ToLower(filepath.Ext(File)) {
+	case ".json":
+		return moncore.TransferJSON
+	case ".csv":
+		return moncore.TransferCSV
+	case ".bson":
+		return moncore.TransferBSON
+	}
+	return moncore.TransferNDJSON
+}
+
+// Writer counting the bytes written
+type countingWriter struct {
+	W io.Writer
+	N int64
+}
+
+func (C *countingWriter) Write(P []byte) (int, error) {
+	n, err := C.W.Write(P)
+	C.N += int64(n)
+	return n, err
+}
+
+func cmdExport(E *Env, Args []string) error {
+	fs := E.Flags("export")
+	file := fs.String("file", "", "output file. Default is stdout")
+	format := fs.String("format", "", "ndjson, json, csv or bson. Default is the file extension, then ndjson")
+	fields := fs.String("fields", "", "CSV columns, like _id,name,email=contact.email. Default is _id and the top-level fields of the first document, other fields are left out")
+	limit := fs.Int64("limit", 0, "most documents to export. 0 is no limit")
+	args, err := parseFlags(fs, Args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return usage("missing database or collection")
+	}
+	F, err := parseFilter(args[2:])
+	if err != nil {
+		return err
+	}
+	B, err := E.Backend()
+	if err != nil {
+		return err
+	}
+
+	out := E.Stdout
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	W := &countingWriter{W: out}
+
+	if transferFormat(*format, *file) == moncore.TransferCSV && *fields == "" {
+		fmt.Fprintln(E.Stderr, "warning : without -fields, CSV columns are the fields of the first document. Fields missing from it are not exported")
+	}
+
+	ctx, cancel := E.Context()
+	defer cancel()
+	err = B.Export(ctx, args[0], args[1], F, client.ExportOptions{Format: transferFormat(*format, *file), Fields: *fields, Limit: *limit}, W)
+	if err != nil {
+		return err
+	}
+	if *file != "" {
+		fmt.Fprintf(E.Stderr, "%d bytes exported to %s\n", W.N, *file)
+	}
+	return nil
+}
+
+func cmdImport(E *Env, Args []string) error {
+	fs := E.Flags("import")
+	file := fs.String("file", "", "input file. Default is stdin")
+	format := fs.String("format", "", "ndjson, json, csv or bson. Default is the file extension, then ndjson")
+	fields := fs.String("fields", "", "CSV columns, like _id,name,email=contact.email,age:int. Default is the header, as strings")
+	mode := fs.String("mode", moncore.ImportUpsert, "upsert (merge fields), replace or insert (skip existing documents)")
+	batch := fs.Int("batch", 0, "documents per bulk write. Default is the server one")
+	keepGoing := fs.Bool("keep-going", false, "report rejected documents and go on")
+	args, err := parseFlags(fs, Args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return usage("needs a database and a collection")
+	}
+	B, err := E.Backend()
+	if err != nil {
+		return err
+	}
+
+	in := E.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	ctx, cancel := E.Context()
+	defer cancel()
+
+	opts := client.ImportOptions{Format: transferFormat(*format, *file), Mode: *mode, BatchSize: *batch, Fields: *fields, KeepGoing: *keepGoing}
+	Res, err := B.Import(ctx, args[0], args[1], in, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, IE := range Res.Errors {
+		fmt.Fprintf(E.Stderr, "record %d %s : %s\n", IE.Record, IE.ID, IE.Message)
+	}
+	fmt.Fprintf(E.Stderr, "%d read, %d inserted, %d updated, %d skipped, %d failed\n", Res.Read, Res.Inserted, Res.Updated, Res.Skipped, Res.Failed)
+	if Res.Failed != 0 {
+		return errors.New("some documents were not written")
+	}
+	return nil
+}
+
+func cmdWatch(E *Env, Args []string) error {
+	fs := E.Flags("watch")
+	events := fs.String("events", "", "event types to show, like insert,delete. Default is all")
+	args, err := parseFlags(fs, Args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return usage("missing database or collection")
+	}
+	F, err := parseFilter(args[2:])
+	if err != nil {
+		return err
+	}
+	B, err := E.Backend()
+	if err != nil {
+		return err
+	}
+	P, err := E.Printer()
+	if err != nil {
+		return err
+	}
+
+	types := []string{}
+	for _, t := range strings.Split(*events, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+
+	ctx, cancel := E.Context()
+	defer cancel()
+	return B.Watch(ctx, args[0], args[1], F, types, func(Event *moncore.ChangeEvent) error {
+		if P.Format != OutputTable {
+			return P.Add(Event)
+		}
+		// Tables can't wait for the end of the stream : one line per event
+		summary := ""
+		if Event.Document != nil {
+			_, values, _ := tableRow(Event.Document)
+			delete(values, "_id")
+			parts := []string{}
+			for k, v := range values {
+				parts = append(parts, k+"="+v)
+			}
+			summary = cell(strings.Join(parts, " "))
+		}
+		_, err := fmt.Fprintf(P.W, "%s  %-6s  %s  %s\n", Event.Time.Local().Format(time.TimeOnly), Event.Type, Event.ID, summary)
+		return err
+	})
+}
+
+func cmdKeys(E *Env, Args []string) error {
+	args, err := parseFlags(E.Flags("keys"), Args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usage("missing subcommand")
+	}
+	B, err := E.Backend()
+	if err != nil {
+		return err
+	}
+	P, err := E.Printer()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := E.Context()
+	defer cancel()
+
+	switch {
+	case args[0] == "ls" && len(args) == 1:
+		keys, err := B.ListKeys(ctx)
+		if err != nil {
+			return err
+		}
+		for _, K := range keys {
+			P.Add(K)
+		}
+		return P.Flush()
+
+	case args[0] == "create" && len(args) >= 3:
+		T, err := B.CreateKey(ctx, args[1], args[2:])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(E.Stderr, "The token is only shown once")
+		return P.One(T)
+
+	case args[0] == "rotate" && len(args) == 2:
+		T, err := B.RotateKey(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(E.Stderr, "The token is only shown once")
+		return P.One(T)
+
+	case args[0] == "revoke" && len(args) == 2:
+		return B.RevokeKey(ctx, args[1])
+	}
+	return usage("bad subcommand or arguments")
+}
+
+func cmdProfile(E *Env, Args []string) error {
+	fs := E.Flags("profile")
+	set := &Profile{}
+	fs.StringVar(&set.URL, "url", "", "base URL of the HTTP API")
+	fs.StringVar(&set.Key, "key", "", "API key or access token")
+	fs.StringVar(&set.Mongo, "mongo", "", "MongoDB connection string, for direct access")
+	fs.StringVar(&set.Output, "output", "", "default output format")
+	args, err := parseFlags(fs, Args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usage("missing subcommand")
+	}
+
+	C, err := E.Config()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "ls" && len(args) == 1:
+		P, err := E.Printer()
+		if err != nil {
+			return err
+		}
+		for _, name := range C.Names() {
+			Pr := C.Profiles[name]
+			target := Pr.URL
+			if target == "" {
+				target = "mongo"
+			}
+			P.Add(map[string]interface{}{"name": name, "default": name == C.Default, "target": target})
+		}
+		return P.Flush()
+
+	case args[0] == "show" && len(args) <= 2:
+		name := E.ProfileName
+		if len(args) == 2 {
+			name = args[1]
+		}
+		Pr, err := C.Profile(name)
+		if err != nil {
+			return err
+		}
+		shown := *Pr
+		if shown.Key != "" {
+			shown.Key = "…" + shown.Key[max(0, len(shown.Key)-4):]
+		}
+		if shown.Mongo != "" {
+			if U, err := url.Parse(shown.Mongo); err == nil && U.User != nil {
+				U.User = url.User(U.User.Username())
+				shown.Mongo = U.String()
+			}
+		}
+		P, err := E.Printer()
+		if err != nil {
+			return err
+		}
+		return P.One(map[string]string{"url": shown.URL, "key": shown.Key, "mongo": shown.Mongo, "output": shown.Output})
+
+	case args[0] == "set" && len(args) == 2:
+		if set.Output != "" {
+			if _, err := NewPrinter(set.Output, nil); err != nil {
+				return err
+			}
+		}
+		Pr, ok := C.Profiles[args[1]]
+		if !ok {
+			Pr = &Profile{}
+			C.Profiles[args[1]] = Pr
+		}
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "url":
+				Pr.URL = set.URL
+			case "key":
+				Pr.Key = set.Key
+			case "mongo":
+				Pr.Mongo = set.Mongo
+			case "output":
+				Pr.Output = set.Output
+			}
+		})
+		if C.Default == "" {
+			C.Default = args[1]
+		}
+		return C.Save()
+
+	case args[0] == "use" && len(args) == 2:
+		if _, ok := C.Profiles[args[1]]; !ok {
+			return errors.New("no profile " + args[1])
+		}
+		C.Default = args[1]
+		return C.Save()
+
+	case args[0] == "rm" && len(args) == 2:
+		if _, ok := C.Profiles[args[1]]; !ok {
+			return errors.New("no profile " + args[1])
+		}
+		delete(C.Profiles, args[1])
+		if C.Default == args[1] {
+			C.Default = ""
+		}
+		return C.Save()
+	}
+	return usage("bad subcommand or arguments")
+}
+
+func cmdTestRules(E *Env, Args []string) error {
+	if len(Args) != 2 {
+		return usage("needs a rules file and a cases file")
+	}
+	failed, err := rules.TestFiles(E.Stdout, Args[0], Args[1])
+	if err != nil {
+		return err
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d cases failed", failed)
+	}
+	return nil
+}
+
+func cmdHelp(E *Env, Args []string) error {
+	if len(Args) == 1 {
+		if Cmd := findCommand(Args[0]); Cmd != nil {
+			E.help(Cmd)
+			return nil
+		}
+	}
+	E.help(nil)
+	return nil
+}
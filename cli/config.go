@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Connection settings of one deployment. URL talks to the HTTP API, Mongo directly to MongoDB through moncore (URL wins if both are set)
+type Profile struct {
+	URL    string `yaml:"url,omitempty"`
+	Key    string `yaml:"key,omitempty"`    // API key or access token for URL
+	Mongo  string `yaml:"mongo,omitempty"`  // MongoDB connection string
+	Output string `yaml:"output,omitempty"` // Default output format : table | json | ndjson
+}
+
+// Config file, YAML :
+//
+//	default: prod
+//	profiles:
+//	  prod:
+//	    url: https://mongomini.example.com
+//	    key: mm_...
+//	  local:
+//	    mongo: mongodb://localhost:27017
+type Config struct {
+	Default  string              `yaml:"default,omitempty"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+
+	path string
+}
+
+// $MONGOMINI_CONFIG, or mongomini/config.yaml in the user config directory
+func ConfigPath() string {
+	if envarg := os.Getenv("MONGOMINI_CONFIG"); len(envarg) != 0 {
+		return envarg
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "mongomini.yaml"
+	}
+	return filepath.Join(dir, "mongomini", "config.yaml")
+}
+
+// Read the config file. A missing file is an empty config
+func LoadConfig(Path string) (*Config, error) {
+	C := &Config{Profiles: map[string]*Profile{}, path: Path}
+
+	src, err := os.ReadFile(Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return C, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(src, C); err != nil {
+		return nil, errors.New(Path + " : " + err.Error())
+	}
+	if C.Profiles == nil {
+		C.Profiles = map[string]*Profile{}
+	}
+	return C, nil
+}
+
+// Write the config file. It holds keys, so only the owner may read it
+func (C *Config) Save() error {
+	src, err := yaml.Marshal(C)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(C.path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(C.path, src, 0o600)
+}
+
+// Profile by name, "" for the default one. Environment variables override it :
+// MONGOMINI_URL, MONGOMINI_KEY, MONGOMINI_MONGO
+func (C *Config) Profile(Name string) (*Profile, error) {
+	if Name == "" {
+		Name = C.Default
+	}
+
+	P := &Profile{}
+	if Name != "" {
+		found, ok := C.Profiles[Name]
+		if !ok {
+			return nil, errors.New("no profile " + Name + " in " + C.path)
+		}
+		*P = *found
+	}
+
+	if envarg := os.Getenv("MONGOMINI_URL"); len(envarg) != 0 {
+		P.URL = envarg
+	}
+	if envarg := os.Getenv("MONGOMINI_KEY"); len(envarg) != 0 {
+		P.Key = envarg
+	}
+	if envarg := os.Getenv("MONGOMINI_MONGO"); len(envarg) != 0 {
+		P.Mongo = envarg
+	}
+	return P, nil
+}
+
+func (C *Config) Names() []string {
+	names := make([]string, 0, len(C.Profiles))
+	for name := range C.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,250 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"mongomini/agra/moncore"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Output formats
+const (
+	OutputTable  = "table"
+	OutputJSON   = "json"
+	OutputNDJSON = "ndjson"
+)
+
+// Longest table cell, in characters
+var MaxCellWidth = 40
+
+// Prints values in the output format. Lists are printed by Add() then Flush(), single values by One()
+type Printer struct {
+	Format string
+	W      io.Writer
+
+	rows []interface{}
+}
+
+func NewPrinter(Format string, W io.Writer) (*Printer, error) {
+	switch Format {
+	case "":
+		Format = OutputTable
+	case OutputTable, OutputJSON, OutputNDJSON:
+	default:
+		return nil, errors.New("unknown output format " + Format + " : table | json | ndjson")
+	}
+	return &Printer{Format: Format, W: W}, nil
+}
+
+// Add a row of a list. NDJSON rows are written at once, others on Flush()
+func (P *Printer) Add(v interface{}) error {
+	if P.Format == OutputNDJSON {
+		J, err := marshalValue(v, false)
+		if err != nil {
+			return err
+		}
+		_, err = P.W.Write(append(J, '\n'))
+		return err
+	}
+	P.rows = append(P.rows, v)
+	return nil
+}
+
+// Print the rows added since the last Flush()
+func (P *Printer) Flush() error {
+	rows := P.rows
+	P.rows = nil
+
+	switch P.Format {
+	case OutputJSON:
+		parts := make([]json.RawMessage, len(rows))
+		for i, v := range rows {
+			J, err := marshalValue(v, false)
+			if err != nil {
+				return err
+			}
+			parts[i] = J
+		}
+		J, err := json.MarshalIndent(parts, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = P.W.Write(append(J, '\n'))
+		return err
+
+	case OutputTable:
+		return P.table(rows)
+	}
+	return nil
+}
+
+// Print a single value. Tables show one field per line
+func (P *Printer) One(v interface{}) error {
+	switch P.Format {
+	case OutputJSON:
+		J, err := marshalValue(v, true)
+		if err != nil {
+			return err
+		}
+		_, err = P.W.Write(append(J, '\n'))
+		return err
+
+	case OutputNDJSON:
+		return P.Add(v)
+	}
+
+	columns, values, err := tableRow(v)
+	if err != nil {
+		return err
+	}
+	T := tabwriter.NewWriter(P.W, 0, 4, 2, ' ', 0)
+	for _, c := range columns {
+		fmt.Fprintf(T, "%s\t%s\n", c, values[c])
+	}
+	return T.Flush()
+}
+
+func (P *Printer) table(rows []interface{}) error {
+	if len(rows) == 0 {
+		_, err := fmt.Fprintln(P.W, "(none)")
+		return err
+	}
+
+	columns := []string{}
+	seen := map[string]bool{}
+	cells := make([]map[string]string, len(rows))
+	for i, v := range rows {
+		cols, values, err := tableRow(v)
+		if err != nil {
+			return err
+		}
+		cells[i] = values
+		for _, c := range cols {
+			if !seen[c] {
+				seen[c] = true
+				columns = append(columns, c)
+			}
+		}
+	}
+
+	T := tabwriter.NewWriter(P.W, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(T, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, values := range cells {
+		line := make([]string, len(columns))
+		for i, c := range columns {
+			line[i] = values[c]
+		}
+		fmt.Fprintln(T, strings.Join(line, "\t"))
+	}
+	return T.Flush()
+}
+
+// Columns and cells of a value : _id then sorted fields for documents, JSON fields in order for others
+func tableRow(v interface{}) ([]string, map[string]string, error) {
+	if Doc, ok := v.(*moncore.GenericDBDocument); ok {
+		fields := make([]string, 0, len(Doc.Doc))
+		for k := range Doc.Doc {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+
+		values := map[string]string{"_id": Doc.ID}
+		for _, k := range fields {
+			values[k] = cell(Doc.Doc[k])
+		}
+		return append([]string{"_id"}, fields...), values, nil
+	}
+
+	J, err := json.Marshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	D := json.NewDecoder(bytes.NewReader(J))
+	D.UseNumber()
+	if tok, err := D.Token(); err != nil || tok != json.Delim('{') {
+		var scalar interface{}
+		json.Unmarshal(J, &scalar)
+		return []string{"value"}, map[string]string{"value": cell(scalar)}, nil
+	}
+
+	columns, values := []string{}, map[string]string{}
+	for D.More() {
+		tok, err := D.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		var field interface{}
+		if err := D.Decode(&field); err != nil {
+			return nil, nil, err
+		}
+		k := tok.(string)
+		columns = append(columns, k)
+		values[k] = cell(field)
+	}
+	return columns, values, nil
+}
+
+// Short text of a field value
+func cell(v interface{}) string {
+	text := ""
+	switch V := v.(type) {
+	case nil:
+	case string:
+		text = V
+	case json.Number:
+		text = V.String()
+	case primitive.DateTime:
+		text = V.Time().UTC().Format(time.RFC3339)
+	case primitive.ObjectID:
+		text = V.Hex()
+	default:
+		if J, err := marshalValue(V, false); err == nil {
+			text = string(J)
+		} else {
+			text = fmt.Sprint(V)
+		}
+	}
+
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) > MaxCellWidth {
+		text = string([]rune(text)[:MaxCellWidth-1]) + "…"
+	}
+	return text
+}
+
+// JSON of a value. Documents and BSON values are relaxed Extended JSON, so they keep their types (and can be imported back)
+func marshalValue(v interface{}, Indent bool) ([]byte, error) {
+	var J []byte
+	var err error
+
+	switch v.(type) {
+	case *moncore.GenericDBDocument, moncore.GenericDocument, bson.M, bson.D, bson.A, primitive.DateTime, primitive.ObjectID, primitive.Decimal128, primitive.Binary:
+		J, err = bson.MarshalExtJSON(bson.M{"v": v}, false, false)
+		if err == nil {
+			J = bytes.TrimSuffix(bytes.TrimPrefix(J, []byte(`{"v":`)), []byte("}"))
+		}
+	default:
+		J, err = json.Marshal(v)
+	}
+	if err != nil || !Indent {
+		return J, err
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, J, "", "  "); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,463 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mongomini/agra/moncore"
+
+	"golang.org/x/term"
+)
+
+// Lines kept in the history file
+var ShellHistorySize = 500
+
+// Interactive shell on MongoDB through moncore, see shellHelp
+type shell struct {
+	E        *Env
+	MC       *moncore.Moncore
+	DB       string
+	Printer  *Printer
+	PageSize int
+	Write    bool // Allow set and delete
+
+	term        *term.Terminal // nil when stdin isn't a terminal
+	out         io.Writer
+	collections []string // Completion candidates of DB
+	history     []string
+	historyFile string
+}
+
+const shellHelp = `use <db>                          switch database
+show collections                  list collections of the database
+find <collection> [filter]        print matching documents, a page at a time
+count <collection> [filter]       count matching documents
+get <collection> <key>            print a document
+set <collection> <key> <json>     create or replace a document (needs -write)
+delete <collection> <key>         delete a document (needs -write)
+output table|json|ndjson          output format
+pagesize <n>                      documents per page
+history                           previous commands. !N runs command N again
+help, exit
+
+Filters are query strings like status==paid&email=exist (see moncore.Filter_FromQueryStrings)
+or JSON objects like {"total": {"$ne": 0}} (see moncore.Filter_FromJSON).
+Tab completes commands and collection names.`
+
+func cmdShell(E *Env, Args []string) error {
+	fs := E.Flags("shell")
+	write := fs.Bool("write", false, "allow set and delete")
+	pageSize := fs.Int("pagesize", 20, "documents per page")
+	args, err := parseFlags(fs, Args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return usage("too many arguments")
+	}
+
+	B, err := E.Backend()
+	if err != nil {
+		return err
+	}
+	direct, ok := B.(*directBackend)
+	if !ok {
+		return errors.New("the shell needs a profile with a mongo connection string")
+	}
+	P, err := E.Printer()
+	if err != nil {
+		return err
+	}
+
+	S := &shell{E: E, MC: direct.mc, Printer: P, PageSize: max(1, *pageSize), Write: *write, out: E.Stdout}
+	if C, err := E.Config(); err == nil {
+		S.historyFile = filepath.Join(filepath.Dir(C.path), "history")
+	}
+	S.loadHistory()
+	if len(args) == 1 {
+		S.use(args[0])
+	}
+
+	if f, ok := E.Stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		state, err := term.MakeRaw(int(f.Fd()))
+		if err != nil {
+			return err
+		}
+		defer term.Restore(int(f.Fd()), state)
+
+		S.term = term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{f, E.Stdout}, "")
+		S.term.AutoCompleteCallback = S.complete
+		if width, height, err := term.GetSize(int(f.Fd())); err == nil {
+			S.term.SetSize(width, height)
+		}
+		S.out = S.term
+		S.Printer.W = S.term
+		fmt.Fprintln(S.out, "mongomini shell. help for commands, Ctrl-D or exit to quit")
+	}
+
+	return S.loop()
+}
+
+func (S *shell) prompt() string {
+	if S.DB == "" {
+		return "mongomini> "
+	}
+	return S.DB + "> "
+}
+
+// Next line, nil error and false at the end of the input
+func (S *shell) readLine(lines *bufio.Scanner, Prompt string) (string, bool, error) {
+	if S.term != nil {
+		S.term.SetPrompt(Prompt)
+		line, err := S.term.ReadLine()
+		if err == io.EOF {
+			return "", false, nil
+		}
+		return line, err == nil, err
+	}
+	if !lines.Scan() {
+		return "", false, lines.Err()
+	}
+	return lines.Text(), true, nil
+}
+
+func (S *shell) loop() error {
+	lines := bufio.NewScanner(S.E.Stdin)
+	lines.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for {
+		line, ok, err := S.readLine(lines, S.prompt())
+		if err != nil || !ok {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "!") {
+			n, err := strconv.Atoi(line[1:])
+			if err != nil || n < 1 || n > len(S.history) {
+				fmt.Fprintln(S.out, "no command "+line)
+				continue
+			}
+			line = S.history[n-1]
+			fmt.Fprintln(S.out, line)
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		S.remember(line)
+
+		if line == "exit" || line == "quit" {
+			return nil
+		}
+		if err := S.run(lines, line); err != nil {
+			fmt.Fprintln(S.out, "error : "+err.Error())
+		}
+	}
+}
+
+// First N words of the line, and the rest
+func splitArgs(Line string, N int) ([]string, string) {
+	words := []string{}
+	rest := strings.TrimSpace(Line)
+	for len(words) < N && rest != "" {
+		end := strings.IndexAny(rest, " \t")
+		if end < 0 {
+			end = len(rest)
+		}
+		words = append(words, rest[:end])
+		rest = strings.TrimSpace(rest[end:])
+	}
+	return words, rest
+}
+
+// Filter of a query string or a JSON object. "" matches all
+func parseShellFilter(Src string) (*moncore.Filter, error) {
+	Src = strings.TrimSpace(Src)
+	switch {
+	case Src == "":
+		return moncore.Filter_MatchAll(), nil
+	case strings.HasPrefix(Src, "{"):
+		return moncore.Filter_FromJSON([]byte(Src))
+	}
+
+	Q, err := url.ParseQuery(Src)
+	if err != nil {
+		return nil, err
+	}
+	return moncore.Filter_FromQueryStrings(Q), nil
+}
+
+func (S *shell) collection(Name string) (*moncore.Collection, error) {
+	if S.DB == "" {
+		return nil, errors.New("no database. use <db> first")
+	}
+	return S.MC.Database(S.DB).Collection(Name), nil
+}
+
+func (S *shell) run(lines *bufio.Scanner, Line string) error {
+	words, rest := splitArgs(Line, 1)
+	cmd := words[0]
+
+	switch cmd {
+	case "help":
+		fmt.Fprintln(S.out, shellHelp)
+
+	case "use":
+		args, extra := splitArgs(rest, 1)
+		if len(args) != 1 || extra != "" {
+			return errors.New("use <db>")
+		}
+		S.use(args[0])
+
+	case "show":
+		if rest != "collections" {
+			return errors.New("show collections")
+		}
+		if S.DB == "" {
+			return errors.New("no database. use <db> first")
+		}
+		S.refreshCollections()
+		for _, name := range S.collections {
+			S.Printer.Add(map[string]string{"collection": name})
+		}
+		return S.Printer.Flush()
+
+	case "find", "count":
+		args, filter := splitArgs(rest, 1)
+		if len(args) != 1 {
+			return errors.New(cmd + " <collection> [filter]")
+		}
+		Col, err := S.collection(args[0])
+		if err != nil {
+			return err
+		}
+		F, err := parseShellFilter(filter)
+		if err != nil {
+			return err
+		}
+		if cmd == "count" {
+			n, err := Col.Count(F)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(S.out, n)
+			return nil
+		}
+		return S.find(lines, Col, F)
+
+	case "get":
+		args, extra := splitArgs(rest, 2)
+		if len(args) != 2 || extra != "" {
+			return errors.New("get <collection> <key>")
+		}
+		Col, err := S.collection(args[0])
+		if err != nil {
+			return err
+		}
+		Doc, err := Col.GetDocument(args[1])
+		if err != nil {
+			return err
+		}
+		return S.Printer.One(Doc)
+
+	case "set", "delete":
+		if !S.Write {
+			return errors.New("read-only shell. Start it with -write to change documents")
+		}
+		args, doc := splitArgs(rest, 2)
+		if len(args) != 2 || (cmd == "set") != (doc != "") {
+			return errors.New("set <collection> <key> <json> | delete <collection> <key>")
+		}
+		Col, err := S.collection(args[0])
+		if err != nil {
+			return err
+		}
+
+		var Res moncore.WriteOperationResponse
+		if cmd == "set" {
+			D, err := parseDocument([]byte(doc))
+			if err != nil {
+				return err
+			}
+			Res = Col.Set(args[1], D)
+		} else {
+			Res = Col.Delete(args[1])
+		}
+		if err := Res.Err(); err != nil {
+			return err
+		}
+		return S.Printer.One(Res)
+
+	case "output":
+		P, err := NewPrinter(rest, S.Printer.W)
+		if err != nil {
+			return err
+		}
+		S.Printer = P
+
+	case "pagesize":
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 1 {
+			return errors.New("pagesize <n>, n > 0")
+		}
+		S.PageSize = n
+
+	case "history":
+		for i, line := range S.history {
+			fmt.Fprintf(S.out, "%4d  %s\n", i+1, line)
+		}
+
+	default:
+		return errors.New("unknown command " + cmd + ". Try help")
+	}
+	return nil
+}
+
+// Print documents a page at a time, asking before the next page (on terminals)
+func (S *shell) find(lines *bufio.Scanner, Col *moncore.Collection, F *moncore.Filter) error {
+	Stream, err := Col.Stream(context.Background(), F, &moncore.QueryOptions{BatchSize: int32(S.PageSize), Buffer: S.PageSize})
+	if err != nil {
+		return err
+	}
+	defer Stream.Close()
+
+	shown := 0
+	for Doc := range Stream.Docs {
+		S.Printer.Add(Doc)
+		shown++
+		if shown%S.PageSize != 0 {
+			continue
+		}
+		if err := S.Printer.Flush(); err != nil {
+			return err
+		}
+		if S.term != nil {
+			answer, ok, err := S.readLine(lines, fmt.Sprintf("-- %d shown. Enter for more, q to stop -- ", shown))
+			if err != nil || !ok || strings.TrimSpace(answer) == "q" {
+				return err
+			}
+		}
+	}
+
+	if shown%S.PageSize != 0 || shown == 0 {
+		if err := S.Printer.Flush(); err != nil {
+			return err
+		}
+	}
+	return Stream.Err()
+}
+
+func (S *shell) use(DB string) {
+	S.DB = DB
+	S.refreshCollections()
+}
+
+func (S *shell) refreshCollections() {
+	S.collections = S.MC.Database(S.DB).ListCollectionNames()
+	sort.Strings(S.collections)
+}
+
+// Commands whose second word is a collection name
+var shellCollectionCommands = map[string]bool{"find": true, "count": true, "get": true, "set": true, "delete": true}
+
+var shellCommands = []string{"count", "delete", "exit", "find", "get", "help", "history", "output", "pagesize", "set", "show", "use"}
+
+// Tab completion of the word before the cursor. Ctrl-C clears the line
+func (S *shell) complete(Line string, Pos int, Key rune) (string, int, bool) {
+	if Key == 3 {
+		fmt.Fprintln(S.term, "^C")
+		return "", 0, true
+	}
+	if Key != '\t' {
+		return "", 0, false
+	}
+
+	before := Line[:Pos]
+	start := strings.LastIndexAny(before, " \t") + 1
+	word := before[start:]
+	previous := strings.Fields(before[:start])
+
+	candidates := []string{}
+	switch {
+	case len(previous) == 0:
+		candidates = shellCommands
+	case len(previous) == 1 && shellCollectionCommands[previous[0]]:
+		candidates = S.collections
+	case len(previous) == 1 && previous[0] == "show":
+		candidates = []string{"collections"}
+	case len(previous) == 1 && previous[0] == "output":
+		candidates = []string{OutputJSON, OutputNDJSON, OutputTable}
+	}
+
+	matches := []string{}
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, true
+	}
+
+	completion := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, completion) {
+			completion = completion[:len(completion)-1]
+		}
+	}
+	if len(matches) == 1 {
+		completion += " "
+	} else if completion == word {
+		if S.term != nil {
+			fmt.Fprintln(S.term, strings.Join(matches, "  "))
+		}
+	}
+
+	return before[:start] + completion + Line[Pos:], start + len(completion), true
+}
+
+func (S *shell) loadHistory() {
+	if S.historyFile == "" {
+		return
+	}
+	src, err := os.ReadFile(S.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(src), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			S.history = append(S.history, line)
+		}
+	}
+}
+
+// Add the line to the history and the history file
+func (S *shell) remember(Line string) {
+	if len(S.history) != 0 && S.history[len(S.history)-1] == Line {
+		return
+	}
+	S.history = append(S.history, Line)
+	if len(S.history) > ShellHistorySize {
+		S.history = S.history[len(S.history)-ShellHistorySize:]
+	}
+
+	if S.historyFile == "" {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(S.historyFile), 0o700) != nil {
+		return
+	}
+	os.WriteFile(S.historyFile, []byte(strings.Join(S.history, "\n")+"\n"), 0o600)
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// Tokens returned by Login
+type AuthTokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Sign in with a user account. Later calls use the access token, refreshed when it expires
+func (c *Client) Login(ctx context.Context, Username string, Password string) (*AuthTokens, error) {
+	body, _ := json.Marshal(map[string]string{"username": Username, "password": Password})
+
+	T := &AuthTokens{}
+	err := c.call(ctx, &request{method: http.MethodPost, segments: []string{"api", "auth", "login"}, body: body, contentType: "application/json"}, T)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.token, c.refreshToken = T.AccessToken, T.RefreshToken
+	c.mu.Unlock()
+	return T, nil
+}
+
+// Revoke the access and refresh tokens of Login
+func (c *Client) Logout(ctx context.Context) error {
+	c.mu.Lock()
+	body, _ := json.Marshal(map[string]string{"refresh_token": c.refreshToken})
+	c.mu.Unlock()
+
+	err := c.call(ctx, &request{method: http.MethodPost, segments: []string{"api", "auth", "logout"}, body: body, contentType: "application/json"}, nil)
+
+	c.mu.Lock()
+	c.token, c.refreshToken = "", ""
+	c.mu.Unlock()
+	return err
+}
+
+func (c *Client) hasRefreshToken() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refreshToken != ""
+}
+
+// Exchange the refresh token for new tokens
+func (c *Client) refresh(ctx context.Context) error {
+	c.mu.Lock()
+	body, _ := json.Marshal(map[string]string{"refresh_token": c.refreshToken})
+	c.mu.Unlock()
+
+	T := &AuthTokens{}
+	err := c.call(ctx, &request{method: http.MethodPost, segments: []string{"api", "auth", "refresh"}, body: body, contentType: "application/json", noRefresh: true}, T)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.refreshToken = "" // Rotated or revoked : don't try again
+		return err
+	}
+	c.token, c.refreshToken = T.AccessToken, T.RefreshToken
+	return nil
+}
@@ -0,0 +1,245 @@
+// Package client calls a mongomini server over HTTP, with the shape of the moncore API :
+//
+//	C, err := client.New("https://mongomini.example.com", client.WithAPIKey("mm_..."))
+//	Col := C.Database("shop").Collection("orders")
+//
+//	res, err := Col.Set(ctx, "order-1", map[string]interface{}{"total": 12.5, "at": time.Now()})
+//	doc, err := Col.Get(ctx, "order-1")
+//	docs, err := Col.Query(ctx, client.Filter_MatchAll().Equals("status", "paid"))
+//
+// Documents travel as MongoDB Extended JSON, so dates, ObjectIDs and decimals keep their type.
+// Failed calls return an *Error, comparable with errors.Is to ErrNotFound, ErrForbidden, ...
+// Idempotent calls are retried with exponential backoff on network errors, 429 and 502-504.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Retries of idempotent calls (GET, PUT, DELETE)
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts, the first one included. 1 disables retries
+	MinBackoff  time.Duration // Wait before the first retry. Doubles at every retry, with jitter
+	MaxBackoff  time.Duration // Longest wait, Retry-After included
+}
+
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, MinBackoff: 200 * time.Millisecond, MaxBackoff: 10 * time.Second}
+
+// Client of a mongomini server. Safe for concurrent use
+type Client struct {
+	BaseURL   *url.URL
+	HTTP      *http.Client
+	Retry     RetryPolicy
+	UserAgent string
+
+	mu           sync.Mutex
+	token        string // API key or access token
+	refreshToken string
+}
+
+// Client option, see New()
+type Option func(*Client)
+
+// Authenticate with an API key
+func WithAPIKey(Key string) Option {
+	return func(c *Client) { c.token = Key }
+}
+
+// Authenticate with an access token (see Login). If RefreshToken is not "", expired tokens are refreshed
+func WithToken(AccessToken string, RefreshToken string) Option {
+	return func(c *Client) { c.token, c.refreshToken = AccessToken, RefreshToken }
+}
+
+// Use this http.Client, for timeouts or transports
+func WithHTTPClient(H *http.Client) Option {
+	return func(c *Client) { c.HTTP = H }
+}
+
+func WithRetry(P RetryPolicy) Option {
+	return func(c *Client) { c.Retry = P }
+}
+
+// New client of the server at BaseURL, like "https://mongomini.example.com"
+func New(BaseURL string, Options ...Option) (*Client, error) {
+	U, err := url.Parse(BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if U.Scheme != "http" && U.Scheme != "https" {
+		return nil, errors.New("client : base URL must be http or https : " + BaseURL)
+	}
+	if !strings.HasSuffix(U.Path, "/") {
+		U.Path += "/"
+	}
+
+	c := &Client{BaseURL: U, HTTP: http.DefaultClient, Retry: DefaultRetryPolicy, UserAgent: "mongomini-client"}
+	for _, opt := range Options {
+		opt(c)
+	}
+	return c, nil
+}
+
+// A request. Segments are escaped and joined into the path (with a trailing slash, like the server routes)
+type request struct {
+	method      string
+	segments    []string
+	query       url.Values
+	body        []byte
+	reader      io.Reader // Streamed body, instead of body. Such requests are sent once
+	contentType string
+	accept      string
+	noRefresh   bool // Don't refresh the access token on 401, for the refresh request itself
+}
+
+func (c *Client) url(R *request) string {
+	escaped := make([]string, len(R.segments))
+	for i, s := range R.segments {
+		escaped[i] = url.PathEscape(s)
+	}
+
+	U := *c.BaseURL
+	U.RawPath = c.BaseURL.EscapedPath() + strings.Join(escaped, "/") + "/"
+	U.Path, _ = url.PathUnescape(U.RawPath)
+	U.RawQuery = R.query.Encode()
+	return U.String()
+}
+
+func idempotent(Method string) bool {
+	switch Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// Send the request, retrying when allowed. Responses with status >= 400 are returned as *Error.
+// The caller closes the body of the returned response
+func (c *Client) do(ctx context.Context, R *request) (*http.Response, error) {
+	attempts := c.Retry.MaxAttempts
+	if attempts < 1 || !idempotent(R.method) || R.reader != nil {
+		attempts = 1
+	}
+	refreshed := false
+
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, R.method, c.url(R), bytes.NewReader(R.body))
+		if err != nil {
+			return nil, err
+		}
+		if R.reader != nil {
+			req.Body, req.ContentLength = io.NopCloser(R.reader), -1
+		} else if R.body == nil {
+			req.Body, req.ContentLength = http.NoBody, 0
+		}
+		if R.contentType != "" {
+			req.Header.Set("Content-Type", R.contentType)
+		}
+		if R.accept != "" {
+			req.Header.Set("Accept", R.accept)
+		}
+		req.Header.Set("User-Agent", c.UserAgent)
+
+		c.mu.Lock()
+		token := c.token
+		c.mu.Unlock()
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		res, err := c.HTTP.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || attempt >= attempts {
+				return nil, err
+			}
+			if werr := c.wait(ctx, attempt, 0); werr != nil {
+				return nil, werr
+			}
+			continue
+		}
+
+		if res.StatusCode < 400 {
+			return res, nil
+		}
+
+		apiErr := readError(res)
+
+		if res.StatusCode == http.StatusUnauthorized && !refreshed && !R.noRefresh && R.reader == nil && c.hasRefreshToken() {
+			refreshed = true
+			if c.refresh(ctx) == nil {
+				attempt--
+				continue
+			}
+		}
+
+		switch res.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			if attempt < attempts {
+				retryAfter, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+				if werr := c.wait(ctx, attempt, time.Duration(retryAfter)*time.Second); werr != nil {
+					return nil, werr
+				}
+				continue
+			}
+		}
+		return nil, apiErr
+	}
+}
+
+// Sleep before retry number attempt, at least RetryAfter (capped by MaxBackoff)
+func (c *Client) wait(ctx context.Context, attempt int, RetryAfter time.Duration) error {
+	backoff := c.Retry.MinBackoff << (attempt - 1)
+	if backoff <= 0 || backoff > c.Retry.MaxBackoff {
+		backoff = c.Retry.MaxBackoff
+	}
+	backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	if RetryAfter > backoff {
+		backoff = min(RetryAfter, c.Retry.MaxBackoff)
+	}
+
+	T := time.NewTimer(backoff)
+	defer T.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-T.C:
+		return nil
+	}
+}
+
+// Send the request and decode the JSON response into out (unless out is nil)
+func (c *Client) call(ctx context.Context, R *request, out interface{}) error {
+	res, err := c.do(ctx, R)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if out == nil || res.StatusCode == http.StatusNoContent {
+		_, err := io.Copy(io.Discard, res.Body)
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// Send the request and return the response body
+func (c *Client) read(ctx context.Context, R *request) ([]byte, *http.Response, error) {
+	res, err := c.do(ctx, R)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	return body, res, err
+}
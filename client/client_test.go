@@ -0,0 +1,273 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mongomini/agra/moncore"
+	"mongomini/endpoints"
+)
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 100 * time.Millisecond}
+
+// Server running endpoints.ServeRequest with the given routes (and global middlewares) instead of API_Endpoints
+func routesServer(t *testing.T, Routes ...endpoints.API_Call_Handler) *httptest.Server {
+	saved := endpoints.API_Endpoints
+	endpoints.API_Endpoints = Routes
+	S := httptest.NewServer(http.HandlerFunc(endpoints.ServeRequest))
+	t.Cleanup(func() {
+		S.Close()
+		endpoints.API_Endpoints = saved
+	})
+	return S
+}
+
+func testClient(t *testing.T, S *httptest.Server, Options ...Option) *Client {
+	c, err := New(S.URL, append([]Option{WithRetry(fastRetry)}, Options...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Answers the document {_id: dockey, Doc: {n: 1}} like mini/get
+func respondDocument(C *endpoints.APICall) {
+	C.Respond(moncore.GenericDBDocument{ID: C.Param("dockey"), Doc: map[string]interface{}{"n": int32(1)}})
+}
+
+const getRoute = `mini/get/{db}/{collection}/{dockey}/`
+
+func TestRetryOnUnavailable(t *testing.T) {
+	calls := int32(0)
+	S := routesServer(t, endpoints.API_GET(getRoute, func(C *endpoints.APICall) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			C.Fail(endpoints.NewAPIError(endpoints.CodeUnavailable, "database restarting"))
+			return
+		}
+		respondDocument(C)
+	}))
+
+	Doc, err := testClient(t, S).Database("db").Collection("col").Get(context.Background(), "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Doc.ID != "k1" || calls != 3 {
+		t.Fatalf("got %q after %d calls, want k1 after 3", Doc.ID, calls)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	calls := int32(0)
+	S := routesServer(t, endpoints.API_GET(getRoute, func(C *endpoints.APICall) {
+		atomic.AddInt32(&calls, 1)
+		C.Fail(endpoints.NewAPIError(endpoints.CodeUnavailable, "down"))
+	}))
+
+	_, err := testClient(t, S).Database("db").Collection("col").Get(context.Background(), "k1")
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("err = %v, want ErrUnavailable", err)
+	}
+	if calls != int32(fastRetry.MaxAttempts) {
+		t.Fatalf("%d calls, want %d", calls, fastRetry.MaxAttempts)
+	}
+}
+
+func TestRetryOnRateLimit(t *testing.T) {
+	limits := endpoints.RateLimitOptions{Burst: 1, Rate: 50, Store: endpoints.NewMemoryRateLimitStore(), Key: func(*endpoints.APICall) string { return "test" }}
+	S := routesServer(t, endpoints.API_GET(getRoute, respondDocument).With(endpoints.RateLimit(limits)))
+	Col := testClient(t, S).Database("db").Collection("col")
+
+	for i := 0; i < 3; i++ {
+		if _, err := Col.Get(context.Background(), "k"+strconv.Itoa(i)); err != nil {
+			t.Fatalf("call %d : %v", i, err)
+		}
+	}
+
+	// Without retries, the empty bucket answers 429
+	c := testClient(t, S, WithRetry(RetryPolicy{MaxAttempts: 1}))
+	c.Database("db").Collection("col").Get(context.Background(), "k")
+	_, err := c.Database("db").Collection("col").Get(context.Background(), "k")
+	var E *Error
+	if !errors.As(err, &E) || !errors.Is(err, ErrRateLimited) || E.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestWritesAreNotRetried(t *testing.T) {
+	calls := int32(0)
+	S := routesServer(t, endpoints.API_Route([]string{"POST", "PUT"}, `mini/import/{db}/{collection}/`, func(C *endpoints.APICall) {
+		atomic.AddInt32(&calls, 1)
+		C.Fail(endpoints.NewAPIError(endpoints.CodeUnavailable, "down"))
+	}))
+
+	_, err := testClient(t, S).Database("db").Collection("col").Import(context.Background(), nil, ImportOptions{})
+	if !errors.Is(err, ErrUnavailable) || calls != 1 {
+		t.Fatalf("err = %v after %d calls, want ErrUnavailable after 1", err, calls)
+	}
+}
+
+// Stand-in for api/auth/refresh/ : "r1" gives the access token "fresh" once, then is used
+func refreshRoute(refreshes *int32) endpoints.API_Call_Handler {
+	return endpoints.API_POST(`api/auth/refresh/`, func(C *endpoints.APICall) {
+		req := struct {
+			RefreshToken string `json:"refresh_token"`
+		}{}
+		if err := C.BodyJsonToStruct(&req); err != nil {
+			C.Fail(err)
+			return
+		}
+		if req.RefreshToken != "r1" || atomic.AddInt32(refreshes, 1) > 1 {
+			C.Fail(endpoints.NewAPIError(endpoints.CodeUnauthorized, "invalid or expired token"))
+			return
+		}
+		C.Respond(endpoints.AuthTokens{AccessToken: "fresh", TokenType: "Bearer", ExpiresIn: 900, RefreshToken: "r2"})
+	})
+}
+
+func requireToken(C *endpoints.APICall) {
+	if C.BearerToken() != "fresh" {
+		C.Fail(endpoints.NewAPIError(endpoints.CodeUnauthorized, "invalid or expired token"))
+		return
+	}
+	respondDocument(C)
+}
+
+func TestRefreshOnUnauthorized(t *testing.T) {
+	refreshes := int32(0)
+	S := routesServer(t, refreshRoute(&refreshes), endpoints.API_GET(getRoute, requireToken))
+	c := testClient(t, S, WithToken("expired", "r1"))
+
+	if _, err := c.Database("db").Collection("col").Get(context.Background(), "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if refreshes != 1 || c.token != "fresh" || c.refreshToken != "r2" {
+		t.Fatalf("%d refreshes, tokens %q %q", refreshes, c.token, c.refreshToken)
+	}
+
+	// The rotated token is used from now on, without refreshing again
+	if _, err := c.Database("db").Collection("col").Get(context.Background(), "k2"); err != nil || refreshes != 1 {
+		t.Fatalf("err = %v after %d refreshes", err, refreshes)
+	}
+}
+
+func TestFailedRefresh(t *testing.T) {
+	refreshes := int32(0)
+	S := routesServer(t, refreshRoute(&refreshes), endpoints.API_GET(getRoute, requireToken))
+	c := testClient(t, S, WithToken("expired", "stolen"))
+
+	_, err := c.Database("db").Collection("col").Get(context.Background(), "k1")
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("err = %v, want ErrUnauthorized", err)
+	}
+	if c.hasRefreshToken() {
+		t.Fatal("refused refresh token kept")
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+	tests := []struct {
+		err    error
+		target error
+		status int
+	}{
+		{moncore.ErrNotFound, ErrNotFound, http.StatusNotFound},
+		{moncore.ErrDenied, ErrForbidden, http.StatusForbidden},
+		{endpoints.NewAPIError(endpoints.CodeProtectedField, "field secret is protected"), ErrProtectedField, http.StatusForbidden},
+		{endpoints.NewAPIError(endpoints.CodeConflict, "exists"), ErrConflict, http.StatusConflict},
+		{endpoints.NewAPIError(endpoints.CodeInvalidPath, "bad path"), ErrInvalidPath, http.StatusBadRequest},
+		{endpoints.NewAPIError(endpoints.CodeQuotaExceeded, "daily reads quota exceeded"), ErrQuotaExceeded, http.StatusTooManyRequests},
+		{errors.New("boom"), ErrInternal, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		S := routesServer(t, endpoints.API_GET(getRoute, func(C *endpoints.APICall) { C.Fail(tt.err) }))
+		_, err := testClient(t, S, WithRetry(RetryPolicy{MaxAttempts: 1})).Database("db").Collection("col").Get(context.Background(), "k1")
+
+		var E *Error
+		if !errors.As(err, &E) {
+			t.Errorf("%v : err = %v, want an *Error", tt.err, err)
+			continue
+		}
+		if !errors.Is(err, tt.target) || E.StatusCode != tt.status {
+			t.Errorf("%v : got %d %q, want %d %q", tt.err, E.StatusCode, E.Code, tt.status, tt.target.(*Error).Code)
+		}
+	}
+
+	// No route : the server's own envelope
+	S := routesServer(t)
+	_, err := testClient(t, S).Database("db").Collection("col").Get(context.Background(), "k1")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("no route : err = %v, want ErrNotFound", err)
+	}
+}
+
+// Full server against MongoDB, configured like endpoints.InitAll from the environment (Mongo_host, ...)
+func TestAgainstMongoDB(t *testing.T) {
+	if os.Getenv("Mongo_host") == "" {
+		t.Skip("needs MongoDB : set Mongo_host (and Mongo_user, Mongo_pass, ...) like for the server")
+	}
+	os.Setenv("Root_API_Key", "mm_client_test_root")
+	os.Setenv("JWT_Secret", "client test secret")
+	os.Setenv("Session_Secret", "client test session secret")
+	endpoints.InitAll()
+
+	S := httptest.NewServer(http.HandlerFunc(endpoints.ServeRequest))
+	defer S.Close()
+	c := testClient(t, S, WithAPIKey("mm_client_test_root"))
+	ctx := context.Background()
+
+	Col := c.Database("mongomini_client_test").Collection("docs_" + strconv.FormatInt(time.Now().UnixNano(), 36))
+	defer func() {
+		for i := 0; i < 5; i++ {
+			Col.Delete(ctx, "k"+strconv.Itoa(i))
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		if _, err := Col.Set(ctx, "k"+strconv.Itoa(i), map[string]interface{}{"n": i, "at": time.Now().UTC()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := struct {
+		N  int       `bson:"n"`
+		At time.Time `bson:"at"`
+	}{}
+	if err := Col.GetInto(ctx, "k3", &got); err != nil || got.N != 3 || got.At.IsZero() {
+		t.Fatalf("GetInto : %+v, %v", got, err)
+	}
+
+	if _, err := Col.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get missing : err = %v, want ErrNotFound", err)
+	}
+
+	Docs, err := Col.Query(ctx, Filter_MatchAll().Exists("n"))
+	if err != nil || len(Docs) != 5 {
+		t.Fatalf("Query : %d documents, %v", len(Docs), err)
+	}
+
+	seen := []string{}
+	P := &Page{}
+	for first := true; first || P.Next != ""; first = false {
+		if P, err = Col.Page(ctx, nil, moncore.PageOptions{Limit: 2, After: P.Next}); err != nil {
+			t.Fatal(err)
+		}
+		for _, D := range P.Docs {
+			seen = append(seen, D.ID)
+		}
+	}
+	if len(seen) != 5 || seen[0] != "k0" || seen[4] != "k4" {
+		t.Fatalf("pages : %v", seen)
+	}
+
+	if _, err := c.Database(moncore.SystemDBName).Collection("users").Get(ctx, "admin"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("system database : err = %v, want ErrForbidden", err)
+	}
+}
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"mongomini/agra/moncore"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Database of the server
+type Database struct {
+	client *Client
+	name   string
+}
+
+// Collection of the server, see moncore.Collection
+type Collection struct {
+	client   *Client
+	database string
+	name     string
+}
+
+func (c *Client) Database(Name string) *Database {
+	return &Database{client: c, name: Name}
+}
+
+func (D *Database) Name() string {
+	return D.name
+}
+
+func (D *Database) Collection(Name string) *Collection {
+	return &Collection{client: D.client, database: D.name, name: Name}
+}
+
+// Names of the collections the caller may list
+func (D *Database) ListCollections(ctx context.Context) ([]string, error) {
+	names := []string{}
+	err := D.client.call(ctx, &request{method: http.MethodGet, segments: []string{"mini", "ls", D.name}, query: url.Values{"format": {"json"}}}, &names)
+	return names, err
+}
+
+func (C *Collection) Name() string {
+	return C.name
+}
+
+func (C *Collection) DatabaseName() string {
+	return C.database
+}
+
+// Read the document. ErrNotFound if it doesn't exist
+func (C *Collection) Get(ctx context.Context, Key string) (*moncore.GenericDBDocument, error) {
+	Doc := &moncore.GenericDBDocument{}
+	err := C.extjson(ctx, []string{"mini", "get", C.database, C.name, Key}, nil, Doc)
+	if err != nil {
+		return nil, err
+	}
+	return Doc, nil
+}
+
+// Read the document into Value (struct pointer or map), like moncore.TypedCollection
+func (C *Collection) GetInto(ctx context.Context, Key string, Value interface{}) error {
+	Doc, err := C.Get(ctx, Key)
+	if err != nil {
+		return err
+	}
+	return DecodeDocument(Doc, Value)
+}
+
+// Create or replace the document
+func (C *Collection) Set(ctx context.Context, Key string, Value interface{}) (*moncore.WriteOperationResponse, error) {
+	body, ctype, err := encodeBody(Value)
+	if err != nil {
+		return nil, err
+	}
+	return C.write(ctx, &request{method: http.MethodPut, segments: []string{"mini", "set", C.database, C.name, Key}, body: body, contentType: ctype})
+}
+
+func (C *Collection) Delete(ctx context.Context, Key string) (*moncore.WriteOperationResponse, error) {
+	return C.write(ctx, &request{method: http.MethodDelete, segments: []string{"mini", "del", C.database, C.name, Key}})
+}
+
+// Read a field of the document. Path is dotted, like "address.city" or "items.0"
+func (C *Collection) GetField(ctx context.Context, Key string, Path string) (interface{}, error) {
+	var Val interface{}
+	err := C.extjson(ctx, []string{"mini", C.database, C.name, Key, "field", Path}, nil, &Val)
+	return Val, err
+}
+
+func (C *Collection) SetField(ctx context.Context, Key string, Path string, Value interface{}) (*moncore.WriteOperationResponse, error) {
+	body, ctype, err := encodeBody(Value)
+	if err != nil {
+		return nil, err
+	}
+	return C.write(ctx, &request{method: http.MethodPut, segments: []string{"mini", C.database, C.name, Key, "field", Path}, body: body, contentType: ctype})
+}
+
+func (C *Collection) UnsetField(ctx context.Context, Key string, Path string) (*moncore.WriteOperationResponse, error) {
+	return C.write(ctx, &request{method: http.MethodDelete, segments: []string{"mini", C.database, C.name, Key, "field", Path}})
+}
+
+// Documents matching the filter (nil matches all). See Page and Stream for large collections
+func (C *Collection) Query(ctx context.Context, F *Filter) ([]*moncore.GenericDBDocument, error) {
+	Docs := []*moncore.GenericDBDocument{}
+	err := C.extjson(ctx, []string{"mini", "ls", C.database, C.name}, F.query(nil), &Docs)
+	return Docs, err
+}
+
+// A page of Page(). Next is "" on the last page
+type Page struct {
+	Docs []*moncore.GenericDBDocument
+	Next string
+}
+
+// Documents matching the filter, sorted by ID, Limit at a time. Pass Page.Next as After to get the following page
+func (C *Collection) Page(ctx context.Context, F *Filter, Options moncore.PageOptions) (*Page, error) {
+	extra := url.Values{}
+	if Options.Limit > 0 {
+		extra.Set("limit", strconv.FormatInt(Options.Limit, 10))
+	}
+	if Options.After != "" {
+		extra.Set("after", Options.After)
+	}
+
+	P := &Page{Docs: []*moncore.GenericDBDocument{}}
+	res, err := C.extjsonResponse(ctx, []string{"mini", "ls", C.database, C.name}, F.query(extra), &P.Docs)
+	if err != nil {
+		return nil, err
+	}
+	P.Next = nextPageToken(res.Header.Get("Link"))
+	return P, nil
+}
+
+// Decode a document into Value (struct pointer or map)
+func DecodeDocument(Doc *moncore.GenericDBDocument, Value interface{}) error {
+	raw, err := bson.Marshal(Doc.Doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, Value)
+}
+
+// GET an Extended JSON response and decode it into out
+func (C *Collection) extjson(ctx context.Context, Segments []string, Query url.Values, out interface{}) error {
+	_, err := C.extjsonResponse(ctx, Segments, Query, out)
+	return err
+}
+
+func (C *Collection) extjsonResponse(ctx context.Context, Segments []string, Query url.Values, out interface{}) (*http.Response, error) {
+	if Query == nil {
+		Query = url.Values{}
+	}
+	Query.Set("format", "extjson")
+
+	body, res, err := C.client.read(ctx, &request{method: http.MethodGet, segments: Segments, query: Query})
+	if err != nil {
+		return nil, err
+	}
+
+	// Extended JSON only decodes documents : wrap the value
+	wrapped := struct {
+		V bson.RawValue `bson:"v"`
+	}{}
+	raw := append(append([]byte(`{"v":`), body...), '}')
+	if err := bson.UnmarshalExtJSON(raw, false, &wrapped); err != nil {
+		return nil, err
+	}
+	return res, wrapped.V.Unmarshal(out)
+}
+
+func (C *Collection) write(ctx context.Context, R *request) (*moncore.WriteOperationResponse, error) {
+	R.query = url.Values{"format": {"json"}}
+	Res := &moncore.WriteOperationResponse{}
+	if err := C.client.call(ctx, R, Res); err != nil {
+		return nil, err
+	}
+	return Res, nil
+}
+
+// Body of a write : Extended JSON for documents (keeps dates, ObjectIDs, ...), JSON for other values
+func encodeBody(Value interface{}) ([]byte, string, error) {
+	if raw, err := bson.MarshalExtJSON(Value, false, false); err == nil {
+		return raw, "application/extjson", nil
+	}
+	raw, err := json.Marshal(Value)
+	if err != nil {
+		return nil, "", err
+	}
+	return raw, "application/json", nil
+}
+
+// The "after" parameter of the rel="next" URL of a Link header
+func nextPageToken(Link string) string {
+	for _, part := range strings.Split(Link, ",") {
+		part = strings.TrimSpace(part)
+		if !strings.Contains(part, `rel="next"`) {
+			continue
+		}
+		start, end := strings.Index(part, "<"), strings.Index(part, ">")
+		if start < 0 || end < start {
+			continue
+		}
+		U, err := url.Parse(part[start+1 : end])
+		if err != nil {
+			continue
+		}
+		return U.Query().Get("after")
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Error answered by the server. See the error codes of the endpoints package (endpoints.ErrorCodes)
+type Error struct {
+	StatusCode int             `json:"-"`
+	Code       string          `json:"code"`
+	Message    string          `json:"message"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Details    json.RawMessage `json:"details,omitempty"`
+}
+
+func (E *Error) Error() string {
+	msg := "mongomini : " + strconv.Itoa(E.StatusCode)
+	if E.Code != "" {
+		msg += " " + E.Code
+	}
+	if E.Message != "" {
+		msg += " : " + E.Message
+	}
+	return msg
+}
+
+// Errors with the same code match : errors.Is(err, client.ErrNotFound)
+func (E *Error) Is(target error) bool {
+	T, ok := target.(*Error)
+	return ok && T.Code != "" && T.Code == E.Code
+}
+
+// Errors to compare with errors.Is
+var (
+	ErrBadRequest     = &Error{Code: "bad_request"}
+	ErrInvalidBody    = &Error{Code: "invalid_body"}
+	ErrInvalidPath    = &Error{Code: "invalid_path"}
+	ErrUnauthorized   = &Error{Code: "unauthorized"}
+	ErrForbidden      = &Error{Code: "forbidden"}
+	ErrProtectedField = &Error{Code: "protected_field"}
+	ErrNotFound       = &Error{Code: "not_found"}
+	ErrConflict       = &Error{Code: "conflict"}
+	ErrRateLimited    = &Error{Code: "rate_limited"}
+	ErrQuotaExceeded  = &Error{Code: "quota_exceeded"}
+	ErrInternal       = &Error{Code: "internal"}
+	ErrUnavailable    = &Error{Code: "unavailable"}
+	ErrTimeout        = &Error{Code: "timeout"}
+)
+
+// Error of a failed response. Bodies that are not error envelopes become the message
+func readError(res *http.Response) *Error {
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+
+	envelope := struct {
+		Error *Error `json:"error"`
+	}{}
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error != nil {
+		envelope.Error.StatusCode = res.StatusCode
+		return envelope.Error
+	}
+
+	return &Error{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(body))}
+}
@@ -0,0 +1,67 @@
+package client
+
+import "net/url"
+
+// Filter of Query, Stream and Watch. Sent as query parameters, see moncore.Filter_FromQueryStrings.
+// Values are compared as strings
+type Filter struct {
+	params url.Values
+}
+
+// Empty filter that matches all
+func Filter_MatchAll() *Filter {
+	return &Filter{params: url.Values{}}
+}
+
+// Match documents whose field (dotted path) equals value
+func (F *Filter) Equals(Field string, Value string) *Filter {
+	F.params.Add(Field, "="+Value)
+	return F
+}
+
+// Match documents whose field doesn't equal value
+func (F *Filter) NotEquals(Field string, Value string) *Filter {
+	F.params.Add(Field, "-"+Value)
+	return F
+}
+
+// Match documents that have the field
+func (F *Filter) Exists(Field string) *Filter {
+	F.params.Add(Field, "exist")
+	return F
+}
+
+// Match documents that don't have the field
+func (F *Filter) NotExists(Field string) *Filter {
+	F.params.Add(Field, "not-exist")
+	return F
+}
+
+// Negate the conditions on the field
+func (F *Filter) Not(Field string) *Filter {
+	F.params.Add(Field, "not")
+	return F
+}
+
+// Query parameters of the filter, plus extra ones
+func (F *Filter) query(Extra url.Values) url.Values {
+	Q := url.Values{}
+	if F != nil {
+		for k, vs := range F.params {
+			Q[k] = append([]string{}, vs...)
+		}
+	}
+	for k, vs := range Extra {
+		Q[k] = vs
+	}
+	return Q
+}
+
+// Filter of query parameters, in the grammar of moncore.Filter_FromQueryStrings
+func Filter_FromQueryStrings(Q url.Values) *Filter {
+	F := Filter_MatchAll()
+	for k, vs := range Q {
+		F.params[k] = append([]string{}, vs...)
+	}
+	return F
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// API key, see endpoints.APIKey. Needs the admin scope
+type APIKey struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Hint    string    `json:"hint"`
+	Scopes  []string  `json:"scopes"`
+	Revoked bool      `json:"revoked"`
+	Expires time.Time `json:"expires"` // Zero for keys that don't expire
+	Created time.Time `json:"created"`
+	Rotated time.Time `json:"rotated"`
+}
+
+// Newly created or rotated key. Token is only returned once
+type APIKeyToken struct {
+	APIKey
+	Token string `json:"token"`
+}
+
+func (c *Client) ListKeys(ctx context.Context) ([]APIKey, error) {
+	keys := []APIKey{}
+	err := c.call(ctx, &request{method: http.MethodGet, segments: []string{"mini", "keys"}, accept: "application/json"}, &keys)
+	return keys, err
+}
+
+func (c *Client) CreateKey(ctx context.Context, Name string, Scopes []string) (*APIKeyToken, error) {
+	body, _ := json.Marshal(map[string]interface{}{"name": Name, "scopes": Scopes})
+
+	T := &APIKeyToken{}
+	err := c.call(ctx, &request{method: http.MethodPost, segments: []string{"mini", "keys"}, body: body, contentType: "application/json", accept: "application/json"}, T)
+	if err != nil {
+		return nil, err
+	}
+	return T, nil
+}
+
+// Replace the secret of the key
+func (c *Client) RotateKey(ctx context.Context, ID string) (*APIKeyToken, error) {
+	T := &APIKeyToken{}
+	err := c.call(ctx, &request{method: http.MethodPost, segments: []string{"mini", "keys", ID, "rotate"}, accept: "application/json"}, T)
+	if err != nil {
+		return nil, err
+	}
+	return T, nil
+}
+
+func (c *Client) RevokeKey(ctx context.Context, ID string) error {
+	return c.call(ctx, &request{method: http.MethodDelete, segments: []string{"mini", "keys", ID}}, nil)
+}
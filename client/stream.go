@@ -0,0 +1,192 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"mongomini/agra/moncore"
+)
+
+// Documents streamed from mini/ls as NDJSON. Read Docs until it is closed, then check Err().
+//
+// Call Close() when done (or to stop early), like moncore.DocumentStream.
+// Documents are decoded from plain JSON : numbers are float64, dates are strings
+type DocumentStream struct {
+	Docs <-chan *moncore.GenericDBDocument
+	*streamState
+}
+
+// Change events of mini/watch, see moncore.ChangeEvent. Events is closed when the server ends the stream
+type ChangeStream struct {
+	Events <-chan *moncore.ChangeEvent
+	*streamState
+}
+
+type streamState struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+// Terminal error of the stream, if any. Only meaningful after the channel is closed.
+// Stopping the stream with Close() is not an error.
+func (S *streamState) Err() error {
+	S.mu.Lock()
+	defer S.mu.Unlock()
+	return S.err
+}
+
+// Stop the stream and wait for the reading goroutine to exit. Safe to call more than once.
+func (S *streamState) Close() {
+	S.mu.Lock()
+	S.closed = true
+	S.mu.Unlock()
+
+	S.cancel()
+	<-S.done
+}
+
+func (S *streamState) fail(err error) {
+	S.mu.Lock()
+	defer S.mu.Unlock()
+
+	if S.closed && errors.Is(err, context.Canceled) {
+		return
+	}
+	if S.err == nil {
+		S.err = err
+	}
+}
+
+// Stream documents matching the filter (nil matches all). Limit 0 means no limit
+func (C *Collection) Stream(ctx context.Context, F *Filter, Limit int64) (*DocumentStream, error) {
+	extra := url.Values{"format": {"ndjson"}}
+	if Limit > 0 {
+		extra.Set("limit", strconv.FormatInt(Limit, 10))
+	}
+
+	docs := make(chan *moncore.GenericDBDocument, 16)
+	S, err := openStream(ctx, C.client, &request{method: http.MethodGet, segments: []string{"mini", "ls", C.database, C.name}, query: F.query(extra), accept: "application/x-ndjson"},
+		func(ctx context.Context, line []byte) error {
+			Doc := &moncore.GenericDBDocument{}
+			if err := json.Unmarshal(line, Doc); err != nil {
+				return err
+			}
+			select {
+			case docs <- Doc:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}, func() { close(docs) })
+	if err != nil {
+		return nil, err
+	}
+	return &DocumentStream{Docs: docs, streamState: S}, nil
+}
+
+// Watch changes of the collection made through the server, until ctx is done or Close() is called.
+// The filter is matched against the documents. Events keeps only those types (moncore.ChangeInsert, ...), all if empty.
+//
+// Only writes handled by the server process answering the watch are seen : writes through other instances
+// (behind a load balancer, serverless), the direct backend of the CLI or other MongoDB clients are not.
+// The server doesn't use MongoDB change streams.
+func (C *Collection) Watch(ctx context.Context, F *Filter, Events ...string) (*ChangeStream, error) {
+	extra := url.Values{"format": {"ndjson"}}
+	if len(Events) != 0 {
+		extra.Set("events", strings.Join(Events, ","))
+	}
+
+	events := make(chan *moncore.ChangeEvent, 16)
+	S, err := openStream(ctx, C.client, &request{method: http.MethodGet, segments: []string{"mini", "watch", C.database, C.name}, query: F.query(extra), accept: "application/x-ndjson"},
+		func(ctx context.Context, line []byte) error {
+			Event := &moncore.ChangeEvent{}
+			if err := json.Unmarshal(line, Event); err != nil {
+				return err
+			}
+			select {
+			case events <- Event:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}, func() { close(events) })
+	if err != nil {
+		return nil, err
+	}
+	return &ChangeStream{Events: events, streamState: S}, nil
+}
+
+// Send the request and start a goroutine passing NDJSON lines to emit.
+// Empty lines are keep-alives. An {"error": ...} line ends the stream with an *Error
+func openStream(ctx context.Context, c *Client, R *request, emit func(context.Context, []byte) error, done func()) (*streamState, error) {
+	ctx_str, cnc_str := context.WithCancel(ctx)
+
+	res, err := c.do(ctx_str, R)
+	if err != nil {
+		cnc_str()
+		return nil, err
+	}
+
+	S := &streamState{cancel: cnc_str, done: make(chan struct{})}
+
+	go func() {
+		defer close(S.done)
+		defer done()
+		defer res.Body.Close()
+
+		reader := bufio.NewReader(res.Body)
+		for {
+			line, rerr := reader.ReadBytes('\n')
+			if line = bytes.TrimSpace(line); len(line) != 0 {
+				if E := lineError(line, res.StatusCode); E != nil {
+					S.fail(E)
+					return
+				}
+				if err := emit(ctx_str, line); err != nil {
+					S.fail(err)
+					return
+				}
+			}
+
+			if rerr == io.EOF {
+				return
+			} else if rerr != nil {
+				if ctx_str.Err() != nil {
+					rerr = ctx_str.Err()
+				}
+				S.fail(rerr)
+				return
+			}
+		}
+	}()
+
+	return S, nil
+}
+
+// Error of an error envelope line, nil for other lines
+func lineError(Line []byte, StatusCode int) *Error {
+	if !bytes.HasPrefix(Line, []byte(`{"error"`)) {
+		return nil
+	}
+	envelope := struct {
+		Error *Error `json:"error"`
+	}{}
+	if json.Unmarshal(Line, &envelope) != nil || envelope.Error == nil {
+		return nil
+	}
+	envelope.Error.StatusCode = StatusCode
+	return envelope.Error
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"mongomini/agra/moncore"
+)
+
+// Options of Export. Format is a moncore transfer format (moncore.TransferNDJSON if ""),
+// Fields the CSV columns, like "_id,name,email=contact.email". Without Fields, CSV columns are _id and the top-level
+// fields of the first document, fields missing from it are left out
+type ExportOptions struct {
+	Format string
+	Fields string
+	Limit  int64
+}
+
+// Options of Import, see moncore.ImportOptions
+type ImportOptions struct {
+	Format    string // moncore.TransferNDJSON if ""
+	Mode      string // moncore.ImportUpsert if ""
+	BatchSize int
+	Fields    string
+	KeepGoing bool
+}
+
+// Write the documents matching the filter (nil matches all) to W, in the export format. Returns the bytes written
+func (C *Collection) Export(ctx context.Context, W io.Writer, F *Filter, Options ExportOptions) (int64, error) {
+	extra := url.Values{}
+	if Options.Format != "" {
+		extra.Set("format", Options.Format)
+	}
+	if Options.Fields != "" {
+		extra.Set("fields", Options.Fields)
+	}
+	if Options.Limit > 0 {
+		extra.Set("limit", strconv.FormatInt(Options.Limit, 10))
+	}
+
+	res, err := C.client.do(ctx, &request{method: http.MethodGet, segments: []string{"mini", "export", C.database, C.name}, query: F.query(extra)})
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	return io.Copy(W, res.Body)
+}
+
+// Upload documents read from R into the collection. The upload is streamed and never retried.
+// When the server stops on an error, the *Error Details hold the moncore.ImportResult until then
+func (C *Collection) Import(ctx context.Context, R io.Reader, Options ImportOptions) (*moncore.ImportResult, error) {
+	if Options.Format == "" {
+		Options.Format = moncore.TransferNDJSON
+	}
+	Q := url.Values{"input": {Options.Format}, "format": {"json"}}
+	if Options.Mode != "" {
+		Q.Set("mode", Options.Mode)
+	}
+	if Options.BatchSize > 0 {
+		Q.Set("batch", strconv.Itoa(Options.BatchSize))
+	}
+	if Options.Fields != "" {
+		Q.Set("fields", Options.Fields)
+	}
+	if Options.KeepGoing {
+		Q.Set("keep_going", "true")
+	}
+
+	Res := &moncore.ImportResult{}
+	err := C.client.call(ctx, &request{method: http.MethodPost, segments: []string{"mini", "import", C.database, C.name}, query: Q,
+		reader: R, contentType: importContentTypes[Options.Format]}, Res)
+	if err != nil {
+		return nil, err
+	}
+	return Res, nil
+}
+
+var importContentTypes = map[string]string{
+	moncore.TransferNDJSON: "application/x-ndjson",
+	moncore.TransferJSON:   "application/json",
+	moncore.TransferCSV:    "text/csv",
+	moncore.TransferBSON:   "application/bson",
+}
@@ -0,0 +1,288 @@
+package endpoints
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"mongomini/agra/moncore"
+)
+
+// Route actions, used to check permissions (see Role). Set with API_Call_Handler.As()
+const (
+	ActionList      = "list"
+	ActionGet       = "get"
+	ActionSet       = "set"
+	ActionDelete    = "delete"
+	ActionAggregate = "aggregate"
+	ActionAdmin     = "admin"
+)
+
+var APIKeysCollectionName string = "apikeys"
+
+// API key. Stored hashed in moncore.SystemDBName/APIKeysCollectionName
+//
+// Scopes :
+//
+//	"read:<db>/<collection>"  : list, get and aggregate. <db> and <collection> are path.Match patterns. "read:<db>" means "read:<db>/*"
+//	"write:<db>/<collection>" : set and delete
+//	"admin"                   : everything, including mini/admin/ routes
+//
+// Patterns never match moncore.SystemDBName, see Permission.Allows
+type APIKey struct {
+	ID      string    `bson:"-" json:"id"`
+	Name    string    `bson:"Name" json:"name"`
+	Hash    string    `bson:"Hash" json:"-"`    // hex SHA-256 of the secret
+	Hint    string    `bson:"Hint" json:"hint"` // Last characters of the secret, to tell keys apart
+	Scopes  []string  `bson:"Scopes" json:"scopes"`
+	Revoked bool      `bson:"Revoked" json:"revoked"`
+	Expires time.Time `bson:"Expires" json:"expires"` // Zero for keys that don't expire
+	Created time.Time `bson:"Created" json:"created"`
+	Rotated time.Time `bson:"Rotated" json:"rotated"`
+}
+
+// Newly created or rotated key. Token is only shown once
+type APIKeyToken struct {
+	APIKey
+	Token string `json:"token"`
+}
+
+const apiKeyPrefix = "mm_"
+
+func apiKeys() *moncore.TypedCollection[APIKey] {
+	return moncore.Typed[APIKey](Moncore.Database(moncore.SystemDBName).Collection(APIKeysCollectionName))
+}
+
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func newSecret() string {
+	return randomToken(32)
+}
+
+// Validate scopes of a new key
+func ValidateScopes(Scopes []string) error {
+	if len(Scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, s := range Scopes {
+		if s == "admin" {
+			continue
+		}
+		kind, target, ok := strings.Cut(s, ":")
+		if !ok || (kind != "read" && kind != "write") || target == "" {
+			return errors.New("bad scope : " + s + " (use read:<db>/<collection>, write:<db>/<collection> or admin)")
+		}
+		if _, err := path.Match(target, ""); err != nil {
+			return errors.New("bad scope pattern : " + s)
+		}
+	}
+	return nil
+}
+
+// Create a new API key. A zero Expires makes a key that doesn't expire
+func CreateAPIKey(Name string, Scopes []string, Expires time.Time) (*APIKeyToken, error) {
+	if err := ValidateScopes(Scopes); err != nil {
+		return nil, err
+	}
+
+	secret := newSecret()
+	key := APIKey{
+		ID:      primitive.NewObjectID().Hex(),
+		Name:    Name,
+		Hash:    hashSecret(secret),
+		Hint:    secret[len(secret)-4:],
+		Scopes:  Scopes,
+		Expires: Expires.UTC(),
+		Created: time.Now().UTC(),
+	}
+	key.Rotated = key.Created
+
+	if res := apiKeys().Set(key.ID, key); res.Status != 1 {
+		return nil, errors.New("can't store key : " + res.Result)
+	}
+
+	return &APIKeyToken{APIKey: key, Token: apiKeyPrefix + key.ID + "_" + secret}, nil
+}
+
+// Replace the secret of a key. The old token stops working immediately
+func RotateAPIKey(ID string) (*APIKeyToken, error) {
+	key, err := apiKeys().Get(ID)
+	if err != nil {
+		return nil, err
+	}
+	if key.Revoked {
+		return nil, errors.New("key is revoked")
+	}
+
+	secret := newSecret()
+	key.Hash = hashSecret(secret)
+	key.Hint = secret[len(secret)-4:]
+	key.Rotated = time.Now().UTC()
+
+	if res := apiKeys().Set(ID, *key); res.Status != 1 {
+		return nil, errors.New("can't store key : " + res.Result)
+	}
+
+	key.ID = ID
+	return &APIKeyToken{APIKey: *key, Token: apiKeyPrefix + ID + "_" + secret}, nil
+}
+
+// Revoke a key. Revoked keys are kept for auditing
+func RevokeAPIKey(ID string) error {
+	key, err := apiKeys().Get(ID)
+	if err != nil {
+		return err
+	}
+
+	key.Revoked = true
+	if res := apiKeys().Set(ID, *key); res.Status != 1 {
+		return errors.New("can't store key : " + res.Result)
+	}
+	return nil
+}
+
+// List all keys (without secrets)
+func ListAPIKeys() ([]APIKey, error) {
+	docs, err := apiKeys().Query(moncore.Filter_MatchAll())
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]APIKey, len(docs))
+	for i, d := range docs {
+		d.Doc.ID = d.ID
+		out[i] = d.Doc
+	}
+	return out, nil
+}
+
+// Check a token (mm_<id>_<secret>). Returns nil if it's unknown, revoked, expired or wrong
+func VerifyAPIKey(Token string) *APIKey {
+	if Root_API_Key != "" && subtle.ConstantTimeCompare([]byte(Token), []byte(Root_API_Key)) == 1 {
+		return &APIKey{ID: "root", Name: "root", Scopes: []string{"admin"}}
+	}
+
+	rest, ok := strings.CutPrefix(Token, apiKeyPrefix)
+	if !ok {
+		return nil
+	}
+	ID, secret, ok := strings.Cut(rest, "_")
+	if !ok {
+		return nil
+	}
+
+	key, err := apiKeys().Get(ID)
+	if err != nil || key.Revoked || key.expired(time.Now()) {
+		return nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.Hash)) != 1 {
+		return nil
+	}
+
+	key.ID = ID
+	return key
+}
+
+func (K *APIKey) expired(Now time.Time) bool {
+	return !K.Expires.IsZero() && !Now.Before(K.Expires)
+}
+
+// Token from "X-API-Key: <token>" or "Authorization: Bearer <token>"
+func (c *APICall) BearerToken() string {
+	if k := c.GetHeader("X-API-Key"); k != "" {
+		return k
+	}
+	if auth := c.GetHeader("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+type apiKeyRequest struct {
+	Name    string    `json:"name"`
+	Scopes  []string  `json:"scopes"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// GET : mini/admin/keys
+func API_List_Keys(C *APICall) {
+	keys, err := ListAPIKeys()
+	if err != nil {
+		C.WriteError("Internal Server Error : ", err, http.StatusInternalServerError)
+		return
+	}
+	C.Respond(keys)
+}
+
+// POST : mini/admin/keys with {"name": "...", "scopes": ["read:db/*", ...], "expires": "<RFC 3339 time, optional>"}. The token is only returned once
+func API_Create_Key(C *APICall) {
+	if !C.Acceptable() {
+		return
+	}
+
+	req := apiKeyRequest{}
+	if err := C.BodyJsonToStruct(&req); err != nil {
+		C.Fail(err)
+		return
+	}
+
+	if !req.Expires.IsZero() && !req.Expires.After(time.Now()) {
+		C.WriteError("Bad Request : ", errors.New("expires is in the past"), http.StatusBadRequest)
+		return
+	}
+
+	key, err := CreateAPIKey(req.Name, req.Scopes, req.Expires)
+	if err != nil {
+		C.WriteError("Bad Request : ", err, http.StatusBadRequest)
+		return
+	}
+
+	C.RespondStatus(http.StatusCreated, key)
+}
+
+// GET : mini/admin/keys/{id}
+func API_Get_Key(C *APICall) {
+	key, err := apiKeys().Get(C.Param("id"))
+	if err != nil {
+		C.WriteError("Not Found : ", errors.New("no key "+C.Param("id")), http.StatusNotFound)
+		return
+	}
+	key.ID = C.Param("id")
+	C.Respond(key)
+}
+
+// POST : mini/admin/keys/{id}/rotate. The new token is only returned once
+func API_Rotate_Key(C *APICall) {
+	key, err := RotateAPIKey(C.Param("id"))
+	if err == moncore.ErrNotFound {
+		C.WriteError("Not Found : ", errors.New("no key "+C.Param("id")), http.StatusNotFound)
+		return
+	} else if err != nil {
+		C.WriteError("Conflict : ", err, http.StatusConflict)
+		return
+	}
+	C.Respond(key)
+}
+
+// DELETE : mini/admin/keys/{id}
+func API_Revoke_Key(C *APICall) {
+	if err := RevokeAPIKey(C.Param("id")); err == moncore.ErrNotFound {
+		C.WriteError("Not Found : ", errors.New("no key "+C.Param("id")), http.StatusNotFound)
+		return
+	} else if err != nil {
+		C.WriteError("Internal Server Error : ", err, http.StatusInternalServerError)
+		return
+	}
+	C.WriteStatus(http.StatusNoContent)
+}
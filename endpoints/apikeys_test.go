@@ -0,0 +1,142 @@
+package endpoints
+
+import (
+	"testing"
+	"time"
+
+	"mongomini/agra/moncore"
+)
+
+func TestValidateScopes(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		ok     bool
+	}{
+		{[]string{"admin"}, true},
+		{[]string{"read:shop", "write:shop/orders"}, true},
+		{[]string{"read:*/*"}, true},
+		{nil, false},
+		{[]string{"read"}, false},
+		{[]string{"read:"}, false},
+		{[]string{"delete:shop"}, false},
+		{[]string{"read:shop/[orders"}, false},
+	}
+	for _, tt := range tests {
+		if err := ValidateScopes(tt.scopes); (err == nil) != tt.ok {
+			t.Errorf("ValidateScopes(%q) = %v, want ok %v", tt.scopes, err, tt.ok)
+		}
+	}
+}
+
+func TestKeyAccess(t *testing.T) {
+	tests := []struct {
+		scope  string
+		action string
+		db     string
+		col    string
+		allows bool
+	}{
+		{"read:shop/orders", ActionList, "shop", "orders", true},
+		{"read:shop/orders", ActionGet, "shop", "orders", true},
+		{"read:shop/orders", ActionAggregate, "shop", "orders", true},
+		{"read:shop/orders", ActionSet, "shop", "orders", false},
+		{"read:shop/orders", ActionDelete, "shop", "orders", false},
+		{"read:shop/orders", ActionGet, "shop", "users", false},
+		{"read:shop/orders", ActionGet, "blog", "orders", false},
+		{"read:shop/orders", ActionList, "shop", "", true}, // collections of the database
+		{"read:shop", ActionGet, "shop", "users", true},
+		{"read:shop", ActionList, "", "", false}, // databases
+		{"write:shop/*", ActionSet, "shop", "orders", true},
+		{"write:shop/*", ActionDelete, "shop", "orders", true},
+		{"write:shop/*", ActionGet, "shop", "orders", false},
+		{"write:shop/ord*", ActionSet, "shop", "orders", true},
+		{"write:shop/ord*", ActionSet, "shop", "users", false},
+		{"read:*", ActionGet, "blog", "posts", true},
+		{"read:*", ActionList, "", "", true},
+		{"read:*", ActionGet, moncore.SystemDBName, "users", false},
+		{"write:*/*", ActionSet, moncore.SystemDBName, "apikeys", false},
+		{"read:*", ActionAdmin, "", "", false},
+		{"admin", ActionSet, "shop", "orders", true},
+		{"admin", ActionAdmin, "", "", true},
+		{"admin", ActionAdmin, moncore.SystemDBName, "users", true},
+		{"admin", ActionGet, moncore.SystemDBName, "users", false},
+	}
+	for _, tt := range tests {
+		A := KeyAccess(&APIKey{ID: "k1", Scopes: []string{tt.scope}})
+		if got := A.Allows(tt.action, tt.db, tt.col); got != tt.allows {
+			t.Errorf("%s : Allows(%s, %q, %q) = %v, want %v", tt.scope, tt.action, tt.db, tt.col, got, tt.allows)
+		}
+	}
+
+	if A := KeyAccess(&APIKey{ID: "k1"}); A.Principal != "key:k1" || len(A.Permissions) != 0 {
+		t.Fatalf("key without scopes : %+v", A)
+	}
+}
+
+func TestVerifyAPIKeyMalformed(t *testing.T) {
+	saved := Root_API_Key
+	t.Cleanup(func() { Root_API_Key = saved })
+	Root_API_Key = "mm_root_secret"
+
+	if K := VerifyAPIKey("mm_root_secret"); K == nil || K.ID != "root" {
+		t.Fatalf("root key : %+v", K)
+	}
+
+	// Rejected before any lookup
+	for _, token := range []string{"", "mm_", "mm_nosecret", "xx_id_secret", "MM_root_secret"} {
+		if K := VerifyAPIKey(token); K != nil {
+			t.Errorf("VerifyAPIKey(%q) = %+v", token, K)
+		}
+	}
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	withMongoDB(t)
+
+	T, err := CreateAPIKey("test", []string{"read:shop"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if K := VerifyAPIKey(T.Token); K == nil || K.ID != T.ID || K.Scopes[0] != "read:shop" {
+		t.Fatalf("VerifyAPIKey() = %+v", K)
+	}
+
+	wrong := T.Token[:len(T.Token)-1] + "x"
+	if T.Token[len(T.Token)-1] == 'x' {
+		wrong = T.Token[:len(T.Token)-1] + "y"
+	}
+	rejected := map[string]string{
+		"wrong secret": wrong,
+		"no secret":    apiKeyPrefix + T.ID + "_",
+		"unknown key":  apiKeyPrefix + "000000000000000000000000_" + T.Token[len(apiKeyPrefix+T.ID+"_"):],
+	}
+
+	rotated, err := RotateAPIKey(T.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected["rotated"] = T.Token
+	if VerifyAPIKey(rotated.Token) == nil {
+		t.Fatal("rotated token rejected")
+	}
+
+	revoked, _ := CreateAPIKey("revoked", []string{"admin"}, time.Time{})
+	if err := RevokeAPIKey(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	rejected["revoked"] = revoked.Token
+
+	expired, _ := CreateAPIKey("expired", []string{"admin"}, time.Now().Add(-time.Minute))
+	rejected["expired"] = expired.Token
+
+	later, _ := CreateAPIKey("later", []string{"admin"}, time.Now().Add(time.Hour))
+	if VerifyAPIKey(later.Token) == nil {
+		t.Fatal("key expiring later rejected")
+	}
+
+	for name, token := range rejected {
+		if K := VerifyAPIKey(token); K != nil {
+			t.Errorf("%s : VerifyAPIKey() = %+v", name, K)
+		}
+	}
+}
@@ -1,11 +1,159 @@
 package endpoints
 
-// func api_POST_Auth(C *API_Call_Receieved) {
-// 	return StringResponse("API POST recieved for api/auth/" + C + " with " + strconv.Itoa(len(body)) + " bytes of body")
-// }
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
 
-func API_Auth(C *APICall) {
-	C.WriteString("API GET recieved. Path : " + C.Path)
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type passwordChange struct {
+	Current string `json:"current_password"`
+	New     string `json:"new_password"`
+}
+
+// POST : api/auth/register with {"username": "...", "password": "..."}
+func API_Auth_Register(C *APICall) {
+	if !Allow_Registration {
+		C.WriteError("Forbidden : ", errors.New("registration is disabled"), http.StatusForbidden)
+		return
+	}
+	if !C.Acceptable() {
+		return
+	}
+
+	req := credentials{}
+	if err := C.BodyJsonToStruct(&req); err != nil {
+		C.Fail(err)
+		return
+	}
+
+	U, err := RegisterUser(req.Username, req.Password)
+	if err == ErrUserExists {
+		C.WriteError("Conflict : ", err, http.StatusConflict)
+		return
+	} else if err != nil {
+		C.WriteError("Bad Request : ", err, http.StatusBadRequest)
+		return
+	}
+
+	C.RespondStatus(http.StatusCreated, U)
+}
+
+// POST : api/auth/login with {"username": "...", "password": "..."}
+func API_Auth_Login(C *APICall) {
+	req := credentials{}
+	if err := C.BodyJsonToStruct(&req); err != nil {
+		C.Fail(err)
+		return
+	}
+
+	U, err := AuthenticateUser(req.Username, req.Password)
+	if err != nil {
+		C.WriteError("Unauthorized : ", err, http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := IssueTokens(U)
+	if err != nil {
+		C.WriteError("Internal Server Error : ", err, http.StatusInternalServerError)
+		return
+	}
+
+	C.SetHeader("Cache-Control", "no-store")
+	C.Respond(tokens)
+}
+
+// POST : api/auth/refresh with {"refresh_token": "..."}
+func API_Auth_Refresh(C *APICall) {
+	req := refreshRequest{}
+	if err := C.BodyJsonToStruct(&req); err != nil {
+		C.Fail(err)
+		return
+	}
+
+	tokens, err := RefreshTokens(req.RefreshToken)
+	if err == ErrBadToken {
+		C.WriteError("Unauthorized : ", err, http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		C.WriteError("Internal Server Error : ", err, http.StatusInternalServerError)
+		return
+	}
+
+	C.SetHeader("Cache-Control", "no-store")
+	C.Respond(tokens)
+}
+
+// POST : api/auth/logout with the access token, and optionally {"refresh_token": "..."}. Or with the session cookie
+func API_Auth_Logout(C *APICall) {
+	if C.User() == nil {
+		C.WriteError("Unauthorized : ", ErrBadToken, http.StatusUnauthorized)
+		return
+	}
+
+	req := refreshRequest{}
+	json.Unmarshal(C.Body(), &req) // The body is optional
+
+	if C.claims != nil {
+		Logout(C.claims, req.RefreshToken)
+	} else {
+		C.DestroySession()
+	}
+	C.WriteStatus(http.StatusNoContent)
+}
+
+// POST : api/auth/password with the access token and {"current_password": "...", "new_password": "..."}
+func API_Auth_Password(C *APICall) {
+	U := C.User()
+	if U == nil {
+		C.WriteError("Unauthorized : ", ErrBadToken, http.StatusUnauthorized)
+		return
+	}
+
+	req := passwordChange{}
+	if err := C.BodyJsonToStruct(&req); err != nil {
+		C.Fail(err)
+		return
+	}
+
+	if err := ChangePassword(U.Username, req.Current, req.New); err == ErrBadCredentials {
+		C.WriteError("Forbidden : ", err, http.StatusForbidden)
+		return
+	} else if err == ErrUserChanged {
+		C.WriteError("Conflict : ", err, http.StatusConflict)
+		return
+	} else if err != nil {
+		C.WriteError("Bad Request : ", err, http.StatusBadRequest)
+		return
+	}
+
+	// Other sessions of the user are invalidated, keep this one under a new ID
+	if C.claims == nil {
+		if U, err := users().Get(U.Username); err == nil && CheckError(C.StartUserSession(U)) {
+			C.DestroySession()
+		}
+	}
+
+	C.WriteStatus(http.StatusNoContent)
+}
 
+// GET : api/auth/me
+func API_Auth_Me(C *APICall) {
+	U := C.User()
+	if U == nil {
+		C.SetHeader("WWW-Authenticate", `Bearer realm="mongomini"`)
+		C.WriteError("Unauthorized : ", ErrBadToken, http.StatusUnauthorized)
+		return
+	}
 
+	C.Respond(U)
 }
@@ -0,0 +1,110 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/url"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"mongomini/agra/moncore"
+)
+
+// Decode the request body into a value that can be stored as a document, according to Content-Type :
+//
+//	application/json (or no Content-Type)          : JSON. Integral numbers are stored as integers
+//	application/extjson, application/ejson          : MongoDB Extended JSON, so dates, ObjectIDs and decimals survive
+//	application/x-www-form-urlencoded               : Object of form fields. Repeated fields become arrays
+//	anything else (text/plain, image/png, ...)      : moncore.Blob holding the raw bytes and the media type
+func (c *APICall) BodyDocument() (interface{}, error) {
+	body := c.Body()
+	if body == nil && c.bodyErr != nil {
+		return nil, c.bodyErr
+	}
+
+	ctype := c.GetHeader("Content-Type")
+	mediaType := ""
+	if ctype != "" {
+		mt, _, err := mime.ParseMediaType(ctype)
+		if err != nil {
+			return nil, err
+		}
+		mediaType = mt
+	}
+
+	switch {
+	case mediaType == "" || mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return ParseJSONValue(body)
+
+	case mediaType == "application/extjson" || mediaType == "application/ejson" || mediaType == "application/x-extended-json":
+		doc := bson.D{}
+		if err := bson.UnmarshalExtJSON(body, false, &doc); err != nil {
+			return nil, err
+		}
+		return doc, nil
+
+	case mediaType == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		doc := map[string]interface{}{}
+		for k, vs := range form {
+			if len(vs) == 1 {
+				doc[k] = vs[0]
+			} else {
+				doc[k] = vs
+			}
+		}
+		return doc, nil
+	}
+
+	return moncore.Blob{ContentType: ctype, Data: body}, nil
+}
+
+// Parse JSON keeping integers as int64 (encoding/json would turn every number into float64)
+func ParseJSONValue(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return normalizeJSONNumbers(v), nil
+}
+
+func normalizeJSONNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = normalizeJSONNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = normalizeJSONNumbers(e)
+		}
+	}
+	return v
+}
+
+// Parse a value given in a URL path segment.
+// JSON literals keep their type (42, 4.2, true, null, "42", {"a":1}). Anything else is a string.
+func ParsePathValue(s string) interface{} {
+	if v, err := ParseJSONValue([]byte(s)); err == nil {
+		return v
+	}
+	return s
+}
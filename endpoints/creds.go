@@ -6,4 +6,29 @@ var (
 	Mongo_pass   string = "apsppasss"
 	Mongo_host   string = "minicluster.hybfy.mongodb.net"
 	Mongo_DBName string = "database"
+
+	CORS_Origins   []string = []string{}       // Allowed CORS origins. Empty disables CORS headers
+	Max_Body_Bytes int64    = 16 * 1024 * 1024 // Request body size limit for mini/ routes
+
+	Max_Import_Bytes int64 = 1024 * 1024 * 1024 // Request body size limit for mini/import/ uploads
+
+	Require_Auth bool   = true // Require an API key or user access token on mini/ routes
+	Root_API_Key string = ""   // Token with the admin scope, to bootstrap key management. Empty disables it
+
+	JWT_Secret         []byte = nil  // HS256 key for access tokens. Required with Require_Auth, random per process otherwise
+	Allow_Registration bool   = true // Allow anyone to create an account through api/auth/register
+
+	Session_Secret []byte = nil  // HMAC key signing session cookies. Required with Require_Auth, random per process otherwise
+	Secure_Cookies bool   = true // Mark cookies Secure even on plain HTTP requests (turn off for local development)
+
+	Trust_Proxy bool = false // Use X-Forwarded-For as the client address (behind a proxy like Vercel's)
+
+	RateLimit_Store    string  = "memory" // "memory" for a single instance, "moncore" to share limits between instances
+	RateLimit_Burst    float64 = 60       // Tokens per caller bucket
+	RateLimit_Rate     float64 = 10       // Tokens refilled per second
+	Quota_Daily_Reads  int64   = 0        // Read requests per caller and day. 0 is unlimited
+	Quota_Daily_Writes int64   = 0        // Write requests per caller and day. 0 is unlimited
+	Quota_Daily_Bytes  int64   = 0        // Response bytes per caller and day. 0 is unlimited
+
+	Rules_File string = "" // Document access rules (see package rules). Empty allows every access permitted by roles
 )
@@ -0,0 +1,101 @@
+package endpoints
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"mongomini/agra/moncore"
+)
+
+// Query parameters of mini/ls/{db}/{collection} that are options, not filters
+var ListQueryParams = []string{"format", "envelope", "total", "limit", "after"}
+
+// Largest ?limit= of a listing
+var MaxListLimit int64 = 1000
+
+// Response of mini/ls/{db}/{collection}?envelope
+type ListEnvelope struct {
+	Data   []moncore.GenericDBDocument `json:"data"`
+	Count  int                         `json:"count"`           // Documents in data
+	Total  *int64                      `json:"total,omitempty"` // Documents matching the filter that the caller may read, with ?total
+	Filter interface{}                 `json:"filter"`          // MongoDB query that was run, as relaxed Extended JSON
+	Paging ListPaging                  `json:"paging"`
+	Timing map[string]float64          `json:"timing"` // Milliseconds spent routing and in the database
+}
+
+type ListPaging struct {
+	Limit int64  `json:"limit,omitempty"`
+	After string `json:"after,omitempty"` // Token of this page
+	Next  string `json:"next,omitempty"`  // Token of the next page, to pass as ?after=. Missing on the last page
+}
+
+// Listing options from the query parameters
+type listQuery struct {
+	Filter   *moncore.Filter
+	Limit    int64
+	After    string
+	Envelope bool
+	Total    bool
+}
+
+func (c *APICall) listQuery() (*listQuery, error) {
+	Q := c.HTTPRequest.URL.Query()
+
+	L := &listQuery{After: Q.Get("after"), Envelope: queryFlag(Q, "envelope"), Total: queryFlag(Q, "total")}
+
+	if v := Q.Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			return nil, NewAPIError(CodeBadRequest, "limit must be a positive integer")
+		}
+		L.Limit = min(n, MaxListLimit)
+	}
+
+	for _, p := range ListQueryParams {
+		Q.Del(p)
+	}
+	L.Filter = moncore.Filter_FromQueryStrings(Q)
+	return L, nil
+}
+
+// Whether the flag is set : "?name", "?name=true" or "?name=1"
+func queryFlag(Q url.Values, name string) bool {
+	if _, ok := Q[name]; !ok {
+		return false
+	}
+	switch strings.ToLower(Q.Get(name)) {
+	case "", "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// Query as plain relaxed Extended JSON values, for ListEnvelope.Filter
+func filterValue(query bson.D) interface{} {
+	J, err := bson.MarshalExtJSON(query, false, false)
+	if CheckError(err) {
+		return nil
+	}
+	v, err := ParseJSONValue(J)
+	if CheckError(err) {
+		return nil
+	}
+	return v
+}
+
+// URL of the same request with ?after=token
+func (c *APICall) pageURL(token string) string {
+	U := *c.HTTPRequest.URL
+	Q := U.Query()
+	Q.Set("after", token)
+	U.RawQuery = Q.Encode()
+	return U.RequestURI()
+}
+
+func milliseconds(D time.Duration) float64 {
+	return float64(D.Microseconds()) / 1000
+}
@@ -0,0 +1,253 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"mongomini/agra/moncore"
+)
+
+// Error codes sent in error responses. They are stable : clients may rely on them, new ones may be added
+const (
+	CodeBadRequest           = "bad_request"
+	CodeInvalidBody          = "invalid_body"
+	CodeInvalidPath          = "invalid_path"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeProtectedField       = "protected_field"
+	CodeCSRF                 = "csrf_failed"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeNotAcceptable        = "not_acceptable"
+	CodeConflict             = "conflict"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeUnprocessable        = "unprocessable"
+	CodeRateLimited          = "rate_limited"
+	CodeQuotaExceeded        = "quota_exceeded"
+	CodeInternal             = "internal"
+	CodeDatabase             = "database_error"
+	CodeUnavailable          = "unavailable"
+	CodeTimeout              = "timeout"
+)
+
+// HTTP status and meaning of an error code
+type ErrorCodeInfo struct {
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+}
+
+// Catalog of the error codes
+var ErrorCodes = map[string]ErrorCodeInfo{
+	CodeBadRequest:           {http.StatusBadRequest, "The request is malformed"},
+	CodeInvalidBody:          {http.StatusBadRequest, "The request body can't be decoded"},
+	CodeInvalidPath:          {http.StatusBadRequest, "A field path or path parameter is invalid"},
+	CodeUnauthorized:         {http.StatusUnauthorized, "Missing or invalid credentials"},
+	CodeForbidden:            {http.StatusForbidden, "The caller isn't allowed to do this"},
+	CodeProtectedField:       {http.StatusForbidden, "The write would change a protected field"},
+	CodeCSRF:                 {http.StatusForbidden, "Missing or wrong CSRF token"},
+	CodeNotFound:             {http.StatusNotFound, "No such route, document or resource"},
+	CodeMethodNotAllowed:     {http.StatusMethodNotAllowed, "The route doesn't accept this method, see the Allow header"},
+	CodeNotAcceptable:        {http.StatusNotAcceptable, "None of the accepted media types can be produced"},
+	CodeConflict:             {http.StatusConflict, "The resource exists or is in a conflicting state"},
+	CodePayloadTooLarge:      {http.StatusRequestEntityTooLarge, "The request body is too large"},
+	CodeUnsupportedMediaType: {http.StatusUnsupportedMediaType, "The Content-Type isn't supported"},
+	CodeUnprocessable:        {http.StatusUnprocessableEntity, "The document can't be converted"},
+	CodeRateLimited:          {http.StatusTooManyRequests, "Too many requests, see the Retry-After header"},
+	CodeQuotaExceeded:        {http.StatusTooManyRequests, "A daily quota is used up, see the Retry-After header"},
+	CodeInternal:             {http.StatusInternalServerError, "Unexpected server error"},
+	CodeDatabase:             {http.StatusInternalServerError, "The database request failed"},
+	CodeUnavailable:          {http.StatusServiceUnavailable, "The database can't be reached"},
+	CodeTimeout:              {http.StatusGatewayTimeout, "The database request timed out"},
+}
+
+// Error sent to clients as {"error": {"code": ..., "message": ..., "request_id": ...}}
+type APIError struct {
+	Status    int         `json:"-"`
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"request_id,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+func (E *APIError) Error() string {
+	return E.Code + " : " + E.Message
+}
+
+// New error with the status of the code in ErrorCodes
+func NewAPIError(Code string, Message string) *APIError {
+	status := http.StatusInternalServerError
+	if info, ok := ErrorCodes[Code]; ok {
+		status = info.Status
+	}
+	return &APIError{Status: status, Code: Code, Message: Message}
+}
+
+// Default code of an HTTP status
+func codeForStatus(Status int) string {
+	switch Status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusNotAcceptable:
+		return CodeNotAcceptable
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	case http.StatusUnprocessableEntity:
+		return CodeUnprocessable
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	}
+	if Status >= 400 && Status < 500 {
+		return CodeBadRequest
+	}
+	return CodeInternal
+}
+
+// Convert any error to an APIError : moncore and MongoDB errors get their matching status
+func ErrorFor(Err error) *APIError {
+	var apiErr *APIError
+	var opErr *moncore.OperationError
+	var decErr *moncore.DocumentDecodeError
+	var sizeErr *http.MaxBytesError
+	var inputErr *moncore.ImportInputError
+
+	switch {
+	case errors.As(Err, &apiErr):
+		E := *apiErr
+		return &E
+
+	case errors.As(Err, &opErr):
+		code := codeForStatus(opErr.HTTPStatus())
+		switch opErr.Action {
+		case "protected":
+			code = CodeProtectedField
+		case "path":
+			code = CodeInvalidPath
+		case "dbreq":
+			code = CodeDatabase
+		}
+		E := NewAPIError(code, opErr.Result)
+		E.Status = opErr.HTTPStatus()
+		return E
+
+	case errors.As(Err, &decErr):
+		E := NewAPIError(CodeUnprocessable, decErr.Error())
+		E.Details = map[string]string{"id": decErr.ID, "field": decErr.Field}
+		return E
+
+	case errors.As(Err, &sizeErr):
+		return NewAPIError(CodePayloadTooLarge, "request body is larger than "+strconv.FormatInt(sizeErr.Limit, 10)+" bytes")
+
+	case errors.As(Err, &inputErr):
+		E := NewAPIError(CodeInvalidBody, inputErr.Error())
+		E.Details = map[string]int64{"record": inputErr.Record}
+		return E
+
+	case errors.Is(Err, moncore.ErrNotFound), errors.Is(Err, mongo.ErrNoDocuments):
+		return NewAPIError(CodeNotFound, Err.Error())
+
+	case errors.Is(Err, moncore.ErrDenied):
+		return NewAPIError(CodeForbidden, Err.Error())
+
+	case errors.Is(Err, ErrBadToken), errors.Is(Err, ErrBadCredentials):
+		return NewAPIError(CodeUnauthorized, Err.Error())
+
+	case errors.Is(Err, ErrUserExists):
+		return NewAPIError(CodeConflict, Err.Error())
+
+	case errors.Is(Err, context.DeadlineExceeded), mongo.IsTimeout(Err):
+		return NewAPIError(CodeTimeout, Err.Error())
+
+	case mongo.IsNetworkError(Err):
+		return NewAPIError(CodeUnavailable, Err.Error())
+
+	case mongo.IsDuplicateKeyError(Err):
+		return NewAPIError(CodeConflict, Err.Error())
+	}
+
+	var cmdErr mongo.CommandError
+	var writeErr mongo.WriteException
+	if errors.As(Err, &cmdErr) || errors.As(Err, &writeErr) {
+		return NewAPIError(CodeDatabase, Err.Error())
+	}
+
+	return NewAPIError(CodeInternal, Err.Error())
+}
+
+// Write the error as the JSON error envelope. See ErrorFor()
+func (c *APICall) Fail(Err error) {
+	c.WriteAPIError(ErrorFor(Err))
+}
+
+// Write the JSON error envelope with the status of the error.
+// If the response was already started, the error can only be logged.
+func (c *APICall) WriteAPIError(E *APIError) {
+	if E.RequestID == "" {
+		E.RequestID = c.RequestID
+	}
+
+	if c.HeadersSent() {
+		PrintErrorMsg("Response already started, can't send error : ", E)
+		return
+	}
+
+	PrintErrorMsg("WriteError: ", E)
+
+	h := (*c.HTTPWriter).Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	c.WriteStatus(E.Status)
+	c.WriteJSON(map[string]*APIError{"error": E})
+	c.WriteString("\n")
+}
+
+// Message of WriteError : the prefix is dropped when it only repeats the status text ("Not Found : ")
+func errorMessage(PrefixMsg string, Err error, HttpStatusCode int) string {
+	prefix := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(PrefixMsg), ":"))
+	if prefix == "" || strings.EqualFold(prefix, http.StatusText(HttpStatusCode)) {
+		return Err.Error()
+	}
+	return PrefixMsg + Err.Error()
+}
+
+// Error of a body that can't be read or decoded : invalid_body, unless it's already an APIError or too large
+func invalidBody(Err error) error {
+	var apiErr *APIError
+	var sizeErr *http.MaxBytesError
+	if errors.As(Err, &apiErr) || errors.As(Err, &sizeErr) {
+		return Err
+	}
+	return NewAPIError(CodeInvalidBody, Err.Error())
+}
+
+// Write the result of a write operation (see Respond), or its failure as the JSON error envelope
+func (c *APICall) WriteOperation(Res moncore.WriteOperationResponse) {
+	if err := Res.Err(); err != nil {
+		c.Fail(err)
+		return
+	}
+	c.Respond(Res)
+}
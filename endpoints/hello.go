@@ -1,7 +1,8 @@
 package endpoints
 
 import (
-	"errors"
+	"encoding/json"
+	"html"
 	"mongomini/agra/moncore"
 	"strings"
 	"time"
@@ -12,12 +13,12 @@ func API_Hello(C *APICall) {
 	C.HTMLBegin()
 
 	C.WriteString("<h1> API_Hello! </h1> \n <br><br> \n Path : " +
-		C.Path + " \n <br> \n Path Params : " + strings.Join(C.Params, ", ") +
-		" \n <br> \n Method : " + C.Method() + " \n <br> \n Body : \n <br> \n " +
-		C.BodyToString() + " \n <br> ")
+		html.EscapeString(C.Path) + " \n <br> \n Path Params : " + html.EscapeString(strings.Join(C.Params, ", ")) +
+		" \n <br> \n Method : " + html.EscapeString(C.Method()) + " \n <br> \n Body : \n <br> \n " +
+		html.EscapeString(C.BodyToString()) + " \n <br> ")
 
 	if len(C.Params) == 2 {
-		C.WriteString("<br> \n <h2> Let's go, Captain " + C.Params[0] + " " + C.Params[1] + "! </h2> \n <br>")
+		C.WriteString("<br> \n <h2> Let's go, Captain " + html.EscapeString(C.Params[0]+" "+C.Params[1]) + "! </h2> \n <br>")
 	}
 
 	filter := moncore.Filter_MatchAll()
@@ -60,67 +61,228 @@ func API_Hello(C *APICall) {
 
 }
 
+// GET : mini/ls/{db}
 func API_List_Collections(C *APICall) {
+	Names := []string{}
+	for _, name := range Moncore.Database(C.Param("db")).ListCollectionNames() {
+		if C.Can(ActionList, C.Param("db"), name) {
+			Names = append(Names, name)
+		}
+	}
+	C.Respond(Names)
+}
+
+// GET : mini/ls/{db}/{collection}. Query parameters are filters (see moncore.Filter_FromQueryStrings), except :
+//
+//	limit=N        : pages of N documents, in _id order. The next page is in the Link header (rel="next")
+//	after=TOKEN    : page following the one that returned TOKEN
+//	envelope       : wrap documents in a ListEnvelope, with the applied filter, paging tokens and timing
+//	total          : count all matching documents in the envelope
+//	format=NAME    : response format, see Respond(). NDJSON is streamed
+func API_List_Documents(C *APICall) {
+
+	if F := C.ResponseFormat(); F != nil && F.Name == FormatNDJSON {
+		API_Stream_Documents(C)
+		return
+	}
+
+	L, err := C.listQuery()
+	if err != nil {
+		C.Fail(err)
+		return
+	}
+
+	Col := C.Collection(C.Param("db"), C.Param("collection"))
+
+	stop := C.StartTiming("db", "Database")
+	Page, err := Col.FindPage(L.Filter, moncore.PageOptions{Limit: L.Limit, After: L.After})
+	var total *int64
+	if err == nil && L.Envelope && L.Total {
+		n, cerr := Col.Count(L.Filter)
+		total, err = &n, cerr
+	}
+	stop()
+
+	if err == moncore.ErrBadPageToken {
+		C.Fail(NewAPIError(CodeBadRequest, "invalid after token"))
+		return
+	} else if err != nil {
+		C.Fail(err)
+		return
+	}
 
-	if len(C.Params) == 1 {
-		C.WriteJSONBeautified(Moncore.Database(C.Params[0]).ListCollectionNames())
+	if Page.Next != "" {
+		C.SetHeader("Link", "<"+C.pageURL(Page.Next)+`>; rel="next"`)
+	}
 
-	} else {
-		C.WriteError("Bad Request", errors.New("endpoints : mini/ls/<db>"), 400)
+	if !L.Envelope {
+		C.Respond(Page.Docs)
 		return
 	}
 
+	C.Respond(ListEnvelope{
+		Data:   Page.Docs,
+		Count:  len(Page.Docs),
+		Total:  total,
+		Filter: filterValue(Col.NormalizedFilter(L.Filter)),
+		Paging: ListPaging{Limit: L.Limit, After: L.After, Next: Page.Next},
+		Timing: map[string]float64{"routing": milliseconds(C.RoutingTime()), "db": milliseconds(C.Timing("db"))},
+	})
+
 }
 
-func API_List_Documents(C *APICall) {
+// Stream documents as newline delimited JSON (one document per line). Takes the filters, limit and after token of
+// API_List_Documents, documents come in the same _id order. Stops reading from the database as soon as the client goes away.
+func API_Stream_Documents(C *APICall) {
+
+	L, err := C.listQuery()
+	if err != nil {
+		C.Fail(err)
+		return
+	}
+
+	Stream, SErr := C.Collection(C.Param("db"), C.Param("collection")).Stream(C.Context(), L.Filter, &moncore.QueryOptions{BatchSize: 100, Buffer: 16, Limit: L.Limit, Paged: true, After: L.After})
 
-	Q := map[string][]string(C.HTTPRequest.URL.Query())
+	if SErr == moncore.ErrBadPageToken {
+		C.Fail(NewAPIError(CodeBadRequest, "invalid after token"))
+		return
+	} else if SErr != nil {
+		C.Fail(SErr)
+		return
+	}
 
-	C.WriteJSONBeautified(Q)
+	defer Stream.Close()
 
-	F := moncore.Filter_FromQueryStrings(Q)
+	C.SetHeader("Content-Type", "application/x-ndjson")
+	C.SetHeader("X-Content-Type-Options", "nosniff")
 
-	C.WriteString("\n\n")
+	for Doc := range Stream.Docs {
 
-	if len(C.Params) == 2 {
-		Docs := Moncore.Database(C.Params[0]).Collection(C.Params[1]).Query(F)
-		C.WriteJSONBeautified(Docs)
+		J, JErr := json.Marshal(Doc)
+		if CheckError(JErr) {
+			continue
+		}
 
-	} else {
-		C.WriteError("Bad Request", errors.New("endpoint : mini/ls/<db>/<collection>"), 400)
+		C.Write(append(J, '\n'))
+		C.Flush()
+	}
+
+	// Headers are already sent, so a terminal error is reported as the last line
+	if err := Stream.Err(); err != nil && C.Context().Err() == nil {
+		E := ErrorFor(err)
+		E.RequestID = C.RequestID
+		J, _ := json.Marshal(map[string]*APIError{"error": E})
+		C.Write(append(J, '\n'))
+	}
+
+}
+
+// GET : mini/get/{db}/{collection}/{dockey}
+func API_Get_Document(C *APICall) {
+
+	Doc, err := C.Collection(C.Param("db"), C.Param("collection")).GetDocument(C.Param("dockey"))
+	if err == moncore.ErrNotFound {
+		C.Fail(NewAPIError(CodeNotFound, "no document "+C.Param("dockey")))
+		return
+	} else if err != nil {
+		C.Fail(err)
 		return
 	}
 
+	C.Respond(Doc)
+
 }
 
+// POST, PUT : mini/set/{db}/{collection}/{dockey} with the document as body (see BodyDocument)
 func API_Set_Document(C *APICall) {
 
-	var doc interface{}
+	doc, err := C.BodyDocument()
+	if err != nil {
+		C.Fail(invalidBody(err))
+		return
+	}
 
-	if len(C.Params) == 3 {
+	Col := C.Collection(C.Param("db"), C.Param("collection"))
 
-		if C.Method() == "POST" {
-			doc = C.BodyToString()
-		} else {
-			mapdoc := map[string]string{"Created": time.Now().UTC().String()}
-			doc = mapdoc
-		}
+	C.WriteOperation(Col.Set(C.Param("dockey"), doc))
+
+}
+
+// GET : mini/set/{db}/{collection}/{dockey}. Sets the document to its creation time
+func API_Touch_Document(C *APICall) {
+
+	doc := map[string]string{"Created": time.Now().UTC().String()}
+
+	Col := C.Collection(C.Param("db"), C.Param("collection"))
+
+	C.WriteOperation(Col.Set(C.Param("dockey"), doc))
+
+}
+
+// GET : mini/set/{db}/{collection}/{dockey}/{key}/{value}. Sets the document to {key: value}
+func API_Set_Document_Value(C *APICall) {
+
+	doc := map[string]interface{}{C.Param("key"): ParsePathValue(C.Param("value"))}
+
+	Col := C.Collection(C.Param("db"), C.Param("collection"))
+
+	C.WriteOperation(Col.Set(C.Param("dockey"), doc))
+
+}
+
+// DELETE : mini/del/{db}/{collection}/{dockey}
+func API_Delete_Document(C *APICall) {
+
+	Col := C.Collection(C.Param("db"), C.Param("collection"))
 
-	} else if len(C.Params) == 5 {
-		key := C.Params[3]
-		value := C.Params[4]
+	C.WriteOperation(Col.Delete(C.Param("dockey")))
 
-		mapdoc := map[string]string{key: value}
-		doc = mapdoc
-	} else {
-		C.WriteError("Bad Request", errors.New("must have only one parameter. Endpoints : mini/set/<db</<collection>/<dockey> or mini/set/<db</<collection>/<dockey>/<key>/<value>"), 400)
+}
+
+// GET : mini/{db}/{collection}/{dockey}/field/{path}. Reads one nested field
+func API_Get_Field(C *APICall) {
+
+	Key, Path := C.Param("dockey"), C.Param("path")
+
+	if err := moncore.ValidateFieldPath(Path); err != nil {
+		C.Fail(NewAPIError(CodeInvalidPath, err.Error()))
+		return
+	}
+
+	Val, err := C.Collection(C.Param("db"), C.Param("collection")).GetField(Key, Path)
+	if err == moncore.ErrNotFound {
+		C.Fail(NewAPIError(CodeNotFound, Key+" has no field "+Path))
+		return
+	} else if err == moncore.ErrDenied {
+		C.Fail(NewAPIError(CodeForbidden, "read of "+Key+" is not allowed"))
+		return
+	} else if err != nil {
+		C.Fail(err)
 		return
 	}
 
-	Col := Moncore.Database(C.Params[0]).Collection(C.Params[1])
+	C.Respond(Val)
+
+}
+
+// PUT : mini/{db}/{collection}/{dockey}/field/{path}. Sets one nested field (body parsed like mini/set)
+func API_Set_Field(C *APICall) {
+
+	Val, err := C.BodyDocument()
+	if err != nil {
+		C.Fail(invalidBody(err))
+		return
+	}
+
+	Col := C.Collection(C.Param("db"), C.Param("collection"))
+
+	C.WriteOperation(Col.SetField(C.Param("dockey"), C.Param("path"), Val))
+
+}
 
-	NewDocKey := Col.Set(C.Params[2], doc)
+// DELETE : mini/{db}/{collection}/{dockey}/field/{path}. Unsets one nested field
+func API_Unset_Field(C *APICall) {
 
-	C.WriteJSONBeautified(NewDocKey)
+	C.WriteOperation(C.Collection(C.Param("db"), C.Param("collection")).UnsetField(C.Param("dockey"), C.Param("path")))
 
 }
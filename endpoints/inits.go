@@ -1,7 +1,10 @@
 package endpoints
 
 import (
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"mongomini/agra/moncore"
@@ -31,6 +34,20 @@ func InitAll() {
 
 	_InitMongoDB()
 
+	_InitWebhooks()
+
+	_InitWatch()
+
+	_InitUsers()
+
+	_InitSessions()
+
+	_InitRules()
+
+	_InitRateLimits()
+
+	_InitMiddlewares()
+
 	_InitEndpoints()
 
 	Inited = true
@@ -61,6 +78,96 @@ func _InitEnvArgs() {
 	if envarg := os.Getenv("Mongo_DBName"); len(envarg) != 0 {
 		Mongo_DBName = envarg
 	}
+
+	if envarg := os.Getenv("CORS_Origins"); len(envarg) != 0 {
+		CORS_Origins = strings.Split(envarg, ",")
+	}
+
+	if envarg := os.Getenv("Require_Auth"); len(envarg) != 0 {
+		if b, err := strconv.ParseBool(envarg); !CheckError(err) {
+			Require_Auth = b
+		}
+	}
+
+	if envarg := os.Getenv("Root_API_Key"); len(envarg) != 0 {
+		Root_API_Key = envarg
+	}
+
+	if envarg := os.Getenv("JWT_Secret"); len(envarg) != 0 {
+		JWT_Secret = []byte(envarg)
+	}
+
+	if envarg := os.Getenv("Allow_Registration"); len(envarg) != 0 {
+		if b, err := strconv.ParseBool(envarg); !CheckError(err) {
+			Allow_Registration = b
+		}
+	}
+
+	if envarg := os.Getenv("Session_Secret"); len(envarg) != 0 {
+		Session_Secret = []byte(envarg)
+	}
+
+	if envarg := os.Getenv("Secure_Cookies"); len(envarg) != 0 {
+		if b, err := strconv.ParseBool(envarg); !CheckError(err) {
+			Secure_Cookies = b
+		}
+	}
+
+	if envarg := os.Getenv("Trust_Proxy"); len(envarg) != 0 {
+		if b, err := strconv.ParseBool(envarg); !CheckError(err) {
+			Trust_Proxy = b
+		}
+	}
+
+	if envarg := os.Getenv("RateLimit_Store"); len(envarg) != 0 {
+		RateLimit_Store = envarg
+	}
+
+	if envarg := os.Getenv("RateLimit_Burst"); len(envarg) != 0 {
+		if f, err := strconv.ParseFloat(envarg, 64); !CheckError(err) {
+			RateLimit_Burst = f
+		}
+	}
+
+	if envarg := os.Getenv("RateLimit_Rate"); len(envarg) != 0 {
+		if f, err := strconv.ParseFloat(envarg, 64); !CheckError(err) {
+			RateLimit_Rate = f
+		}
+	}
+
+	if envarg := os.Getenv("Quota_Daily_Reads"); len(envarg) != 0 {
+		if n, err := strconv.ParseInt(envarg, 10, 64); !CheckError(err) {
+			Quota_Daily_Reads = n
+		}
+	}
+
+	if envarg := os.Getenv("Quota_Daily_Writes"); len(envarg) != 0 {
+		if n, err := strconv.ParseInt(envarg, 10, 64); !CheckError(err) {
+			Quota_Daily_Writes = n
+		}
+	}
+
+	if envarg := os.Getenv("Quota_Daily_Bytes"); len(envarg) != 0 {
+		if n, err := strconv.ParseInt(envarg, 10, 64); !CheckError(err) {
+			Quota_Daily_Bytes = n
+		}
+	}
+
+	if envarg := os.Getenv("Rules_File"); len(envarg) != 0 {
+		Rules_File = envarg
+	}
+
+	if envarg := os.Getenv("Max_Body_Bytes"); len(envarg) != 0 {
+		if n, err := strconv.ParseInt(envarg, 10, 64); !CheckError(err) {
+			Max_Body_Bytes = n
+		}
+	}
+
+	if envarg := os.Getenv("Max_Import_Bytes"); len(envarg) != 0 {
+		if n, err := strconv.ParseInt(envarg, 10, 64); !CheckError(err) {
+			Max_Import_Bytes = n
+		}
+	}
 }
 
 // Initialize the mongo client
@@ -76,17 +183,147 @@ func _InitMongoDB() {
 
 }
 
+// Initialize the global middlewares
+func _InitMiddlewares() {
+
+	API_Middlewares = append(API_Middlewares,
+		Recover(),
+		DeliverWebhooks(),
+		RequestID(),
+		AccessLog(),
+		CSRFProtect(),
+	)
+
+	if len(CORS_Origins) != 0 {
+		API_Middlewares = append(API_Middlewares, CORS(CORSOptions{
+			AllowedOrigins: CORS_Origins,
+			ExposedHeaders: []string{"X-Request-ID"},
+			MaxAge:         10 * time.Minute,
+		}))
+	}
+
+	API_Middlewares = append(API_Middlewares, Compress())
+}
+
+// Open a user collection. The document layout (enveloped or raw) is detected automatically.
+func OpenCollection(db string, collection string) *moncore.Collection {
+	return Moncore.Database(db).CollectionWithMode(collection, moncore.ModeAuto)
+}
+
+// Start webhook delivery for document changes
+func _InitWebhooks() {
+	Webhooks = Moncore.EnableWebhooks()
+}
+
 // Initialize the endpoints
 func _InitEndpoints() {
 
-	API_Endpoints = append(API_Endpoints,
-		API_Call_Handler_Prefix(`api/auth/`, API_Auth),
+	// Address limits come first, so credentials are checked only for clients with tokens left
+	Auth := API_Group{Prefix: "api/auth/", Middlewares: []Middleware{BodyLimit(64 * 1024), RateLimit(API_AddressRateLimits), RateLimit(API_RateLimits)}}
+	Mini := API_Group{Prefix: "mini/", Middlewares: []Middleware{BodyLimit(Max_Body_Bytes), RateLimit(API_AddressRateLimits), Authorize(), RateLimit(API_RateLimits)}}
+	Admin := Mini.Group("admin/")
+	Transfer := API_Group{Prefix: "mini/", Middlewares: []Middleware{BodyLimit(Max_Import_Bytes), RateLimit(API_AddressRateLimits), Authorize(), RateLimit(API_RateLimits)}}
+	Hello := API_Group{Middlewares: Mini.Middlewares}
+	Docs := API_Group{Prefix: "mini/", Middlewares: []Middleware{RateLimit(API_RateLimits)}}
+
+	// API_Hello shows documents of users/accounts : the demo routes have no action, so they need the admin one
+	API_Endpoints = append(API_Endpoints, Hello.Routes(
 		API_Call_Handler_Prefix(`api/hello/`, API_Hello),
 		API_Call_Handler_Exact(`mini/hello/([^/]+)/([^/]+)/`, API_Hello),
 		API_Call_Handler_Prefix(`mini/hello/(.*)`, API_Hello),
-		API_Call_Handler_Exact(`mini/ls/([^/]+)/`, API_List_Collections),                              // GET : mini/ls/<db>
-		API_Call_Handler_Exact(`mini/ls/([^/]+)/([^/]+)/`, API_List_Documents),                        // GET : mini/ls/<db>/<collection>
-		API_Call_Handler_Exact(`mini/set/([^/]+)/([^/]+)/([^/]+)/`, API_Set_Document),                 // POST : mini/set/<db>/<collection>/<dockey>
-		API_Call_Handler_Exact(`mini/set/([^/]+)/([^/]+)/([^/]+)/([^/]+)/([^/]+)/`, API_Set_Document), // GET : mini/set/<db</<collection>/<dockey>/<key>/<value>
+	)...)
+
+	API_Endpoints = append(API_Endpoints, Auth.Routes(
+		API_POST(`register/`, API_Auth_Register).Describe(RouteDoc{Summary: "Create a user account", Body: credentials{}, Response: User{}, Status: http.StatusCreated}),
+		API_POST(`login/`, API_Auth_Login).Describe(RouteDoc{Summary: "Sign in, get an access and a refresh token", Body: credentials{}, Response: AuthTokens{}}),
+		API_POST(`refresh/`, API_Auth_Refresh).Describe(RouteDoc{Summary: "Exchange a refresh token for new tokens", Body: refreshRequest{}, Response: AuthTokens{}}),
+		API_POST(`logout/`, API_Auth_Logout).Describe(RouteDoc{Summary: "Revoke the access token (and refresh token), or end the session", Auth: true}),
+		API_POST(`password/`, API_Auth_Password).Describe(RouteDoc{Summary: "Change the password. Other sessions and tokens stop working", Body: passwordChange{}, Auth: true}),
+		API_GET(`me/`, API_Auth_Me).Describe(RouteDoc{Summary: "Signed in user", Response: User{}, Auth: true}),
+		API_GET(`session/`, API_Auth_Session).Describe(RouteDoc{Summary: "Signed in user and CSRF token of the session", Response: sessionInfo{}}),
+		API_POST(`session/`, API_Auth_Session_Login).Describe(RouteDoc{Summary: "Sign in with a session cookie", Body: credentials{}, Response: sessionInfo{}}),
+		API_DELETE(`session/`, API_Auth_Session_Logout).Describe(RouteDoc{Summary: "Sign out of the session"}),
+	)...)
+
+	API_Endpoints = append(API_Endpoints, Docs.Routes(
+		API_GET(`openapi.json/`, API_OpenAPI).Describe(RouteDoc{Summary: "This OpenAPI document", Tags: []string{"docs"}, Response: map[string]interface{}{}}),
+		API_GET(`docs/`, API_Docs).Describe(RouteDoc{Summary: "API explorer (HTML)", Tags: []string{"docs"}}),
+	)...)
+
+	dbParams := []ParamDoc{
+		{Name: "db", In: "path", Description: "Database name"},
+		{Name: "collection", In: "path", Description: "Collection name"},
+		{Name: "dockey", In: "path", Description: "Document key (_id)"},
+		{Name: "path", In: "path", Description: "Dotted field path, like address.city"},
+	}
+	listParams := append(append([]ParamDoc{}, dbParams...),
+		ParamDoc{Name: "limit", In: "query", Type: "integer", Description: "Page size, in _id order. The next page is in the Link header"},
+		ParamDoc{Name: "after", In: "query", Description: "Next token of the previous page"},
+		ParamDoc{Name: "envelope", In: "query", Type: "boolean", Description: "Answer a ListEnvelope with count, filter, paging and timing"},
+		ParamDoc{Name: "total", In: "query", Type: "boolean", Description: "Count all matching documents in the envelope"},
+	)
+	anyBody := []string{"application/json", "application/extjson", "application/x-www-form-urlencoded", "application/octet-stream"}
+
+	API_Endpoints = append(API_Endpoints, Mini.Routes(
+		API_GET(`ls/{db}/`, API_List_Collections).As(ActionList).Describe(RouteDoc{Summary: "Collections of a database", Params: dbParams, Response: []string{}}),
+		API_GET(`ls/{db}/{collection}/`, API_List_Documents).As(ActionList).Describe(RouteDoc{Summary: "Documents of a collection",
+			Description: "Other query parameters filter the documents : key==value, key=-value (not equal), key=exist, key=not-exist, key=not. " +
+				"With ?envelope the response is a ListEnvelope. NDJSON responses are streamed",
+			Params: listParams, Response: []moncore.GenericDBDocument{}}),
+		API_GET(`get/{db}/{collection}/{dockey}/`, API_Get_Document).As(ActionGet).Describe(RouteDoc{Summary: "Read a document", Params: dbParams, Response: moncore.GenericDBDocument{}}),
+		API_GET(`watch/{db}/{collection}/`, API_Watch_Collection).As(ActionList).Describe(RouteDoc{Summary: "Stream change events of a collection",
+			Description: "Server-sent events with Accept: text/event-stream, NDJSON of WatchEvent otherwise. " +
+				"Query parameters filter the documents like in mini/ls. Only writes made through this server are seen",
+			Params:   append(append([]ParamDoc{}, dbParams...), ParamDoc{Name: "events", In: "query", Description: "Event types to keep, like insert,delete"}),
+			Response: WatchEvent{}}),
+		API_Route([]string{"POST", "PUT"}, `set/{db}/{collection}/{dockey}/`, API_Set_Document).As(ActionSet).Describe(RouteDoc{Summary: "Create or replace a document",
+			Description: "The body is decoded according to Content-Type. Other media types are stored as a blob",
+			Params:      dbParams, Body: map[string]interface{}{}, BodyTypes: anyBody, Response: moncore.WriteOperationResponse{}}),
+		API_GET(`set/{db}/{collection}/{dockey}/`, API_Touch_Document).As(ActionSet).Describe(RouteDoc{Summary: "Set a document to its creation time", Description: "Needs an API key or access token : session cookies only authorize reads with GET", Params: dbParams, Response: moncore.WriteOperationResponse{}}),
+		API_GET(`set/{db}/{collection}/{dockey}/{key}/{value}/`, API_Set_Document_Value).As(ActionSet).Describe(RouteDoc{Summary: "Set a document to {key: value}", Description: "Needs an API key or access token : session cookies only authorize reads with GET", Params: dbParams, Response: moncore.WriteOperationResponse{}}),
+		API_Route([]string{"DELETE", "POST"}, `del/{db}/{collection}/{dockey}/`, API_Delete_Document).As(ActionDelete).Describe(RouteDoc{Summary: "Delete a document", Params: dbParams, Response: moncore.WriteOperationResponse{}}),
+		API_GET(`{db}/{collection}/{dockey}/field/{path}/`, API_Get_Field).As(ActionGet).Describe(RouteDoc{Summary: "Read a field", Params: dbParams, Response: new(interface{})}),
+		API_PUT(`{db}/{collection}/{dockey}/field/{path}/`, API_Set_Field).As(ActionSet).Describe(RouteDoc{Summary: "Set a field", Params: dbParams, Body: new(interface{}), BodyTypes: anyBody, Response: moncore.WriteOperationResponse{}}),
+		API_DELETE(`{db}/{collection}/{dockey}/field/{path}/`, API_Unset_Field).As(ActionSet).Describe(RouteDoc{Summary: "Remove a field", Params: dbParams, Response: moncore.WriteOperationResponse{}}),
+	)...)
+
+	exportParams := append(append([]ParamDoc{}, dbParams...),
+		ParamDoc{Name: "format", In: "query", Description: "ndjson (default), json, csv or bson"},
+		ParamDoc{Name: "fields", In: "query", Description: "CSV columns like _id,name,email=contact.email,age:int. Default is _id and the top-level fields of the first document, other fields are left out"},
+		ParamDoc{Name: "limit", In: "query", Type: "integer", Description: "Largest number of documents"},
 	)
+	importParams := append(append([]ParamDoc{}, dbParams...),
+		ParamDoc{Name: "input", In: "query", Description: "ndjson, json, csv or bson. Defaults to the Content-Type of the body, then ndjson"},
+		ParamDoc{Name: "mode", In: "query", Description: "upsert (merge fields, default), replace or insert (skip existing documents)"},
+		ParamDoc{Name: "batch", In: "query", Type: "integer", Description: "Documents per bulk write"},
+		ParamDoc{Name: "fields", In: "query", Description: "CSV columns like _id,name,email=contact.email,age:int"},
+		ParamDoc{Name: "keep_going", In: "query", Type: "boolean", Description: "Go on after rejected documents"},
+	)
+
+	API_Endpoints = append(API_Endpoints, Transfer.Routes(
+		API_GET(`export/{db}/{collection}/`, API_Export_Collection).As(ActionList).Describe(RouteDoc{Summary: "Download the documents of a collection",
+			Description: "Documents are {_id, Doc} in relaxed Extended JSON, or BSON. Other query parameters filter the documents like in mini/ls",
+			Params:      exportParams}),
+		API_Route([]string{"POST", "PUT"}, `import/{db}/{collection}/`, API_Import_Collection).As(ActionSet).Describe(RouteDoc{Summary: "Upload documents into a collection",
+			Description: "Documents are written in bulk. Rejected ones are counted and listed in the result",
+			Params:      importParams, Body: []moncore.GenericDBDocument{}, BodyTypes: []string{"application/x-ndjson", "application/json", "text/csv", "application/bson"}, Response: moncore.ImportResult{}}),
+	)...)
+
+	API_Endpoints = append(API_Endpoints, Admin.Routes(
+		API_GET(`webhooks/`, API_List_Webhooks).As(ActionAdmin).Describe(RouteDoc{Summary: "Webhooks", Tags: []string{"admin"}, Response: []moncore.Webhook{}}),
+		API_POST(`webhooks/`, API_Create_Webhook).As(ActionAdmin).Describe(RouteDoc{Summary: "Register a webhook. The secret is only returned once", Tags: []string{"admin"}, Body: moncore.Webhook{}, Response: moncore.Webhook{}, Status: http.StatusCreated}),
+		API_GET(`webhooks/deadletters/`, API_Webhook_DeadLetters).As(ActionAdmin).Describe(RouteDoc{Summary: "Latest failed deliveries", Tags: []string{"admin"}, Response: []moncore.WebhookDeadLetter{}}),
+		API_GET(`webhooks/{id}/`, API_Get_Webhook).As(ActionAdmin).Describe(RouteDoc{Summary: "A webhook", Tags: []string{"admin"}, Response: moncore.Webhook{}}),
+		API_DELETE(`webhooks/{id}/`, API_Delete_Webhook).As(ActionAdmin).Describe(RouteDoc{Summary: "Remove a webhook", Tags: []string{"admin"}}),
+		API_GET(`keys/`, API_List_Keys).As(ActionAdmin).Describe(RouteDoc{Summary: "API keys", Tags: []string{"admin"}, Response: []APIKey{}}),
+		API_POST(`keys/`, API_Create_Key).As(ActionAdmin).Describe(RouteDoc{Summary: "Create an API key. The token is only returned once", Tags: []string{"admin"}, Body: apiKeyRequest{}, Response: APIKeyToken{}, Status: http.StatusCreated}),
+		API_GET(`keys/{id}/`, API_Get_Key).As(ActionAdmin).Describe(RouteDoc{Summary: "An API key", Tags: []string{"admin"}, Response: APIKey{}}),
+		API_POST(`keys/{id}/rotate/`, API_Rotate_Key).As(ActionAdmin).Describe(RouteDoc{Summary: "Replace the secret of an API key. The token is only returned once", Tags: []string{"admin"}, Response: APIKeyToken{}}),
+		API_DELETE(`keys/{id}/`, API_Revoke_Key).As(ActionAdmin).Describe(RouteDoc{Summary: "Revoke an API key", Tags: []string{"admin"}}),
+		API_GET(`roles/`, API_List_Roles).As(ActionAdmin).Describe(RouteDoc{Summary: "Roles, built-in ones included", Tags: []string{"admin"}, Response: []Role{}}),
+		API_POST(`roles/`, API_Save_Role).As(ActionAdmin).Describe(RouteDoc{Summary: "Create or replace a role", Tags: []string{"admin"}, Body: Role{}, Response: Role{}}),
+		API_GET(`roles/{name}/`, API_Get_Role).As(ActionAdmin).Describe(RouteDoc{Summary: "A role", Tags: []string{"admin"}, Response: Role{}}),
+		API_DELETE(`roles/{name}/`, API_Delete_Role).As(ActionAdmin).Describe(RouteDoc{Summary: "Delete a role", Tags: []string{"admin"}}),
+		API_PUT(`users/{username}/roles/`, API_Set_User_Roles).As(ActionAdmin).Describe(RouteDoc{Summary: "Set the roles of a user", Tags: []string{"admin"}, Body: []string{}, Response: User{}}),
+	)...)
 }
@@ -0,0 +1,76 @@
+package endpoints
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Claims of access tokens
+type JWTClaims struct {
+	Issuer   string `json:"iss"`
+	Subject  string `json:"sub"` // Username
+	ID       string `json:"jti"`
+	IssuedAt int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+	Version  int64  `json:"ver"` // TokenVersion of the user
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Sign claims as a HS256 JWT
+func SignJWT(Claims *JWTClaims, Secret []byte) (string, error) {
+	payload, err := json.Marshal(Claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + jwtSignature(unsigned, Secret), nil
+}
+
+// Verify a HS256 JWT signature and expiry, and decode its claims
+func VerifyJWT(Token string, Secret []byte) (*JWTClaims, error) {
+	parts := strings.Split(Token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+	}{}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(hb, &header) != nil || header.Alg != "HS256" {
+		return nil, errors.New("unsupported token header")
+	}
+
+	if !hmac.Equal([]byte(parts[2]), []byte(jwtSignature(parts[0]+"."+parts[1], Secret))) {
+		return nil, errors.New("bad token signature")
+	}
+
+	pb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed token payload")
+	}
+
+	claims := JWTClaims{}
+	if err := json.Unmarshal(pb, &claims); err != nil {
+		return nil, errors.New("malformed token payload")
+	}
+
+	if time.Now().Unix() >= claims.Expires {
+		return nil, errors.New("token expired")
+	}
+
+	return &claims, nil
+}
+
+func jwtSignature(Unsigned string, Secret []byte) string {
+	mac := hmac.New(sha256.New, Secret)
+	mac.Write([]byte(Unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,65 @@
+package endpoints
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJWTRoundTrip(t *testing.T) {
+	secret := []byte("test secret")
+	now := time.Now().Unix()
+	claims := JWTClaims{Issuer: "mongomini", Subject: "alice", ID: "t1", IssuedAt: now, Expires: now + 60, Version: 3}
+
+	token, err := SignJWT(&claims, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := VerifyJWT(token, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != claims {
+		t.Fatalf("VerifyJWT() = %+v, want %+v", *got, claims)
+	}
+}
+
+func TestVerifyJWTRejects(t *testing.T) {
+	secret := []byte("test secret")
+	now := time.Now().Unix()
+
+	valid, _ := SignJWT(&JWTClaims{Subject: "alice", Expires: now + 60}, secret)
+	expired, _ := SignJWT(&JWTClaims{Subject: "alice", Expires: now - 1}, secret)
+	parts := strings.Split(valid, ".")
+
+	// Tokens with another header, signed with the same key
+	withHeader := func(Header string) string {
+		unsigned := base64.RawURLEncoding.EncodeToString([]byte(Header)) + "." + parts[1]
+		return unsigned + "." + jwtSignature(unsigned, secret)
+	}
+	admin := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","exp":9999999999}`))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"alg none", base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "."},
+		{"alg none signed", withHeader(`{"alg":"none","typ":"JWT"}`)},
+		{"other alg", withHeader(`{"alg":"HS512","typ":"JWT"}`)},
+		{"expired", expired},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2]))},
+		{"tampered payload", parts[0] + "." + admin + "." + parts[2]},
+		{"missing signature", parts[0] + "." + parts[1]},
+		{"garbage", "not.a.token"},
+	}
+	for _, tt := range tests {
+		if _, err := VerifyJWT(tt.token, secret); err == nil {
+			t.Errorf("%s : accepted", tt.name)
+		}
+	}
+
+	if _, err := VerifyJWT(valid, []byte("other secret")); err == nil {
+		t.Error("token accepted with another secret")
+	}
+}
@@ -0,0 +1,368 @@
+package endpoints
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// API call handler
+type Handler func(*APICall)
+
+// Middleware wraps a handler. It can act before and after calling next, or not call it at all.
+type Middleware func(next Handler) Handler
+
+// Global middlewares, applied to every request (even unmatched ones). First one is the outermost.
+var API_Middlewares []Middleware = []Middleware{}
+
+// Wrap handler with middlewares. First one is the outermost
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Records the status and size of the response
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (W *responseWriter) WriteHeader(status int) {
+	if W.status != 0 {
+		return
+	}
+	W.status = status
+	W.ResponseWriter.WriteHeader(status)
+}
+
+func (W *responseWriter) Write(b []byte) (int, error) {
+	if W.status == 0 {
+		W.status = http.StatusOK
+	}
+	n, err := W.ResponseWriter.Write(b)
+	W.bytes += int64(n)
+	return n, err
+}
+
+func (W *responseWriter) Flush() {
+	if f, ok := W.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (W *responseWriter) Unwrap() http.ResponseWriter {
+	return W.ResponseWriter
+}
+
+// HTTP status sent to the client. 0 if nothing was sent yet
+func (c *APICall) StatusCode() int {
+	if c.recorder == nil {
+		return 0
+	}
+	return c.recorder.status
+}
+
+// Whether the status line and headers were already sent to the client
+func (c *APICall) HeadersSent() bool {
+	return c.StatusCode() != 0
+}
+
+// Add a request header to the Vary response header, keeping the ones other middlewares added
+func (c *APICall) AddVary(Header string) {
+	H := (*c.HTTPWriter).Header()
+	for _, line := range H.Values("Vary") {
+		for _, v := range strings.Split(line, ",") {
+			if v = strings.TrimSpace(v); v == "*" || strings.EqualFold(v, Header) {
+				return
+			}
+		}
+	}
+	H.Add("Vary", Header)
+}
+
+// Number of body bytes sent to the client (before compression by the Compress middleware)
+func (c *APICall) BytesWritten() int64 {
+	if c.recorder == nil {
+		return 0
+	}
+	return c.recorder.bytes
+}
+
+// Recover from panics in handlers, log the stack and answer 500 with a JSON error if nothing was sent yet
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(C *APICall) {
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+
+				Print("panic serving " + C.Method() + " " + C.Path + " : " + fmt.Sprint(rec) + "\n" + string(debug.Stack()))
+
+				C.WriteAPIError(NewAPIError(CodeInternal, "internal server error"))
+			}()
+
+			next(C)
+		}
+	}
+}
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Assign a request ID (from the X-Request-ID header if it's sane, otherwise a random one)
+// and send it back in the X-Request-ID header
+func RequestID() Middleware {
+	return func(next Handler) Handler {
+		return func(C *APICall) {
+			id := C.GetHeader("X-Request-ID")
+			if !validRequestID.MatchString(id) {
+				id = randomToken(12)
+			}
+
+			C.RequestID = id
+			C.SetHeader("X-Request-ID", id)
+
+			next(C)
+		}
+	}
+}
+
+// Random hex string of n bytes
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Log one line per request with status, size and latency
+func AccessLog() Middleware {
+	return func(next Handler) Handler {
+		return func(C *APICall) {
+			start := time.Now()
+
+			next(C)
+
+			status := C.StatusCode()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			Print(C.Method() + " /" + C.Path + " " + strconv.Itoa(status) + " " +
+				strconv.FormatInt(C.BytesWritten(), 10) + "B " + time.Since(start).String() +
+				" " + C.RequestID)
+		}
+	}
+}
+
+// Compress responses with gzip or deflate when the client accepts it
+func Compress() Middleware {
+	return func(next Handler) Handler {
+		return func(C *APICall) {
+			encoding := ""
+			for _, part := range strings.Split(C.GetHeader("Accept-Encoding"), ",") {
+				e := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+				if e == "gzip" || e == "deflate" {
+					encoding = e
+					break
+				}
+			}
+
+			if encoding == "" || C.Method() == http.MethodHead {
+				next(C)
+				return
+			}
+
+			C.AddVary("Accept-Encoding")
+
+			// Compress below the status recorder, so it still sees what the handler writes
+			if C.recorder != nil {
+				under := C.recorder.ResponseWriter
+				cw := &compressWriter{ResponseWriter: under, encoding: encoding}
+				C.recorder.ResponseWriter = cw
+				defer func() {
+					cw.Close()
+					C.recorder.ResponseWriter = under // outer middlewares (like Recover) write uncompressed
+				}()
+			} else {
+				cw := &compressWriter{ResponseWriter: *C.HTTPWriter, encoding: encoding}
+				var writer http.ResponseWriter = cw
+				C.HTTPWriter = &writer
+				defer cw.Close()
+			}
+
+			next(C)
+		}
+	}
+}
+
+// Compresses the body lazily, so empty responses (204, 304, ...) stay empty
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	cw       io.WriteCloser
+	flusher  interface{ Flush() error }
+}
+
+func (W *compressWriter) start() {
+	if W.cw != nil {
+		return
+	}
+	W.Header().Del("Content-Length")
+	W.Header().Set("Content-Encoding", W.encoding)
+
+	if W.encoding == "gzip" {
+		gz := gzip.NewWriter(W.ResponseWriter)
+		W.cw, W.flusher = gz, gz
+	} else {
+		fl, _ := flate.NewWriter(W.ResponseWriter, flate.DefaultCompression)
+		W.cw, W.flusher = fl, fl
+	}
+}
+
+func (W *compressWriter) WriteHeader(status int) {
+	if status != http.StatusNoContent && status != http.StatusNotModified && W.Header().Get("Content-Encoding") == "" {
+		W.start()
+	}
+	W.ResponseWriter.WriteHeader(status)
+}
+
+func (W *compressWriter) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if W.cw == nil {
+		if W.Header().Get("Content-Encoding") != "" {
+			return W.ResponseWriter.Write(b) // Already encoded by the handler
+		}
+		if W.Header().Get("Content-Type") == "" {
+			W.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		W.start()
+	}
+	return W.cw.Write(b)
+}
+
+func (W *compressWriter) Flush() {
+	if W.flusher != nil {
+		W.flusher.Flush()
+	}
+	if f, ok := W.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (W *compressWriter) Close() {
+	if W.cw != nil {
+		PrintError(W.cw.Close())
+	}
+}
+
+func (W *compressWriter) Unwrap() http.ResponseWriter {
+	return W.ResponseWriter
+}
+
+func (W *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := W.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijacking not supported")
+}
+
+// Limit request bodies to MaxBytes. Larger bodies are answered with 413 Request Entity Too Large
+func BodyLimit(MaxBytes int64) Middleware {
+	return func(next Handler) Handler {
+		return func(C *APICall) {
+			if C.HTTPRequest.ContentLength > MaxBytes {
+				C.Fail(NewAPIError(CodePayloadTooLarge, "request body is larger than "+strconv.FormatInt(MaxBytes, 10)+" bytes"))
+				return
+			}
+
+			C.HTTPRequest.Body = http.MaxBytesReader(*C.HTTPWriter, C.HTTPRequest.Body, MaxBytes)
+			next(C)
+		}
+	}
+}
+
+// CORS settings
+type CORSOptions struct {
+	AllowedOrigins   []string // Origins like "https://app.example.com". "*" allows any origin
+	AllowedMethods   []string // Defaults to GET, HEAD, POST, PUT, DELETE
+	AllowedHeaders   []string // Defaults to the headers requested by the preflight
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration // How long browsers may cache preflight results
+}
+
+// Answer CORS preflight requests and add CORS headers for allowed origins
+func CORS(Options CORSOptions) Middleware {
+	if len(Options.AllowedMethods) == 0 {
+		Options.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete}
+	}
+
+	return func(next Handler) Handler {
+		return func(C *APICall) {
+			origin := C.GetHeader("Origin")
+			if origin == "" {
+				next(C)
+				return
+			}
+
+			C.AddVary("Origin")
+			if !contains(Options.AllowedOrigins, origin) && !contains(Options.AllowedOrigins, "*") {
+				next(C)
+				return
+			}
+
+			H := (*C.HTTPWriter).Header()
+			if contains(Options.AllowedOrigins, "*") && !Options.AllowCredentials {
+				H.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				H.Set("Access-Control-Allow-Origin", origin)
+			}
+			if Options.AllowCredentials {
+				H.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if len(Options.ExposedHeaders) != 0 {
+				H.Set("Access-Control-Expose-Headers", strings.Join(Options.ExposedHeaders, ", "))
+			}
+
+			// Preflight
+			if C.Method() == http.MethodOptions && C.GetHeader("Access-Control-Request-Method") != "" {
+				H.Set("Access-Control-Allow-Methods", strings.Join(Options.AllowedMethods, ", "))
+				if len(Options.AllowedHeaders) != 0 {
+					H.Set("Access-Control-Allow-Headers", strings.Join(Options.AllowedHeaders, ", "))
+				} else if req := C.GetHeader("Access-Control-Request-Headers"); req != "" {
+					H.Set("Access-Control-Allow-Headers", req)
+				}
+				if Options.MaxAge > 0 {
+					H.Set("Access-Control-Max-Age", strconv.Itoa(int(Options.MaxAge.Seconds())))
+				}
+				C.WriteStatus(http.StatusNoContent)
+				return
+			}
+
+			next(C)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package endpoints
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewaresKeepVary(t *testing.T) {
+	saved := API_Middlewares
+	t.Cleanup(func() { API_Middlewares = saved })
+	API_Middlewares = []Middleware{CORS(CORSOptions{AllowedOrigins: []string{"https://app.example.com"}}), Compress()}
+
+	withRoutes(t, []API_Call_Handler{
+		API_GET(`things/`, func(C *APICall) {
+			C.AddVary("Origin") // already there
+			C.Write([]byte("ok"))
+		}),
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/things", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	ServeRequest(w, r)
+
+	got := w.Header().Values("Vary")
+	if len(got) != 2 || got[0] != "Origin" || got[1] != "Accept-Encoding" {
+		t.Fatalf("Vary = %q, want [Origin Accept-Encoding]", got)
+	}
+}
@@ -0,0 +1,364 @@
+package endpoints
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Title and version of the generated OpenAPI document
+var (
+	OpenAPI_Title   string = "mongomini"
+	OpenAPI_Version string = "1.0.0"
+)
+
+// Documentation of a route, used to generate the OpenAPI document. See API_Call_Handler.Describe()
+type RouteDoc struct {
+	Summary     string
+	Description string
+	Tags        []string
+
+	// Query and header parameters. Path parameters come from the pattern; list them here (In "path") to describe them
+	Params []ParamDoc
+
+	Body      interface{} // Value of the type of the request body, like Role{}. nil if the route takes no body
+	BodyTypes []string    // Media types of the body. Defaults to application/json
+
+	Response interface{} // Value of the type of the response. nil if the route answers without a body
+	Status   int         // Success status. Defaults to 200, or 204 without Response
+
+	// Needs an API key, access token or session. Routes with an Action always do (when Require_Auth is on)
+	Auth bool
+}
+
+// A parameter of a route
+type ParamDoc struct {
+	Name        string
+	In          string // "query", "path" or "header"
+	Description string
+	Type        string // JSON schema type : "string", "integer", "boolean". Defaults to "string"
+	Required    bool
+}
+
+// Set the documentation of the route
+func (H API_Call_Handler) Describe(D RouteDoc) API_Call_Handler {
+	H.Doc = &D
+	return H
+}
+
+// OpenAPI 3 document of the routes of API_Endpoints created by API_Route (regex routes are left out)
+func OpenAPI() map[string]interface{} {
+	B := &schemaBuilder{defs: map[string]interface{}{}}
+	B.schema(reflect.TypeOf(APIError{}))
+
+	paths := map[string]map[string]interface{}{}
+
+	for i := range API_Endpoints {
+		H := &API_Endpoints[i]
+		if H.Pattern == "" || H.Handler == nil {
+			continue
+		}
+
+		path := "/" + H.Pattern
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+
+		for _, method := range H.Methods {
+			if method == http.MethodHead || method == http.MethodOptions {
+				continue
+			}
+			method = strings.ToLower(method)
+			if _, taken := paths[path][method]; taken {
+				continue // The first route wins, like in ServeRequest
+			}
+			paths[path][method] = B.operation(H, method)
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]interface{}{"title": OpenAPI_Title, "version": OpenAPI_Version},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": B.defs,
+			"securitySchemes": map[string]interface{}{
+				"apiKey":  map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearer":  map[string]interface{}{"type": "http", "scheme": "bearer", "description": "API key or access token"},
+				"session": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": SessionCookieName},
+			},
+		},
+	}
+}
+
+func (B *schemaBuilder) operation(H *API_Call_Handler, method string) map[string]interface{} {
+	D := H.Doc
+	if D == nil {
+		D = &RouteDoc{}
+	}
+
+	op := map[string]interface{}{
+		"operationId": method + operationName(H.Pattern),
+	}
+	if D.Summary != "" {
+		op["summary"] = D.Summary
+	}
+	if D.Description != "" {
+		op["description"] = D.Description
+	}
+	if len(D.Tags) != 0 {
+		op["tags"] = D.Tags
+	} else {
+		op["tags"] = []string{strings.SplitN(H.Pattern, "/", 2)[0]}
+	}
+
+	params := []interface{}{}
+	for _, m := range routePlaceholder.FindAllStringSubmatch(H.Pattern, -1) {
+		P := ParamDoc{Name: m[1], In: "path", Required: true}
+		for _, p := range D.Params {
+			if p.In == "path" && p.Name == m[1] {
+				P.Description, P.Type = p.Description, p.Type
+			}
+		}
+		params = append(params, paramObject(P))
+	}
+	for _, p := range D.Params {
+		if p.In != "path" {
+			params = append(params, paramObject(p))
+		}
+	}
+
+	responses := map[string]interface{}{}
+	status := D.Status
+	if D.Response != nil {
+		params = append(params, paramObject(ParamDoc{Name: "format", In: "query", Description: "Response format, instead of the Accept header : " + strings.Join(responseFormatNames(), ", ")}))
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		content := map[string]interface{}{}
+		schema := B.schema(reflect.TypeOf(D.Response))
+		for _, F := range ResponseFormats {
+			mt := strings.TrimSpace(strings.Split(F.ContentType, ";")[0])
+			if _, ok := content[mt]; ok {
+				continue
+			}
+			if mt == "application/json" || mt == "application/yaml" {
+				content[mt] = map[string]interface{}{"schema": schema}
+			} else {
+				content[mt] = map[string]interface{}{}
+			}
+		}
+		responses[strconv.Itoa(status)] = map[string]interface{}{"description": http.StatusText(status), "content": content}
+	} else {
+		if status == 0 {
+			status = http.StatusNoContent
+		}
+		responses[strconv.Itoa(status)] = map[string]interface{}{"description": http.StatusText(status)}
+	}
+
+	errorContent := map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"error": map[string]interface{}{"$ref": "#/components/schemas/APIError"}},
+	}}}
+	responses["default"] = map[string]interface{}{"description": "Error, see the error code", "content": errorContent}
+
+	if D.Auth || H.Action != "" {
+		op["security"] = []interface{}{
+			map[string]interface{}{"apiKey": []string{}},
+			map[string]interface{}{"bearer": []string{}},
+			map[string]interface{}{"session": []string{}},
+		}
+		responses["401"] = map[string]interface{}{"description": "Missing or invalid credentials", "content": errorContent}
+		responses["403"] = map[string]interface{}{"description": "Not allowed", "content": errorContent}
+	}
+
+	if D.Body != nil {
+		types := D.BodyTypes
+		if len(types) == 0 {
+			types = []string{"application/json"}
+		}
+		content := map[string]interface{}{}
+		for _, mt := range types {
+			content[mt] = map[string]interface{}{"schema": B.schema(reflect.TypeOf(D.Body))}
+		}
+		op["requestBody"] = map[string]interface{}{"required": true, "content": content}
+	}
+
+	if len(params) != 0 {
+		op["parameters"] = params
+	}
+	op["responses"] = responses
+	return op
+}
+
+func paramObject(P ParamDoc) map[string]interface{} {
+	if P.Type == "" {
+		P.Type = "string"
+	}
+	O := map[string]interface{}{"name": P.Name, "in": P.In, "schema": map[string]interface{}{"type": P.Type}}
+	if P.Description != "" {
+		O["description"] = P.Description
+	}
+	if P.Required || P.In == "path" {
+		O["required"] = true
+	}
+	return O
+}
+
+// "mini/ls/{db}/" -> "MiniLsDb"
+func operationName(Pattern string) string {
+	name := strings.Builder{}
+	for _, part := range strings.FieldsFunc(Pattern, func(r rune) bool { return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') }) {
+		name.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return name.String()
+}
+
+func responseFormatNames() []string {
+	names := []string{}
+	for _, F := range ResponseFormats {
+		names = append(names, F.Name)
+	}
+	return names
+}
+
+// JSON schemas of Go types, following encoding/json rules. Named structs go to components/schemas
+type schemaBuilder struct {
+	defs map[string]interface{}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	dateTimeType   = reflect.TypeOf(primitive.DateTime(0))
+	objectIDType   = reflect.TypeOf(primitive.ObjectID{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (B *schemaBuilder) schema(T reflect.Type) map[string]interface{} {
+	if T == nil {
+		return map[string]interface{}{}
+	}
+	for T.Kind() == reflect.Ptr {
+		T = T.Elem()
+	}
+
+	switch T {
+	case timeType, dateTimeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case objectIDType:
+		return map[string]interface{}{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch T.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if T.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": B.schema(T.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": B.schema(T.Elem())}
+	case reflect.Struct:
+		if T.Name() == "" {
+			return B.structSchema(T)
+		}
+		name := schemaName(T)
+		if _, ok := B.defs[name]; !ok {
+			B.defs[name] = map[string]interface{}{} // Placeholder for recursive types
+			B.defs[name] = B.structSchema(T)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+func (B *schemaBuilder) structSchema(T reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	required := []string{}
+	B.addFields(T, props, &required)
+
+	S := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) != 0 {
+		sort.Strings(required)
+		S["required"] = required
+	}
+	return S
+}
+
+func (B *schemaBuilder) addFields(T reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < T.NumField(); i++ {
+		f := T.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				B.addFields(ft, props, required)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		props[name] = B.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// Name of a schema : type name, generic arguments dropped
+func schemaName(T reflect.Type) string {
+	name, _, _ := strings.Cut(T.Name(), "[")
+	return name
+}
+
+// GET : mini/openapi.json. OpenAPI document of the routes
+func API_OpenAPI(C *APICall) {
+	C.Respond(OpenAPI())
+}
+
+//go:embed explorer.html
+var explorerPage string
+
+// GET : mini/docs. API explorer. The document is embedded in the page, so it works offline once saved
+func API_Docs(C *APICall) {
+	spec, err := json.Marshal(OpenAPI()) // Escapes <, > and &, so it can't close the <script> element
+	if err != nil {
+		C.Fail(err)
+		return
+	}
+
+	C.SetHeader("Content-Type", "text/html; charset=utf-8")
+	C.SetHeader("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+	C.WriteString(strings.Replace(explorerPage, "{{SPEC}}", string(spec), 1))
+}
@@ -0,0 +1,245 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// OpenAPI document as served, decoded into generic JSON values
+func openAPIDocument(t *testing.T) map[string]interface{} {
+	J, err := json.Marshal(OpenAPI())
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(J, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// Value at a path of object keys, nil if missing
+func jsonAt(v interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = o[k]
+	}
+	return v
+}
+
+// Parameters of an operation by "<in>:<name>"
+func operationParams(op interface{}) map[string]map[string]interface{} {
+	out := map[string]map[string]interface{}{}
+	list, _ := jsonAt(op, "parameters").([]interface{})
+	for _, p := range list {
+		P := p.(map[string]interface{})
+		out[P["in"].(string)+":"+P["name"].(string)] = P
+	}
+	return out
+}
+
+// Every "$ref" of the document
+func schemaRefs(v interface{}, refs map[string]bool) {
+	switch o := v.(type) {
+	case map[string]interface{}:
+		for k, e := range o {
+			if ref, ok := e.(string); ok && k == "$ref" {
+				refs[ref] = true
+			}
+			schemaRefs(e, refs)
+		}
+	case []interface{}:
+		for _, e := range o {
+			schemaRefs(e, refs)
+		}
+	}
+}
+
+// Every route of the server is documented, with its path parameters, and every schema reference resolves
+func TestOpenAPIRoutes(t *testing.T) {
+	withRoutes(t, nil)
+	_InitEndpoints()
+	doc := openAPIDocument(t)
+
+	if doc["openapi"] != "3.0.3" || jsonAt(doc, "info", "title") != OpenAPI_Title {
+		t.Fatalf("header %v %v", doc["openapi"], doc["info"])
+	}
+
+	paths := doc["paths"].(map[string]interface{})
+	if len(paths) < 20 {
+		t.Fatalf("%d paths", len(paths))
+	}
+	for _, H := range API_Endpoints {
+		if H.Pattern == "" {
+			continue
+		}
+		for _, method := range H.Methods {
+			if method == http.MethodHead || method == http.MethodOptions {
+				continue
+			}
+			op := jsonAt(paths, "/"+H.Pattern, strings.ToLower(method))
+			if op == nil {
+				t.Errorf("%s /%s missing", method, H.Pattern)
+				continue
+			}
+
+			params := operationParams(op)
+			for _, m := range routePlaceholder.FindAllStringSubmatch(H.Pattern, -1) {
+				if P := params["path:"+m[1]]; P == nil || P["required"] != true {
+					t.Errorf("%s /%s : path parameter %s = %v", method, H.Pattern, m[1], P)
+				}
+			}
+			if H.Doc != nil && H.Doc.Summary != "" && jsonAt(op, "summary") != H.Doc.Summary {
+				t.Errorf("%s /%s : summary %v", method, H.Pattern, jsonAt(op, "summary"))
+			}
+			if jsonAt(op, "responses", "default", "content", "application/json", "schema", "properties", "error", "$ref") != "#/components/schemas/APIError" {
+				t.Errorf("%s /%s : no error envelope", method, H.Pattern)
+			}
+		}
+	}
+
+	for path := range paths {
+		if strings.ContainsAny(path, "()[]*") {
+			t.Errorf("regex route documented : %s", path)
+		}
+	}
+
+	schemas := jsonAt(doc, "components", "schemas").(map[string]interface{})
+	refs := map[string]bool{}
+	schemaRefs(doc, refs)
+	for ref := range refs {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		if _, ok := schemas[name]; !ok || name == ref {
+			t.Errorf("unresolved %s", ref)
+		}
+	}
+}
+
+func TestOpenAPIErrorEnvelope(t *testing.T) {
+	withRoutes(t, nil)
+	doc := openAPIDocument(t)
+
+	E := jsonAt(doc, "components", "schemas", "APIError")
+	for _, field := range []string{"code", "message", "request_id", "details"} {
+		if jsonAt(E, "properties", field) == nil {
+			t.Errorf("APIError.%s missing", field)
+		}
+	}
+	if jsonAt(E, "properties", "Status") != nil || jsonAt(E, "properties", "status") != nil {
+		t.Error("APIError.Status is not sent")
+	}
+	if got := jsonAt(E, "required"); !reflect.DeepEqual(got, []interface{}{"code", "message"}) {
+		t.Errorf("APIError required %v", got)
+	}
+}
+
+type openAPIThing struct {
+	Name    string            `json:"name"`
+	Count   int               `json:"count,omitempty"`
+	Secret  string            `json:"-"`
+	Created time.Time         `json:"created"`
+	Tags    []string          `json:"tags"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Parent  *openAPIThing     `json:"parent"`
+}
+
+func TestOpenAPIDescribe(t *testing.T) {
+	withRoutes(t, []API_Call_Handler{
+		API_GET(`things/{db}/{id}/`, noopHandler).As(ActionGet).Describe(RouteDoc{
+			Summary: "A thing", Description: "Longer text", Tags: []string{"stuff"},
+			Params: []ParamDoc{
+				{Name: "id", In: "path", Description: "Thing ID", Type: "integer"},
+				{Name: "verbose", In: "query", Type: "boolean", Description: "More fields"},
+				{Name: "X-Trace", In: "header", Required: true},
+			},
+			Response: openAPIThing{},
+		}),
+		API_POST(`things/`, noopHandler).Describe(RouteDoc{Summary: "Create a thing", Body: openAPIThing{}, BodyTypes: []string{"application/json", "text/csv"}, Response: openAPIThing{}, Status: http.StatusCreated}),
+		API_POST(`things/`, noopHandler).Describe(RouteDoc{Summary: "Shadowed"}),
+		API_DELETE(`things/{id}/`, noopHandler),
+		API_Call_Handler_Prefix(`legacy/(.*)`, noopHandler),
+	})
+	doc := openAPIDocument(t)
+
+	get := jsonAt(doc, "paths", "/things/{db}/{id}/", "get")
+	if get == nil {
+		t.Fatalf("paths %v", jsonAt(doc, "paths"))
+	}
+	if jsonAt(get, "summary") != "A thing" || jsonAt(get, "description") != "Longer text" || jsonAt(get, "operationId") != "getThingsDbId" {
+		t.Errorf("get : %v", get)
+	}
+	if tags := jsonAt(get, "tags"); !reflect.DeepEqual(tags, []interface{}{"stuff"}) {
+		t.Errorf("get tags %v", tags)
+	}
+
+	params := operationParams(get)
+	if P := params["path:db"]; P == nil || P["required"] != true || jsonAt(P, "schema", "type") != "string" {
+		t.Errorf("db parameter %v", P)
+	}
+	if P := params["path:id"]; P == nil || P["description"] != "Thing ID" || jsonAt(P, "schema", "type") != "integer" {
+		t.Errorf("id parameter %v", P)
+	}
+	if P := params["query:verbose"]; P == nil || P["required"] != nil || jsonAt(P, "schema", "type") != "boolean" {
+		t.Errorf("verbose parameter %v", P)
+	}
+	if P := params["header:X-Trace"]; P == nil || P["required"] != true {
+		t.Errorf("X-Trace parameter %v", P)
+	}
+	if params["query:format"] == nil {
+		t.Error("format parameter missing on a route with a response")
+	}
+	if len(params) != 5 {
+		t.Errorf("%d parameters : %v", len(params), params)
+	}
+
+	// Routes with an action need credentials
+	if jsonAt(get, "security") == nil || jsonAt(get, "responses", "401") == nil || jsonAt(get, "responses", "403") == nil {
+		t.Errorf("get : no security %v", get)
+	}
+	if ref := jsonAt(get, "responses", "200", "content", "application/json", "schema", "$ref"); ref != "#/components/schemas/openAPIThing" {
+		t.Errorf("get response %v", ref)
+	}
+
+	post := jsonAt(doc, "paths", "/things/", "post")
+	if jsonAt(post, "summary") != "Create a thing" {
+		t.Errorf("first route doesn't win : %v", jsonAt(post, "summary"))
+	}
+	if jsonAt(post, "responses", "201") == nil || jsonAt(post, "responses", "200") != nil {
+		t.Errorf("post responses %v", jsonAt(post, "responses"))
+	}
+	if jsonAt(post, "requestBody", "content", "text/csv", "schema", "$ref") != "#/components/schemas/openAPIThing" || jsonAt(post, "requestBody", "required") != true {
+		t.Errorf("post body %v", jsonAt(post, "requestBody"))
+	}
+	if jsonAt(post, "security") != nil {
+		t.Error("route without action needs credentials")
+	}
+
+	del := jsonAt(doc, "paths", "/things/{id}/", "delete")
+	if jsonAt(del, "responses", "204") == nil || jsonAt(del, "requestBody") != nil {
+		t.Errorf("delete %v", del)
+	}
+	if tags := jsonAt(del, "tags"); !reflect.DeepEqual(tags, []interface{}{"things"}) {
+		t.Errorf("default tags %v", tags)
+	}
+	if jsonAt(doc, "paths", "/legacy/(.*)") != nil || len(jsonAt(doc, "paths").(map[string]interface{})) != 3 {
+		t.Errorf("paths %v", jsonAt(doc, "paths"))
+	}
+
+	thing := jsonAt(doc, "components", "schemas", "openAPIThing")
+	if got := jsonAt(thing, "required"); !reflect.DeepEqual(got, []interface{}{"created", "name", "tags"}) {
+		t.Errorf("required %v", got)
+	}
+	if jsonAt(thing, "properties", "Secret") != nil || jsonAt(thing, "properties", "parent", "$ref") != "#/components/schemas/openAPIThing" {
+		t.Errorf("properties %v", jsonAt(thing, "properties"))
+	}
+	if jsonAt(thing, "properties", "created", "format") != "date-time" || jsonAt(thing, "properties", "labels", "additionalProperties", "type") != "string" {
+		t.Errorf("properties %v", jsonAt(thing, "properties"))
+	}
+}
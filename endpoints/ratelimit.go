@@ -0,0 +1,346 @@
+package endpoints
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mongomini/agra/moncore"
+)
+
+var RateLimitsCollectionName string = "ratelimits"
+
+// Token cost of a request by route action. Routes can override it with API_Call_Handler.WithCost()
+var RouteCosts = map[string]float64{
+	ActionList:      5,
+	ActionAggregate: 10,
+	ActionGet:       1,
+	ActionSet:       2,
+	ActionDelete:    2,
+	ActionAdmin:     1,
+}
+
+// Where buckets and quota counters are kept
+type RateLimitStore interface {
+	// Take N tokens from the bucket of key. See moncore.Collection.TakeTokens
+	TakeTokens(Key string, N float64, Capacity float64, Rate float64) (Remaining float64, OK bool, err error)
+
+	// Add N to the counter of key, created with an expiry time. Returns the new value
+	Increment(Key string, N int64, Expires time.Time) (int64, error)
+}
+
+// Rate limit and quota settings
+type RateLimitOptions struct {
+	Burst float64 // Bucket capacity, in tokens
+	Rate  float64 // Tokens added per second
+
+	// Daily quotas per caller (UTC days). 0 means unlimited
+	DailyReads  int64 // Requests of list, get and aggregate routes
+	DailyWrites int64 // Requests of set and delete routes
+	DailyBytes  int64 // Response bytes
+
+	Store RateLimitStore
+
+	// Identifies the caller. Defaults to RateLimitKey
+	Key func(*APICall) string
+
+	// Tokens taken by a request. Defaults to the cost of the route, see RouteCosts
+	Cost func(*APICall) float64
+}
+
+// Caller identity for rate limits : "key:<id>", "user:<username>" or "ip:<address>"
+func RateLimitKey(C *APICall) string {
+	if C.APIKey != nil {
+		return "key:" + C.APIKey.ID
+	}
+	if U := C.User(); U != nil {
+		return "user:" + U.Username
+	}
+	return "ip:" + C.ClientIP()
+}
+
+// Client address for rate limits ahead of authentication : "addr:<address>".
+// Kept apart from the "ip:" buckets of RateLimitKey, so callers without credentials aren't charged twice
+func RateLimitAddress(C *APICall) string {
+	return "addr:" + C.ClientIP()
+}
+
+// Address of the client. X-Forwarded-For is used only if Trust_Proxy is on
+func (c *APICall) ClientIP() string {
+	if Trust_Proxy {
+		if fwd := c.GetHeader("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(c.HTTPRequest.RemoteAddr)
+	if err != nil {
+		return c.HTTPRequest.RemoteAddr
+	}
+	return host
+}
+
+// Set the token cost of the route, instead of the one of its action in RouteCosts
+func (H API_Call_Handler) WithCost(Cost float64) API_Call_Handler {
+	H.Cost = Cost
+	return H
+}
+
+// Count the request as N reads or writes for the daily quotas of RateLimit, instead of one.
+// For routes that read or write many documents at once, like exports and imports
+func (c *APICall) ChargeQuota(N int64) {
+	c.quotaUnits = N
+}
+
+func routeCost(C *APICall) float64 {
+	if C.Route == nil {
+		return 1
+	}
+	if C.Route.Cost > 0 {
+		return C.Route.Cost
+	}
+	if cost, ok := RouteCosts[C.Route.Action]; ok {
+		return cost
+	}
+	return 1
+}
+
+// Limit requests with a token bucket per caller, and enforce daily quotas.
+// Sends RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers,
+// answers 429 Too Many Requests (with Retry-After) when the bucket or a quota is exhausted.
+// Requests charged more than one unit with APICall.ChargeQuota count that many against the quota, once they are done.
+// Store errors let the request through.
+func RateLimit(Opts RateLimitOptions) Middleware {
+	keyOf := Opts.Key
+	if keyOf == nil {
+		keyOf = RateLimitKey
+	}
+	costOf := Opts.Cost
+	if costOf == nil {
+		costOf = routeCost
+	}
+
+	return func(next Handler) Handler {
+		return func(C *APICall) {
+			key := keyOf(C)
+			cost := costOf(C)
+
+			remaining, ok, err := Opts.Store.TakeTokens("bucket:"+key, cost, Opts.Burst, Opts.Rate)
+			if CheckError(err) {
+				next(C)
+				return
+			}
+
+			C.SetHeader("RateLimit-Limit", strconv.FormatFloat(Opts.Burst, 'f', -1, 64))
+			C.SetHeader("RateLimit-Remaining", strconv.FormatFloat(math.Floor(remaining), 'f', -1, 64))
+			C.SetHeader("RateLimit-Reset", strconv.Itoa(int(math.Ceil((Opts.Burst-remaining)/Opts.Rate))))
+			C.SetHeader("RateLimit-Policy", strconv.FormatFloat(Opts.Burst, 'f', -1, 64)+";w="+strconv.Itoa(int(math.Ceil(Opts.Burst/Opts.Rate))))
+
+			if !ok {
+				retry := int(math.Ceil((cost - remaining) / Opts.Rate))
+				tooManyRequests(C, retry, NewAPIError(CodeRateLimited, "rate limit exceeded"))
+				return
+			}
+
+			day := time.Now().UTC().Format("2006-01-02")
+			endOfDay := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+			untilTomorrow := int(time.Until(endOfDay).Seconds()) + 1
+
+			var action string
+			if C.Route != nil {
+				action = C.Route.Action
+			}
+
+			quota, kind, header := int64(0), "", ""
+			quotaKey := ""
+			switch action {
+			case ActionList, ActionGet, ActionAggregate:
+				quota, kind, header = Opts.DailyReads, "reads", "RateLimit-Quota-Reads"
+			case ActionSet, ActionDelete:
+				quota, kind, header = Opts.DailyWrites, "writes", "RateLimit-Quota-Writes"
+			}
+
+			if quota > 0 {
+				quotaKey = "quota:" + key + ":" + kind + ":" + day
+				n, err := Opts.Store.Increment(quotaKey, 1, endOfDay.Add(time.Hour))
+				if !CheckError(err) {
+					C.SetHeader(header, strconv.FormatInt(max(quota-n, 0), 10))
+					if n > quota {
+						tooManyRequests(C, untilTomorrow, NewAPIError(CodeQuotaExceeded, "daily "+kind+" quota exceeded"))
+						return
+					}
+				}
+			}
+
+			bytesKey := "quota:" + key + ":bytes:" + day
+			if Opts.DailyBytes > 0 {
+				n, err := Opts.Store.Increment(bytesKey, 0, endOfDay.Add(time.Hour))
+				if !CheckError(err) && n >= Opts.DailyBytes {
+					tooManyRequests(C, untilTomorrow, NewAPIError(CodeQuotaExceeded, "daily bytes quota exceeded"))
+					return
+				}
+			}
+
+			next(C)
+
+			if quotaKey != "" && C.quotaUnits > 1 {
+				_, err := Opts.Store.Increment(quotaKey, C.quotaUnits-1, endOfDay.Add(time.Hour))
+				PrintError(err)
+			}
+			if Opts.DailyBytes > 0 && C.BytesWritten() > 0 {
+				_, err := Opts.Store.Increment(bytesKey, C.BytesWritten(), endOfDay.Add(time.Hour))
+				PrintError(err)
+			}
+		}
+	}
+}
+
+func tooManyRequests(C *APICall, RetryAfter int, Err *APIError) {
+	C.SetHeader("Retry-After", strconv.Itoa(max(RetryAfter, 1)))
+	C.WriteAPIError(Err)
+}
+
+// Rate limit state of a single instance
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	counters  map[string]*memoryCounter
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	expires time.Time
+}
+
+type memoryCounter struct {
+	count   int64
+	expires time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*memoryBucket{}, counters: map[string]*memoryCounter{}}
+}
+
+func (S *MemoryRateLimitStore) TakeTokens(Key string, N float64, Capacity float64, Rate float64) (float64, bool, error) {
+	S.mu.Lock()
+	defer S.mu.Unlock()
+
+	now := time.Now()
+	S.sweep(now)
+
+	B, ok := S.buckets[Key]
+	if !ok {
+		B = &memoryBucket{tokens: Capacity, updated: now}
+		S.buckets[Key] = B
+	}
+
+	B.tokens = math.Min(Capacity, B.tokens+now.Sub(B.updated).Seconds()*Rate)
+	B.updated = now
+	B.expires = now.Add(time.Duration(Capacity / Rate * float64(time.Second)))
+
+	if B.tokens < N {
+		return B.tokens, false, nil
+	}
+	B.tokens -= N
+	return B.tokens, true, nil
+}
+
+func (S *MemoryRateLimitStore) Increment(Key string, N int64, Expires time.Time) (int64, error) {
+	S.mu.Lock()
+	defer S.mu.Unlock()
+
+	now := time.Now()
+	S.sweep(now)
+
+	ctr, ok := S.counters[Key]
+	if !ok || now.After(ctr.expires) {
+		ctr = &memoryCounter{expires: Expires}
+		S.counters[Key] = ctr
+	}
+	ctr.count += N
+	return ctr.count, nil
+}
+
+// Drop expired buckets and counters, at most once a minute
+func (S *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(S.lastSweep) < time.Minute {
+		return
+	}
+	S.lastSweep = now
+
+	for k, b := range S.buckets {
+		if now.After(b.expires) {
+			delete(S.buckets, k)
+		}
+	}
+	for k, c := range S.counters {
+		if now.After(c.expires) {
+			delete(S.counters, k)
+		}
+	}
+}
+
+// Rate limit state shared by every instance, in moncore.SystemDBName/RateLimitsCollectionName
+type MoncoreRateLimitStore struct {
+	Col *moncore.Collection
+}
+
+func NewMoncoreRateLimitStore() *MoncoreRateLimitStore {
+	Col := Moncore.Database(moncore.SystemDBName).Collection(RateLimitsCollectionName)
+	PrintError(Col.EnsureTTLIndex("Expires", 0))
+	return &MoncoreRateLimitStore{Col: Col}
+}
+
+func (S *MoncoreRateLimitStore) TakeTokens(Key string, N float64, Capacity float64, Rate float64) (float64, bool, error) {
+	return S.Col.TakeTokens(Key, N, Capacity, Rate)
+}
+
+func (S *MoncoreRateLimitStore) Increment(Key string, N int64, Expires time.Time) (int64, error) {
+	return S.Col.Increment(Key, N, Expires)
+}
+
+// Rate limits of the API routes, set up by _InitRateLimits
+var API_RateLimits RateLimitOptions
+
+// Rate limits per client address, ahead of authentication : every request takes a token,
+// so failed credentials are limited too. Set up by _InitRateLimits
+var API_AddressRateLimits RateLimitOptions
+
+// Build API_RateLimits from the RateLimit_* and Quota_* settings
+func _InitRateLimits() {
+	var store RateLimitStore
+	switch RateLimit_Store {
+	case "moncore":
+		store = NewMoncoreRateLimitStore()
+	case "memory", "":
+		store = NewMemoryRateLimitStore()
+	default:
+		panic("unknown RateLimit_Store : " + RateLimit_Store + " (use memory or moncore)")
+	}
+
+	if RateLimit_Rate <= 0 || RateLimit_Burst <= 0 {
+		panic("RateLimit_Rate and RateLimit_Burst must be positive")
+	}
+
+	API_RateLimits = RateLimitOptions{
+		Burst:       RateLimit_Burst,
+		Rate:        RateLimit_Rate,
+		DailyReads:  Quota_Daily_Reads,
+		DailyWrites: Quota_Daily_Writes,
+		DailyBytes:  Quota_Daily_Bytes,
+		Store:       store,
+	}
+
+	API_AddressRateLimits = RateLimitOptions{
+		Burst: RateLimit_Burst,
+		Rate:  RateLimit_Rate,
+		Store: store,
+		Key:   RateLimitAddress,
+		Cost:  func(*APICall) float64 { return 1 },
+	}
+}
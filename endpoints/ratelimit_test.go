@@ -0,0 +1,243 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStoreBucket(t *testing.T) {
+	S := NewMemoryRateLimitStore()
+
+	// Capacity 10, 2 tokens per second. elapsed moves the last refill of the bucket back before taking
+	tests := []struct {
+		name      string
+		elapsed   time.Duration
+		take      float64
+		ok        bool
+		remaining float64
+	}{
+		{"new bucket is full", 0, 4, true, 6},
+		{"more than left", 0, 7, false, 6},
+		{"refilled after a second", time.Second, 7, true, 1},
+		{"refill stops at capacity", time.Hour, 10, true, 0},
+		{"empty", 0, 1, false, 0},
+	}
+	for _, tt := range tests {
+		if B := S.buckets["k"]; B != nil {
+			B.updated = B.updated.Add(-tt.elapsed)
+		}
+		remaining, ok, err := S.TakeTokens("k", tt.take, 10, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.ok || remaining < tt.remaining || remaining > tt.remaining+0.01 {
+			t.Fatalf("%s : TakeTokens(%v) = %v, %v, want %v, %v", tt.name, tt.take, remaining, ok, tt.remaining, tt.ok)
+		}
+	}
+
+	if _, ok, _ := S.TakeTokens("other", 10, 10, 2); !ok {
+		t.Fatal("buckets of other keys are shared")
+	}
+}
+
+func TestMemoryRateLimitStoreCounter(t *testing.T) {
+	S := NewMemoryRateLimitStore()
+	tomorrow := time.Now().Add(24 * time.Hour)
+
+	if n, _ := S.Increment("c", 3, tomorrow); n != 3 {
+		t.Fatalf("first Increment = %d", n)
+	}
+	if n, _ := S.Increment("c", 2, tomorrow); n != 5 {
+		t.Fatalf("second Increment = %d", n)
+	}
+
+	S.counters["c"].expires = time.Now().Add(-time.Second)
+	if n, _ := S.Increment("c", 1, tomorrow); n != 1 {
+		t.Fatalf("Increment of an expired counter = %d, want 1", n)
+	}
+}
+
+// Routes of every action behind RateLimit with the options, all callers sharing one key
+func withRateLimit(t *testing.T, Opts RateLimitOptions, Routes ...API_Call_Handler) {
+	if Opts.Store == nil {
+		Opts.Store = NewMemoryRateLimitStore()
+	}
+	if Opts.Key == nil {
+		Opts.Key = func(*APICall) string { return "test" }
+	}
+	ok := func(C *APICall) { C.Write([]byte("ok")) }
+
+	G := API_Group{Middlewares: []Middleware{RateLimit(Opts)}}
+	withRoutes(t, G.Routes(append([]API_Call_Handler{
+		API_GET(`list/`, ok).As(ActionList),
+		API_GET(`aggregate/`, ok).As(ActionAggregate),
+		API_GET(`get/`, ok).As(ActionGet),
+		API_POST(`set/`, ok).As(ActionSet),
+		API_GET(`cheap/`, ok).As(ActionList).WithCost(1),
+	}, Routes...)...))
+}
+
+func rateLimited(Method string, Path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ServeRequest(w, httptest.NewRequest(Method, Path, nil))
+	return w
+}
+
+func TestRateLimitCosts(t *testing.T) {
+	tests := []struct {
+		path      string
+		remaining string
+	}{
+		{"/get", "99"},
+		{"/list", "95"},
+		{"/aggregate", "90"},
+		{"/set", "98"},
+		{"/cheap", "99"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			withRateLimit(t, RateLimitOptions{Burst: 100, Rate: 1})
+
+			method := http.MethodGet
+			if tt.path == "/set" {
+				method = http.MethodPost
+			}
+			w := rateLimited(method, tt.path)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d", w.Code)
+			}
+			if got := w.Header().Get("RateLimit-Remaining"); got != tt.remaining {
+				t.Fatalf("RateLimit-Remaining %q, want %q", got, tt.remaining)
+			}
+			if got := w.Header().Get("RateLimit-Limit"); got != "100" {
+				t.Fatalf("RateLimit-Limit %q", got)
+			}
+			if got := w.Header().Get("RateLimit-Policy"); got != "100;w=100" {
+				t.Fatalf("RateLimit-Policy %q", got)
+			}
+		})
+	}
+}
+
+func TestRateLimitExhausted(t *testing.T) {
+	withRateLimit(t, RateLimitOptions{Burst: 12, Rate: 0.5})
+
+	for i := 0; i < 2; i++ {
+		if w := rateLimited(http.MethodGet, "/list"); w.Code != http.StatusOK {
+			t.Fatalf("request %d : status %d", i+1, w.Code)
+		}
+	}
+
+	w := rateLimited(http.MethodGet, "/list")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", w.Code)
+	}
+	// 2 tokens left, 3 missing at 0.5 per second
+	if got := w.Header().Get("Retry-After"); got != "6" {
+		t.Fatalf("Retry-After %q, want 6", got)
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "2" {
+		t.Fatalf("RateLimit-Remaining %q, want 2", got)
+	}
+	if code := errorCode(t, w); code != CodeRateLimited {
+		t.Fatalf("error code %q", code)
+	}
+
+	if w := rateLimited(http.MethodGet, "/get"); w.Code != http.StatusOK {
+		t.Fatalf("cheaper route : status %d", w.Code)
+	}
+}
+
+func TestRateLimitQuotas(t *testing.T) {
+	bigWrite := func(C *APICall) {
+		C.ChargeQuota(3)
+		C.Write([]byte("ok"))
+	}
+	large := func(C *APICall) { C.Write(make([]byte, 60)) }
+
+	tests := []struct {
+		name     string
+		opts     RateLimitOptions
+		requests []string // method and path, the last one is refused
+		header   string
+	}{
+		{"reads", RateLimitOptions{DailyReads: 2}, []string{"GET /get", "GET /list", "GET /aggregate"}, "RateLimit-Quota-Reads"},
+		{"writes", RateLimitOptions{DailyWrites: 2}, []string{"POST /set", "POST /set", "POST /set"}, "RateLimit-Quota-Writes"},
+		{"writes of many documents", RateLimitOptions{DailyWrites: 4}, []string{"POST /import", "POST /set", "POST /set"}, "RateLimit-Quota-Writes"},
+		{"bytes", RateLimitOptions{DailyBytes: 100}, []string{"GET /large", "GET /large", "GET /get"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Burst, tt.opts.Rate = 1000, 1
+			withRateLimit(t, tt.opts,
+				API_POST(`import/`, bigWrite).As(ActionSet),
+				API_GET(`large/`, large).As(ActionGet),
+			)
+
+			for i, r := range tt.requests {
+				method, path, _ := strings.Cut(r, " ")
+				w := rateLimited(method, path)
+
+				if i < len(tt.requests)-1 {
+					if w.Code != http.StatusOK {
+						t.Fatalf("%s : status %d", r, w.Code)
+					}
+					continue
+				}
+
+				if w.Code != http.StatusTooManyRequests {
+					t.Fatalf("%s : status %d, want 429", r, w.Code)
+				}
+				if code := errorCode(t, w); code != CodeQuotaExceeded {
+					t.Fatalf("error code %q", code)
+				}
+				if retry, _ := strconv.Atoi(w.Header().Get("Retry-After")); retry < 1 || retry > 24*3600+1 {
+					t.Fatalf("Retry-After %q, want the seconds until tomorrow", w.Header().Get("Retry-After"))
+				}
+				if tt.header != "" && w.Header().Get(tt.header) != "0" {
+					t.Fatalf("%s %q, want 0", tt.header, w.Header().Get(tt.header))
+				}
+			}
+		})
+	}
+}
+
+func TestAddressRateLimitBeforeAuthorize(t *testing.T) {
+	saved := Require_Auth
+	t.Cleanup(func() { Require_Auth = saved })
+	Require_Auth = true
+
+	G := API_Group{Middlewares: []Middleware{
+		RateLimit(RateLimitOptions{Burst: 2, Rate: 0.01, Store: NewMemoryRateLimitStore(), Key: RateLimitAddress, Cost: func(*APICall) float64 { return 1 }}),
+		Authorize(),
+	}}
+	withRoutes(t, G.Routes(API_GET(`things/`, func(C *APICall) { C.Write([]byte("ok")) }).As(ActionList)))
+
+	want := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}
+	for i, status := range want {
+		r := httptest.NewRequest(http.MethodGet, "/things", nil)
+		r.Header.Set("Authorization", "Bearer not-a-token")
+		w := httptest.NewRecorder()
+		ServeRequest(w, r)
+		if w.Code != status {
+			t.Fatalf("attempt %d : status %d, want %d", i+1, w.Code, status)
+		}
+	}
+}
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var E struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &E); err != nil {
+		t.Fatalf("error envelope %q : %v", w.Body.String(), err)
+	}
+	return E.Error.Code
+}
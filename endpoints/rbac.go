@@ -0,0 +1,438 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"mongomini/agra/moncore"
+)
+
+var RolesCollectionName string = "roles"
+
+// Actions allowed by a role on the databases and collections matching its patterns.
+//
+// Database and Collection are path.Match patterns ('*' matches all).
+// HiddenFields and ProtectedFields are paths inside Doc, see moncore.FieldMasks.
+type Permission struct {
+	Actions         []string `bson:"Actions" json:"actions"`
+	Database        string   `bson:"Database" json:"database"`
+	Collection      string   `bson:"Collection" json:"collection"`
+	HiddenFields    []string `bson:"HiddenFields" json:"hidden_fields,omitempty"`
+	ProtectedFields []string `bson:"ProtectedFields" json:"protected_fields,omitempty"`
+}
+
+// Named set of permissions. Custom roles are stored in moncore.SystemDBName/RolesCollectionName
+type Role struct {
+	Name        string       `bson:"-" json:"name"`
+	Description string       `bson:"Description" json:"description"`
+	Permissions []Permission `bson:"Permissions" json:"permissions"`
+	BuiltIn     bool         `bson:"-" json:"builtin"`
+}
+
+var allActions = []string{ActionList, ActionGet, ActionSet, ActionDelete, ActionAggregate, ActionAdmin}
+
+// Built-in roles. They apply to every database and can't be changed
+var BuiltInRoles = map[string]*Role{
+	"viewer": {Name: "viewer", Description: "Read documents", BuiltIn: true, Permissions: []Permission{
+		{Actions: []string{ActionList, ActionGet}, Database: "*", Collection: "*"},
+	}},
+	"editor": {Name: "editor", Description: "Read and write documents", BuiltIn: true, Permissions: []Permission{
+		{Actions: []string{ActionList, ActionGet, ActionSet, ActionDelete}, Database: "*", Collection: "*"},
+	}},
+	"owner": {Name: "owner", Description: "Everything, including mini/admin/ routes", BuiltIn: true, Permissions: []Permission{
+		{Actions: allActions, Database: "*", Collection: "*"},
+	}},
+}
+
+var validRoleName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+func roles() *moncore.TypedCollection[Role] {
+	return moncore.Typed[Role](Moncore.Database(moncore.SystemDBName).Collection(RolesCollectionName))
+}
+
+// Check that the permission allows the action on db/collection.
+// collection is empty for database level actions (any collection pattern matches),
+// db is empty for server level actions (only a '*' database pattern matches).
+// The system database (users, keys, sessions, webhooks) is only reachable with the admin action, whatever the patterns.
+func (P *Permission) Allows(Action string, DB string, Collection string) bool {
+	if !contains(P.Actions, Action) {
+		return false
+	}
+	if DB == moncore.SystemDBName && Action != ActionAdmin {
+		return false
+	}
+	return P.covers(DB, Collection)
+}
+
+func (P *Permission) covers(DB string, Collection string) bool {
+	if DB == "" {
+		return P.Database == "*"
+	}
+	if m, _ := path.Match(P.Database, DB); !m {
+		return false
+	}
+	if Collection == "" {
+		return true
+	}
+	m, _ := path.Match(P.Collection, Collection)
+	return m
+}
+
+func (P *Permission) validate() error {
+	if len(P.Actions) == 0 {
+		return errors.New("permission needs at least one action")
+	}
+	for _, a := range P.Actions {
+		if !contains(allActions, a) {
+			return errors.New("unknown action : " + a)
+		}
+	}
+	if P.Database == "" {
+		P.Database = "*"
+	}
+	if P.Collection == "" {
+		P.Collection = "*"
+	}
+	if _, err := path.Match(P.Database, ""); err != nil {
+		return errors.New("bad database pattern : " + P.Database)
+	}
+	if _, err := path.Match(P.Collection, ""); err != nil {
+		return errors.New("bad collection pattern : " + P.Collection)
+	}
+	for _, f := range append(append([]string{}, P.HiddenFields...), P.ProtectedFields...) {
+		if err := moncore.ValidateFieldPath(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get a built-in or custom role
+func GetRole(Name string) (*Role, error) {
+	if R, ok := BuiltInRoles[Name]; ok {
+		return R, nil
+	}
+
+	R, err := roles().Get(Name)
+	if err != nil {
+		return nil, err
+	}
+	R.Name = Name
+	return R, nil
+}
+
+// List built-in and custom roles
+func ListRoles() ([]Role, error) {
+	out := []Role{*BuiltInRoles["viewer"], *BuiltInRoles["editor"], *BuiltInRoles["owner"]}
+
+	docs, err := roles().Query(moncore.Filter_MatchAll())
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range docs {
+		d.Doc.Name = d.ID
+		out = append(out, d.Doc)
+	}
+	return out, nil
+}
+
+// Create or replace a custom role
+func SaveRole(R *Role) error {
+	if !validRoleName.MatchString(R.Name) {
+		return errors.New("role name must be 1-64 characters of a-z, 0-9, '.', '_' or '-'")
+	}
+	if _, ok := BuiltInRoles[R.Name]; ok {
+		return errors.New("can't change built-in role " + R.Name)
+	}
+	for i := range R.Permissions {
+		if err := R.Permissions[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	if res := roles().Set(R.Name, *R); res.Status != 1 {
+		return errors.New("can't store role : " + res.Result)
+	}
+	return nil
+}
+
+// Delete a custom role. Users keep the name in their roles, but it grants nothing
+func DeleteRole(Name string) error {
+	if _, ok := BuiltInRoles[Name]; ok {
+		return errors.New("can't delete built-in role " + Name)
+	}
+	if res := roles().Delete(Name); res.Status != 1 {
+		return moncore.ErrNotFound
+	}
+	return nil
+}
+
+// Replace the roles of a user. Unknown roles are rejected
+func SetUserRoles(Username string, Roles []string) (*User, error) {
+	for _, r := range Roles {
+		if _, err := GetRole(r); err != nil {
+			return nil, errors.New("unknown role : " + r)
+		}
+	}
+
+	U, err := users().Get(Username)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the roles are written, and only if they are still the ones read, so concurrent password changes aren't overwritten
+	if res := users().CompareAndSetField(Username, "Roles", U.Roles, Roles); res.Status == http.StatusConflict {
+		return nil, ErrUserChanged
+	} else if res.Status != 1 {
+		return nil, errors.New("can't store user : " + res.Result)
+	}
+	U.Roles = Roles
+	return U, nil
+}
+
+// Resolved permissions of the caller of an API call
+type Access struct {
+	Principal   string // "user:<username>" or "key:<id>"
+	Permissions []Permission
+}
+
+// Permissions of a user, from all of its roles
+func UserAccess(U *User) *Access {
+	A := &Access{Principal: "user:" + U.Username}
+	for _, name := range U.Roles {
+		R, err := GetRole(name)
+		if err != nil {
+			continue
+		}
+		A.Permissions = append(A.Permissions, R.Permissions...)
+	}
+	return A
+}
+
+// Permissions of an API key, from its scopes
+func KeyAccess(K *APIKey) *Access {
+	A := &Access{Principal: "key:" + K.ID}
+	for _, s := range K.Scopes {
+		if s == "admin" {
+			A.Permissions = append(A.Permissions, Permission{Actions: allActions, Database: "*", Collection: "*"})
+			continue
+		}
+
+		kind, target, _ := strings.Cut(s, ":")
+		db, col, ok := strings.Cut(target, "/")
+		if !ok {
+			col = "*"
+		}
+
+		P := Permission{Database: db, Collection: col}
+		switch kind {
+		case "read":
+			P.Actions = []string{ActionList, ActionGet, ActionAggregate}
+		case "write":
+			P.Actions = []string{ActionSet, ActionDelete}
+		default:
+			continue
+		}
+		A.Permissions = append(A.Permissions, P)
+	}
+	return A
+}
+
+// Check whether any permission allows the action. See Permission.Allows
+func (A *Access) Allows(Action string, DB string, Collection string) bool {
+	for i := range A.Permissions {
+		if A.Permissions[i].Allows(Action, DB, Collection) {
+			return true
+		}
+	}
+	return false
+}
+
+// Field masks on db/collection. Roles add up, so a path is only masked if every permission covering
+// the collection masks it (or one of its parents).
+func (A *Access) Masks(DB string, Collection string) moncore.FieldMasks {
+	covering := []*Permission{}
+	for i := range A.Permissions {
+		if A.Permissions[i].covers(DB, Collection) {
+			covering = append(covering, &A.Permissions[i])
+		}
+	}
+
+	M := moncore.FieldMasks{}
+	if len(covering) == 0 {
+		return M
+	}
+
+	M.HiddenFields = commonPaths(covering, func(P *Permission) []string { return P.HiddenFields })
+	M.ProtectedFields = commonPaths(covering, func(P *Permission) []string { return P.ProtectedFields })
+	return M
+}
+
+// Paths of the first permission that are equal to or inside a path of every other permission
+func commonPaths(Perms []*Permission, Paths func(*Permission) []string) []string {
+	out := []string{}
+	for _, p := range Paths(Perms[0]) {
+		all := true
+		for _, other := range Perms[1:] {
+			found := false
+			for _, q := range Paths(other) {
+				if p == q || strings.HasPrefix(p, q+".") {
+					found = true
+					break
+				}
+			}
+			if !found {
+				all = false
+				break
+			}
+		}
+		if all {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Authenticate the caller (API key, user access token or session cookie) and check the route action on the
+// {db} and {collection} path params. Routes without an action require the admin action.
+// Session cookies only authorize reads on GET routes, see sessionMayAuthorize.
+//
+// Handlers should open collections with APICall.Collection, so field masks of the caller apply.
+func Authorize() Middleware {
+	return func(next Handler) Handler {
+		return func(C *APICall) {
+			if C.Param("db") == moncore.SystemDBName {
+				C.WriteError("Forbidden : ", errors.New("the "+moncore.SystemDBName+" database is only reachable through mini/admin/ routes"), http.StatusForbidden)
+				return
+			}
+
+			if !Require_Auth {
+				next(C)
+				return
+			}
+
+			action := ActionAdmin
+			if C.Route != nil && C.Route.Action != "" {
+				action = C.Route.Action
+			}
+
+			token := C.BearerToken()
+
+			if key := VerifyAPIKey(token); token != "" && key != nil {
+				C.APIKey = key
+				C.Access = KeyAccess(key)
+			} else if U := C.User(); U != nil {
+				if token == "" && !sessionMayAuthorize(C.Method(), action) {
+					C.WriteAPIError(NewAPIError(CodeCSRF, "a session cookie can't authorize "+action+" with "+C.Method()+", use an Authorization header"))
+					return
+				}
+				C.Access = UserAccess(U)
+			} else if token == "" {
+				C.SetHeader("WWW-Authenticate", `Bearer realm="mongomini"`)
+				C.WriteError("Unauthorized : ", errors.New("missing API key, access token or session"), http.StatusUnauthorized)
+				return
+			} else {
+				C.SetHeader("WWW-Authenticate", `Bearer realm="mongomini", error="invalid_token"`)
+				C.WriteError("Unauthorized : ", errors.New("invalid API key or access token"), http.StatusUnauthorized)
+				return
+			}
+
+			if !C.Access.Allows(action, C.Param("db"), C.Param("collection")) {
+				C.WriteError("Forbidden : ", errors.New(C.Access.Principal+" isn't allowed to "+action+" here"), http.StatusForbidden)
+				return
+			}
+
+			next(C)
+		}
+	}
+}
+
+// Open a user collection with the field masks of the caller, guarded by the Rules
+func (c *APICall) Collection(db string, collection string) *moncore.Collection {
+	Col := OpenCollection(db, collection)
+	if c.Access != nil {
+		Col.Masks = c.Access.Masks(db, collection)
+	}
+	if Rules != nil {
+		Col.Guard = c.rulesGuard(Rules)
+	}
+	return Col
+}
+
+// Check whether the caller may do the action on db/collection. Always true when Require_Auth is off
+func (c *APICall) Can(Action string, db string, collection string) bool {
+	return c.Access == nil || c.Access.Allows(Action, db, collection)
+}
+
+// GET : mini/admin/roles
+func API_List_Roles(C *APICall) {
+	R, err := ListRoles()
+	if err != nil {
+		C.WriteError("Internal Server Error : ", err, http.StatusInternalServerError)
+		return
+	}
+	C.Respond(R)
+}
+
+// POST : mini/admin/roles with {"name": "...", "description": "...", "permissions": [...]}
+func API_Save_Role(C *APICall) {
+	R := Role{}
+	if err := C.BodyJsonToStruct(&R); err != nil {
+		C.Fail(err)
+		return
+	}
+
+	if err := SaveRole(&R); err != nil {
+		C.WriteError("Bad Request : ", err, http.StatusBadRequest)
+		return
+	}
+
+	C.Respond(R)
+}
+
+// GET : mini/admin/roles/{name}
+func API_Get_Role(C *APICall) {
+	R, err := GetRole(C.Param("name"))
+	if err != nil {
+		C.WriteError("Not Found : ", errors.New("no role "+C.Param("name")), http.StatusNotFound)
+		return
+	}
+	C.Respond(R)
+}
+
+// DELETE : mini/admin/roles/{name}
+func API_Delete_Role(C *APICall) {
+	if err := DeleteRole(C.Param("name")); err == moncore.ErrNotFound {
+		C.WriteError("Not Found : ", errors.New("no role "+C.Param("name")), http.StatusNotFound)
+		return
+	} else if err != nil {
+		C.WriteError("Bad Request : ", err, http.StatusBadRequest)
+		return
+	}
+	C.WriteStatus(http.StatusNoContent)
+}
+
+// PUT : mini/admin/users/{username}/roles with ["viewer", ...]
+func API_Set_User_Roles(C *APICall) {
+	R := []string{}
+	if err := C.BodyJsonToStruct(&R); err != nil {
+		C.Fail(err)
+		return
+	}
+
+	U, err := SetUserRoles(C.Param("username"), R)
+	if err == moncore.ErrNotFound {
+		C.WriteError("Not Found : ", errors.New("no user "+C.Param("username")), http.StatusNotFound)
+		return
+	} else if err == ErrUserChanged {
+		C.WriteError("Conflict : ", err, http.StatusConflict)
+		return
+	} else if err != nil {
+		C.WriteError("Bad Request : ", err, http.StatusBadRequest)
+		return
+	}
+	C.Respond(U)
+}
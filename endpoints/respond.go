@@ -0,0 +1,313 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"gopkg.in/yaml.v3"
+)
+
+// Response formats of Respond(), chosen with ?format= or the Accept header
+const (
+	FormatJSON             = "json"              // Compact JSON
+	FormatPretty           = "pretty"            // Indented JSON, the default
+	FormatNDJSON           = "ndjson"            // One JSON value per line (one line per element of lists)
+	FormatCSV              = "csv"               // One row per document, flattened field paths as columns ("ID", "Doc.address.city")
+	FormatYAML             = "yaml"              // YAML
+	FormatExtJSON          = "extjson"           // Relaxed MongoDB Extended JSON. Dates, ObjectIDs and decimals keep their type
+	FormatExtJSONCanonical = "extjson-canonical" // Canonical MongoDB Extended JSON. Numbers keep their exact BSON type too
+)
+
+// A response encoding
+type ResponseFormat struct {
+	Name        string
+	ContentType string
+	MediaTypes  []string // Matched against the Accept header
+	Encode      func(Value interface{}) ([]byte, error)
+}
+
+// Formats supported by Respond()
+var ResponseFormats = []ResponseFormat{
+	{FormatPretty, "application/json; charset=utf-8", nil, encodePretty},
+	{FormatJSON, "application/json; charset=utf-8", []string{"application/json"}, encodeJSON},
+	{FormatNDJSON, "application/x-ndjson", []string{"application/x-ndjson", "application/jsonl"}, encodeNDJSON},
+	{FormatCSV, "text/csv; charset=utf-8", []string{"text/csv"}, encodeCSV},
+	{FormatYAML, "application/yaml; charset=utf-8", []string{"application/yaml", "application/x-yaml", "text/yaml"}, encodeYAML},
+	{FormatExtJSON, "application/extjson", []string{"application/extjson", "application/ejson"}, encodeExtJSON(false)},
+	{FormatExtJSONCanonical, "application/extjson; mode=canonical", nil, encodeExtJSON(true)},
+}
+
+func responseFormat(Name string) *ResponseFormat {
+	for i := range ResponseFormats {
+		if ResponseFormats[i].Name == Name {
+			return &ResponseFormats[i]
+		}
+	}
+	return nil
+}
+
+// Format of the response : the ?format= parameter, else the best match of the Accept header, else pretty JSON.
+// nil if the client accepts none of ResponseFormats
+func (c *APICall) ResponseFormat() *ResponseFormat {
+	if name := c.HTTPRequest.URL.Query().Get("format"); name != "" {
+		return responseFormat(strings.ToLower(name))
+	}
+
+	accept := c.GetHeader("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return responseFormat(FormatPretty)
+	}
+
+	type acceptable struct {
+		mediaType string
+		params    map[string]string
+		q         float64
+	}
+	ranges := []acceptable{}
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, acceptable{mt, params, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, r := range ranges {
+		switch r.mediaType {
+		case "*/*", "application/*":
+			return responseFormat(FormatPretty)
+		case "application/extjson", "application/ejson":
+			if r.params["mode"] == "canonical" {
+				return responseFormat(FormatExtJSONCanonical)
+			}
+		}
+		for i := range ResponseFormats {
+			if contains(ResponseFormats[i].MediaTypes, r.mediaType) {
+				return &ResponseFormats[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Whether the client accepts one of ResponseFormats. Answers 406 Not Acceptable and returns false if not.
+// Check it before work that can't be repeated, like creating a secret that is only returned once
+func (c *APICall) Acceptable() bool {
+	if c.ResponseFormat() != nil {
+		return true
+	}
+
+	c.AddVary("Accept")
+	names := []string{}
+	for _, f := range ResponseFormats {
+		names = append(names, f.Name)
+	}
+	c.Fail(NewAPIError(CodeNotAcceptable, "supported formats are "+strings.Join(names, ", ")))
+	return false
+}
+
+// Write Value in the format asked by the client (see ResponseFormat()), with a Server-Timing header.
+// Answers 406 Not Acceptable if no format fits, and 422 if Value can't be encoded
+func (c *APICall) Respond(Value interface{}) {
+	c.RespondStatus(http.StatusOK, Value)
+}
+
+// Respond() with another status than 200 OK. The status is only sent once the format is chosen and Value encoded,
+// so the headers and the errors of Respond() still reach the client
+func (c *APICall) RespondStatus(Status int, Value interface{}) {
+	if !c.Acceptable() {
+		return
+	}
+	c.AddVary("Accept")
+	F := c.ResponseFormat()
+
+	start := time.Now()
+	out, err := F.Encode(Value)
+	if err != nil {
+		c.Fail(NewAPIError(CodeUnprocessable, "can't write "+F.Name+" : "+err.Error()))
+		return
+	}
+
+	c.SetHeader("Server-Timing", c.serverTiming(ServerTiming{"encode", time.Since(start), "Encoding " + F.Name}))
+	c.SetHeader("Content-Type", F.ContentType)
+	c.SetHeader("X-Content-Type-Options", "nosniff")
+	c.WriteStatus(Status)
+	c.Write(out)
+}
+
+func encodeJSON(Value interface{}) ([]byte, error) {
+	return json.Marshal(Value)
+}
+
+func encodePretty(Value interface{}) ([]byte, error) {
+	return json.MarshalIndent(Value, "", "  ")
+}
+
+// Elements of Value if it's a slice or an array, else Value alone
+func responseItems(Value interface{}) []interface{} {
+	v := reflect.ValueOf(Value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return []interface{}{Value}
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+		return []interface{}{Value} // []byte is a single value
+	}
+
+	items := make([]interface{}, v.Len())
+	for i := range items {
+		items[i] = v.Index(i).Interface()
+	}
+	return items
+}
+
+func encodeNDJSON(Value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, item := range responseItems(Value) {
+		J, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(J)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// Value as plain JSON types : maps, []interface{}, strings, int64, float64, bool and nil
+func plainValue(Value interface{}) (interface{}, error) {
+	J, err := json.Marshal(Value)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJSONValue(J)
+}
+
+func encodeYAML(Value interface{}) ([]byte, error) {
+	plain, err := plainValue(Value)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(plain)
+}
+
+func encodeExtJSON(Canonical bool) func(interface{}) ([]byte, error) {
+	return func(Value interface{}) ([]byte, error) {
+		// Extended JSON needs a document at the top : wrap the value and cut the wrapper off
+		J, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: Value}}, Canonical, false)
+		if err != nil {
+			return nil, err
+		}
+		J = bytes.TrimSpace(J)
+		J = bytes.TrimPrefix(J, []byte(`{"v":`))
+		J = bytes.TrimSuffix(J, []byte(`}`))
+		return J, nil
+	}
+}
+
+// One row per element of Value. Columns are the flattened paths of all rows, in order of first appearance
+func encodeCSV(Value interface{}) ([]byte, error) {
+	rows := []map[string]string{}
+	columns := []string{}
+	seen := map[string]bool{}
+
+	for _, item := range responseItems(Value) {
+		plain, err := plainValue(item)
+		if err != nil {
+			return nil, err
+		}
+
+		row := map[string]string{}
+		var paths []string
+		if m, ok := plain.(map[string]interface{}); ok {
+			paths = flattenRow("", m, row)
+		} else {
+			row["value"] = csvCell(plain)
+			paths = []string{"value"}
+		}
+
+		for _, p := range paths {
+			if !seen[p] {
+				seen[p] = true
+				columns = append(columns, p)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	var buf bytes.Buffer
+	W := csv.NewWriter(&buf)
+	W.Write(columns)
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, col := range columns {
+			record[i] = row[col]
+		}
+		W.Write(record)
+	}
+	W.Flush()
+	return buf.Bytes(), W.Error()
+}
+
+// Flatten nested objects into row as "a.b.c" cells. Scalars come before nested objects, both sorted.
+// Returns the paths in column order
+func flattenRow(Prefix string, M map[string]interface{}, Row map[string]string) []string {
+	keys := make([]string, 0, len(M))
+	for k := range M {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		_, iNested := M[keys[i]].(map[string]interface{})
+		_, jNested := M[keys[j]].(map[string]interface{})
+		if iNested != jNested {
+			return jNested
+		}
+		return keys[i] < keys[j]
+	})
+
+	paths := []string{}
+	for _, k := range keys {
+		if nested, ok := M[k].(map[string]interface{}); ok && len(nested) != 0 {
+			paths = append(paths, flattenRow(Prefix+k+".", nested, Row)...)
+			continue
+		}
+		Row[Prefix+k] = csvCell(M[k])
+		paths = append(paths, Prefix+k)
+	}
+	return paths
+}
+
+// Text of a cell. Arrays and objects are written as JSON
+func csvCell(V interface{}) string {
+	switch t := V.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case bool:
+		return strconv.FormatBool(t)
+	case int64:
+		return strconv.FormatInt(t, 10)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+	J, _ := json.Marshal(V)
+	return string(J)
+}
@@ -0,0 +1,67 @@
+package endpoints
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRespondStatus(t *testing.T) {
+	created := 0
+	withRoutes(t, []API_Call_Handler{
+		API_POST(`things/`, func(C *APICall) {
+			if !C.Acceptable() {
+				return
+			}
+			created++
+			C.RespondStatus(http.StatusCreated, map[string]string{"secret": "s3cr3t"})
+		}),
+	})
+
+	tests := []struct {
+		accept      string
+		status      int
+		contentType string
+		created     int
+	}{
+		{"", http.StatusCreated, "application/json; charset=utf-8", 1},
+		{"text/csv", http.StatusCreated, "text/csv; charset=utf-8", 2},
+		{"image/png", http.StatusNotAcceptable, "application/json; charset=utf-8", 2},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/things", nil)
+		r.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		ServeRequest(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("Accept %q : status %d, want %d", tt.accept, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("Accept %q : Content-Type %q, want %q", tt.accept, got, tt.contentType)
+		}
+		if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+			t.Errorf("Accept %q : X-Content-Type-Options %q", tt.accept, got)
+		}
+		if created != tt.created {
+			t.Errorf("Accept %q : created %d times, want %d", tt.accept, created, tt.created)
+		}
+	}
+}
+
+func TestRespondKeepsVary(t *testing.T) {
+	withRoutes(t, []API_Call_Handler{
+		API_GET(`things/`, func(C *APICall) {
+			C.AddVary("Accept-Encoding")
+			C.Respond([]string{"a"})
+		}),
+	})
+
+	w := httptest.NewRecorder()
+	ServeRequest(w, httptest.NewRequest(http.MethodGet, "/things", nil))
+
+	got := w.Header().Values("Vary")
+	if len(got) != 2 || got[0] != "Accept-Encoding" || got[1] != "Accept" {
+		t.Fatalf("Vary = %q, want [Accept-Encoding Accept]", got)
+	}
+}
@@ -0,0 +1,194 @@
+package endpoints
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Route lookup for API_Endpoints.
+//
+// Routes created by API_Route (or API_GET, ...) whose segments are plain literals or whole {placeholders}
+// are indexed in a segment tree, so finding them doesn't depend on the number of routes.
+// Other routes (regex routes, mixed segments) are tried one by one, as before.
+// The first route in API_Endpoints order that matches the path and the method still wins.
+type router struct {
+	mu sync.RWMutex
+
+	// Snapshot of API_Endpoints the router was built from
+	size  int
+	first *API_Call_Handler
+
+	tree    *routeNode
+	regexes []int // Indexes of routes not in the tree, in table order
+}
+
+type routeNode struct {
+	static map[string]*routeNode // Literal segments, lower cased
+	param  *routeNode            // {placeholder} segment
+	routes []int                 // Indexes of routes ending here
+}
+
+var defaultRouter = &router{}
+
+// Find the route for the request. Returns the route and its regex submatches,
+// or the methods accepted by routes matching only the path.
+func (R *router) match(Method string, urlpath string) (*API_Call_Handler, []string, []string) {
+	R.refresh()
+
+	R.mu.RLock()
+	defer R.mu.RUnlock()
+
+	segments := strings.Split(strings.TrimSuffix(urlpath, "/"), "/")
+	for i, s := range segments {
+		segments[i] = strings.ToLower(s)
+	}
+
+	candidates := []int{}
+	if strings.HasSuffix(urlpath, "/") {
+		R.tree.collect(segments, &candidates)
+		sort.Ints(candidates)
+	}
+
+	allowed := []string{}
+	best := -1
+	var submatches []string
+
+	for _, i := range candidates {
+		handler := &API_Endpoints[i]
+		if handler.Handler == nil {
+			continue
+		}
+		sub := handler.Path.FindStringSubmatch(urlpath)
+		if sub == nil {
+			continue
+		}
+		if handler.AcceptsMethod(Method) {
+			best, submatches = i, sub
+			break
+		}
+		allowed = appendMissing(allowed, handler.Methods...)
+	}
+
+	for _, i := range R.regexes {
+		if best != -1 && i > best {
+			break
+		}
+
+		handler := &API_Endpoints[i]
+		if handler.Handler == nil {
+			continue
+		}
+		sub := handler.Path.FindStringSubmatch(urlpath)
+		if sub == nil {
+			continue
+		}
+		if handler.AcceptsMethod(Method) {
+			best, submatches = i, sub
+			break
+		}
+		allowed = appendMissing(allowed, handler.Methods...)
+	}
+
+	if best == -1 {
+		return nil, nil, allowed
+	}
+
+	return &API_Endpoints[best], submatches, nil
+}
+
+// Rebuild the tree if API_Endpoints changed
+func (R *router) refresh() {
+	R.mu.RLock()
+	fresh := R.tree != nil && R.size == len(API_Endpoints) && (R.size == 0 || R.first == &API_Endpoints[0])
+	R.mu.RUnlock()
+
+	if fresh {
+		return
+	}
+
+	R.mu.Lock()
+	defer R.mu.Unlock()
+
+	R.tree = &routeNode{}
+	R.regexes = nil
+
+	for i := range API_Endpoints {
+		segments, ok := treeSegments(API_Endpoints[i].Pattern)
+		if !ok {
+			R.regexes = append(R.regexes, i)
+			continue
+		}
+		R.tree.insert(segments, i)
+	}
+
+	R.size = len(API_Endpoints)
+	R.first = nil
+	if R.size != 0 {
+		R.first = &API_Endpoints[0]
+	}
+}
+
+// Split a route pattern into tree segments. "" marks a placeholder.
+// Returns false if the pattern can't be indexed.
+func treeSegments(Pattern string) ([]string, bool) {
+	if Pattern == "" || !strings.HasSuffix(Pattern, "/") {
+		return nil, false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(Pattern, "/"), "/")
+	for i, p := range parts {
+		locs := routePlaceholder.FindAllStringIndex(p, -1)
+
+		switch {
+		case len(locs) == 0 && p != "":
+			parts[i] = strings.ToLower(p)
+		case len(locs) == 1 && locs[0][0] == 0 && locs[0][1] == len(p):
+			parts[i] = ""
+		default:
+			return nil, false
+		}
+	}
+	return parts, true
+}
+
+func (N *routeNode) insert(segments []string, index int) {
+	if len(segments) == 0 {
+		N.routes = append(N.routes, index)
+		return
+	}
+
+	var next *routeNode
+	if segments[0] == "" {
+		if N.param == nil {
+			N.param = &routeNode{}
+		}
+		next = N.param
+	} else {
+		if N.static == nil {
+			N.static = map[string]*routeNode{}
+		}
+		next = N.static[segments[0]]
+		if next == nil {
+			next = &routeNode{}
+			N.static[segments[0]] = next
+		}
+	}
+
+	next.insert(segments[1:], index)
+}
+
+// Collect indexes of every route matching the segments
+func (N *routeNode) collect(segments []string, out *[]int) {
+	if len(segments) == 0 {
+		*out = append(*out, N.routes...)
+		return
+	}
+
+	if next := N.static[segments[0]]; next != nil {
+		next.collect(segments[1:], out)
+	}
+	if N.param != nil && segments[0] != "" {
+		N.param.collect(segments[1:], out)
+	}
+}
@@ -0,0 +1,96 @@
+package endpoints
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func noopHandler(C *APICall) {}
+
+// Routes r0/ ... r{N-1}/, each with GET and DELETE templates, plus a regex route
+func benchRoutes(N int) []API_Call_Handler {
+	routes := []API_Call_Handler{}
+	for i := 0; i < N/2; i++ {
+		prefix := "r" + strconv.Itoa(i) + "/"
+		routes = append(routes,
+			API_GET(prefix+`{db}/{collection}/`, noopHandler),
+			API_DELETE(prefix+`{db}/{collection}/{dockey}/`, noopHandler),
+		)
+	}
+	return append(routes, API_Call_Handler_Prefix(`legacy/(.*)`, noopHandler))
+}
+
+func withRoutes(tb testing.TB, routes []API_Call_Handler) *router {
+	saved := API_Endpoints
+	tb.Cleanup(func() { API_Endpoints = saved })
+	API_Endpoints = routes
+	return &router{}
+}
+
+func TestRouterMatch(t *testing.T) {
+	R := withRoutes(t, append(benchRoutes(10),
+		API_GET(`r1/{db}/{collection}/`, noopHandler).As(ActionAdmin), // shadowed by the earlier r1 route
+		API_POST(`r2/{db}/{collection}/`, noopHandler),
+	))
+
+	tests := []struct {
+		method  string
+		path    string
+		index   int
+		params  []string
+		allowed []string
+	}{
+		{http.MethodGet, "r1/db/col/", 2, []string{"r1/db/col/", "db", "col"}, nil},
+		{http.MethodDelete, "R4/db/col/key/", 9, []string{"R4/db/col/key/", "db", "col", "key"}, nil},
+		{http.MethodPost, "r2/db/col/", 12, []string{"r2/db/col/", "db", "col"}, nil},
+		{http.MethodGet, "legacy/a/b/", 10, []string{"legacy/a/b/", "a/b/"}, nil},
+		{http.MethodPut, "r3/db/col/", -1, nil, []string{http.MethodGet, http.MethodHead}},
+		{http.MethodGet, "r3/db/", -1, nil, []string{}},
+	}
+
+	for _, tt := range tests {
+		handler, params, allowed := R.match(tt.method, tt.path)
+
+		index := -1
+		for i := range API_Endpoints {
+			if handler == &API_Endpoints[i] {
+				index = i
+			}
+		}
+		if index != tt.index {
+			t.Errorf("%s %s : route %d, want %d", tt.method, tt.path, index, tt.index)
+		}
+		if len(params) != len(tt.params) {
+			t.Errorf("%s %s : params %q, want %q", tt.method, tt.path, params, tt.params)
+		} else {
+			for i := range params {
+				if params[i] != tt.params[i] {
+					t.Errorf("%s %s : params %q, want %q", tt.method, tt.path, params, tt.params)
+					break
+				}
+			}
+		}
+		if len(allowed) != len(tt.allowed) {
+			t.Errorf("%s %s : allowed %q, want %q", tt.method, tt.path, allowed, tt.allowed)
+		}
+	}
+}
+
+func BenchmarkRouter(b *testing.B) {
+	for _, N := range []int{10, 100, 1000} {
+		b.Run(strconv.Itoa(N)+" routes", func(b *testing.B) {
+			R := withRoutes(b, benchRoutes(N))
+			last := "r" + strconv.Itoa(N/2-1) + "/db/col/key/"
+			R.refresh()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if handler, _, _ := R.match(http.MethodDelete, last); handler == nil {
+					b.Fatal("no route for " + last)
+				}
+			}
+		})
+	}
+}
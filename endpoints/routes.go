@@ -0,0 +1,166 @@
+package endpoints
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Matches {name} placeholders in route patterns
+var routePlaceholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Creates a case insensitive route for the given methods from a path template.
+// Placeholders like {db} match one path segment and are readable with APICall.Param("db").
+// Everything else is matched literally.
+// Pattern must not begin with a slash. Pattern must end with a slash.
+// For example, "mini/ls/{db}/{collection}/" is a valid pattern.
+func API_Route(Methods []string, Pattern string, Handler func(*APICall)) API_Call_Handler {
+	return API_Call_Handler{
+		Handler: Handler,
+		Path:    compileRoutePattern(Pattern),
+		Methods: normalizeMethods(Methods),
+		Pattern: Pattern,
+	}
+}
+
+// GET (and HEAD) route. See API_Route
+func API_GET(Pattern string, Handler func(*APICall)) API_Call_Handler {
+	return API_Route([]string{http.MethodGet, http.MethodHead}, Pattern, Handler)
+}
+
+// POST route. See API_Route
+func API_POST(Pattern string, Handler func(*APICall)) API_Call_Handler {
+	return API_Route([]string{http.MethodPost}, Pattern, Handler)
+}
+
+// PUT route. See API_Route
+func API_PUT(Pattern string, Handler func(*APICall)) API_Call_Handler {
+	return API_Route([]string{http.MethodPut}, Pattern, Handler)
+}
+
+// DELETE route. See API_Route
+func API_DELETE(Pattern string, Handler func(*APICall)) API_Call_Handler {
+	return API_Route([]string{http.MethodDelete}, Pattern, Handler)
+}
+
+// Routes sharing a path prefix
+type API_Group struct {
+	// Prefix prepended to every route of the group. Must not begin with a slash, must end with a slash.
+	Prefix string
+
+	// Middlewares applied to every route of the group, before the route's own middlewares
+	Middlewares []Middleware
+}
+
+// Prefix the routes with the group prefix
+func (G API_Group) Routes(Routes ...API_Call_Handler) []API_Call_Handler {
+	out := make([]API_Call_Handler, len(Routes))
+
+	for i, route := range Routes {
+		if route.Pattern != "" {
+			route.Pattern = G.Prefix + route.Pattern
+			route.Path = compileRoutePattern(route.Pattern)
+		} else {
+			src := strings.TrimPrefix(route.Path.String(), `(?i)^`)
+			route.Path = regexp.MustCompile(`(?i)^` + regexp.QuoteMeta(G.Prefix) + src)
+		}
+		route.Middlewares = append(append([]Middleware{}, G.Middlewares...), route.Middlewares...)
+		out[i] = route
+	}
+
+	return out
+}
+
+// Nested group. Inherits the group middlewares
+func (G API_Group) Group(Prefix string, Middlewares ...Middleware) API_Group {
+	return API_Group{Prefix: G.Prefix + Prefix, Middlewares: append(append([]Middleware{}, G.Middlewares...), Middlewares...)}
+}
+
+// Add route middlewares. First one is the outermost
+func (H API_Call_Handler) With(Middlewares ...Middleware) API_Call_Handler {
+	H.Middlewares = append(append([]Middleware{}, H.Middlewares...), Middlewares...)
+	return H
+}
+
+// Set the route action (ActionList, ActionGet, ...), used for permission checks
+func (H API_Call_Handler) As(Action string) API_Call_Handler {
+	H.Action = Action
+	return H
+}
+
+// Value of a named path parameter ({name} placeholder or (?P<name>) regex group). "" if absent
+func (c *APICall) Param(name string) string {
+	return c.namedParams[name]
+}
+
+// Check if the route accepts the HTTP method
+func (H *API_Call_Handler) AcceptsMethod(Method string) bool {
+	if len(H.Methods) == 0 {
+		return true
+	}
+	for _, m := range H.Methods {
+		if m == Method {
+			return true
+		}
+	}
+	return false
+}
+
+func (H *API_Call_Handler) namedParams(matches []string) map[string]string {
+	names := H.Path.SubexpNames()
+	params := map[string]string{}
+
+	for i, name := range names {
+		if i != 0 && name != "" && i < len(matches) {
+			params[name] = matches[i]
+		}
+	}
+	return params
+}
+
+func compileRoutePattern(Pattern string) *regexp.Regexp {
+	src := strings.Builder{}
+	last := 0
+
+	for _, loc := range routePlaceholder.FindAllStringSubmatchIndex(Pattern, -1) {
+		src.WriteString(regexp.QuoteMeta(Pattern[last:loc[0]]))
+		src.WriteString(`(?P<` + Pattern[loc[2]:loc[3]] + `>[^/]+)`)
+		last = loc[1]
+	}
+	src.WriteString(regexp.QuoteMeta(Pattern[last:]))
+
+	return regexp.MustCompile(`(?i)^` + src.String() + `$`)
+}
+
+func normalizeMethods(Methods []string) []string {
+	out := make([]string, 0, len(Methods))
+	for _, m := range Methods {
+		out = appendMissing(out, strings.ToUpper(m))
+	}
+	return out
+}
+
+func contains(list []string, value string) bool {
+	for _, e := range list {
+		if e == value {
+			return true
+		}
+	}
+	return false
+}
+
+func appendMissing(list []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, e := range list {
+			if e == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
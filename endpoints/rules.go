@@ -0,0 +1,62 @@
+package endpoints
+
+import (
+	"mongomini/agra/moncore"
+	"mongomini/agra/rules"
+)
+
+// Document access rules loaded from Rules_File. nil if not configured
+var Rules *rules.RuleSet
+
+// Load the rules. A broken rule file stops the server instead of leaving documents unprotected
+func _InitRules() {
+	if Rules_File == "" {
+		return
+	}
+
+	RS, err := rules.ParseFile(Rules_File)
+	if err != nil {
+		panic("can't load rules from " + Rules_File + " : " + err.Error())
+	}
+
+	Rules = RS
+	Print("Loaded " + Rules_File)
+}
+
+// Guard checking the rules for the caller of the API call
+func (c *APICall) rulesGuard(RS *rules.RuleSet) moncore.AccessGuard {
+	return func(R *moncore.AccessRequest) bool {
+		request := map[string]interface{}{
+			"op":     R.Op,
+			"method": c.Method(),
+			"doc":    R.Incoming,
+			"params": c.namedParams,
+		}
+
+		var resource interface{}
+		if R.Resource != nil {
+			resource = map[string]interface{}{"id": R.ID, "doc": R.Resource}
+		}
+
+		D := RS.Check(R.Op, R.Database+"/"+R.Collection+"/"+R.ID, map[string]interface{}{
+			"auth":     c.ruleAuth(),
+			"request":  request,
+			"resource": resource,
+		})
+		for _, err := range D.Errors {
+			PrintErrorMsg("Rules : ", err)
+		}
+		return D.Allowed
+	}
+}
+
+// The caller as seen by rules : {uid, type, roles}, or nil if anonymous
+func (c *APICall) ruleAuth() interface{} {
+	if c.APIKey != nil {
+		return map[string]interface{}{"uid": c.APIKey.ID, "type": "key", "roles": []string{}, "scopes": c.APIKey.Scopes}
+	}
+	if U := c.User(); U != nil {
+		return map[string]interface{}{"uid": U.Username, "type": "user", "roles": U.Roles}
+	}
+	return nil
+}
@@ -3,15 +3,18 @@ package endpoints
 // Self-contained handler for API calls.
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 )
 
-// You can use API_Call_Handler_*** templates to easily define API endpoints.
-// First regex match in the order of the array will be used, and no other matches will be attempted.
+// You can use API_Call_Handler_*** templates or API_Route to easily define API endpoints.
+// First match (path and method) in the order of the array will be used, and no other matches will be attempted.
 //
 // URL paths are standardised to start without a slash and end with a slash before matching.
 // For example, "https://api.example.com/api/user/123" will become "api/user/123/" before matching.
@@ -24,6 +27,11 @@ var API_Endpoints []API_Call_Handler = []API_Call_Handler{}
 var API_Not_Found_Handler func(*APICall)
 
 // ServeRequest is the main entry point for the API.
+//
+// Routes that match the path but not the method are skipped. If the path matched only such routes,
+// OPTIONS requests are answered with the Allow header and other methods get 405 Method Not Allowed.
+//
+// Every request goes through API_Middlewares, matched routes also through their group and route middlewares.
 func ServeRequest(w http.ResponseWriter, r *http.Request) {
 
 	// Standarize the path
@@ -32,28 +40,52 @@ func ServeRequest(w http.ResponseWriter, r *http.Request) {
 		urlpath += "/"
 	}
 
-	for _, handler := range API_Endpoints {
-		rmatches := handler.Path.FindAllStringSubmatch(urlpath, 1)
-		if len(rmatches) == 0 || len(rmatches[0]) == 0 || handler.Handler == nil {
-			continue
-		}
+	rw := &responseWriter{ResponseWriter: w}
+	var writer http.ResponseWriter = rw
+
+	C := &APICall{HTTPWriter: &writer, HTTPRequest: r, Path: urlpath, Params: nil, recorder: rw, Started: time.Now()}
 
+	handler, rmatches, allowed := defaultRouter.match(r.Method, urlpath)
+
+	var final Handler
+
+	if handler != nil {
 		// We have a match, so call the handler
-		pathParams := rmatches[0][1:]
-		C := &APICall{HTTPWriter: &w, HTTPRequest: r, Path: urlpath, Params: pathParams}
-		handler.Handler(C)
+		C.Params = rmatches[1:]
+		C.Route = handler
+		C.namedParams = handler.namedParams(rmatches)
+		final = Chain(stampHandler(handler.Handler), handler.Middlewares...)
 
-		return
-	}
+	} else if len(allowed) != 0 {
+		final = methodNotAllowedHandler(allowed)
+
+	} else if API_Not_Found_Handler != nil {
+		// If we get here, we didn't find a handler
+		final = API_Not_Found_Handler
 
-	// If we get here, we didn't find a handler
-	if API_Not_Found_Handler != nil {
-		C := &APICall{HTTPWriter: &w, HTTPRequest: r, Path: urlpath, Params: nil}
-		API_Not_Found_Handler(C)
 	} else {
-		http.NotFound(w, r)
+		final = func(C *APICall) { C.Fail(NewAPIError(CodeNotFound, "no route for "+C.Method()+" /"+C.Path)) }
 	}
 
+	Chain(final, API_Middlewares...)(C)
+
+}
+
+// Answers OPTIONS with the Allow header, other methods with 405 Method Not Allowed
+func methodNotAllowedHandler(allowed []string) Handler {
+	return func(C *APICall) {
+		if contains(allowed, http.MethodGet) {
+			allowed = appendMissing(allowed, http.MethodHead)
+		}
+		allowed = appendMissing(allowed, http.MethodOptions)
+		C.SetHeader("Allow", strings.Join(allowed, ", "))
+
+		if C.Method() == http.MethodOptions {
+			C.WriteStatus(http.StatusNoContent)
+		} else {
+			C.Fail(NewAPIError(CodeMethodNotAllowed, C.Method()+" is not allowed on /"+C.Path))
+		}
+	}
 }
 
 type APICall struct {
@@ -66,6 +98,36 @@ type APICall struct {
 	// Parameters in the path. Created from submatches of regexp from hanlder (see API_Call_Handler).
 	// Will be empty if no parameters were used.
 	Params []string
+
+	// Matched route. nil for API_Not_Found_Handler
+	Route *API_Call_Handler
+
+	// Request ID, set by the RequestID middleware
+	RequestID string
+
+	// Arrival time of the request
+	Started time.Time
+
+	// API key used for the call, set by the Authorize middleware
+	APIKey *APIKey
+
+	// Permissions of the caller, set by the Authorize middleware. nil if authorization is disabled
+	Access *Access
+
+	namedParams map[string]string
+	recorder    *responseWriter
+	bodyErr     error
+	quotaUnits  int64
+
+	handlerStarted time.Time
+	timings        []ServerTiming
+
+	userLoaded bool
+	user       *User
+	claims     *JWTClaims
+
+	sessionLoaded bool
+	session       *Session
 }
 
 type API_Call_Handler struct {
@@ -75,8 +137,27 @@ type API_Call_Handler struct {
 
 	// Path is the regex that will be used to match the path.
 	// Captured regex groups (Submatches) will be passed to the APICall handler as Params.
+	// Named groups (and {placeholders} of API_Route) are also available through APICall.Param().
 	// Path must not begin with a slash.
 	Path *regexp.Regexp
+
+	// HTTP methods accepted by the route. Empty means any method.
+	Methods []string
+
+	// Path template used by API_Route (like "mini/ls/{db}/"). Empty for regex routes.
+	Pattern string
+
+	// Route middlewares (including the ones of its API_Group). First one is the outermost
+	Middlewares []Middleware
+
+	// What the route does (ActionList, ActionGet, ...), used for permission checks
+	Action string
+
+	// Rate limit tokens taken by a request. 0 uses the cost of the action (see RouteCosts)
+	Cost float64
+
+	// Documentation of the route for the OpenAPI document. See Describe()
+	Doc *RouteDoc
 }
 
 // Method specifies the HTTP method (GET, POST, PUT, etc.).
@@ -85,30 +166,39 @@ func (c *APICall) Method() string {
 	return c.HTTPRequest.Method
 }
 
-// Request body
+// Request body. nil if it can't be read, see BodyError()
 func (c *APICall) Body() []byte {
 
 	body, err := ioutil.ReadAll(c.HTTPRequest.Body)
 	if err != nil {
 		PrintErrorMsg("Error reading body: ", err)
-		http.Error(*c.HTTPWriter, "can't read body", http.StatusBadRequest)
+		c.bodyErr = err
 		return nil
 	}
 
 	return body
 }
 
+// Why the body couldn't be read (like *http.MaxBytesError over BodyLimit), or nil
+func (c *APICall) BodyError() error {
+	return c.bodyErr
+}
+
 // Request body as string
 func (c *APICall) BodyToString() string {
 	return string(c.Body())
 }
 
-// Deserializing request body to struct type
-func (c *APICall) BodyJsonToStruct(Type interface{}) {
-	if err := json.Unmarshal(c.Body(), Type); err != nil {
-		PrintErrorMsg("Error unmarshaling body: ", err)
-		c.WriteError("Error deserializing body", err, http.StatusUnprocessableEntity)
+// Deserializing request body to struct type. Nothing is written, report errors with Fail()
+func (c *APICall) BodyJsonToStruct(Type interface{}) error {
+	body := c.Body()
+	if body == nil && c.bodyErr != nil {
+		return invalidBody(c.bodyErr)
+	}
+	if err := json.Unmarshal(body, Type); err != nil {
+		return NewAPIError(CodeInvalidBody, "can't decode JSON body : "+err.Error())
 	}
+	return nil
 }
 
 // Write binary to response
@@ -121,11 +211,16 @@ func (c *APICall) WriteString(S string) {
 	c.Write([]byte(S))
 }
 
-// Set HTML Content-Type header to response and write <html> tag to response
+// Set HTML Content-Type header to response and write <html> tag to response.
+// The CSRF token of the current session, if any, goes in <meta name="csrf-token">. No session is started :
+// pages with forms call CSRFField() (or CSRFToken()) before writing the body
 func (c *APICall) HTMLBegin() {
 	c.SetHeader("Content-Type", "text/html; charset=utf-8")
 	c.WriteString("<!DOCTYPE html>")
 	c.WriteString("<html>")
+	if S := c.CurrentSession(); S != nil {
+		c.WriteString(`<head><meta name="csrf-token" content="` + S.CSRF + `"></head>`)
+	}
 }
 
 // Write </html> tags to response
@@ -137,8 +232,7 @@ func (c *APICall) HTMLEnd() {
 func (c *APICall) WriteJSON(Obj interface{}) {
 	J, JErr := json.Marshal(Obj)
 	if JErr != nil {
-		PrintErrorMsg("Error marshalling JSON: ", JErr)
-		c.WriteError("Error Writing to JSON ", JErr, http.StatusUnprocessableEntity)
+		c.Fail(NewAPIError(CodeUnprocessable, "can't write JSON : "+JErr.Error()))
 		return
 	}
 
@@ -149,14 +243,35 @@ func (c *APICall) WriteJSON(Obj interface{}) {
 func (c *APICall) WriteJSONBeautified(Obj interface{}) {
 	J, JErr := json.MarshalIndent(Obj, "", "  ")
 	if JErr != nil {
-		PrintErrorMsg("Error marshalling JSON: ", JErr)
-		c.WriteError("Error Writing to JSON ", JErr, http.StatusUnprocessableEntity)
+		c.Fail(NewAPIError(CodeUnprocessable, "can't write JSON : "+JErr.Error()))
 		return
 	}
 
 	c.Write(J)
 }
 
+// Flush buffered response data to the client, if supported by the writer
+func (c *APICall) Flush() {
+	if f, ok := (*c.HTTPWriter).(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Request context. Cancelled when the client goes away
+func (c *APICall) Context() context.Context {
+	return c.HTTPRequest.Context()
+}
+
+// Check if the client accepts the given media type (like "application/x-ndjson")
+func (c *APICall) Accepts(mediaType string) bool {
+	for _, part := range strings.Split(c.GetHeader("Accept"), ",") {
+		if strings.EqualFold(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]), mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
 // Write http.Status___ code to response
 func (c *APICall) WriteStatus(HttpStatusCode int) {
 	(*c.HTTPWriter).WriteHeader(HttpStatusCode)
@@ -172,10 +287,14 @@ func (c *APICall) SetHeader(key string, value string) {
 	(*c.HTTPWriter).Header().Set(key, value)
 }
 
-// Write error to response
+// Write error to response as the JSON error envelope, with the default code of the status.
+// *APIError errors are written as they are. See Fail() to get the status from the error
 func (c *APICall) WriteError(PrefixMsg string, Err error, HttpStatusCode int) {
-	PrintErrorMsg("WriteError: ", Err)
-	http.Error(*c.HTTPWriter, PrefixMsg+Err.Error(), HttpStatusCode)
+	var E *APIError
+	if !errors.As(Err, &E) {
+		E = &APIError{Status: HttpStatusCode, Code: codeForStatus(HttpStatusCode), Message: errorMessage(PrefixMsg, Err, HttpStatusCode)}
+	}
+	c.WriteAPIError(E)
 }
 
 // Get cookie from request
@@ -187,12 +306,35 @@ func (c *APICall) GetCookie(key string) string {
 	return cookie.Value
 }
 
-// Set cookie to response
+// Set a session cookie to response. HttpOnly, SameSite=Lax, and Secure unless Secure_Cookies is off on plain HTTP
 func (c *APICall) SetCookie(key string, value string) {
-	http.SetCookie(*c.HTTPWriter, &http.Cookie{
-		Name:  key,
-		Value: value,
-	})
+	c.SetCookieWithAge(key, value, 0)
+}
+
+// Set cookie to response, expiring after MaxAge. 0 means a session cookie, negative deletes the cookie
+func (c *APICall) SetCookieWithAge(key string, value string, MaxAge time.Duration) {
+	cookie := &http.Cookie{
+		Name:     key,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   Secure_Cookies || c.HTTPRequest.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if MaxAge < 0 {
+		cookie.MaxAge = -1
+	} else if MaxAge > 0 {
+		cookie.MaxAge = int(MaxAge.Seconds())
+		cookie.Expires = time.Now().Add(MaxAge).UTC()
+	}
+
+	http.SetCookie(*c.HTTPWriter, cookie)
+}
+
+// Remove a cookie from the client
+func (c *APICall) DeleteCookie(key string) {
+	c.SetCookieWithAge(key, "", -1)
 }
 
 // Creates case insensitive Regex matcher begining with 'prefix'. Prefix is also considered as a regex.
@@ -200,7 +342,7 @@ func (c *APICall) SetCookie(key string, value string) {
 // For example, "api/auth/" is a valid path prefix.
 func API_Call_Handler_Prefix(prefix string, Handler func(*APICall)) API_Call_Handler {
 	r := regexp.MustCompile(`(?i)^` + prefix)
-	return API_Call_Handler{Handler, r}
+	return API_Call_Handler{Handler: Handler, Path: r}
 }
 
 // Creates case insensitive Regex matcher containing only 'path'. Path is also considered as a regex.
@@ -208,5 +350,5 @@ func API_Call_Handler_Prefix(prefix string, Handler func(*APICall)) API_Call_Han
 // For example, "api/auth/" is a valid path.
 func API_Call_Handler_Exact(path string, Handler func(*APICall)) API_Call_Handler {
 	r := regexp.MustCompile(`(?i)^` + path + `$`)
-	return API_Call_Handler{Handler, r}
+	return API_Call_Handler{Handler: Handler, Path: r}
 }
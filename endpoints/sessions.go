@@ -0,0 +1,345 @@
+package endpoints
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"mongomini/agra/moncore"
+)
+
+var (
+	SessionsCollectionName string = "sessions"
+
+	SessionCookieName string        = "mm_session"
+	SessionTTL        time.Duration = 24 * time.Hour // Idle lifetime. Every SaveSession() extends it
+
+	CSRFHeaderName string = "X-CSRF-Token"
+	CSRFFieldName  string = "csrf_token"
+)
+
+// Server side session. Stored in moncore.SystemDBName/SessionsCollectionName, the cookie only holds the signed ID
+type Session struct {
+	ID            string                 `bson:"-" json:"-"`
+	User          string                 `bson:"User" json:"user,omitempty"` // Signed in user, "" if anonymous
+	Authenticated time.Time              `bson:"Authenticated" json:"-"`     // Time of sign in
+	TokenVersion  int64                  `bson:"TokenVersion" json:"-"`      // TokenVersion of the user at sign in. Password changes invalidate older sessions
+	Data          map[string]interface{} `bson:"Data" json:"data"`
+	CSRF          string                 `bson:"CSRF" json:"-"`
+	Created       time.Time              `bson:"Created" json:"created"`
+	Expires       time.Time              `bson:"Expires" json:"expires"`
+
+	stored bool
+}
+
+func sessions() *moncore.TypedCollection[Session] {
+	return moncore.Typed[Session](Moncore.Database(moncore.SystemDBName).Collection(SessionsCollectionName))
+}
+
+// Create the TTL index so expired sessions clean themselves up
+func _InitSessions() {
+	PrintError(sessions().EnsureTTLIndex("Expires", 0))
+
+	if len(Session_Secret) == 0 {
+		// Cookies signed by one instance must be accepted by the others
+		if Require_Auth {
+			panic("Session_Secret must be set when Require_Auth is on, so every instance accepts the session cookies of the others")
+		}
+		Print("Session_Secret is not set. Using a random secret, sessions won't survive restarts")
+		Session_Secret = []byte(randomToken(32))
+	}
+}
+
+// Sign a cookie value with Session_Secret : "<value>.<signature>"
+func SignCookieValue(Value string) string {
+	mac := hmac.New(sha256.New, Session_Secret)
+	mac.Write([]byte(Value))
+	return Value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Check a signed cookie value. Returns the value, or false if the signature is wrong
+func VerifyCookieValue(Signed string) (string, bool) {
+	i := strings.LastIndexByte(Signed, '.')
+	if i < 0 {
+		return "", false
+	}
+	if !hmac.Equal([]byte(SignCookieValue(Signed[:i])), []byte(Signed)) {
+		return "", false
+	}
+	return Signed[:i], true
+}
+
+// Set a cookie signed with Session_Secret
+func (c *APICall) SetSignedCookie(key string, value string, MaxAge time.Duration) {
+	c.SetCookieWithAge(key, SignCookieValue(value), MaxAge)
+}
+
+// Get a signed cookie from request. Returns "" if missing or tampered with
+func (c *APICall) GetSignedCookie(key string) string {
+	v, ok := VerifyCookieValue(c.GetCookie(key))
+	if !ok {
+		return ""
+	}
+	return v
+}
+
+// Session of the request, or nil if it has none. See Session() to start one
+func (c *APICall) CurrentSession() *Session {
+	if !c.sessionLoaded {
+		c.sessionLoaded = true
+
+		if ID := c.GetSignedCookie(SessionCookieName); ID != "" {
+			S, err := sessions().Get(ID)
+			if err == nil && time.Now().Before(S.Expires) {
+				S.ID, S.stored = ID, true
+				c.session = S
+			}
+		}
+	}
+	return c.session
+}
+
+// Session of the request. A new one is started if needed; it's stored by SaveSession()
+func (c *APICall) Session() *Session {
+	if S := c.CurrentSession(); S != nil {
+		return S
+	}
+
+	now := time.Now().UTC()
+	c.session = &Session{
+		ID:      randomToken(32),
+		Data:    map[string]interface{}{},
+		CSRF:    randomToken(32),
+		Created: now,
+		Expires: now.Add(SessionTTL),
+	}
+	return c.session
+}
+
+// Store the session and (re)send its cookie. Must be called before writing the response body
+func (c *APICall) SaveSession() error {
+	S := c.Session()
+	S.Expires = time.Now().Add(SessionTTL).UTC()
+
+	if res := sessions().Set(S.ID, *S); res.Status != 1 {
+		return errors.New("can't store session : " + res.Result)
+	}
+	S.stored = true
+
+	c.SetSignedCookie(SessionCookieName, S.ID, SessionTTL)
+	return nil
+}
+
+// Give the session a new ID and CSRF token, keeping its data. The old ID stops working.
+// Call it whenever the privileges of the session change (sign in, sign out, password change),
+// so a session ID planted or leaked before can't be used afterwards.
+func (c *APICall) RotateSession() error {
+	S := c.Session()
+	if S.stored {
+		sessions().Delete(S.ID)
+	}
+
+	S.ID = randomToken(32)
+	S.CSRF = randomToken(32)
+	S.stored = false
+	return c.SaveSession()
+}
+
+// Delete the session and its cookie
+func (c *APICall) DestroySession() {
+	if S := c.CurrentSession(); S != nil && S.stored {
+		sessions().Delete(S.ID)
+	}
+	c.session, c.sessionLoaded = nil, true
+	c.DeleteCookie(SessionCookieName)
+}
+
+// Sign the user in on the session
+func (c *APICall) StartUserSession(U *User) error {
+	S := c.Session()
+	S.User = U.Username
+	S.Authenticated = time.Now().UTC()
+	S.TokenVersion = U.TokenVersion
+	c.user, c.userLoaded = U, true
+	return c.RotateSession()
+}
+
+// User signed in on the session, or nil
+func (c *APICall) sessionUser() *User {
+	S := c.CurrentSession()
+	if S == nil || S.User == "" {
+		return nil
+	}
+
+	U, err := users().Get(S.User)
+	if err != nil || S.TokenVersion != U.TokenVersion {
+		return nil
+	}
+	return U
+}
+
+// CSRF token of the session. Starts and stores a session if needed, so it must be called before writing the response body
+func (c *APICall) CSRFToken() string {
+	S := c.Session()
+	if !S.stored && CheckError(c.SaveSession()) {
+		return ""
+	}
+	return S.CSRF
+}
+
+// Hidden <input> holding the CSRF token, for HTML forms
+func (c *APICall) CSRFField() string {
+	return `<input type="hidden" name="` + CSRFFieldName + `" value="` + html.EscapeString(c.CSRFToken()) + `">`
+}
+
+// Get a session value
+func (S *Session) Get(key string) interface{} {
+	return S.Data[key]
+}
+
+// Set a session value. Call APICall.SaveSession() to store it
+func (S *Session) Set(key string, value interface{}) {
+	if S.Data == nil {
+		S.Data = map[string]interface{}{}
+	}
+	S.Data[key] = value
+}
+
+// Remove a session value. Call APICall.SaveSession() to store it
+func (S *Session) Delete(key string) {
+	delete(S.Data, key)
+}
+
+// Reject unsafe requests (POST, PUT, DELETE, ...) made with a session cookie, unless they carry the session
+// CSRF token in the X-CSRF-Token header or the csrf_token form field.
+// Requests authenticated with an Authorization header can't be forged by browsers and are not checked.
+func CSRFProtect() Middleware {
+	return func(next Handler) Handler {
+		return func(C *APICall) {
+			if safeMethod(C.Method()) {
+				next(C)
+				return
+			}
+
+			if C.BearerToken() != "" || C.GetCookie(SessionCookieName) == "" {
+				next(C)
+				return
+			}
+
+			S := C.CurrentSession()
+			if S == nil {
+				// Expired or forged cookie : the request is anonymous
+				next(C)
+				return
+			}
+
+			token := C.GetHeader(CSRFHeaderName)
+			if token == "" {
+				token = C.formCSRFToken()
+			}
+
+			if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(S.CSRF)) != 1 {
+				C.WriteAPIError(NewAPIError(CodeCSRF, "missing or wrong CSRF token"))
+				return
+			}
+
+			next(C)
+		}
+	}
+}
+
+// Methods that CSRFProtect doesn't check
+func safeMethod(Method string) bool {
+	switch Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// Whether a session cookie may authorize the action with the method. Any page can make the browser send
+// a GET with the cookie and no CSRF token, so those requests may only read
+func sessionMayAuthorize(Method string, Action string) bool {
+	return !safeMethod(Method) || Action == ActionList || Action == ActionGet
+}
+
+// csrf_token field of a form body. The body is put back for the handler
+func (c *APICall) formCSRFToken() string {
+	mt, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mt != "application/x-www-form-urlencoded" {
+		return ""
+	}
+
+	// Only look at the beginning of the body, size limits are checked later by BodyLimit
+	orig := c.HTTPRequest.Body
+	body, err := io.ReadAll(io.LimitReader(orig, 64*1024))
+	c.HTTPRequest.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), orig), orig}
+	if err != nil {
+		return ""
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ""
+	}
+	return form.Get(CSRFFieldName)
+}
+
+type sessionInfo struct {
+	User      *User  `json:"user"`
+	CSRFToken string `json:"csrf_token,omitempty"`
+}
+
+// GET : api/auth/session. The signed in user and the CSRF token of the session.
+// Callers without a session get neither, and no session is stored for them :
+// CSRFProtect doesn't check requests without a session cookie, so they need no token until they sign in
+func API_Auth_Session(C *APICall) {
+	info := sessionInfo{User: C.sessionUser()}
+	if S := C.CurrentSession(); S != nil {
+		info.CSRFToken = S.CSRF
+	}
+	C.SetHeader("Cache-Control", "no-store")
+	C.Respond(info)
+}
+
+// POST : api/auth/session with {"username": "...", "password": "..."}. Signs in with a session cookie
+func API_Auth_Session_Login(C *APICall) {
+	req := credentials{}
+	if err := C.BodyJsonToStruct(&req); err != nil {
+		C.Fail(err)
+		return
+	}
+
+	U, err := AuthenticateUser(req.Username, req.Password)
+	if err != nil {
+		C.WriteError("Unauthorized : ", err, http.StatusUnauthorized)
+		return
+	}
+
+	if err := C.StartUserSession(U); err != nil {
+		C.WriteError("Internal Server Error : ", err, http.StatusInternalServerError)
+		return
+	}
+
+	C.SetHeader("Cache-Control", "no-store")
+	C.Respond(sessionInfo{User: U, CSRFToken: C.Session().CSRF})
+}
+
+// DELETE : api/auth/session. Signs out
+func API_Auth_Session_Logout(C *APICall) {
+	C.DestroySession()
+	C.WriteStatus(http.StatusNoContent)
+}
@@ -0,0 +1,25 @@
+package endpoints
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAnonymousSessionInfo(t *testing.T) {
+	withRoutes(t, []API_Call_Handler{API_GET(`session/`, API_Auth_Session)})
+
+	w := httptest.NewRecorder()
+	ServeRequest(w, httptest.NewRequest(http.MethodGet, "/session", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if cookie := w.Header().Get("Set-Cookie"); cookie != "" {
+		t.Fatalf("anonymous caller got a session : %s", cookie)
+	}
+	if strings.Contains(w.Body.String(), "csrf_token") {
+		t.Fatalf("anonymous caller got a CSRF token : %s", w.Body.String())
+	}
+}
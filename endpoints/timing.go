@@ -0,0 +1,72 @@
+package endpoints
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A metric of the Server-Timing header
+type ServerTiming struct {
+	Name        string
+	Duration    time.Duration
+	Description string
+}
+
+// Add a metric to the Server-Timing header sent by Respond()
+func (c *APICall) AddTiming(Name string, D time.Duration, Description string) {
+	c.timings = append(c.timings, ServerTiming{Name, D, Description})
+}
+
+// Start timing a step, the returned function stops it and adds it with AddTiming() :
+//
+//	stop := C.StartTiming("db", "Database")
+//	docs, err := Col.Find(F)
+//	stop()
+func (c *APICall) StartTiming(Name string, Description string) func() {
+	start := time.Now()
+	return func() { c.AddTiming(Name, time.Since(start), Description) }
+}
+
+// Total duration of the metrics named Name
+func (c *APICall) Timing(Name string) time.Duration {
+	var total time.Duration
+	for _, T := range c.timings {
+		if T.Name == Name {
+			total += T.Duration
+		}
+	}
+	return total
+}
+
+// Time from the arrival of the request to the start of the route handler (routing and middlewares)
+func (c *APICall) RoutingTime() time.Duration {
+	if c.handlerStarted.IsZero() {
+		return 0
+	}
+	return c.handlerStarted.Sub(c.Started)
+}
+
+// Mark the start of the route handler, for RoutingTime()
+func stampHandler(H Handler) Handler {
+	return func(C *APICall) {
+		C.handlerStarted = time.Now()
+		H(C)
+	}
+}
+
+// Server-Timing header value : routing time, added metrics, then extra ones
+func (c *APICall) serverTiming(Extra ...ServerTiming) string {
+	all := append([]ServerTiming{{"routing", c.RoutingTime(), "Routing and middlewares"}}, c.timings...)
+	all = append(all, Extra...)
+
+	parts := make([]string, 0, len(all))
+	for _, T := range all {
+		part := T.Name + ";dur=" + strconv.FormatFloat(float64(T.Duration)/float64(time.Millisecond), 'f', 3, 64)
+		if T.Description != "" {
+			part += `;desc="` + T.Description + `"`
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,137 @@
+package endpoints
+
+import (
+	"strconv"
+	"strings"
+
+	"mongomini/agra/moncore"
+)
+
+// Query parameters of mini/export/{db}/{collection} that are options, not filters
+var ExportQueryParams = []string{"format", "fields", "limit"}
+
+// Content-Type of each transfer format
+var TransferContentTypes = map[string]string{
+	moncore.TransferNDJSON: "application/x-ndjson",
+	moncore.TransferJSON:   "application/json",
+	moncore.TransferCSV:    "text/csv; charset=utf-8",
+	moncore.TransferBSON:   "application/bson",
+}
+
+// Transfer format of a query parameter, or of the Content-Type of the body for imports
+func transferFormat(Format string, ContentType string) (string, error) {
+	if Format == "" {
+		mediaType := strings.TrimSpace(strings.SplitN(ContentType, ";", 2)[0])
+		for f, t := range TransferContentTypes {
+			if strings.SplitN(t, ";", 2)[0] == mediaType {
+				return f, nil
+			}
+		}
+		return moncore.TransferNDJSON, nil
+	}
+	if _, ok := TransferContentTypes[Format]; !ok {
+		return "", NewAPIError(CodeBadRequest, "transfer format must be ndjson, json, csv or bson")
+	}
+	return Format, nil
+}
+
+// GET : mini/export/{db}/{collection}?format=ndjson|json|csv|bson&fields=&limit= with filters like mini/ls.
+// The export is streamed as a download, each document counts as a read for the daily quota. Without fields, CSV columns are _id and the top-level fields of the first document :
+// fields missing from it are left out of the whole export (and logged)
+func API_Export_Collection(C *APICall) {
+
+	Q := C.HTTPRequest.URL.Query()
+
+	format, err := transferFormat(Q.Get("format"), "")
+	if err != nil {
+		C.Fail(err)
+		return
+	}
+	opts := &moncore.ExportOptions{Format: format}
+
+	if v := Q.Get("fields"); v != "" {
+		if opts.Fields, err = moncore.ParseFieldMappings(v); err != nil {
+			C.Fail(NewAPIError(CodeBadRequest, err.Error()))
+			return
+		}
+	}
+	if v := Q.Get("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			C.Fail(NewAPIError(CodeBadRequest, "limit must be a positive integer"))
+			return
+		}
+		opts.Limit = n
+	}
+
+	for _, p := range ExportQueryParams {
+		Q.Del(p)
+	}
+	opts.Filter = moncore.Filter_FromQueryStrings(Q)
+
+	Col := C.Collection(C.Param("db"), C.Param("collection"))
+
+	C.SetHeader("Content-Type", TransferContentTypes[format])
+	C.SetHeader("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(Col.Name(), `"`, "_")+"."+format+`"`)
+	C.SetHeader("X-Content-Type-Options", "nosniff")
+
+	n, err := Col.Export(C.Context(), *C.HTTPWriter, opts)
+	C.ChargeQuota(n)
+
+	// Once the download started, failures can only cut it short (and be logged)
+	if err != nil && C.Context().Err() == nil {
+		(*C.HTTPWriter).Header().Del("Content-Disposition")
+		C.Fail(err)
+	}
+}
+
+// POST, PUT : mini/import/{db}/{collection}?input=&mode=upsert|replace|insert&batch=&fields=&keep_going with the documents as body.
+// The input format defaults to the Content-Type of the body (?format= stays the response format). When the import stops on an error,
+// the documents written until then are in the details of the error as an ImportResult. Each document written counts as a write for the daily quota
+func API_Import_Collection(C *APICall) {
+
+	Q := C.HTTPRequest.URL.Query()
+
+	format, err := transferFormat(Q.Get("input"), C.GetHeader("Content-Type"))
+	if err != nil {
+		C.Fail(err)
+		return
+	}
+	opts := &moncore.ImportOptions{Format: format, Mode: Q.Get("mode"), KeepGoing: queryFlag(Q, "keep_going")}
+
+	switch opts.Mode {
+	case "", moncore.ImportUpsert, moncore.ImportReplace, moncore.ImportInsert:
+	default:
+		C.Fail(NewAPIError(CodeBadRequest, "mode must be upsert, replace or insert"))
+		return
+	}
+	if v := Q.Get("fields"); v != "" {
+		if opts.Fields, err = moncore.ParseFieldMappings(v); err != nil {
+			C.Fail(NewAPIError(CodeBadRequest, err.Error()))
+			return
+		}
+	}
+	if v := Q.Get("batch"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 10000 {
+			C.Fail(NewAPIError(CodeBadRequest, "batch must be an integer between 1 and 10000"))
+			return
+		}
+		opts.BatchSize = n
+	}
+
+	Res, err := C.Collection(C.Param("db"), C.Param("collection")).Import(C.Context(), C.HTTPRequest.Body, opts)
+	if Res != nil {
+		C.ChargeQuota(Res.Inserted + Res.Updated)
+	}
+	if err != nil {
+		E := ErrorFor(err)
+		if Res != nil && Res.Read != 0 {
+			E.Details = Res
+		}
+		C.WriteAPIError(E)
+		return
+	}
+
+	C.Respond(Res)
+}
@@ -0,0 +1,315 @@
+package endpoints
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+
+	"mongomini/agra/moncore"
+)
+
+var (
+	UsersCollectionName         string = "users"
+	RefreshTokensCollectionName string = "refresh_tokens"
+	RevokedTokensCollectionName string = "revoked_tokens"
+
+	AccessTokenTTL  time.Duration = 15 * time.Minute
+	RefreshTokenTTL time.Duration = 30 * 24 * time.Hour
+
+	MinPasswordLength int = 8
+)
+
+var validUsername = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{2,63}$`)
+
+var (
+	ErrBadCredentials = errors.New("wrong username or password")
+	ErrUserExists     = errors.New("username is taken")
+	ErrBadToken       = errors.New("invalid or expired token")
+	ErrUserChanged    = errors.New("the account was changed meanwhile, try again")
+)
+
+// User account. Stored in moncore.SystemDBName/UsersCollectionName with the username as ID
+type User struct {
+	Username     string    `bson:"Username" json:"username"`
+	PasswordHash string    `bson:"PasswordHash" json:"-"` // bcrypt
+	Roles        []string  `bson:"Roles" json:"roles"`
+	Created      time.Time `bson:"Created" json:"created"`
+	TokenVersion int64     `bson:"TokenVersion" json:"-"` // Incremented by password changes. Tokens and sessions of other versions are rejected
+}
+
+// Refresh token record. The token itself is rt_<ID>_<secret>, only its hash is stored
+type refreshToken struct {
+	User    string    `bson:"User"`
+	Hash    string    `bson:"Hash"`
+	Family  string    `bson:"Family"`  // Tokens rotated from the same login. Reusing a rotated token revokes the family
+	Version int64     `bson:"Version"` // TokenVersion of the user
+	Used    bool      `bson:"Used"`
+	Expires time.Time `bson:"Expires"`
+}
+
+type revokedToken struct {
+	Expires time.Time `bson:"Expires"`
+}
+
+// Tokens returned by login and refresh
+type AuthTokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+const refreshTokenPrefix = "rt_"
+
+func users() *moncore.TypedCollection[User] {
+	return moncore.Typed[User](Moncore.Database(moncore.SystemDBName).Collection(UsersCollectionName))
+}
+
+func refreshTokens() *moncore.TypedCollection[refreshToken] {
+	return moncore.Typed[refreshToken](Moncore.Database(moncore.SystemDBName).Collection(RefreshTokensCollectionName))
+}
+
+func revokedTokens() *moncore.TypedCollection[revokedToken] {
+	return moncore.Typed[revokedToken](Moncore.Database(moncore.SystemDBName).Collection(RevokedTokensCollectionName))
+}
+
+// Create TTL indexes so expired refresh tokens and revocations clean themselves up
+func _InitUsers() {
+	PrintError(refreshTokens().EnsureTTLIndex("Expires", 0))
+	PrintError(revokedTokens().EnsureTTLIndex("Expires", 0))
+
+	if len(JWT_Secret) == 0 {
+		// Instances behind a load balancer (or serverless ones) would each sign with their own key
+		if Require_Auth {
+			panic("JWT_Secret must be set when Require_Auth is on, so every instance verifies the tokens of the others")
+		}
+		Print("JWT_Secret is not set. Using a random secret, tokens won't survive restarts")
+		JWT_Secret = []byte(randomToken(32))
+	}
+}
+
+func validatePassword(Password string) error {
+	if len(Password) < MinPasswordLength {
+		return errors.New("password is too short")
+	}
+	if len(Password) > 72 {
+		return errors.New("password is too long") // bcrypt limit
+	}
+	return nil
+}
+
+// Create a user account
+func RegisterUser(Username string, Password string) (*User, error) {
+	Username = strings.ToLower(Username)
+	if !validUsername.MatchString(Username) {
+		return nil, errors.New("username must be 3-64 characters of a-z, 0-9, '.', '_' or '-'")
+	}
+	if err := validatePassword(Password); err != nil {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	U := User{Username: Username, PasswordHash: string(hash), Roles: []string{}, Created: time.Now().UTC()}
+	if res := users().Insert(Username, U); res.Status == http.StatusConflict {
+		return nil, ErrUserExists
+	} else if res.Status != 1 {
+		return nil, errors.New("can't store user : " + res.Result)
+	}
+	return &U, nil
+}
+
+// Check username and password
+func AuthenticateUser(Username string, Password string) (*User, error) {
+	U, err := users().Get(strings.ToLower(Username))
+	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(Password)) // same timing as a wrong password
+		return nil, ErrBadCredentials
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(U.PasswordHash), []byte(Password)) != nil {
+		return nil, ErrBadCredentials
+	}
+	return U, nil
+}
+
+// bcrypt hash compared against when the user doesn't exist
+var dummyHash = []byte("$2a$10$Tnv9m/1lSC5OVIdjPHy.pOiAMYTnUW0owPsuUVdroIdd86bAOA4x2")
+
+// Change the password. Existing access and refresh tokens stop working
+func ChangePassword(Username string, Current string, New string) error {
+	U, err := AuthenticateUser(Username, Current)
+	if err != nil {
+		return err
+	}
+	if err := validatePassword(New); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(New), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	// Conditional writes, so concurrent changes of the user (like its roles) aren't overwritten and of two concurrent
+	// password changes only one succeeds. The version goes first : if the hash can't be stored, old tokens are rejected anyway
+	if res := users().CompareAndSetField(U.Username, "TokenVersion", U.TokenVersion, U.TokenVersion+1); res.Status == http.StatusConflict {
+		return ErrUserChanged
+	} else if res.Status != 1 {
+		return errors.New("can't store user : " + res.Result)
+	}
+	if res := users().CompareAndSetField(U.Username, "PasswordHash", U.PasswordHash, string(hash)); res.Status == http.StatusConflict {
+		return ErrUserChanged
+	} else if res.Status != 1 {
+		return errors.New("can't store user : " + res.Result)
+	}
+
+	_, err = refreshTokens().DeleteMatching(moncore.Filter_MatchAll().Add("User", moncore.Filterlet_new().Equals(U.Username)))
+	return err
+}
+
+// Issue an access token and a new refresh token family
+func IssueTokens(U *User) (*AuthTokens, error) {
+	return issueTokens(U, primitive.NewObjectID().Hex())
+}
+
+func issueTokens(U *User, Family string) (*AuthTokens, error) {
+	now := time.Now()
+
+	access, err := SignJWT(&JWTClaims{
+		Issuer:   "mongomini",
+		Subject:  U.Username,
+		ID:       randomToken(12),
+		IssuedAt: now.Unix(),
+		Expires:  now.Add(AccessTokenTTL).Unix(),
+		Version:  U.TokenVersion,
+	}, JWT_Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	ID, secret := primitive.NewObjectID().Hex(), randomToken(32)
+	rt := refreshToken{User: U.Username, Hash: hashSecret(secret), Family: Family, Version: U.TokenVersion, Expires: now.Add(RefreshTokenTTL).UTC()}
+	if res := refreshTokens().Set(ID, rt); res.Status != 1 {
+		return nil, errors.New("can't store refresh token : " + res.Result)
+	}
+
+	return &AuthTokens{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(AccessTokenTTL.Seconds()),
+		RefreshToken: refreshTokenPrefix + ID + "_" + secret,
+	}, nil
+}
+
+func parseRefreshToken(Token string) (string, *refreshToken, error) {
+	rest, ok := strings.CutPrefix(Token, refreshTokenPrefix)
+	if !ok {
+		return "", nil, ErrBadToken
+	}
+	ID, secret, ok := strings.Cut(rest, "_")
+	if !ok {
+		return "", nil, ErrBadToken
+	}
+
+	rt, err := refreshTokens().Get(ID)
+	if err != nil || time.Now().After(rt.Expires) {
+		return "", nil, ErrBadToken
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(rt.Hash)) != 1 {
+		return "", nil, ErrBadToken
+	}
+	return ID, rt, nil
+}
+
+// Exchange a refresh token for new tokens. The refresh token can't be used again;
+// if it is, its whole family is revoked (the token was probably stolen).
+func RefreshTokens(Token string) (*AuthTokens, error) {
+	ID, rt, err := parseRefreshToken(Token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Marked used only if it wasn't already, so two concurrent refreshes can't both succeed
+	if res := refreshTokens().CompareAndSetField(ID, "Used", false, true); res.Status == http.StatusConflict {
+		revokeFamily(rt.Family)
+		return nil, ErrBadToken
+	} else if res.Status != 1 {
+		return nil, errors.New("can't store refresh token : " + res.Result)
+	}
+
+	// A password change stores the user before deleting refresh tokens : this one may be of the previous version
+	U, err := users().Get(rt.User)
+	if err != nil || U.TokenVersion != rt.Version {
+		return nil, ErrBadToken
+	}
+	return issueTokens(U, rt.Family)
+}
+
+func revokeFamily(Family string) {
+	_, err := refreshTokens().DeleteMatching(moncore.Filter_MatchAll().Add("Family", moncore.Filterlet_new().Equals(Family)))
+	PrintError(err)
+}
+
+// Revoke an access token (until it expires) and the refresh token family, if given
+func Logout(Claims *JWTClaims, RefreshToken string) {
+	revokedTokens().Set(Claims.ID, revokedToken{Expires: time.Unix(Claims.Expires, 0).UTC()})
+
+	if RefreshToken != "" {
+		if _, rt, err := parseRefreshToken(RefreshToken); err == nil && rt.User == Claims.Subject {
+			revokeFamily(rt.Family)
+		}
+	}
+}
+
+// Verify an access token: signature, expiry, revocation and password changes
+func VerifyAccessToken(Token string) (*User, *JWTClaims, error) {
+	claims, err := VerifyJWT(Token, JWT_Secret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only a missing revocation lets the token through, database errors don't
+	if _, err := revokedTokens().Get(claims.ID); err == nil {
+		return nil, nil, ErrBadToken
+	} else if err != moncore.ErrNotFound {
+		return nil, nil, err
+	}
+
+	U, err := users().Get(claims.Subject)
+	if err != nil {
+		return nil, nil, ErrBadToken
+	}
+	if claims.Version != U.TokenVersion {
+		return nil, nil, ErrBadToken
+	}
+
+	return U, claims, nil
+}
+
+// Authenticated user of the call (from an "Authorization: Bearer <access token>" header, or the session cookie), or nil
+func (c *APICall) User() *User {
+	if !c.userLoaded {
+		c.userLoaded = true
+
+		token := c.BearerToken()
+		if token != "" && !strings.HasPrefix(token, apiKeyPrefix) {
+			U, claims, err := VerifyAccessToken(token)
+			if err == nil {
+				c.user, c.claims = U, claims
+			}
+		} else if token == "" {
+			c.user = c.sessionUser()
+		}
+	}
+	return c.user
+}
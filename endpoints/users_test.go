@@ -0,0 +1,111 @@
+package endpoints
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"mongomini/agra/moncore"
+)
+
+// Connect to MongoDB like InitAll, from the environment (Mongo_host, ...), with system collections of the test
+func withMongoDB(t *testing.T) {
+	if os.Getenv("Mongo_host") == "" {
+		t.Skip("needs MongoDB : set Mongo_host (and Mongo_user, Mongo_pass, ...) like for the server")
+	}
+	if Moncore == nil {
+		_InitEnvArgs()
+		_InitMongoDB()
+	}
+
+	names := []*string{&UsersCollectionName, &RefreshTokensCollectionName, &RevokedTokensCollectionName, &APIKeysCollectionName}
+	saved := make([]string, len(names))
+	suffix := "_test_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	for i, name := range names {
+		saved[i] = *name
+		*name += suffix
+	}
+	savedSecret := JWT_Secret
+	JWT_Secret = []byte("test secret")
+
+	t.Cleanup(func() {
+		for i, name := range names {
+			Moncore.Database(moncore.SystemDBName).Collection(*name).MC.Drop(context.Background())
+			*name = saved[i]
+		}
+		JWT_Secret = savedSecret
+	})
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	withMongoDB(t)
+
+	U, err := RegisterUser("alice", "correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := IssueTokens(U)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := RefreshTokens(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reusing a rotated token revokes the whole family, the stolen copy and the legitimate one alike
+	if _, err := RefreshTokens(first.RefreshToken); err != ErrBadToken {
+		t.Fatalf("reuse : err = %v, want ErrBadToken", err)
+	}
+	if _, err := RefreshTokens(second.RefreshToken); err != ErrBadToken {
+		t.Fatalf("refresh after reuse : err = %v, want ErrBadToken", err)
+	}
+
+	// Other families are kept
+	other, _ := IssueTokens(U)
+	if _, err := RefreshTokens(other.RefreshToken); err != nil {
+		t.Fatalf("other family : %v", err)
+	}
+}
+
+func TestChangePasswordInvalidatesTokens(t *testing.T) {
+	withMongoDB(t)
+
+	U, err := RegisterUser("bob", "correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := IssueTokens(U)
+	if _, _, err := VerifyAccessToken(before.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ChangePassword("bob", "wrong password", "tr0ub4dor&3 staple"); err != ErrBadCredentials {
+		t.Fatalf("wrong current password : err = %v", err)
+	}
+	if err := ChangePassword("bob", "correct horse battery", "tr0ub4dor&3 staple"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := VerifyAccessToken(before.AccessToken); err == nil {
+		t.Fatal("access token of the old password accepted")
+	}
+	if _, err := RefreshTokens(before.RefreshToken); err == nil {
+		t.Fatal("refresh token of the old password accepted")
+	}
+	if _, err := AuthenticateUser("bob", "correct horse battery"); err == nil {
+		t.Fatal("old password accepted")
+	}
+
+	U, err = AuthenticateUser("bob", "tr0ub4dor&3 staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, _ := IssueTokens(U)
+	if _, _, err := VerifyAccessToken(after.AccessToken); err != nil {
+		t.Fatalf("new token : %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"mongomini/agra/moncore"
+)
+
+// Interval of keep-alive lines sent to idle watchers
+var WatchHeartbeat = 15 * time.Second
+
+// Events a watcher may fall behind by before it is disconnected
+var WatchBuffer = 256
+
+// Change event sent by mini/watch/{db}/{collection}
+type WatchEvent struct {
+	Type       string                     `json:"type"` // insert | update | delete
+	Database   string                     `json:"database"`
+	Collection string                     `json:"collection"`
+	ID         string                     `json:"id"`
+	Document   *moncore.GenericDBDocument `json:"document,omitempty"` // After the write, or the removed document for deletes
+	Time       time.Time                  `json:"time"`
+}
+
+type watcher struct {
+	db, collection string
+	events         chan *moncore.ChangeEvent
+	overflow       chan struct{}
+}
+
+// Watchers of change events of this instance
+type watchHub struct {
+	mu       sync.RWMutex
+	watchers map[*watcher]struct{}
+}
+
+var watchers = &watchHub{watchers: map[*watcher]struct{}{}}
+
+// Forward writes made through this instance to mini/watch clients
+func _InitWatch() {
+	moncore.OnChange(watchers.publish)
+}
+
+func (H *watchHub) publish(Event *moncore.ChangeEvent) {
+	H.mu.RLock()
+	defer H.mu.RUnlock()
+
+	for W := range H.watchers {
+		if W.db != Event.Database || W.collection != Event.Collection {
+			continue
+		}
+		select {
+		case W.events <- Event:
+		default:
+			// Listeners must not block : slow watchers are disconnected
+			select {
+			case W.overflow <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (H *watchHub) add(db string, collection string) *watcher {
+	W := &watcher{db: db, collection: collection, events: make(chan *moncore.ChangeEvent, WatchBuffer), overflow: make(chan struct{}, 1)}
+
+	H.mu.Lock()
+	H.watchers[W] = struct{}{}
+	H.mu.Unlock()
+	return W
+}
+
+func (H *watchHub) remove(W *watcher) {
+	H.mu.Lock()
+	delete(H.watchers, W)
+	H.mu.Unlock()
+}
+
+// GET : mini/watch/{db}/{collection}. Streams change events of the collection (WatchEvent) until the client goes away.
+// Server-sent events if the client accepts text/event-stream, NDJSON otherwise. Empty lines (or SSE comments) keep the connection alive.
+//
+// Query parameters are filters like in mini/ls, matched against the document. ?events=insert,delete keeps only those types.
+// Only writes made through this instance are seen.
+func API_Watch_Collection(C *APICall) {
+
+	Q := C.HTTPRequest.URL.Query()
+	types := map[string]bool{}
+	for _, t := range strings.Split(Q.Get("events"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types[t] = true
+		}
+	}
+	Q.Del("events")
+	Q.Del("format")
+	F := moncore.Filter_FromQueryStrings(Q)
+
+	Col := C.Collection(C.Param("db"), C.Param("collection"))
+	if err := Col.CheckFilter(F); err != nil {
+		C.Fail(err)
+		return
+	}
+
+	W := watchers.add(Col.DatabaseName(), Col.Name())
+	defer watchers.remove(W)
+
+	sse := C.Accepts("text/event-stream")
+	if sse {
+		C.SetHeader("Content-Type", "text/event-stream")
+	} else {
+		C.SetHeader("Content-Type", "application/x-ndjson")
+	}
+	C.SetHeader("Cache-Control", "no-store")
+	C.SetHeader("X-Content-Type-Options", "nosniff")
+	C.WriteStatus(200)
+	C.Flush()
+
+	heartbeat := time.NewTicker(WatchHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-C.Context().Done():
+			return
+
+		case <-W.overflow:
+			E := NewAPIError(CodeRateLimited, "watcher fell behind, events were lost")
+			E.RequestID = C.RequestID
+			J, _ := json.Marshal(map[string]*APIError{"error": E})
+			if sse {
+				C.WriteString("event: error\ndata: " + string(J) + "\n\n")
+			} else {
+				C.Write(append(J, '\n'))
+			}
+			return
+
+		case <-heartbeat.C:
+			if sse {
+				C.WriteString(": keep-alive\n\n")
+			} else {
+				C.WriteString("\n")
+			}
+			C.Flush()
+
+		case Event := <-W.events:
+			if len(types) != 0 && !types[Event.Type] {
+				continue
+			}
+			if Event.Document == nil || !F.Matches(Event.Document) {
+				continue
+			}
+
+			Doc, err := Col.Visible(Event.Document)
+			if err != nil {
+				continue // Denied by the rules
+			}
+
+			J, err := json.Marshal(WatchEvent{
+				Type:       Event.Type,
+				Database:   Event.Database,
+				Collection: Event.Collection,
+				ID:         Event.ID,
+				Document:   Doc,
+				Time:       Event.Time,
+			})
+			if CheckError(err) {
+				continue
+			}
+
+			if sse {
+				C.WriteString("event: " + Event.Type + "\ndata: " + string(J) + "\n\n")
+			} else {
+				C.Write(append(J, '\n'))
+			}
+			C.Flush()
+		}
+	}
+}
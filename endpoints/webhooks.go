@@ -0,0 +1,90 @@
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"mongomini/agra/moncore"
+)
+
+var Webhooks *moncore.WebhookDispatcher
+
+// Webhook deliveries attempted after a request, see DeliverWebhooks
+var WebhookDeliveriesPerRequest int = 5
+
+// Send due webhook deliveries once the request is handled and its response flushed.
+// Writes queue deliveries in the system database, so retries and deliveries left by stopped instances
+// go out with later requests. See moncore.WebhookDispatcher.DeliverDue
+func DeliverWebhooks() Middleware {
+	return func(next Handler) Handler {
+		return func(C *APICall) {
+			next(C)
+
+			if Webhooks == nil {
+				return
+			}
+			if C.HeadersSent() {
+				C.Flush()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), moncore.WebhookRequestTimeout)
+			defer cancel()
+			Webhooks.DeliverDue(ctx, WebhookDeliveriesPerRequest)
+		}
+	}
+}
+
+// GET : mini/admin/webhooks
+func API_List_Webhooks(C *APICall) {
+	C.Respond(Webhooks.List())
+}
+
+// POST : mini/admin/webhooks with a JSON body (see moncore.Webhook). The secret is only returned once
+func API_Create_Webhook(C *APICall) {
+	if !C.Acceptable() {
+		return
+	}
+
+	hook := moncore.Webhook{}
+	if err := C.BodyJsonToStruct(&hook); err != nil {
+		C.Fail(err)
+		return
+	}
+
+	created, err := Webhooks.Register(&hook)
+	if err != nil {
+		C.WriteError("Bad Request : ", err, http.StatusBadRequest)
+		return
+	}
+
+	C.RespondStatus(http.StatusCreated, created)
+}
+
+// GET : mini/admin/webhooks/{id}
+func API_Get_Webhook(C *APICall) {
+
+	hook := Webhooks.Get(C.Param("id"))
+	if hook == nil {
+		C.WriteError("Not Found : ", errors.New("no webhook "+C.Param("id")), http.StatusNotFound)
+		return
+	}
+
+	C.Respond(hook)
+}
+
+// DELETE : mini/admin/webhooks/{id}
+func API_Delete_Webhook(C *APICall) {
+
+	if !Webhooks.Remove(C.Param("id")) {
+		C.WriteError("Not Found : ", errors.New("no webhook "+C.Param("id")), http.StatusNotFound)
+		return
+	}
+
+	C.WriteStatus(http.StatusNoContent)
+}
+
+// GET : mini/admin/webhooks/deadletters. Latest failed deliveries
+func API_Webhook_DeadLetters(C *APICall) {
+	C.Respond(Webhooks.DeadLetters(100))
+}
@@ -1,8 +1,14 @@
 module mongomini
 
-go 1.17
+go 1.21
 
-require go.mongodb.org/mongo-driver v1.7.2
+require (
+	go.mongodb.org/mongo-driver v1.7.2
+	go.uber.org/goleak v1.1.12
+	golang.org/x/crypto v0.33.0
+	golang.org/x/term v0.29.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/go-stack/stack v1.8.0 // indirect
@@ -13,7 +19,7 @@ require (
 	github.com/xdg-go/scram v1.0.2 // indirect
 	github.com/xdg-go/stringprep v1.0.2 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
-	golang.org/x/crypto v0.0.0-20200302210943-78000ba7a073 // indirect
-	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e // indirect
-	golang.org/x/text v0.3.5 // indirect
+	golang.org/x/sync v0.11.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
 )
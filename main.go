@@ -3,15 +3,22 @@ package main
 import (
 	"errors"
 	"log"
+	"mongomini/cli"
 	"mongomini/endpoints"
 	"net"
 	"net/http"
+	"os"
 )
 
 var HTTPPort string = "49525"
 
 func main() {
 
+	// mongomini <command> [arguments] : command-line tool, see package cli
+	if len(os.Args) > 1 && os.Args[1] != "serve" {
+		os.Exit(cli.Main(os.Args[1:]))
+	}
+
 	FullMux := http.NewServeMux()
 
 	FullMux.HandleFunc("/", endpoints.ServeRequest)